@@ -0,0 +1,415 @@
+package goracle
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mr-joshcrane/goracle/client"
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+// CacheStore holds cached completions by key. Implementations must be safe
+// for concurrent use, and are free to evict entries to stay within a size
+// limit.
+type CacheStore interface {
+	Get(key string) (data []byte, ok bool, err error)
+	Put(key string, data []byte) error
+	Delete(key string) error
+}
+
+// Cache serves repeated completions without asking the model again. Requests
+// are keyed by a hash of the canonical prompt and the model's name, and
+// identical requests in flight at the same time are collapsed into one.
+// Create one with [NewCache] and add it to an Oracle with [Caching].
+type Cache struct {
+	store CacheStore
+	ttl   time.Duration
+
+	mu       sync.Mutex
+	inflight map[string]*flight
+	stats    CacheStats
+}
+
+// CacheStats counts how requests to a [Cache] were served.
+type CacheStats struct {
+	// Hits were answered from the store.
+	Hits int
+	// Misses were passed on to the model.
+	Misses int
+	// Shared waited for an identical request already in flight.
+	Shared int
+	// SaveErrors counts the completions that couldn't be written to the
+	// store. They are still returned, but will be missed again next time.
+	SaveErrors int
+	// LastSaveError is the most recent error writing to the store, if any.
+	LastSaveError error
+}
+
+// flight is a completion in progress that identical requests can wait on.
+type flight struct {
+	done  chan struct{}
+	entry cacheEntry
+	err   error
+}
+
+// cacheEntry is a cached completion, as kept in a [CacheStore].
+type cacheEntry struct {
+	Text      string         `json:"text"`
+	ToolCalls []llm.ToolCall `json:"tool_calls,omitempty"`
+	Created   time.Time      `json:"created"`
+	// Usage is what the completion cost to fetch. It is only reported to the
+	// request that fetched it, since hits and shared requests cost nothing.
+	Usage llm.Usage `json:"-"`
+}
+
+// NewCache returns a Cache that keeps completions in store. Entries older
+// than ttl are ignored, and a ttl of 0 keeps entries until the store evicts
+// them.
+func NewCache(store CacheStore, ttl time.Duration) *Cache {
+	return &Cache{
+		store:    store,
+		ttl:      ttl,
+		inflight: map[string]*flight{},
+	}
+}
+
+// Stats returns how requests to the cache have been served so far.
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Caching returns middleware that serves completions from c. A missed
+// completion is read to the end before it is returned, so answers are no
+// longer streamed as they are generated. Failed completions aren't cached.
+// Only the request that reaches the model reports its usage, so hits don't
+// count towards an Oracle's budget.
+func Caching(c *Cache) Middleware {
+	return func(next LanguageModel) LanguageModel {
+		return LanguageModelFunc(func(ctx context.Context, prompt client.Prompt) (io.Reader, error) {
+			key, err := cacheKey(modelName(next), prompt)
+			if err != nil {
+				return nil, err
+			}
+			entry, err := c.completion(ctx, key, func() (cacheEntry, error) {
+				return complete(ctx, next, prompt)
+			})
+			if err != nil {
+				return nil, err
+			}
+			return llm.Once(llm.Delta{Text: entry.Text, ToolCalls: entry.ToolCalls, Usage: entry.Usage}), nil
+		})
+	}
+}
+
+// completion returns the entry for key from the store, from an identical
+// request already in flight, or failing both by calling fetch. A fetched
+// entry that can't be saved is returned all the same, and the error is
+// counted in the cache's stats.
+func (c *Cache) completion(ctx context.Context, key string, fetch func() (cacheEntry, error)) (cacheEntry, error) {
+	c.mu.Lock()
+	if f, ok := c.inflight[key]; ok {
+		c.stats.Shared++
+		c.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.entry, f.err
+		case <-ctx.Done():
+			return cacheEntry{}, ctx.Err()
+		}
+	}
+	entry, ok := c.lookup(key)
+	if ok {
+		c.stats.Hits++
+		c.mu.Unlock()
+		return entry, nil
+	}
+	c.stats.Misses++
+	f := &flight{done: make(chan struct{})}
+	c.inflight[key] = f
+	c.mu.Unlock()
+
+	entry, err := fetch()
+	var saveErr error
+	if err == nil {
+		saveErr = c.save(key, entry)
+	}
+	f.entry, f.err = entry, err
+	f.entry.Usage = llm.Usage{}
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if saveErr != nil {
+		c.stats.SaveErrors++
+		c.stats.LastSaveError = saveErr
+	}
+	c.mu.Unlock()
+	close(f.done)
+	return entry, err
+}
+
+// lookup reads a fresh entry from the store, removing it if it has expired.
+// Entries that can't be read are treated as missing.
+func (c *Cache) lookup(key string) (cacheEntry, bool) {
+	data, ok, err := c.store.Get(key)
+	if err != nil || !ok {
+		return cacheEntry{}, false
+	}
+	var entry cacheEntry
+	err = json.Unmarshal(data, &entry)
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	if c.ttl > 0 && time.Since(entry.Created) > c.ttl {
+		c.store.Delete(key)
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (c *Cache) save(key string, entry cacheEntry) error {
+	entry.Created = time.Now()
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return c.store.Put(key, data)
+}
+
+// complete reads a whole completion from model.
+func complete(ctx context.Context, model LanguageModel, prompt client.Prompt) (cacheEntry, error) {
+	data, err := model.Completion(ctx, prompt)
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	text, err := readChunks(data, func(string) bool { return true })
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	entry := cacheEntry{Text: text}
+	if tc, ok := data.(llm.ToolCaller); ok {
+		entry.ToolCalls = tc.ToolCalls()
+	}
+	if u, ok := data.(llm.UsageReporter); ok {
+		entry.Usage = u.Usage()
+	}
+	return entry, nil
+}
+
+// cacheKey hashes everything about a request that can change its answer.
+func cacheKey(model string, prompt client.Prompt) (string, error) {
+	inputs, outputs := prompt.GetHistory()
+	canonical, err := json.Marshal(struct {
+		Model          string         `json:"model"`
+		Purpose        string         `json:"purpose"`
+		Inputs         []string       `json:"inputs"`
+		Outputs        []string       `json:"outputs"`
+		References     []Reference    `json:"references"`
+		Question       string         `json:"question"`
+		ResponseFormat []string       `json:"response_format"`
+		ResponseSchema map[string]any `json:"response_schema"`
+		Tools          []llm.Tool     `json:"tools"`
+		ToolTurns      []llm.ToolTurn `json:"tool_turns"`
+		Options        llm.Options    `json:"options"`
+	}{
+		Model:          model,
+		Purpose:        prompt.GetPurpose(),
+		Inputs:         inputs,
+		Outputs:        outputs,
+		References:     prompt.GetReferences(),
+		Question:       prompt.GetQuestion(),
+		ResponseFormat: prompt.GetResponseFormat(),
+		ResponseSchema: prompt.GetResponseSchema(),
+		Tools:          prompt.GetTools(),
+		ToolTurns:      prompt.GetToolTurns(),
+		Options:        prompt.GetOptions(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("hashing prompt: %w", err)
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// MemoryStore is a [CacheStore] that keeps entries in memory, evicting the
+// least recently used once they take up more than its size limit.
+type MemoryStore struct {
+	mu       sync.Mutex
+	maxBytes int
+	size     int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key  string
+	data []byte
+}
+
+// NewMemoryStore returns a MemoryStore holding at most maxBytes of entries.
+// A maxBytes of 0 means there is no limit.
+func NewMemoryStore(maxBytes int) *MemoryStore {
+	return &MemoryStore{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (s *MemoryStore) Get(key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	s.order.MoveToFront(e)
+	return e.Value.(memoryEntry).data, true, nil
+}
+
+func (s *MemoryStore) Put(key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+	s.entries[key] = s.order.PushFront(memoryEntry{key: key, data: data})
+	s.size += len(data)
+	for s.maxBytes > 0 && s.size > s.maxBytes && s.order.Len() > 0 {
+		s.remove(s.order.Back().Value.(memoryEntry).key)
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+	return nil
+}
+
+func (s *MemoryStore) remove(key string) {
+	e, ok := s.entries[key]
+	if !ok {
+		return
+	}
+	s.size -= len(e.Value.(memoryEntry).data)
+	s.order.Remove(e)
+	delete(s.entries, key)
+}
+
+// DiskStore is a [CacheStore] that keeps each entry in its own file in a
+// directory, so that the cache survives between runs. Once the entries take
+// up more than its size limit, the least recently written are removed.
+type DiskStore struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+}
+
+// NewDiskStore returns a DiskStore in dir, creating the directory if needed.
+// A maxBytes of 0 means there is no limit.
+func NewDiskStore(dir string, maxBytes int64) (*DiskStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskStore{
+		dir:      dir,
+		maxBytes: maxBytes,
+	}, nil
+}
+
+func (s *DiskStore) path(key string) string {
+	return filepath.Join(s.dir, key+".json")
+}
+
+func (s *DiskStore) Get(key string) ([]byte, bool, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func (s *DiskStore) Put(key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tmp, err := os.CreateTemp(s.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return s.evict()
+}
+
+func (s *DiskStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// evict removes the oldest entries until the store is within its size limit.
+// The caller must hold the lock.
+func (s *DiskStore) evict() error {
+	if s.maxBytes <= 0 {
+		return nil
+	}
+	dirEntries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	var files []fs.FileInfo
+	var size int64
+	for _, d := range dirEntries {
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".json") {
+			continue
+		}
+		info, err := d.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+		size += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, f := range files {
+		if size <= s.maxBytes {
+			break
+		}
+		err := os.Remove(filepath.Join(s.dir, f.Name()))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		size -= f.Size()
+	}
+	return nil
+}
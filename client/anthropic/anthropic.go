@@ -5,13 +5,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	_ "image/gif"
-	_ "image/jpeg"
-	_ "image/png"
 	"io"
 	"net/http"
 	"os"
 	"strings"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+	"github.com/mr-joshcrane/goracle/client/pdf"
 )
 
 type Role string
@@ -25,7 +25,11 @@ type Prompt interface {
 	GetPurpose() string
 	GetHistory() ([]string, []string)
 	GetQuestion() string
-	GetReferences() [][]byte
+	GetReferences() []llm.Reference
+	GetResponseSchema() map[string]any
+	GetTools() []llm.Tool
+	GetToolTurns() []llm.ToolTurn
+	GetOptions() llm.Options
 }
 
 type ChatMessage struct {
@@ -57,20 +61,39 @@ func Authenticate() (token string, err error) {
 	return key, nil
 }
 
-func capabilityCheck(model ModelConfig, prompt Prompt) error {
+// capabilityCheck makes sure the model can handle the prompt's references,
+// replacing PDF documents with their text if it can't read them itself.
+func capabilityCheck(model ModelConfig, prompt Prompt) (Prompt, error) {
 	if !model.SupportsVision {
 		for _, ref := range prompt.GetReferences() {
 			kind := detectDataKind(ref)
 			if kind == DataKindImage {
-				return fmt.Errorf("model %s does not support image references", model.Name)
+				return nil, fmt.Errorf("model %s does not support image references", model.Name)
 			}
 		}
 	}
-	return nil
+	if !model.SupportsDocuments {
+		refs, err := pdf.TextReferences(prompt.GetReferences())
+		if err != nil {
+			return nil, err
+		}
+		prompt = withReferences{Prompt: prompt, references: refs}
+	}
+	return prompt, nil
 }
 
-func Completion(ctx context.Context, token string, model ModelConfig, prompt Prompt) (io.Reader, error) {
-	err := capabilityCheck(model, prompt)
+// withReferences replaces the references of a prompt.
+type withReferences struct {
+	Prompt
+	references []llm.Reference
+}
+
+func (p withReferences) GetReferences() []llm.Reference {
+	return p.references
+}
+
+func Completion(ctx context.Context, httpClient *http.Client, token string, model ModelConfig, prompt Prompt) (io.Reader, error) {
+	prompt, err := capabilityCheck(model, prompt)
 	if err != nil {
 		return nil, err
 	}
@@ -79,22 +102,45 @@ func Completion(ctx context.Context, token string, model ModelConfig, prompt Pro
 		return nil, err
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := llm.HTTPClient(httpClient).Do(req)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-
 	return parseAnthropicResponse(resp)
 }
 
 func createCompletionRequest(ctx context.Context, token string, model ModelConfig, prompt Prompt) (*http.Request, error) {
-	messages := createAnthropicMessages(prompt)
+	messages, err := createAnthropicMessages(prompt)
+	if err != nil {
+		return nil, err
+	}
 	requestBody := map[string]any{
 		"model":      model.Name,
 		"system":     prompt.GetPurpose(),
 		"max_tokens": model.MaxTokens,
 		"messages":   messages,
+		"stream":     true,
+	}
+	err = addOptions(requestBody, model, prompt.GetOptions())
+	if err != nil {
+		return nil, err
+	}
+	tools := toolDefinitions(prompt.GetTools())
+	if schema := prompt.GetResponseSchema(); schema != nil {
+		// Anthropic has no JSON mode as such; the idiomatic equivalent is a
+		// tool whose input is the answer, which the model is made to call.
+		tools = append(tools, ToolDefinition{
+			Name:        responseTool,
+			Description: "Respond to the user with an answer matching this schema.",
+			InputSchema: schema,
+		})
+		requestBody["tool_choice"] = map[string]string{"type": "tool", "name": responseTool}
+		if len(tools) > 1 {
+			requestBody["tool_choice"] = map[string]string{"type": "any"}
+		}
+	}
+	if len(tools) > 0 {
+		requestBody["tools"] = tools
 	}
 
 	jsonBody, err := json.Marshal(requestBody)
@@ -131,7 +177,7 @@ type ImagePayload struct {
 	} `json:"source"`
 }
 
-func createAnthropicMessages(prompt Prompt) []Message {
+func createAnthropicMessages(prompt Prompt) ([]Message, error) {
 	messages := []Message{}
 	userHistory, assistantHistory := prompt.GetHistory()
 	for i := range userHistory {
@@ -140,32 +186,280 @@ func createAnthropicMessages(prompt Prompt) []Message {
 	}
 	messages = append(messages, Message{Role: "user", Content: prompt.GetQuestion()})
 
-	for _, ref := range prompt.GetReferences() {
-		content, err := processReference(ref)
+	for i, ref := range prompt.GetReferences() {
+		content, err := processReference(ref, i+1)
 		if err != nil {
-			continue
+			return nil, fmt.Errorf("preparing %s: %w", ref.Label(i+1), err)
 		}
 		messages = append(messages, Message{Role: "user", Content: content})
 	}
+	return append(messages, toolTurnMessages(prompt.GetToolTurns())...), nil
+}
+
+// addOptions sets the generation options Anthropic accepts on a request
+// body. There is no seed or penalty, and reasoning effort isn't supported.
+func addOptions(requestBody map[string]any, model ModelConfig, opts llm.Options) error {
+	err := opts.Reject(model.Name,
+		llm.OptionSeed,
+		llm.OptionPresencePenalty,
+		llm.OptionFrequencyPenalty,
+		llm.OptionReasoningEffort,
+	)
+	if err != nil {
+		return err
+	}
+	if opts.Temperature != nil {
+		requestBody["temperature"] = *opts.Temperature
+	}
+	if opts.TopP != nil {
+		requestBody["top_p"] = *opts.TopP
+	}
+	if opts.TopK != nil {
+		requestBody["top_k"] = *opts.TopK
+	}
+	if opts.MaxOutputTokens != nil {
+		requestBody["max_tokens"] = *opts.MaxOutputTokens
+	}
+	if opts.StopSequences != nil {
+		requestBody["stop_sequences"] = opts.StopSequences
+	}
+	return nil
+}
+
+// responseTool is the name of the tool used to collect structured answers.
+// Its input is returned as the text of the completion, not as a tool call.
+const responseTool = "structured_response"
+
+// ToolDefinition advertises a function the model may call.
+type ToolDefinition struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"input_schema"`
+}
+
+type TextBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type ToolUseBlock struct {
+	Type  string          `json:"type"`
+	ID    string          `json:"id"`
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+type ToolResultBlock struct {
+	Type      string `json:"type"`
+	ToolUseID string `json:"tool_use_id"`
+	Content   string `json:"content"`
+	IsError   bool   `json:"is_error,omitempty"`
+}
+
+func toolDefinitions(tools []llm.Tool) []ToolDefinition {
+	var definitions []ToolDefinition
+	for _, t := range tools {
+		definitions = append(definitions, ToolDefinition{
+			Name:        t.Name,
+			Description: t.Description,
+			InputSchema: t.Parameters,
+		})
+	}
+	return definitions
+}
+
+func toolTurnMessages(turns []llm.ToolTurn) []Message {
+	messages := []Message{}
+	for _, turn := range turns {
+		uses := []any{}
+		if turn.Text != "" {
+			uses = append(uses, TextBlock{Type: "text", Text: turn.Text})
+		}
+		for _, c := range turn.Calls {
+			input := c.Arguments
+			if len(input) == 0 {
+				input = json.RawMessage("{}")
+			}
+			uses = append(uses, ToolUseBlock{Type: "tool_use", ID: c.ID, Name: c.Name, Input: input})
+		}
+		messages = append(messages, Message{Role: "assistant", Content: uses})
+		results := []ToolResultBlock{}
+		for _, r := range turn.Results {
+			results = append(results, ToolResultBlock{
+				Type:      "tool_result",
+				ToolUseID: r.ID,
+				Content:   r.Content,
+				IsError:   r.IsError,
+			})
+		}
+		messages = append(messages, Message{Role: "user", Content: results})
+	}
 	return messages
 }
 
+// streamEvent is the subset of Anthropic's server-sent events we care about.
+type streamEvent struct {
+	Type         string `json:"type"`
+	Index        int    `json:"index"`
+	ContentBlock struct {
+		Type string `json:"type"`
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"content_block"`
+	Delta struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+	// Message carries the input usage in message_start, while the output
+	// usage arrives in message_delta.
+	Message struct {
+		Usage Usage `json:"usage"`
+	} `json:"message"`
+	Usage Usage `json:"usage"`
+}
+
+// Usage is the usage block Anthropic returns with a message.
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+func (u Usage) usage() llm.Usage {
+	return llm.Usage{
+		InputTokens:  u.InputTokens,
+		OutputTokens: u.OutputTokens,
+	}
+}
+
+// statusError reports an unsuccessful response, along with the error message
+// Anthropic sends in the body. Prompts that are too long wrap
+// [llm.ErrContextLength], anything else is an [llm.StatusError], so that
+// rate limits and overloaded_error can be retried.
+func statusError(resp *http.Response) error {
+	defer resp.Body.Close()
+	var body struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body)
+	if err != nil || body.Error.Message == "" {
+		return llm.NewStatusError(resp, resp.Status)
+	}
+	if strings.Contains(body.Error.Message, "prompt is too long") {
+		return fmt.Errorf("%w: %s", llm.ErrContextLength, body.Error.Message)
+	}
+	return llm.NewStatusError(resp, body.Error.Type+": "+body.Error.Message)
+}
+
 func parseAnthropicResponse(resp *http.Response) (io.Reader, error) {
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad status code: %d; %s", resp.StatusCode, resp.Status)
+		return nil, statusError(resp)
+	}
+	if llm.IsEventStream(resp.Header.Get("Content-Type")) {
+		return streamAnthropicResponse(resp.Body), nil
 	}
+	defer resp.Body.Close()
 
 	var responseBody struct {
 		Content []struct {
-			Text string `json:"text"`
+			Type  string          `json:"type"`
+			Text  string          `json:"text"`
+			ID    string          `json:"id"`
+			Name  string          `json:"name"`
+			Input json.RawMessage `json:"input"`
 		} `json:"content"`
+		Usage Usage `json:"usage"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&responseBody); err != nil {
 		return nil, fmt.Errorf("failed to decode response body: %w", err)
 	}
-	var completion string
-	for _, message := range responseBody.Content {
-		completion += message.Text
+	delta := llm.Delta{Usage: responseBody.Usage.usage()}
+	for _, block := range responseBody.Content {
+		if block.Type == "tool_use" && block.Name == responseTool {
+			delta.Text += string(block.Input)
+			continue
+		}
+		if block.Type == "tool_use" {
+			delta.ToolCalls = append(delta.ToolCalls, llm.ToolCall{
+				ID:        block.ID,
+				Name:      block.Name,
+				Arguments: block.Input,
+			})
+			continue
+		}
+		delta.Text += block.Text
 	}
-	return strings.NewReader(completion), nil
+	return llm.Once(delta), nil
+}
+
+// streamAnthropicResponse decodes Anthropic's server-sent events. Tool use
+// blocks stream their input as partial JSON, which is assembled until the
+// block stops and then handed over as a complete call.
+func streamAnthropicResponse(body io.ReadCloser) *llm.Stream {
+	events := llm.NewEventScanner(body)
+	calls := map[int]*llm.ToolCall{}
+	inputs := map[int]*strings.Builder{}
+	return llm.NewStream(body, func() (llm.Delta, error) {
+		event, err := events.Next()
+		if err != nil {
+			return llm.Delta{}, err
+		}
+		var e streamEvent
+		err = json.Unmarshal([]byte(event.Data), &e)
+		if err != nil {
+			return llm.Delta{}, fmt.Errorf("failed to decode stream event: %w", err)
+		}
+		switch e.Type {
+		case "message_start":
+			return llm.Delta{Usage: e.Message.Usage.usage()}, nil
+		case "message_delta":
+			return llm.Delta{Usage: e.Usage.usage()}, nil
+		case "content_block_start":
+			if e.ContentBlock.Type == "tool_use" {
+				calls[e.Index] = &llm.ToolCall{ID: e.ContentBlock.ID, Name: e.ContentBlock.Name}
+				inputs[e.Index] = new(strings.Builder)
+			}
+		case "content_block_delta":
+			switch e.Delta.Type {
+			case "text_delta":
+				return llm.Delta{Text: e.Delta.Text}, nil
+			case "input_json_delta":
+				if input, ok := inputs[e.Index]; ok {
+					input.WriteString(e.Delta.PartialJSON)
+				}
+			}
+		case "content_block_stop":
+			call, ok := calls[e.Index]
+			if !ok {
+				break
+			}
+			delete(calls, e.Index)
+			call.Arguments = json.RawMessage(inputs[e.Index].String())
+			if len(call.Arguments) == 0 {
+				call.Arguments = json.RawMessage("{}")
+			}
+			if call.Name == responseTool {
+				return llm.Delta{Text: string(call.Arguments)}, nil
+			}
+			return llm.Delta{ToolCalls: []llm.ToolCall{*call}}, nil
+		case "message_stop":
+			return llm.Delta{}, io.EOF
+		case "error":
+			if e.Error.Type == "overloaded_error" {
+				return llm.Delta{}, fmt.Errorf("stream error: %w", &llm.StatusError{
+					StatusCode: llm.StatusOverloaded,
+					Message:    e.Error.Type + ": " + e.Error.Message,
+				})
+			}
+			return llm.Delta{}, fmt.Errorf("stream error: %s: %s", e.Error.Type, e.Error.Message)
+		}
+		return llm.Delta{}, nil
+	})
 }
@@ -1,42 +1,66 @@
 package anthropic
 
+import "github.com/mr-joshcrane/goracle/client/llm"
+
 type ModelConfig struct {
 	Provider       string
 	Name           string
 	SupportsVision bool
-	Description    string
-	MaxTokens      int
+	// SupportsDocuments models read PDF documents natively. Others are given
+	// the text of them instead.
+	SupportsDocuments bool
+	Description       string
+	MaxTokens         int
+	// ContextWindow is the most tokens the model accepts in a single request.
+	ContextWindow int
+	// Price is what the model costs per million tokens.
+	Price llm.Pricing
 }
 
 var Models = map[string]ModelConfig{
 	"ClaudeOpus4": {
-		Name:           "claude-opus-4-20250514",
-		SupportsVision: true,
-		MaxTokens:      64000,
-		Description:    "Claude Opus 4 is the latest model with advanced capabilities and a large context window.",
+		Name:              "claude-opus-4-20250514",
+		SupportsVision:    true,
+		SupportsDocuments: true,
+		MaxTokens:         64000,
+		ContextWindow:     200000,
+		Description:       "Claude Opus 4 is the latest model with advanced capabilities and a large context window.",
+		Price:             llm.Pricing{InputPerMillion: 15, OutputPerMillion: 75},
 	},
 	"ClaudeSonnet4": {
-		Name:           "claude-sonnet-4-20250514",
-		SupportsVision: true,
-		MaxTokens:      64000,
-		Description:    "Claude Sonnet 4 is designed for complex reasoning tasks with a large context window.",
+		Name:              "claude-sonnet-4-20250514",
+		SupportsVision:    true,
+		SupportsDocuments: true,
+		MaxTokens:         64000,
+		ContextWindow:     200000,
+		Description:       "Claude Sonnet 4 is designed for complex reasoning tasks with a large context window.",
+		Price:             llm.Pricing{InputPerMillion: 3, OutputPerMillion: 15},
 	},
 	"ClaudeSonnet3_7": {
-		Name:           "claude-3-7-sonnet-20250219",
-		SupportsVision: true,
-		MaxTokens:      64000,
-		Description:    "Claude Sonnet 3.7 is optimized for advanced reasoning and complex tasks.",
+		Name:              "claude-3-7-sonnet-20250219",
+		SupportsVision:    true,
+		SupportsDocuments: true,
+		MaxTokens:         64000,
+		ContextWindow:     200000,
+		Description:       "Claude Sonnet 3.7 is optimized for advanced reasoning and complex tasks.",
+		Price:             llm.Pricing{InputPerMillion: 3, OutputPerMillion: 15},
 	},
 	"ClaudeSonnet3_5": {
-		Name:           "claude-3-5-sonnet-20241022",
-		SupportsVision: true,
-		MaxTokens:      64000,
-		Description:    "Claude Sonnet 3.5 (New) is a versatile model with enhanced capabilities for various tasks.",
+		Name:              "claude-3-5-sonnet-20241022",
+		SupportsVision:    true,
+		SupportsDocuments: true,
+		MaxTokens:         64000,
+		ContextWindow:     200000,
+		Description:       "Claude Sonnet 3.5 (New) is a versatile model with enhanced capabilities for various tasks.",
+		Price:             llm.Pricing{InputPerMillion: 3, OutputPerMillion: 15},
 	},
 	"ClaudeHaiku3_5": {
-		Name:           "claude-3-5-haiku-20241022",
-		SupportsVision: true,
-		MaxTokens:      64000,
-		Description:    "Claude Haiku 3.5 is designed for tasks requiring concise and efficient responses.",
+		Name:              "claude-3-5-haiku-20241022",
+		SupportsVision:    true,
+		SupportsDocuments: true,
+		MaxTokens:         64000,
+		ContextWindow:     200000,
+		Description:       "Claude Haiku 3.5 is designed for tasks requiring concise and efficient responses.",
+		Price:             llm.Pricing{InputPerMillion: 0.8, OutputPerMillion: 4},
 	},
 }
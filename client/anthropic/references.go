@@ -1,14 +1,11 @@
 package anthropic
 
 import (
-	"bytes"
 	"encoding/base64"
 	"fmt"
-	"image"
-	_ "image/gif" // Register image formats
-	"image/jpeg"  // For re-encoding
-	_ "image/jpeg"
-	_ "image/png"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+	"github.com/mr-joshcrane/goracle/client/pdf"
 )
 
 // DataKind represents the type of data contained in the []byte
@@ -18,35 +15,64 @@ const (
 	DataKindUnknown DataKind = iota
 	DataKindImage
 	DataKindText
+	DataKindDocument
 )
 
-// detectDataKind determines the kind of data in the byte slice.
-func detectDataKind(data []byte) DataKind {
-	_, _, err := image.Decode(bytes.NewReader(data))
-	if err == nil {
+// ImagePolicy is how images are prepared for Claude, and what they cost.
+// Claude takes PNG, JPEG, GIF and WebP images of up to 5MB, but scales down
+// any larger than 1568px on the long side or about 1.15 megapixels, so
+// there's no use sending more. An image costs about one token for every 750
+// pixels.
+var ImagePolicy = llm.ImagePolicy{
+	Formats:     []string{llm.ImagePNG, llm.ImageJPEG, llm.ImageGIF, llm.ImageWebP},
+	MaxLongSide: 1568,
+	MaxPixels:   1_150_000,
+	MaxBytes:    5 << 20,
+	Tokens: func(width, height int) int {
+		return (width*height + 749) / 750
+	},
+}
+
+// detectDataKind determines the kind of data a reference holds.
+func detectDataKind(ref llm.Reference) DataKind {
+	if pdf.IsPDF(ref) {
+		return DataKindDocument
+	}
+	if llm.ImageType(ref.Data) != "" {
 		return DataKindImage
 	}
 	return DataKindText // Default to text if not an image
 }
 
-func processReference(data []byte) (interface{}, error) {
-	kind := detectDataKind(data)
+// processReference turns the nth reference into message content. Named
+// references are labelled, so that answers can point at them.
+func processReference(ref llm.Reference, n int) (interface{}, error) {
+	data := ref.Data
+	kind := detectDataKind(ref)
 
 	switch kind {
-	case DataKindImage:
-		// Re-encode to JPEG for consistency if already an image
-		img, _, err := image.Decode(bytes.NewReader(data))
-		if err != nil { // Should not happen, but handle it defensively
-			return nil, fmt.Errorf("image decoding failed after positive detection: %w", err)
+	case DataKindDocument:
+		title := ref.Name
+		if title == "" {
+			title = ref.Label(n)
 		}
-		var buf bytes.Buffer
-		err = jpeg.Encode(&buf, img, nil) // Use nil for default options or customize
+		return []any{createDocumentContent(data, title)}, nil
+
+	case DataKindImage:
+		image, mediaType, err := ImagePolicy.Prepare(data)
 		if err != nil {
-			return nil, fmt.Errorf("image re-encoding failed: %w", err)
+			return nil, err
 		}
-		return []ImagePayload{createImageContent(buf.Bytes())}, nil // Wrap in a slice as before
+		content := []any{createImageContent(image, mediaType)}
+		if ref.Name != "" {
+			content = append([]any{TextBlock{Type: "text", Text: ref.Label(n) + ":"}}, content...)
+		}
+		return content, nil
 
 	case DataKindText:
+		if ref.Name != "" {
+			return fmt.Sprintf("%s: %s", ref.Label(n), data), nil
+		}
 		return string(data), nil // Directly use the string
 
 	default:
@@ -54,7 +80,27 @@ func processReference(data []byte) (interface{}, error) {
 	}
 }
 
-func createImageContent(imageData []byte) ImagePayload {
+// DocumentPayload is a PDF document, which Claude reads page by page, text
+// and images alike.
+type DocumentPayload struct {
+	Type   string `json:"type"`
+	Source struct {
+		Type      string `json:"type"`
+		MediaType string `json:"media_type"`
+		Data      string `json:"data"`
+	} `json:"source"`
+	Title string `json:"title,omitempty"`
+}
+
+func createDocumentContent(data []byte, title string) DocumentPayload {
+	doc := DocumentPayload{Type: "document", Title: title}
+	doc.Source.Type = "base64"
+	doc.Source.MediaType = pdf.MIMEType
+	doc.Source.Data = base64.StdEncoding.EncodeToString(data)
+	return doc
+}
+
+func createImageContent(imageData []byte, mediaType string) ImagePayload {
 	return ImagePayload{
 		Type: "image",
 		Source: struct {
@@ -63,7 +109,7 @@ func createImageContent(imageData []byte) ImagePayload {
 			Data      string `json:"data"`
 		}{
 			Type:      "base64",
-			MediaType: "image/jpeg", // Consistent media type
+			MediaType: mediaType,
 			Data:      base64.StdEncoding.EncodeToString(imageData),
 		},
 	}
@@ -0,0 +1,134 @@
+package client_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mr-joshcrane/goracle"
+	"github.com/mr-joshcrane/goracle/client"
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+const anthropicToolUseStream = `event: message_start
+data: {"type":"message_start","message":{"usage":{"input_tokens":20,"output_tokens":1}}}
+
+event: content_block_start
+data: {"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_3","name":"count"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"animal\":"}}
+
+event: content_block_delta
+data: {"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"\"emu\"}"}}
+
+event: content_block_stop
+data: {"type":"content_block_stop","index":0}
+
+event: message_stop
+data: {"type":"message_stop"}
+
+`
+
+func TestAnthropic_SendsToolTurnsAndParsesToolCalls(t *testing.T) {
+	t.Parallel()
+	var sent []byte
+	c := client.NewAnthropic("test-token")
+	c.HTTPClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent, _ = io.ReadAll(req.Body)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"text/event-stream"}},
+			Body:       io.NopCloser(strings.NewReader(anthropicToolUseStream)),
+		}, nil
+	})}
+	data, err := c.Completion(t.Context(), goracle.Prompt{
+		Question: "How many quokkas and wombats are there?",
+		Tools:    []llm.Tool{{Name: "count", Parameters: map[string]any{"type": "object"}}},
+		ToolTurns: []llm.ToolTurn{{
+			Text: "Let me count them.",
+			Calls: []llm.ToolCall{
+				{ID: "toolu_1", Name: "count", Arguments: json.RawMessage(`{"animal":"quokka"}`)},
+				{ID: "toolu_2", Name: "count", Arguments: json.RawMessage(`{"animal":"wombat"}`)},
+			},
+			Results: []llm.ToolResult{
+				{ID: "toolu_1", Name: "count", Content: "3"},
+				{ID: "toolu_2", Name: "count", Content: "no wombats here", IsError: true},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.Copy(io.Discard, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Messages []struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		} `json:"messages"`
+	}
+	err = json.Unmarshal(sent, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Messages) != 3 {
+		t.Fatalf("expected the question, the calls and their results, got %s", sent)
+	}
+	var uses []map[string]any
+	err = json.Unmarshal(body.Messages[1].Content, &uses)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantUses := []map[string]any{
+		{"type": "text", "text": "Let me count them."},
+		{"type": "tool_use", "id": "toolu_1", "name": "count", "input": map[string]any{"animal": "quokka"}},
+		{"type": "tool_use", "id": "toolu_2", "name": "count", "input": map[string]any{"animal": "wombat"}},
+	}
+	if body.Messages[1].Role != "assistant" || !cmp.Equal(wantUses, uses) {
+		t.Errorf("unexpected calls from %s: %s", body.Messages[1].Role, cmp.Diff(wantUses, uses))
+	}
+	var results []map[string]any
+	err = json.Unmarshal(body.Messages[2].Content, &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResults := []map[string]any{
+		{"type": "tool_result", "tool_use_id": "toolu_1", "content": "3"},
+		{"type": "tool_result", "tool_use_id": "toolu_2", "content": "no wombats here", "is_error": true},
+	}
+	if body.Messages[2].Role != "user" || !cmp.Equal(wantResults, results) {
+		t.Errorf("unexpected results from %s: %s", body.Messages[2].Role, cmp.Diff(wantResults, results))
+	}
+	calls, ok := data.(llm.ToolCaller)
+	if !ok {
+		t.Fatal("expected the completion to report tool calls")
+	}
+	wantCalls := []llm.ToolCall{{ID: "toolu_3", Name: "count", Arguments: json.RawMessage(`{"animal":"emu"}`)}}
+	if !cmp.Equal(wantCalls, calls.ToolCalls()) {
+		t.Error(cmp.Diff(wantCalls, calls.ToolCalls()))
+	}
+}
+
+func TestAnthropic_ReportsReferencesItCannotSend(t *testing.T) {
+	t.Parallel()
+	c := client.NewAnthropic("test-token")
+	c.HTTPClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		t.Error("expected no request to be made")
+		return nil, http.ErrHandlerTimeout
+	})}
+	// Too large to send as it is, and too broken to be scaled down.
+	corrupt := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 6<<20)...)
+	_, err := c.Completion(t.Context(), goracle.Prompt{
+		Question:   "What animal is this?",
+		References: []llm.Reference{{Name: "quokka.png", Data: corrupt}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "quokka.png") {
+		t.Errorf("expected an error naming the reference, got %v", err)
+	}
+}
@@ -0,0 +1,437 @@
+// Package cassette records the HTTP requests a client makes, and the
+// responses it gets, to a file, and replays them later without the network.
+// Tests can then run the whole path from a question, through a provider's
+// API, to a parsed answer, offline and with the same result every time. API
+// keys and tokens are scrubbed before anything is written.
+//
+// Give a client the recorder's HTTP client:
+//
+//	r, err := cassette.New("testdata/chatgpt.json", cassette.ModeFromEnv())
+//	c := client.NewChatGPT(token)
+//	c.HTTPClient = r.Client()
+//	...
+//	err = r.Close()
+package cassette
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Version is the version of the cassette format. [New] rejects cassettes
+// from newer versions.
+const Version = 1
+
+// Redacted replaces the secrets scrubbed from a cassette.
+const Redacted = "[REDACTED]"
+
+// Mode decides whether a [Recorder] makes requests for real.
+type Mode int
+
+const (
+	// Replay answers requests from the cassette, failing any it has no
+	// recording of.
+	Replay Mode = iota
+	// Record makes requests for real and records them, replacing the
+	// cassette when the recorder is closed.
+	Record
+	// ReplayOrRecord replays the cassette if it exists, or records it if
+	// not.
+	ReplayOrRecord
+)
+
+// ModeFromEnv returns [Record] if the GORACLE_RECORD environment variable is
+// set to a true value, such as 1, and [Replay] if not, so that cassettes
+// can be recorded again against the real APIs without changing any tests.
+func ModeFromEnv() Mode {
+	record, _ := strconv.ParseBool(os.Getenv("GORACLE_RECORD"))
+	if record {
+		return Record
+	}
+	return Replay
+}
+
+// Interaction is a request and the response it got.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is a recorded request, with its secrets scrubbed.
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   Body        `json:"body,omitempty"`
+}
+
+// Response is a recorded response, with its secrets scrubbed.
+type Response struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       Body        `json:"body,omitempty"`
+}
+
+// Body is the body of a request or response. It is written to the cassette
+// as text, so that it can be read and reviewed, unless it isn't valid UTF-8,
+// such as audio, in which case it is written as base64.
+type Body []byte
+
+func (b Body) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(b) {
+		return json.Marshal(string(b))
+	}
+	return json.Marshal(map[string]string{"base64": base64.StdEncoding.EncodeToString(b)})
+}
+
+func (b *Body) UnmarshalJSON(data []byte) error {
+	var text string
+	if json.Unmarshal(data, &text) == nil {
+		*b = Body(text)
+		return nil
+	}
+	var encoded struct {
+		Base64 string `json:"base64"`
+	}
+	err := json.Unmarshal(data, &encoded)
+	if err != nil {
+		return err
+	}
+	*b, err = base64.StdEncoding.DecodeString(encoded.Base64)
+	return err
+}
+
+// file is the on-disk form of a cassette.
+type file struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Matcher reports whether a recorded request answers req. Both have been
+// scrubbed, so secrets don't need to match.
+type Matcher func(req Request, recorded Request) bool
+
+// DefaultMatcher matches requests with the same method, URL and body. JSON
+// bodies are compared by their content, so that the order of their fields
+// doesn't matter, and multipart bodies, whose boundaries are random, aren't
+// compared at all.
+func DefaultMatcher(req Request, recorded Request) bool {
+	if req.Method != recorded.Method || req.URL != recorded.URL {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if strings.HasPrefix(mediaType, "multipart/") {
+		return true
+	}
+	if bytes.Equal(req.Body, recorded.Body) {
+		return true
+	}
+	var a, b any
+	if json.Unmarshal(req.Body, &a) != nil || json.Unmarshal(recorded.Body, &b) != nil {
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// secretHeaders carry credentials, and are always scrubbed.
+var secretHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Api-Key",
+	"X-Api-Key",
+	"X-Goog-Api-Key",
+	"Cookie",
+	"Set-Cookie",
+	"Openai-Organization",
+	"Openai-Project",
+}
+
+// secretParams carry credentials in URLs, and are always scrubbed.
+var secretParams = []string{"key", "api_key", "apikey", "access_token", "token"}
+
+// secretPatterns match the API keys and tokens of the supported providers
+// wherever they appear.
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`sk-[A-Za-z0-9_\-]{16,}`),
+	regexp.MustCompile(`ya29\.[A-Za-z0-9_\-.]+`),
+	regexp.MustCompile(`AIza[A-Za-z0-9_\-]{35}`),
+}
+
+// Recorder is an [http.RoundTripper] that records interactions to a cassette
+// or replays them from it. It is safe for concurrent use.
+type Recorder struct {
+	path      string
+	mode      Mode
+	transport http.RoundTripper
+	matcher   Matcher
+
+	mu           sync.Mutex
+	replacements []string
+	patterns     []*regexp.Regexp
+	interactions []Interaction
+	used         []bool
+}
+
+// New returns a Recorder for the cassette at path. In [Replay] mode the
+// cassette must exist.
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{
+		path:      path,
+		mode:      mode,
+		transport: http.DefaultTransport,
+		matcher:   DefaultMatcher,
+		patterns:  secretPatterns,
+	}
+	if mode == Record {
+		return r, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) && mode == ReplayOrRecord {
+		r.mode = Record
+		return r, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %w", path, err)
+	}
+	if f.Version < 1 || f.Version > Version {
+		return nil, fmt.Errorf("unsupported cassette version %d in %s", f.Version, path)
+	}
+	r.mode = Replay
+	r.interactions = f.Interactions
+	r.used = make([]bool, len(f.Interactions))
+	return r, nil
+}
+
+// Recording reports whether the recorder makes requests for real.
+func (r *Recorder) Recording() bool {
+	return r.mode == Record
+}
+
+// SetTransport sets what makes requests for real while recording. The
+// default is [http.DefaultTransport].
+func (r *Recorder) SetTransport(t http.RoundTripper) {
+	r.transport = t
+}
+
+// SetMatcher sets how requests are matched with recordings while replaying.
+// The default is [DefaultMatcher].
+func (r *Recorder) SetMatcher(m Matcher) {
+	r.matcher = m
+}
+
+// Scrub replaces secrets, such as API keys, wherever they appear in the
+// cassette, along with the headers, URL parameters and provider keys that are
+// always scrubbed. Empty secrets are ignored.
+func (r *Recorder) Scrub(secrets ...string) {
+	for _, s := range secrets {
+		r.ScrubAs(s, Redacted)
+	}
+}
+
+// ScrubAs replaces secret with placeholder wherever it appears, such as a
+// project ID in a URL, so that the requests made while replaying can use the
+// placeholder and still match.
+func (r *Recorder) ScrubAs(secret, placeholder string) {
+	if secret == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.replacements = append(r.replacements, secret, placeholder)
+}
+
+// ScrubPattern replaces anything matching patterns wherever it appears.
+func (r *Recorder) ScrubPattern(patterns ...*regexp.Regexp) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.patterns = append(r.patterns[:len(r.patterns):len(r.patterns)], patterns...)
+}
+
+// Client returns an HTTP client that makes its requests through the
+// recorder.
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// RoundTrip records or replays req.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	recorded := r.scrubRequest(Request{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: req.Header,
+		Body:   body,
+	})
+	if r.mode == Record {
+		return r.record(req, recorded)
+	}
+	return r.replay(req, recorded)
+}
+
+func (r *Recorder) record(req *http.Request, recorded Request) (*http.Response, error) {
+	resp, err := r.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	response := Response{
+		StatusCode: resp.StatusCode,
+		Header:     r.scrubHeader(resp.Header),
+		Body:       Body(r.scrub(string(body))),
+	}
+	// Scrubbing can change the body's length, which is given when it is
+	// replayed.
+	response.Header.Del("Content-Length")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, Interaction{Request: recorded, Response: response})
+	return resp, nil
+}
+
+func (r *Recorder) replay(req *http.Request, recorded Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for n, i := range r.interactions {
+		if r.used[n] || !r.matcher(recorded, i.Request) {
+			continue
+		}
+		r.used[n] = true
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", i.Response.StatusCode, http.StatusText(i.Response.StatusCode)),
+			StatusCode:    i.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        i.Response.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader(i.Response.Body)),
+			ContentLength: int64(len(i.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("cassette %s has no recording of %s %s", r.path, recorded.Method, recorded.URL)
+}
+
+// Close saves the cassette, if recording, replacing it only once it has
+// been written in full.
+func (r *Recorder) Close() error {
+	if r.mode != Record {
+		return nil
+	}
+	r.mu.Lock()
+	f := file{Version: Version, Interactions: r.interactions}
+	r.mu.Unlock()
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(r.path), 0o755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.path), filepath.Base(r.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.path)
+}
+
+// scrubRequest removes secrets from a request's URL, headers and body.
+func (r *Recorder) scrubRequest(req Request) Request {
+	if u, err := url.Parse(req.URL); err == nil {
+		query := u.Query()
+		scrubbed := false
+		for _, p := range secretParams {
+			if query.Has(p) {
+				query.Set(p, Redacted)
+				scrubbed = true
+			}
+		}
+		if scrubbed {
+			u.RawQuery = query.Encode()
+			req.URL = u.String()
+		}
+	}
+	req.URL = r.scrub(req.URL)
+	req.Header = r.scrubHeader(req.Header)
+	req.Body = Body(r.scrub(string(req.Body)))
+	return req
+}
+
+// scrubHeader returns a copy of h without secrets.
+func (r *Recorder) scrubHeader(h http.Header) http.Header {
+	if len(h) == 0 {
+		return nil
+	}
+	h = h.Clone()
+	for _, name := range secretHeaders {
+		if values := h.Values(name); len(values) > 0 {
+			h[http.CanonicalHeaderKey(name)] = []string{Redacted}
+		}
+	}
+	for name, values := range h {
+		for n, v := range values {
+			values[n] = r.scrub(v)
+		}
+		h[name] = values
+	}
+	return h
+}
+
+// scrub replaces the secrets and patterns given to the recorder in text.
+func (r *Recorder) scrub(text string) string {
+	r.mu.Lock()
+	replacements, patterns := r.replacements, r.patterns
+	r.mu.Unlock()
+	if len(replacements) > 0 {
+		text = strings.NewReplacer(replacements...).Replace(text)
+	}
+	for _, p := range patterns {
+		text = p.ReplaceAllString(text, Redacted)
+	}
+	return text
+}
@@ -4,10 +4,13 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/mr-joshcrane/goracle/client/anthropic"
 	"github.com/mr-joshcrane/goracle/client/google"
+	"github.com/mr-joshcrane/goracle/client/llm"
 	"github.com/mr-joshcrane/goracle/client/ollama"
 	"github.com/mr-joshcrane/goracle/client/openai"
 )
@@ -17,15 +20,35 @@ type Prompt interface {
 	GetPurpose() string
 	GetHistory() ([]string, []string)
 	GetQuestion() string
-	GetReferences() [][]byte
+	GetReferences() []llm.Reference
 	GetResponseFormat() []string
+	GetResponseSchema() map[string]any
+	GetTools() []llm.Tool
+	GetToolTurns() []llm.ToolTurn
+	GetOptions() llm.Options
 }
 
 // --- Dummy Client
 type Dummy struct {
+	mu            sync.Mutex
 	fixedResponse string
 	Failure       error
 	P             Prompt
+	// Calls counts the completions requested, so that middleware such as
+	// retries and caching can be observed.
+	Calls int
+	// ToolCalls are requested on the first step of each question, before
+	// the fixed response is given once their results have been seen.
+	ToolCalls []llm.ToolCall
+	// Responses are given in order, one per completion, before falling
+	// back to the fixed response.
+	Responses []string
+	// Errors are returned in order, one per completion, before any
+	// responses are given. A nil entry lets that completion succeed.
+	Errors []error
+	// TokenUsage is reported by every completion, priced at Price.
+	TokenUsage llm.Usage
+	Price      llm.Pricing
 }
 
 func NewDummyClient(fixedResponse string, err error) *Dummy {
@@ -36,15 +59,91 @@ func NewDummyClient(fixedResponse string, err error) *Dummy {
 }
 
 func (d *Dummy) Completion(ctx context.Context, prompt Prompt) (io.Reader, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Calls++
 	d.P = prompt
-	return strings.NewReader(d.fixedResponse), d.Failure
+	if len(d.Errors) > 0 {
+		err := d.Errors[0]
+		d.Errors = d.Errors[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	if d.Failure != nil {
+		return nil, d.Failure
+	}
+	if len(d.ToolCalls) > 0 && len(prompt.GetToolTurns()) == 0 {
+		return llm.Once(llm.Delta{ToolCalls: d.ToolCalls}), nil
+	}
+	response := d.fixedResponse
+	if len(d.Responses) > 0 {
+		response = d.Responses[0]
+		d.Responses = d.Responses[1:]
+	}
+	if d.TokenUsage != (llm.Usage{}) {
+		usage := d.TokenUsage
+		usage.Cost = d.Price.Cost(usage)
+		return llm.Once(llm.Delta{Text: response, Usage: usage}), nil
+	}
+	return strings.NewReader(response), nil
+}
+
+// Pricing returns the price the Dummy charges for its tokens.
+func (d *Dummy) Pricing() llm.Pricing {
+	return d.Price
+}
+
+// --- Usage
+
+// meter adds up the usage of every completion a client returns. Clients
+// embed it to report their running totals.
+type meter struct {
+	mu    sync.Mutex
+	total llm.Usage
+}
+
+// Usage returns the tokens used and their cost across every completion read
+// so far.
+func (m *meter) Usage() llm.Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.total
+}
+
+// measure wraps a completion so that its usage reports what it cost at
+// price, and is added to the meter once the completion has been read.
+func (m *meter) measure(data io.Reader, price llm.Pricing) io.Reader {
+	r := &metered{price: price}
+	r.Observed = llm.Observe(data, func(int, error) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.total = m.total.Add(r.Usage())
+	})
+	return r
+}
+
+// metered is a completion that knows the price of its tokens.
+type metered struct {
+	*llm.Observed
+	price llm.Pricing
+}
+
+func (r *metered) Usage() llm.Usage {
+	u := r.Observed.Usage()
+	u.Cost = r.price.Cost(u)
+	return u
 }
 
 // --- ChatGPT Client
 
 type ChatGPT struct {
+	meter
 	Token string
 	Model openai.ModelConfig
+	// HTTPClient makes the client's requests, such as one that records or
+	// replays them. The default is [http.DefaultClient].
+	HTTPClient *http.Client
 }
 
 func NewChatGPT(token string) *ChatGPT {
@@ -67,28 +166,51 @@ func (c *ChatGPT) WithModel(model string) error {
 	return nil
 }
 
+// ContextWindow returns the number of tokens the current model accepts.
+func (c *ChatGPT) ContextWindow() int {
+	return c.Model.ContextWindow
+}
+
+// ModelName returns the name of the model completions are requested from.
+func (c *ChatGPT) ModelName() string {
+	return c.Model.Name
+}
+
 func (c *ChatGPT) Completion(ctx context.Context, prompt Prompt) (io.Reader, error) {
-	return openai.Do(ctx, c.Token, c.Model, prompt)
+	data, err := openai.Do(ctx, c.HTTPClient, c.Token, c.Model, prompt)
+	if err != nil {
+		return nil, err
+	}
+	return c.measure(data, c.Model.Price), nil
+}
+
+// Pricing returns the price of the current model.
+func (c *ChatGPT) Pricing() llm.Pricing {
+	return c.Model.Price
 }
 
 func (c *ChatGPT) CreateImage(ctx context.Context, prompt string) ([]byte, error) {
-	return openai.DoImageRequest(ctx, c.Token, prompt)
+	return openai.DoImageRequest(ctx, c.HTTPClient, c.Token, prompt)
 }
 
 func (c *ChatGPT) CreateTranscript(ctx context.Context, audio []byte) (string, error) {
-	return openai.SpeechToText(ctx, c.Token, audio)
+	return openai.SpeechToText(ctx, c.HTTPClient, c.Token, audio)
 }
 
 func (c *ChatGPT) CreateAudio(ctx context.Context, text string) ([]byte, error) {
-	return openai.TextToSpeech(ctx, c.Token, text)
+	return openai.TextToSpeech(ctx, c.HTTPClient, c.Token, text)
 }
 
 // --- Vertex client
 
 type Vertex struct {
+	meter
 	Token     string
 	ProjectID string
 	Model     google.ModelConfig
+	// HTTPClient makes the client's requests. The default is
+	// [http.DefaultClient].
+	HTTPClient *http.Client
 }
 
 func NewVertex() *Vertex {
@@ -110,6 +232,16 @@ func (v *Vertex) WithModel(model string) error {
 	return nil
 }
 
+// ContextWindow returns the number of tokens the current model accepts.
+func (v *Vertex) ContextWindow() int {
+	return v.Model.ContextWindow
+}
+
+// ModelName returns the name of the model completions are requested from.
+func (v *Vertex) ModelName() string {
+	return v.Model.Name
+}
+
 func (v *Vertex) Completion(ctx context.Context, prompt Prompt) (io.Reader, error) {
 	if v.ProjectID == "" || v.Token == "" {
 		project, token, err := google.Authenticate()
@@ -119,14 +251,27 @@ func (v *Vertex) Completion(ctx context.Context, prompt Prompt) (io.Reader, erro
 		v.ProjectID = project
 		v.Token = token
 	}
-	return google.Completion(ctx, v.Token, v.ProjectID, v.Model, prompt)
+	data, err := google.Completion(ctx, v.HTTPClient, v.Token, v.ProjectID, v.Model, prompt)
+	if err != nil {
+		return nil, err
+	}
+	return v.measure(data, v.Model.Price), nil
+}
+
+// Pricing returns the price of the current model.
+func (v *Vertex) Pricing() llm.Pricing {
+	return v.Model.Price
 }
 
 // --- Anthropic client
 
 type Anthropic struct {
+	meter
 	Token string
 	Model anthropic.ModelConfig
+	// HTTPClient makes the client's requests. The default is
+	// [http.DefaultClient].
+	HTTPClient *http.Client
 }
 
 func NewAnthropic(token string) *Anthropic {
@@ -149,6 +294,16 @@ func (a *Anthropic) WithModel(model string) error {
 	return nil
 }
 
+// ContextWindow returns the number of tokens the current model accepts.
+func (a *Anthropic) ContextWindow() int {
+	return a.Model.ContextWindow
+}
+
+// ModelName returns the name of the model completions are requested from.
+func (a *Anthropic) ModelName() string {
+	return a.Model.Name
+}
+
 func (a *Anthropic) Completion(ctx context.Context, prompt Prompt) (io.Reader, error) {
 	if a.Token == "" {
 		token, err := anthropic.Authenticate()
@@ -157,14 +312,27 @@ func (a *Anthropic) Completion(ctx context.Context, prompt Prompt) (io.Reader, e
 		}
 		a.Token = token
 	}
-	return anthropic.Completion(ctx, a.Token, a.Model, prompt)
+	data, err := anthropic.Completion(ctx, a.HTTPClient, a.Token, a.Model, prompt)
+	if err != nil {
+		return nil, err
+	}
+	return a.measure(data, a.Model.Price), nil
+}
+
+// Pricing returns the price of the current model.
+func (a *Anthropic) Pricing() llm.Pricing {
+	return a.Model.Price
 }
 
 // --- Ollama client
 
 type Ollama struct {
+	meter
 	Model    string
 	Endpoint string
+	// HTTPClient makes the client's requests. The default is
+	// [http.DefaultClient].
+	HTTPClient *http.Client
 }
 
 func NewOllama(model string, endpoint string) *Ollama {
@@ -174,14 +342,30 @@ func NewOllama(model string, endpoint string) *Ollama {
 	}
 }
 
+// ModelName returns the name of the model completions are requested from.
+func (o *Ollama) ModelName() string {
+	return o.Model
+}
+
 func (o *Ollama) Completion(ctx context.Context, prompt Prompt) (io.Reader, error) {
-	answer, err := ollama.DoChatCompletion(o.Model, o.Endpoint, prompt)
+	data, err := ollama.DoChatCompletion(ctx, o.HTTPClient, o.Model, o.Endpoint, prompt)
 	if err != nil {
 		return nil, err
 	}
-	return strings.NewReader(answer), nil
+	return o.measure(data, llm.Pricing{}), nil
 }
 
+// GenerateEmbedding returns a vector for the prompt's question.
+//
+// Deprecated: Use an [OllamaEmbedder], which embeds many texts at once.
 func (o *Ollama) GenerateEmbedding(ctx context.Context, prompt Prompt) ([]float64, error) {
-	return ollama.GetEmbedding(o.Model, o.Endpoint, prompt)
+	vectors, err := ollama.Embed(ctx, o.HTTPClient, o.Model, o.Endpoint, 0, []string{prompt.GetQuestion()})
+	if err != nil {
+		return nil, err
+	}
+	embedding := make([]float64, len(vectors[0]))
+	for i, x := range vectors[0] {
+		embedding[i] = float64(x)
+	}
+	return embedding, nil
 }
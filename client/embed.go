@@ -0,0 +1,192 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/mr-joshcrane/goracle/client/google"
+	"github.com/mr-joshcrane/goracle/client/ollama"
+	"github.com/mr-joshcrane/goracle/client/openai"
+)
+
+// --- ChatGPT Embedder
+
+type ChatGPTEmbedder struct {
+	Token string
+	Model openai.EmbeddingModelConfig
+	// HTTPClient makes the embedder's requests. The default is
+	// [http.DefaultClient].
+	HTTPClient *http.Client
+	dimensions int
+}
+
+func NewChatGPTEmbedder(token string) *ChatGPTEmbedder {
+	return &ChatGPTEmbedder{
+		Token: token,
+		Model: openai.EmbeddingModels["text-embedding-3-small"],
+	}
+}
+
+// WithModel switches to another embedding model, at its full dimensions.
+func (e *ChatGPTEmbedder) WithModel(model string) error {
+	m, ok := openai.EmbeddingModels[model]
+	if !ok {
+		supportedModels := make([]string, 0, len(openai.EmbeddingModels))
+		for k := range openai.EmbeddingModels {
+			supportedModels = append(supportedModels, k)
+		}
+		return fmt.Errorf("model %s not found. Supported models include: %s", model, strings.Join(supportedModels, ", "))
+	}
+	e.Model = m
+	e.dimensions = 0
+	return nil
+}
+
+// WithDimensions shortens the vectors returned, if the model supports it.
+func (e *ChatGPTEmbedder) WithDimensions(dimensions int) error {
+	if dimensions <= 0 || dimensions > e.Model.Dimensions {
+		return fmt.Errorf("model %s returns between 1 and %d dimensions, not %d", e.Model.Name, e.Model.Dimensions, dimensions)
+	}
+	if dimensions != e.Model.Dimensions && !e.Model.Shortenable {
+		return fmt.Errorf("model %s only returns vectors of %d dimensions", e.Model.Name, e.Model.Dimensions)
+	}
+	e.dimensions = dimensions
+	return nil
+}
+
+// Dimensions returns the length of the vectors returned.
+func (e *ChatGPTEmbedder) Dimensions() int {
+	if e.dimensions != 0 {
+		return e.dimensions
+	}
+	return e.Model.Dimensions
+}
+
+func (e *ChatGPTEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return openai.Embed(ctx, e.HTTPClient, e.Token, e.Model, e.dimensions, texts)
+}
+
+// --- Vertex Embedder
+
+type VertexEmbedder struct {
+	Token     string
+	ProjectID string
+	Model     google.EmbeddingModelConfig
+	// HTTPClient makes the embedder's requests. The default is
+	// [http.DefaultClient].
+	HTTPClient *http.Client
+	dimensions int
+}
+
+func NewVertexEmbedder() *VertexEmbedder {
+	return &VertexEmbedder{
+		Model: google.EmbeddingModels["TextEmbedding"],
+	}
+}
+
+// WithModel switches to another embedding model, at its full dimensions.
+func (e *VertexEmbedder) WithModel(model string) error {
+	m, ok := google.EmbeddingModels[model]
+	if !ok {
+		supportedModels := make([]string, 0, len(google.EmbeddingModels))
+		for k := range google.EmbeddingModels {
+			supportedModels = append(supportedModels, k)
+		}
+		return fmt.Errorf("model %s not found. Supported models include: %s", model, strings.Join(supportedModels, ", "))
+	}
+	e.Model = m
+	e.dimensions = 0
+	return nil
+}
+
+// WithDimensions shortens the vectors returned.
+func (e *VertexEmbedder) WithDimensions(dimensions int) error {
+	if dimensions <= 0 || dimensions > e.Model.Dimensions {
+		return fmt.Errorf("model %s returns between 1 and %d dimensions, not %d", e.Model.Name, e.Model.Dimensions, dimensions)
+	}
+	e.dimensions = dimensions
+	return nil
+}
+
+// Dimensions returns the length of the vectors returned.
+func (e *VertexEmbedder) Dimensions() int {
+	if e.dimensions != 0 {
+		return e.dimensions
+	}
+	return e.Model.Dimensions
+}
+
+func (e *VertexEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if e.ProjectID == "" || e.Token == "" {
+		project, token, err := google.Authenticate()
+		if err != nil {
+			return nil, err
+		}
+		e.ProjectID = project
+		e.Token = token
+	}
+	return google.Embed(ctx, e.HTTPClient, e.Token, e.ProjectID, e.Model, e.dimensions, texts)
+}
+
+// --- Ollama Embedder
+
+type OllamaEmbedder struct {
+	Model    string
+	Endpoint string
+	// HTTPClient makes the embedder's requests. The default is
+	// [http.DefaultClient].
+	HTTPClient *http.Client
+
+	mu         sync.Mutex
+	dimensions int
+	seen       int
+}
+
+func NewOllamaEmbedder(model string, endpoint string) *OllamaEmbedder {
+	return &OllamaEmbedder{
+		Model:    model,
+		Endpoint: endpoint,
+	}
+}
+
+// WithDimensions shortens the vectors returned, for models that support it.
+func (e *OllamaEmbedder) WithDimensions(dimensions int) error {
+	if dimensions <= 0 {
+		return fmt.Errorf("dimensions must be positive, not %d", dimensions)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.dimensions = dimensions
+	return nil
+}
+
+// Dimensions returns the length of the vectors returned. Ollama doesn't say
+// how long a model's vectors are, so unless dimensions have been set, this
+// is 0 until something has been embedded.
+func (e *OllamaEmbedder) Dimensions() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.dimensions != 0 {
+		return e.dimensions
+	}
+	return e.seen
+}
+
+func (e *OllamaEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.mu.Lock()
+	dimensions := e.dimensions
+	e.mu.Unlock()
+	vectors, err := ollama.Embed(ctx, e.HTTPClient, e.Model, e.Endpoint, dimensions, texts)
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) > 0 {
+		e.mu.Lock()
+		e.seen = len(vectors[0])
+		e.mu.Unlock()
+	}
+	return vectors, nil
+}
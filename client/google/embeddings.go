@@ -0,0 +1,142 @@
+package google
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+// EmbeddingModelConfig describes a model that turns text into vectors.
+type EmbeddingModelConfig struct {
+	Name string
+	// Dimensions is the length of the vectors the model returns. Any shorter
+	// length can be asked for instead, at some cost to accuracy.
+	Dimensions int
+	// MaxBatch is the most texts the model embeds in a single request.
+	MaxBatch int
+	// Price is what the model costs per million input tokens.
+	Price llm.Pricing
+}
+
+var EmbeddingModels = map[string]EmbeddingModelConfig{
+	"GeminiEmbedding": {
+		Name:       "gemini-embedding-001",
+		Dimensions: 3072,
+		MaxBatch:   1,
+		Price:      llm.Pricing{InputPerMillion: 0.15},
+	},
+	"TextEmbedding": {
+		Name:       "text-embedding-005",
+		Dimensions: 768,
+		MaxBatch:   250,
+		Price:      llm.Pricing{InputPerMillion: 0.025},
+	},
+	"TextMultilingualEmbedding": {
+		Name:       "text-multilingual-embedding-002",
+		Dimensions: 768,
+		MaxBatch:   250,
+		Price:      llm.Pricing{InputPerMillion: 0.025},
+	},
+}
+
+type EmbeddingInstance struct {
+	Content string `json:"content"`
+}
+
+type EmbeddingParameters struct {
+	OutputDimensionality int  `json:"outputDimensionality,omitempty"`
+	AutoTruncate         bool `json:"autoTruncate"`
+}
+
+type EmbeddingRequest struct {
+	Instances  []EmbeddingInstance `json:"instances"`
+	Parameters EmbeddingParameters `json:"parameters"`
+}
+
+type EmbeddingResponse struct {
+	Predictions []struct {
+		Embeddings struct {
+			Values []float32 `json:"values"`
+		} `json:"embeddings"`
+	} `json:"predictions"`
+}
+
+// Embed returns a vector for each of texts, in order, of the given
+// dimensions. Zero dimensions means the model's full size. Shortened vectors
+// aren't normalised by Vertex AI, so every vector is normalised here. A nil
+// httpClient means [http.DefaultClient].
+func Embed(ctx context.Context, httpClient *http.Client, token string, projectID string, model EmbeddingModelConfig, dimensions int, texts []string) ([][]float32, error) {
+	if dimensions > model.Dimensions {
+		return nil, fmt.Errorf("model %s returns at most %d dimensions", model.Name, model.Dimensions)
+	}
+	vectors := make([][]float32, 0, len(texts))
+	for batch := range slices.Chunk(texts, max(1, model.MaxBatch)) {
+		req, err := CreateEmbeddingRequest(token, projectID, model, dimensions, batch)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := llm.HTTPClient(httpClient).Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		embedded, err := ParseEmbeddingResponse(*resp)
+		if err != nil {
+			return nil, err
+		}
+		if len(embedded) != len(batch) {
+			return nil, fmt.Errorf("asked for %d embeddings, got %d", len(batch), len(embedded))
+		}
+		for _, v := range embedded {
+			vectors = append(vectors, llm.Normalize(v))
+		}
+	}
+	return vectors, nil
+}
+
+func CreateEmbeddingRequest(token string, projectID string, model EmbeddingModelConfig, dimensions int, texts []string) (*http.Request, error) {
+	URI := fmt.Sprintf("https://us-central1-aiplatform.googleapis.com/v1/projects/%s/locations/us-central1/publishers/google/models/%s:predict", projectID, model.Name)
+	body := EmbeddingRequest{
+		Parameters: EmbeddingParameters{
+			OutputDimensionality: dimensions,
+			AutoTruncate:         true,
+		},
+	}
+	for _, text := range texts {
+		body.Instances = append(body.Instances, EmbeddingInstance{Content: text})
+	}
+	d, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, URI, bytes.NewReader(d))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Authorization", "Bearer "+token)
+	req.Header.Add("Content-Type", "application/json; charset=utf-8")
+	return req, nil
+}
+
+// ParseEmbeddingResponse returns the vectors in the order their texts were
+// given.
+func ParseEmbeddingResponse(resp http.Response) ([][]float32, error) {
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+	defer resp.Body.Close()
+	var embeddings EmbeddingResponse
+	err := json.NewDecoder(resp.Body).Decode(&embeddings)
+	if err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, len(embeddings.Predictions))
+	for i, p := range embeddings.Predictions {
+		vectors[i] = p.Embeddings.Values
+	}
+	return vectors, nil
+}
@@ -10,29 +10,106 @@ import (
 	"net/http"
 	"os/exec"
 	"strings"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+	"github.com/mr-joshcrane/goracle/client/pdf"
 )
 
 type Role string
 
 var (
-	User Role = "USER"
-	Bot  Role = "ASSISTANT"
+	User Role = "user"
+	Bot  Role = "model"
 )
 
 type Prompt interface {
 	GetPurpose() string
 	GetHistory() ([]string, []string)
 	GetQuestion() string
-	GetReferences() [][]byte
+	GetReferences() []llm.Reference
+	GetResponseSchema() map[string]any
+	GetTools() []llm.Tool
+	GetToolTurns() []llm.ToolTurn
+	GetOptions() llm.Options
 }
 
 type ChatMessage struct {
-	Role  Role        `json:"role"`
-	Parts MessagePart `json:"parts"`
+	Role  Role          `json:"role"`
+	Parts []MessagePart `json:"parts"`
 }
 
 type MessagePart struct {
-	Text string `json:"text,omitempty"`
+	Text             string            `json:"text,omitempty"`
+	InlineData       *VisualInlineData `json:"inlineData,omitempty"`
+	FunctionCall     *FunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *FunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type FunctionCall struct {
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args"`
+}
+
+type FunctionResponse struct {
+	Name     string         `json:"name"`
+	Response map[string]any `json:"response"`
+}
+
+// Tool groups the function declarations the model may call.
+type Tool struct {
+	FunctionDeclarations []FunctionDeclaration `json:"functionDeclarations"`
+}
+
+type FunctionDeclaration struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters"`
+}
+
+func toolDefinitions(tools []llm.Tool) []Tool {
+	if len(tools) == 0 {
+		return nil
+	}
+	declarations := []FunctionDeclaration{}
+	for _, t := range tools {
+		declarations = append(declarations, FunctionDeclaration{
+			Name:        t.Name,
+			Description: t.Description,
+			Parameters:  responseSchema(t.Parameters),
+		})
+	}
+	return []Tool{{FunctionDeclarations: declarations}}
+}
+
+// toolTurnMessages gives each tool turn as one model content holding all of
+// its calls, followed by one user content holding all of their results, as
+// Gemini expects when several functions are called at once.
+func toolTurnMessages(turns []llm.ToolTurn) []ChatMessage {
+	messages := []ChatMessage{}
+	for _, turn := range turns {
+		calls := []MessagePart{}
+		if turn.Text != "" {
+			calls = append(calls, MessagePart{Text: turn.Text})
+		}
+		for _, c := range turn.Calls {
+			calls = append(calls, MessagePart{FunctionCall: &FunctionCall{Name: c.Name, Args: c.Arguments}})
+		}
+		results := []MessagePart{}
+		for _, r := range turn.Results {
+			response := map[string]any{"content": r.Content}
+			if r.IsError {
+				response = map[string]any{"error": r.Content}
+			}
+			results = append(results, MessagePart{FunctionResponse: &FunctionResponse{Name: r.Name, Response: response}})
+		}
+		if len(calls) > 0 {
+			messages = append(messages, ChatMessage{Role: Bot, Parts: calls})
+		}
+		if len(results) > 0 {
+			messages = append(messages, ChatMessage{Role: User, Parts: results})
+		}
+	}
+	return messages
 }
 
 // More serious authentication methods left as an exercise to the reader
@@ -58,194 +135,279 @@ func MessagesFromPrompt(prompt Prompt) []ChatMessage {
 	messages := []ChatMessage{
 		{
 			Role:  User,
-			Parts: MessagePart{Text: "SYSTEM: USER PROVIDED PURPOSE: " + prompt.GetPurpose()},
+			Parts: []MessagePart{{Text: "SYSTEM: USER PROVIDED PURPOSE: " + prompt.GetPurpose()}},
 		},
 		{
 			Role:  Bot,
-			Parts: MessagePart{Text: "Understood!"},
+			Parts: []MessagePart{{Text: "Understood!"}},
 		},
 	}
 	idealInputs, idealOutputs := prompt.GetHistory()
 	for i, idealInput := range idealInputs {
 		messages = append(messages, ChatMessage{
 			Role:  User,
-			Parts: MessagePart{Text: idealInput},
+			Parts: []MessagePart{{Text: idealInput}},
 		})
 		messages = append(messages, ChatMessage{
 			Role:  Bot,
-			Parts: MessagePart{Text: idealOutputs[i]},
+			Parts: []MessagePart{{Text: idealOutputs[i]}},
 		})
 	}
 	for i, ref := range prompt.GetReferences() {
-		if isPNG(ref) {
+		if pdf.IsPDF(ref) {
 			messages = append(messages, ChatMessage{
 				Role:  User,
-				Parts: MessagePart{Text: string(ref)},
+				Parts: []MessagePart{{Text: fmt.Sprintf("SYSTEM: USER PROVIDED DOCUMENT %d%s:", i+1, nameSuffix(ref))}},
+			})
+			messages = append(messages, ChatMessage{
+				Role: User,
+				Parts: []MessagePart{{InlineData: &VisualInlineData{
+					MimeType: pdf.MIMEType,
+					Data:     base64.StdEncoding.EncodeToString(ref.Data),
+				}}},
+			})
+			messages = append(messages, ChatMessage{
+				Role:  Bot,
+				Parts: []MessagePart{{Text: "Understood. I will refer to this document in my future answers!"}},
+			})
+			continue
+		}
+		if mimeType := llm.ImageType(ref.Data); mimeType != "" {
+			if ref.Name != "" {
+				messages = append(messages, ChatMessage{
+					Role:  User,
+					Parts: []MessagePart{{Text: fmt.Sprintf("SYSTEM: USER PROVIDED IMAGE %d (%s):", i+1, ref.Name)}},
+				})
+			}
+			messages = append(messages, ChatMessage{
+				Role: User,
+				Parts: []MessagePart{{InlineData: &VisualInlineData{
+					MimeType: mimeType,
+					Data:     base64.StdEncoding.EncodeToString(ref.Data),
+				}}},
 			})
 			continue
 		}
 		messages = append(messages, ChatMessage{
 			Role:  User,
-			Parts: MessagePart{Text: fmt.Sprintf("SYSTEM: USER PROVIDED FILE %d: %s", i+1, string(ref))},
+			Parts: []MessagePart{{Text: fmt.Sprintf("SYSTEM: USER PROVIDED FILE %d%s: %s", i+1, nameSuffix(ref), string(ref.Data))}},
 		})
 		messages = append(messages, ChatMessage{
 			Role:  Bot,
-			Parts: MessagePart{Text: "Understood. I will refer to this text in my future answers!"},
+			Parts: []MessagePart{{Text: "Understood. I will refer to this text in my future answers!"}},
 		})
 	}
 	messages = append(messages, ChatMessage{
 		Role:  User,
-		Parts: MessagePart{Text: prompt.GetQuestion()},
+		Parts: []MessagePart{{Text: prompt.GetQuestion()}},
 	})
-	return messages
+	return append(messages, toolTurnMessages(prompt.GetToolTurns())...)
 }
 
-func textCompletion(ctx context.Context, token string, projectID string, model ModelConfig, messages []ChatMessage) (io.Reader, error) {
-	req, err := CreateVertexTextCompletionRequest(token, projectID, model, messages)
-	if err != nil {
-		return nil, err
+// nameSuffix gives a reference's name in brackets, if it has one, so that
+// answers can point at it.
+func nameSuffix(ref llm.Reference) string {
+	if ref.Name == "" {
+		return ""
 	}
-	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
-	if err != nil {
-		return nil, err
-	}
-	answer, err := ParseVertexTextCompletionResponse(*resp)
+	return fmt.Sprintf(" (%s)", ref.Name)
+}
+
+func textCompletion(ctx context.Context, httpClient *http.Client, token string, projectID string, model ModelConfig, messages []ChatMessage, prompt Prompt) (io.Reader, error) {
+	config, err := generationConfig(model, prompt.GetOptions())
 	if err != nil {
 		return nil, err
 	}
-	return answer, err
-}
-
-func visionCompletion(ctx context.Context, token string, projectID string, model ModelConfig, messages []ChatMessage) (io.Reader, error) {
-	URI := fmt.Sprintf("https://us-central1-aiplatform.googleapis.com/v1/projects/%s/locations/us-central1/publishers/%s/models/%s:streamGenerateContent", projectID, model.Provider, model.Name)
-	payload := VisualCompletionRequest{
-		GenerationConfig: GenerationConfig{
-			MaxOutputTokens: 1024,
-			Temperature:     0.0,
-			TopP:            0.8,
-			TopK:            40,
-		},
-		Contents: []VisualRequestContents{
-			{
-				Role:  User,
-				Parts: []any{},
-			},
-		},
+	body := TextCompletionRequest{
+		Contents:         messages,
+		GenerationConfig: config,
+		Tools:            toolDefinitions(prompt.GetTools()),
 	}
-	payload.Contents[0].Parts = append(payload.Contents[0].Parts, struct {
-		Text string `json:"text"`
-	}{
-		Text: "",
-	})
-
-	var text string
-	for _, message := range messages {
-		if isPNG([]byte(message.Parts.Text)) {
-			payload.Contents[0].Parts = append(payload.Contents[0].Parts, struct {
-				InlineData VisualInlineData `json:"inlineData,omitempty"`
-			}{
-				InlineData: VisualInlineData{
-					MimeType: "image/png",
-					Data:     base64.StdEncoding.EncodeToString([]byte(message.Parts.Text)),
-				},
-			})
-		} else {
-			text += "\n" + message.Parts.Text
-		}
+	if schema := prompt.GetResponseSchema(); schema != nil {
+		body.GenerationConfig.ResponseMimeType = "application/json"
+		body.GenerationConfig.ResponseSchema = responseSchema(schema)
 	}
-	payload.Contents[0].Parts[0] = struct {
-		Text string `json:"text"`
-	}{Text: text}
-
-	d, err := json.Marshal(payload)
+	req, err := newVertexRequest(token, projectID, model, body)
 	if err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest(http.MethodPost, URI, bytes.NewReader(d))
+	resp, err := llm.HTTPClient(httpClient).Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Authorization", "Bearer "+token)
-	req.Header.Add("Content-Type", "application/json; charset=utf-8")
-	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	return ParseVertexTextCompletionResponse(*resp)
+}
+
+func Completion(ctx context.Context, httpClient *http.Client, token string, projectID string, model ModelConfig, prompt Prompt) (io.Reader, error) {
+	// Use the passed in token and projectID
+	refs, err := prepareReferences(model, prompt.GetReferences())
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad status code: %d; %s", resp.StatusCode, resp.Status)
-	}
-	defer resp.Body.Close()
-	body := []struct {
-		Candidates []struct {
-			Content struct {
-				Role  string `json:"role"`
-				Parts []struct {
-					Text string `json:"text"`
-				} `json:"parts"`
-			} `json:"content"`
-		} `json:"candidates"`
-	}{}
-
-	err = json.NewDecoder(resp.Body).Decode(&body)
+	prompt = withReferences{Prompt: prompt, references: refs}
+	// Images go inline among the other messages, so that tools and response
+	// schemas apply to requests with images too.
+	answer, err := textCompletion(ctx, httpClient, token, projectID, model, MessagesFromPrompt(prompt), prompt)
 	if err != nil {
 		return nil, err
 	}
+	return answer, nil
+}
 
-	if len(body) < 1 {
-		return nil, fmt.Errorf("no predictions returned")
-	}
-	var answer string
-	for _, candidate := range body {
-		for _, content := range candidate.Candidates {
-			answer += content.Content.Parts[0].Text
+// ImagePolicy is how images are prepared for Gemini, and what they cost.
+// Gemini takes PNG, JPEG and WebP images, with GIFs converted to PNG, and
+// has no use for more than 3072px on a side. Inline data is limited to 20MB
+// a request, so each image is kept under 7MB. An image of up to 384px on
+// both sides costs 258 tokens, and larger ones 258 tokens for each 768px
+// tile.
+var ImagePolicy = llm.ImagePolicy{
+	Formats:     []string{llm.ImagePNG, llm.ImageJPEG, llm.ImageWebP},
+	MaxLongSide: 3072,
+	MaxBytes:    7 << 20,
+	Tokens: func(width, height int) int {
+		if width <= 384 && height <= 384 {
+			return 258
 		}
-	}
-	answer = strings.Trim(answer, " ")
-	return strings.NewReader(answer), nil
+		return 258 * ((width + 767) / 768) * ((height + 767) / 768)
+	},
 }
-func Completion(ctx context.Context, token string, projectID string, model ModelConfig, prompt Prompt) (io.Reader, error) {
-	// Use the passed in token and projectID
-	strategy := textCompletion
-	messages := MessagesFromPrompt(prompt)
-
-	if model.SupportsVision {
-		for _, message := range messages {
-			if isPNG([]byte(message.Parts.Text)) {
-				strategy = visionCompletion
-				break
-			}
+
+// prepareReferences puts references into a form the model can read. PDF
+// documents are replaced with their text if the model can't read them
+// itself, and images are prepared according to [ImagePolicy].
+func prepareReferences(model ModelConfig, refs []llm.Reference) ([]llm.Reference, error) {
+	if !model.SupportsDocuments {
+		var err error
+		refs, err = pdf.TextReferences(refs)
+		if err != nil {
+			return nil, err
 		}
 	}
-	answer, err := strategy(ctx, token, projectID, model, messages)
-	if err != nil {
-		return nil, err
+	prepared := make([]llm.Reference, len(refs))
+	for i, ref := range refs {
+		if llm.ImageType(ref.Data) != "" {
+			if !model.SupportsVision {
+				return nil, fmt.Errorf("model %s does not support image references", model.Name)
+			}
+			data, mimeType, err := ImagePolicy.Prepare(ref.Data)
+			if err != nil {
+				return nil, err
+			}
+			ref.Data, ref.MIMEType = data, mimeType
+		}
+		prepared[i] = ref
 	}
-	return answer, nil
+	return prepared, nil
+}
+
+// withReferences replaces the references of a prompt.
+type withReferences struct {
+	Prompt
+	references []llm.Reference
+}
+
+func (p withReferences) GetReferences() []llm.Reference {
+	return p.references
 }
 
 type TextCompletionRequest struct {
 	Contents         []ChatMessage    `json:"contents"`
 	GenerationConfig GenerationConfig `json:"generation_config"`
+	Tools            []Tool           `json:"tools,omitempty"`
 }
 
+// GenerationConfig holds the sampling settings for a request. Settings left
+// unset are omitted, so that the model's own defaults apply.
 type GenerationConfig struct {
-	MaxOutputTokens int     `json:"maxOutputTokens"`
-	Temperature     float64 `json:"temperature"`
-	TopP            float64 `json:"topP"`
-	TopK            int     `json:"topK"`
+	MaxOutputTokens  *int           `json:"maxOutputTokens,omitempty"`
+	Temperature      *float64       `json:"temperature,omitempty"`
+	TopP             *float64       `json:"topP,omitempty"`
+	TopK             *int           `json:"topK,omitempty"`
+	StopSequences    []string       `json:"stopSequences,omitempty"`
+	Seed             *int           `json:"seed,omitempty"`
+	PresencePenalty  *float64       `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float64       `json:"frequencyPenalty,omitempty"`
+	ResponseMimeType string         `json:"responseMimeType,omitempty"`
+	ResponseSchema   map[string]any `json:"responseSchema,omitempty"`
+}
+
+// generationConfig returns the settings given in opts. Reasoning effort is
+// refused, as the Gemini models offered don't think before they answer.
+func generationConfig(model ModelConfig, opts llm.Options) (GenerationConfig, error) {
+	err := opts.Reject(model.Name, llm.OptionReasoningEffort)
+	if err != nil {
+		return GenerationConfig{}, err
+	}
+	return GenerationConfig{
+		MaxOutputTokens:  opts.MaxOutputTokens,
+		Temperature:      opts.Temperature,
+		TopP:             opts.TopP,
+		TopK:             opts.TopK,
+		StopSequences:    opts.StopSequences,
+		Seed:             opts.Seed,
+		PresencePenalty:  opts.PresencePenalty,
+		FrequencyPenalty: opts.FrequencyPenalty,
+	}, nil
+}
+
+// responseSchema converts a JSON schema into the OpenAPI subset Gemini
+// accepts, which has no notion of additionalProperties. Maps, being objects
+// with no fixed properties, can't be expressed in it, so they are left
+// untyped and their value schema is described to the model instead.
+func responseSchema(schema map[string]any) map[string]any {
+	if schema == nil {
+		return nil
+	}
+	if values, ok := schema["additionalProperties"].(map[string]any); ok && schema["properties"] == nil {
+		return mapSchema(schema, values)
+	}
+	converted := map[string]any{}
+	for k, v := range schema {
+		switch k {
+		case "additionalProperties":
+			continue
+		case "properties":
+			properties := map[string]any{}
+			given, _ := v.(map[string]any)
+			for name, property := range given {
+				if p, ok := property.(map[string]any); ok {
+					properties[name] = responseSchema(p)
+				}
+			}
+			converted[k] = properties
+		case "items":
+			if items, ok := v.(map[string]any); ok {
+				converted[k] = responseSchema(items)
+			}
+		default:
+			converted[k] = v
+		}
+	}
+	return converted
+}
+
+// mapSchema describes a map whose values match the schema values.
+func mapSchema(schema map[string]any, values map[string]any) map[string]any {
+	description, _ := schema["description"].(string)
+	valueSchema, err := json.Marshal(values)
+	if err != nil {
+		valueSchema = []byte("{}")
+	}
+	if description != "" {
+		description += " "
+	}
+	description += "A JSON object with any string keys, each mapping to a value matching this JSON schema: " + string(valueSchema)
+	return map[string]any{"description": description}
 }
 
 func CreateVertexTextCompletionRequest(token string, projectID string, model ModelConfig, messages []ChatMessage) (*http.Request, error) {
-	URI := fmt.Sprintf("https://us-central1-aiplatform.googleapis.com/v1/projects/%s/locations/us-central1/publishers/%s/models/%s:streamGenerateContent", projectID, model.Provider, model.Name)
-	body := TextCompletionRequest{
+	return newVertexRequest(token, projectID, model, TextCompletionRequest{
 		Contents: messages,
-		GenerationConfig: GenerationConfig{
-			MaxOutputTokens: 8192,
-			Temperature:     0.9,
-			TopP:            0.8,
-			TopK:            40,
-		},
-	}
+	})
+}
+
+func newVertexRequest(token string, projectID string, model ModelConfig, body TextCompletionRequest) (*http.Request, error) {
+	URI := fmt.Sprintf("https://us-central1-aiplatform.googleapis.com/v1/projects/%s/locations/us-central1/publishers/%s/models/%s:streamGenerateContent", projectID, model.Provider, model.Name)
 	d, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
@@ -261,45 +423,108 @@ func CreateVertexTextCompletionRequest(token string, projectID string, model Mod
 
 }
 
-func ParseVertexTextCompletionResponse(resp http.Response) (io.Reader, error) {
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad status code: %d, %s", resp.StatusCode, resp.Status)
-	}
+// GenerateContentResponse is a single element of the array returned by the
+// streamGenerateContent endpoint.
+type GenerateContentResponse struct {
+	Candidates []struct {
+		Content struct {
+			Role  string `json:"role"`
+			Parts []struct {
+				Text         string        `json:"text"`
+				FunctionCall *FunctionCall `json:"functionCall"`
+			} `json:"parts"`
+		} `json:"content"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// vertexError is the error body Vertex AI returns, either on its own or as
+// the only element of an array for streaming requests.
+type vertexError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
 
+// statusError reports an unsuccessful response, along with the error message
+// Vertex AI sends in the body. Prompts with too many tokens wrap
+// [llm.ErrContextLength], anything else is an [llm.StatusError], so that
+// quota errors can be retried.
+func statusError(resp http.Response) error {
 	defer resp.Body.Close()
-	body := []struct {
-		Candidates []struct {
-			Content struct {
-				Role  string `json:"role"`
-				Parts []struct {
-					Text string `json:"text"`
-				} `json:"parts"`
-			} `json:"content"`
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return llm.NewStatusError(&resp, resp.Status)
+	}
+	var body vertexError
+	if json.Unmarshal(data, &body) != nil {
+		var bodies []vertexError
+		if json.Unmarshal(data, &bodies) == nil && len(bodies) > 0 {
+			body = bodies[0]
 		}
-	}{}
-	err := json.NewDecoder(resp.Body).Decode(&body)
+	}
+	message := body.Error.Message
+	if message == "" {
+		return llm.NewStatusError(&resp, resp.Status)
+	}
+	if strings.Contains(message, "exceeds the maximum number of tokens") {
+		return fmt.Errorf("%w: %s", llm.ErrContextLength, message)
+	}
+	return llm.NewStatusError(&resp, body.Error.Status+": "+message)
+}
+
+// ParseVertexTextCompletionResponse decodes the streamGenerateContent JSON
+// array one element at a time, so the answer can be read as it is generated.
+func ParseVertexTextCompletionResponse(resp http.Response) (io.Reader, error) {
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp)
+	}
+	decoder := json.NewDecoder(resp.Body)
+	tok, err := decoder.Token()
 	if err != nil {
+		resp.Body.Close()
 		return nil, err
 	}
-	if len(body) < 1 {
+	if tok != json.Delim('[') {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected response token: %v", tok)
+	}
+	if !decoder.More() {
+		resp.Body.Close()
 		return nil, fmt.Errorf("no predictions returned")
 	}
-
-	var answer string
-	for _, candidate := range body {
-		for _, content := range candidate.Candidates {
-			if len(content.Content.Parts) < 1 {
-				continue
+	return llm.NewStream(resp.Body, func() (llm.Delta, error) {
+		if !decoder.More() {
+			return llm.Delta{}, io.EOF
+		}
+		var chunk GenerateContentResponse
+		err := decoder.Decode(&chunk)
+		if err != nil {
+			return llm.Delta{}, err
+		}
+		delta := llm.Delta{Usage: llm.Usage{
+			InputTokens:  chunk.UsageMetadata.PromptTokenCount,
+			OutputTokens: chunk.UsageMetadata.CandidatesTokenCount,
+		}}
+		for _, candidate := range chunk.Candidates {
+			for _, part := range candidate.Content.Parts {
+				delta.Text += part.Text
+				if part.FunctionCall != nil {
+					delta.ToolCalls = append(delta.ToolCalls, llm.ToolCall{
+						ID:        part.FunctionCall.Name,
+						Name:      part.FunctionCall.Name,
+						Arguments: part.FunctionCall.Args,
+					})
+				}
 			}
-			answer += content.Content.Parts[0].Text
 		}
-	}
-	answer = strings.Trim(answer, " ")
-	return strings.NewReader(answer), nil
-}
-
-func isPNG(data []byte) bool {
-	return bytes.HasPrefix(data, []byte{0x89, 0x50, 0x4E, 0x47})
+		return delta, nil
+	}), nil
 }
 
 type VisualCompletionRequest struct {
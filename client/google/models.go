@@ -1,33 +1,49 @@
 package google
 
+import "github.com/mr-joshcrane/goracle/client/llm"
+
 type ModelConfig struct {
 	Provider       string
 	Name           string
 	SupportsVision bool
-	Description    string
+	// SupportsDocuments models read PDF documents natively. Others are given
+	// the text of them instead.
+	SupportsDocuments bool
+	Description       string
+	// ContextWindow is the most tokens the model accepts in a single request.
+	ContextWindow int
+	// Price is what the model costs per million tokens.
+	Price llm.Pricing
 }
 
 var Models = map[string]ModelConfig{
 	"GeminiPro": {
-		Provider:       "google",
-		Name:           "gemini-1.5-pro-002",
-		SupportsVision: true,
-		Description:    "Created to be multimodal (text, images, code) and to scale across a wide range of tasks",
+		Provider:          "google",
+		Name:              "gemini-1.5-pro-002",
+		SupportsVision:    true,
+		SupportsDocuments: true,
+		ContextWindow:     2097152,
+		Description:       "Created to be multimodal (text, images, code) and to scale across a wide range of tasks",
+		Price:             llm.Pricing{InputPerMillion: 1.25, OutputPerMillion: 5},
 	},
 	"ClaudeSonnet": {
 		Provider:       "anthropic",
 		Name:           "claude-3-5-sonnet-v2@20241022",
 		SupportsVision: true,
+		ContextWindow:  200000,
 		Description: `The upgraded Claude 3.5 Sonnet is now state-of-the-art 
 									for a variety of tasks including real-world software engineering,
 									enhanced agentic capabilities, and computer use.`,
+		Price: llm.Pricing{InputPerMillion: 3, OutputPerMillion: 15},
 	},
 	"ClaudeHaiku": {
 		Provider:       "anthropic",
 		Name:           "claude-3-5-haiku@20241022",
 		SupportsVision: false,
+		ContextWindow:  200000,
 		Description: `Claude 3 Haiku is Anthropic's fastest vision and text model 
 									for near-instant responses to simple queries, meant for seamless
 									AI experiences mimicking human interactions.`,
+		Price: llm.Pricing{InputPerMillion: 0.8, OutputPerMillion: 4},
 	},
 }
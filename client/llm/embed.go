@@ -0,0 +1,42 @@
+package llm
+
+import "math"
+
+// Normalize returns v scaled to unit length, so that the dot product of two
+// normalised vectors is their cosine similarity. The zero vector is returned
+// as it is.
+func Normalize(v []float32) []float32 {
+	var sum float64
+	for _, x := range v {
+		sum += float64(x) * float64(x)
+	}
+	normalised := make([]float32, len(v))
+	if sum == 0 {
+		copy(normalised, v)
+		return normalised
+	}
+	scale := 1 / math.Sqrt(sum)
+	for i, x := range v {
+		normalised[i] = float32(float64(x) * scale)
+	}
+	return normalised
+}
+
+// CosineSimilarity measures how alike two vectors are, from -1 for opposite
+// to 1 for the same direction. Vectors of different lengths, or the zero
+// vector, have a similarity of 0.
+func CosineSimilarity(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return float32(dot / math.Sqrt(normA*normB))
+}
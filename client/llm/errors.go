@@ -0,0 +1,71 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrContextLength is wrapped by provider errors when a request is rejected
+// because the prompt doesn't fit in the model's context window.
+var ErrContextLength = errors.New("prompt exceeds the model's context window")
+
+// StatusOverloaded is the non-standard status Anthropic uses when its API is
+// temporarily overloaded.
+const StatusOverloaded = 529
+
+// StatusError is an unsuccessful response from a provider's API. RetryAfter
+// holds how long the provider asked us to wait before trying again, if it
+// said.
+type StatusError struct {
+	StatusCode int
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("bad status code: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("bad status code: %d; %s", e.StatusCode, e.Message)
+}
+
+// Temporary reports whether the request may succeed if retried: when rate
+// limited, overloaded or on a server error.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode == StatusOverloaded ||
+		e.StatusCode >= 500
+}
+
+// NewStatusError returns a StatusError for resp, taking the retry delay from
+// its Retry-After header.
+func NewStatusError(resp *http.Response, message string) *StatusError {
+	return &StatusError{
+		StatusCode: resp.StatusCode,
+		Message:    message,
+		RetryAfter: ParseRetryAfter(resp.Header),
+	}
+}
+
+// ParseRetryAfter reads how long to wait before retrying from the standard
+// Retry-After header, given in seconds or as a date, or the retry-after-ms
+// header some providers send. It returns 0 if there is no usable hint.
+func ParseRetryAfter(h http.Header) time.Duration {
+	if ms, err := strconv.ParseFloat(h.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.ParseFloat(v, 64); err == nil {
+		return max(time.Duration(s*float64(time.Second)), 0)
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
@@ -0,0 +1,12 @@
+package llm
+
+import "net/http"
+
+// HTTPClient returns c, or [http.DefaultClient] if c is nil, so that
+// providers can be given a nil client to make their requests the usual way.
+func HTTPClient(c *http.Client) *http.Client {
+	if c != nil {
+		return c
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,213 @@
+package llm
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // Register image formats for decoding
+	"image/jpeg"
+	"image/png"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// The image formats providers accept.
+const (
+	ImagePNG  = "image/png"
+	ImageJPEG = "image/jpeg"
+	ImageGIF  = "image/gif"
+	ImageWebP = "image/webp"
+)
+
+// DetectMIMEType works out the MIME type of data from its contents, such as
+// "image/jpeg" or "text/plain; charset=utf-8".
+func DetectMIMEType(data []byte) string {
+	return http.DetectContentType(data)
+}
+
+// ImageType returns the MIME type of the image in data, if it is a PNG,
+// JPEG, GIF or WebP image, and "" otherwise.
+func ImageType(data []byte) string {
+	switch t := DetectMIMEType(data); t {
+	case ImagePNG, ImageJPEG, ImageGIF, ImageWebP:
+		return t
+	}
+	return ""
+}
+
+// ImageFor returns the image in data in a format a provider accepts,
+// converting it only if its own format isn't among those given. Images are
+// converted to PNG, or to JPEG if the provider doesn't take PNG. WebP images
+// can't be converted.
+func ImageFor(data []byte, accepted ...string) ([]byte, string, error) {
+	return ImagePolicy{Formats: accepted}.Prepare(data)
+}
+
+// ImagePolicy describes the images a provider accepts: their formats, how
+// large they should be and how many tokens they cost.
+type ImagePolicy struct {
+	// Formats lists the MIME types accepted. Images in other formats are
+	// converted.
+	Formats []string
+	// MaxLongSide and MaxShortSide are the largest dimensions, in pixels,
+	// worth sending. Larger images are scaled down to fit, keeping their
+	// aspect ratio. Zero means no limit.
+	MaxLongSide  int
+	MaxShortSide int
+	// MaxPixels is the largest area worth sending, with zero meaning no
+	// limit.
+	MaxPixels int
+	// MaxBytes is the largest encoded image accepted. Larger images are
+	// re-encoded as JPEG, at lower quality and then smaller sizes, until they
+	// fit. Zero means no limit.
+	MaxBytes int
+	// Tokens counts the tokens an image of the given size costs, once it has
+	// been scaled to fit.
+	Tokens func(width, height int) int
+}
+
+// jpegQualities are tried in turn to fit an image into a byte limit.
+var jpegQualities = []int{85, 70, 55, 40}
+
+// Prepare returns the image in data ready to send: in an accepted format, no
+// larger than the policy allows, along with its MIME type. Images that
+// already fit are returned unchanged.
+func (p ImagePolicy) Prepare(data []byte) ([]byte, string, error) {
+	t := ImageType(data)
+	if t == "" {
+		return nil, "", fmt.Errorf("not a supported image: %s", strings.TrimSpace(DetectMIMEType(data)))
+	}
+	fitsBytes := p.MaxBytes == 0 || len(data) <= p.MaxBytes
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// WebP images can't be decoded, so can only be sent as they are.
+		if slices.Contains(p.Formats, t) && fitsBytes {
+			return data, t, nil
+		}
+		return nil, "", fmt.Errorf("preparing %s image: %w", t, err)
+	}
+	width, height := p.fit(config.Width, config.Height)
+	resized := width != config.Width || height != config.Height
+	if !resized && fitsBytes && slices.Contains(p.Formats, t) {
+		return data, t, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("preparing %s image: %w", t, err)
+	}
+	if resized {
+		img = Resize(img, width, height)
+	}
+	target := p.target(t)
+	if target == "" {
+		return nil, "", fmt.Errorf("no way to convert %s image to %s", t, strings.Join(p.Formats, ", "))
+	}
+	buf := new(bytes.Buffer)
+	if target == ImagePNG {
+		err = png.Encode(buf, img)
+	} else {
+		err = jpeg.Encode(buf, img, &jpeg.Options{Quality: jpegQualities[0]})
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if p.MaxBytes == 0 || buf.Len() <= p.MaxBytes {
+		return buf.Bytes(), target, nil
+	}
+	if !slices.Contains(p.Formats, ImageJPEG) {
+		return nil, "", fmt.Errorf("image of %d bytes is larger than the limit of %d", buf.Len(), p.MaxBytes)
+	}
+	for img.Bounds().Dx() > 1 && img.Bounds().Dy() > 1 {
+		for _, quality := range jpegQualities {
+			buf.Reset()
+			err = jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+			if err != nil {
+				return nil, "", err
+			}
+			if buf.Len() <= p.MaxBytes {
+				return buf.Bytes(), ImageJPEG, nil
+			}
+		}
+		img = Resize(img, img.Bounds().Dx()/2, img.Bounds().Dy()/2)
+	}
+	return nil, "", fmt.Errorf("image can't be made smaller than the limit of %d bytes", p.MaxBytes)
+}
+
+// target picks the format to encode an image of type t as: its own, if
+// that can be encoded and is accepted, or else PNG or JPEG.
+func (p ImagePolicy) target(t string) string {
+	for _, f := range []string{t, ImagePNG, ImageJPEG} {
+		if (f == ImagePNG || f == ImageJPEG) && slices.Contains(p.Formats, f) {
+			return f
+		}
+	}
+	return ""
+}
+
+// EstimateTokens estimates the tokens the image in data will cost once
+// prepared, without preparing it.
+func (p ImagePolicy) EstimateTokens(data []byte) (int, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("reading image size: %w", err)
+	}
+	if p.Tokens == nil {
+		return 0, nil
+	}
+	return p.Tokens(p.fit(config.Width, config.Height)), nil
+}
+
+// fit scales width and height down to the policy's limits, keeping their
+// aspect ratio.
+func (p ImagePolicy) fit(width, height int) (int, int) {
+	scale := 1.0
+	long, short := max(width, height), min(width, height)
+	if p.MaxLongSide > 0 && long > p.MaxLongSide {
+		scale = min(scale, float64(p.MaxLongSide)/float64(long))
+	}
+	if p.MaxShortSide > 0 && short > p.MaxShortSide {
+		scale = min(scale, float64(p.MaxShortSide)/float64(short))
+	}
+	if p.MaxPixels > 0 && width*height > p.MaxPixels {
+		scale = min(scale, math.Sqrt(float64(p.MaxPixels)/float64(width*height)))
+	}
+	if scale == 1 {
+		return width, height
+	}
+	return max(1, int(float64(width)*scale)), max(1, int(float64(height)*scale))
+}
+
+// Resize scales img down to width by height, averaging the pixels each new
+// pixel covers.
+func Resize(img image.Image, width, height int) image.Image {
+	b := img.Bounds()
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		y0, y1 := y*sh/height, max((y+1)*sh/height, y*sh/height+1)
+		for x := range width {
+			x0, x1 := x*sw/width, max((x+1)*sw/width, x*sw/width+1)
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[src.PixOffset(src.Bounds().Min.X+x0, src.Bounds().Min.Y+sy):]
+				for i := range (x1 - x0) * 4 {
+					sum[i%4] += int(row[i])
+				}
+			}
+			n := (x1 - x0) * (y1 - y0)
+			i := dst.PixOffset(x, y)
+			for c := range 4 {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
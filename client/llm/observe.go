@@ -0,0 +1,67 @@
+package llm
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// Observed passes a completion through unchanged, while reporting when it has
+// been read to the end, has failed, or was closed early. It forwards the
+// optional interfaces a completion may implement, such as [ToolCaller] and
+// [UsageReporter], so that wrapping a completion doesn't hide its metadata.
+type Observed struct {
+	r    io.Reader
+	n    int
+	once sync.Once
+	done func(n int, err error)
+}
+
+// Observe wraps r so that done is called exactly once, with the number of
+// bytes read and any error other than [io.EOF], when r is finished with.
+func Observe(r io.Reader, done func(n int, err error)) *Observed {
+	return &Observed{r: r, done: done}
+}
+
+func (o *Observed) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	o.n += n
+	if errors.Is(err, io.EOF) {
+		o.finish(nil)
+	} else if err != nil {
+		o.finish(err)
+	}
+	return n, err
+}
+
+// Close closes the underlying completion, if it can be closed.
+func (o *Observed) Close() error {
+	var err error
+	if c, ok := o.r.(io.Closer); ok {
+		err = c.Close()
+	}
+	o.finish(nil)
+	return err
+}
+
+// ToolCalls returns the tool calls of the underlying completion, if any.
+func (o *Observed) ToolCalls() []ToolCall {
+	if tc, ok := o.r.(ToolCaller); ok {
+		return tc.ToolCalls()
+	}
+	return nil
+}
+
+// Usage returns the usage of the underlying completion, if it reports any.
+func (o *Observed) Usage() Usage {
+	if u, ok := o.r.(UsageReporter); ok {
+		return u.Usage()
+	}
+	return Usage{}
+}
+
+func (o *Observed) finish(err error) {
+	o.once.Do(func() {
+		o.done(o.n, err)
+	})
+}
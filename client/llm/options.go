@@ -0,0 +1,124 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Options control how a model generates its answer, in terms common to every
+// provider. A nil or empty field leaves the provider's default in place, so
+// that a temperature of 0 can be told apart from no temperature at all. Use
+// [Ptr] to set the pointer fields.
+type Options struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	TopK             *int     `json:"top_k,omitempty"`
+	MaxOutputTokens  *int     `json:"max_output_tokens,omitempty"`
+	StopSequences    []string `json:"stop_sequences,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	// ReasoningEffort is how hard a reasoning model should think before it
+	// answers, such as "low", "medium" or "high".
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+}
+
+// The names of the options, as reported by [UnsupportedOptionError].
+const (
+	OptionTemperature      = "temperature"
+	OptionTopP             = "top_p"
+	OptionTopK             = "top_k"
+	OptionMaxOutputTokens  = "max_output_tokens"
+	OptionStopSequences    = "stop_sequences"
+	OptionSeed             = "seed"
+	OptionPresencePenalty  = "presence_penalty"
+	OptionFrequencyPenalty = "frequency_penalty"
+	OptionReasoningEffort  = "reasoning_effort"
+)
+
+// Ptr returns a pointer to v, for setting the fields of [Options].
+func Ptr[T any](v T) *T {
+	return &v
+}
+
+// Merge returns o with every field that is set in override replaced.
+func (o Options) Merge(override Options) Options {
+	if override.Temperature != nil {
+		o.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		o.TopP = override.TopP
+	}
+	if override.TopK != nil {
+		o.TopK = override.TopK
+	}
+	if override.MaxOutputTokens != nil {
+		o.MaxOutputTokens = override.MaxOutputTokens
+	}
+	if override.StopSequences != nil {
+		o.StopSequences = slices.Clone(override.StopSequences)
+	}
+	if override.Seed != nil {
+		o.Seed = override.Seed
+	}
+	if override.PresencePenalty != nil {
+		o.PresencePenalty = override.PresencePenalty
+	}
+	if override.FrequencyPenalty != nil {
+		o.FrequencyPenalty = override.FrequencyPenalty
+	}
+	if override.ReasoningEffort != "" {
+		o.ReasoningEffort = override.ReasoningEffort
+	}
+	return o
+}
+
+// Names returns the names of the options that are set.
+func (o Options) Names() []string {
+	var names []string
+	add := func(set bool, name string) {
+		if set {
+			names = append(names, name)
+		}
+	}
+	add(o.Temperature != nil, OptionTemperature)
+	add(o.TopP != nil, OptionTopP)
+	add(o.TopK != nil, OptionTopK)
+	add(o.MaxOutputTokens != nil, OptionMaxOutputTokens)
+	add(o.StopSequences != nil, OptionStopSequences)
+	add(o.Seed != nil, OptionSeed)
+	add(o.PresencePenalty != nil, OptionPresencePenalty)
+	add(o.FrequencyPenalty != nil, OptionFrequencyPenalty)
+	add(o.ReasoningEffort != "", OptionReasoningEffort)
+	return names
+}
+
+// ErrUnsupportedOption is wrapped by every [UnsupportedOptionError].
+var ErrUnsupportedOption = errors.New("unsupported option")
+
+// UnsupportedOptionError reports an option that a model can't honour, which
+// is refused rather than silently ignored.
+type UnsupportedOptionError struct {
+	Model  string
+	Option string
+}
+
+func (e *UnsupportedOptionError) Error() string {
+	return fmt.Sprintf("model %s does not support the %s option", e.Model, e.Option)
+}
+
+func (e *UnsupportedOptionError) Unwrap() error {
+	return ErrUnsupportedOption
+}
+
+// Reject returns an [UnsupportedOptionError] for the first of the named
+// options that is set, or nil if none of them are.
+func (o Options) Reject(model string, names ...string) error {
+	for _, name := range o.Names() {
+		if slices.Contains(names, name) {
+			return &UnsupportedOptionError{Model: model, Option: name}
+		}
+	}
+	return nil
+}
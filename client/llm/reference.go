@@ -0,0 +1,25 @@
+package llm
+
+import "fmt"
+
+// Reference is material given to the model alongside a question, such as a
+// file, an image or a snippet of text.
+type Reference struct {
+	// Name is shown to the model, so that its answers can point at the
+	// reference, such as a file's path.
+	Name string
+	// Source is where the reference came from, such as a path or URL.
+	Source string
+	// MIMEType describes Data, such as "text/plain" or "image/png".
+	MIMEType string
+	Data     []byte
+}
+
+// Label introduces the nth reference to the model, giving its name if it
+// has one.
+func (r Reference) Label(n int) string {
+	if r.Name == "" {
+		return fmt.Sprintf("Reference %d", n)
+	}
+	return fmt.Sprintf("Reference %d (%s)", n, r.Name)
+}
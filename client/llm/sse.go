@@ -0,0 +1,68 @@
+package llm
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Event is a single server-sent event.
+type Event struct {
+	Name string
+	Data string
+}
+
+// EventScanner decodes a text/event-stream body one event at a time.
+type EventScanner struct {
+	scanner *bufio.Scanner
+}
+
+// NewEventScanner returns an EventScanner reading from r.
+func NewEventScanner(r io.Reader) *EventScanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	return &EventScanner{scanner: scanner}
+}
+
+// Next returns the next event in the stream, or [io.EOF] once the stream is
+// exhausted. Comments and events without data are skipped.
+func (s *EventScanner) Next() (Event, error) {
+	var event Event
+	var data []string
+	for s.scanner.Scan() {
+		line := s.scanner.Text()
+		if line == "" {
+			if len(data) == 0 {
+				event = Event{}
+				continue
+			}
+			event.Data = strings.Join(data, "\n")
+			return event, nil
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event.Name = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	if err := s.scanner.Err(); err != nil {
+		return Event{}, err
+	}
+	if len(data) > 0 {
+		event.Data = strings.Join(data, "\n")
+		return event, nil
+	}
+	return Event{}, io.EOF
+}
+
+// IsEventStream reports whether a Content-Type header describes a
+// server-sent event stream.
+func IsEventStream(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/event-stream")
+}
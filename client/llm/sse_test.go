@@ -0,0 +1,343 @@
+package llm_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/png"
+	"io"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+func TestEventScanner_DecodesNamedAndMultilineEvents(t *testing.T) {
+	t.Parallel()
+	body := ": keep-alive\n\nevent: ping\ndata: {}\n\ndata: first\ndata: second\n\ndata: trailing"
+	scanner := llm.NewEventScanner(strings.NewReader(body))
+	var got []llm.Event
+	for {
+		event, err := scanner.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, event)
+	}
+	want := []llm.Event{
+		{Name: "ping", Data: "{}"},
+		{Data: "first\nsecond"},
+		{Data: "trailing"},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestStream_ReadsDeltasUntilEOF(t *testing.T) {
+	t.Parallel()
+	deltas := []string{"Hello", "", " World"}
+	stream := llm.NewStream(nil, func() (llm.Delta, error) {
+		if len(deltas) == 0 {
+			return llm.Delta{}, io.EOF
+		}
+		d := deltas[0]
+		deltas = deltas[1:]
+		return llm.Delta{Text: d}, nil
+	})
+	got, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "Hello World" {
+		t.Errorf("Expected Hello World, got %q", got)
+	}
+}
+
+func TestObserve_ReportsOnceAndForwardsToolCalls(t *testing.T) {
+	t.Parallel()
+	calls := []llm.ToolCall{{ID: "1", Name: "lookup"}}
+	reports := 0
+	gotBytes := 0
+	r := llm.Observe(llm.Once(llm.Delta{Text: "hello", ToolCalls: calls}), func(n int, err error) {
+		reports++
+		gotBytes = n
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	if string(data) != "hello" || gotBytes != 5 {
+		t.Errorf("expected 5 bytes of hello, got %d bytes of %q", gotBytes, data)
+	}
+	if reports != 1 {
+		t.Errorf("expected a single report, got %d", reports)
+	}
+	if !cmp.Equal(calls, r.ToolCalls()) {
+		t.Error(cmp.Diff(calls, r.ToolCalls()))
+	}
+}
+
+func TestParseRetryAfter_ReadsSecondsAndMilliseconds(t *testing.T) {
+	t.Parallel()
+	h := http.Header{}
+	if got := llm.ParseRetryAfter(h); got != 0 {
+		t.Errorf("expected no hint, got %s", got)
+	}
+	h.Set("Retry-After", "2")
+	if got := llm.ParseRetryAfter(h); got != 2*time.Second {
+		t.Errorf("expected 2s, got %s", got)
+	}
+	h.Set("Retry-After-Ms", "150")
+	if got := llm.ParseRetryAfter(h); got != 150*time.Millisecond {
+		t.Errorf("expected 150ms, got %s", got)
+	}
+}
+
+func TestStatusError_TemporaryForRateLimitsAndServerErrors(t *testing.T) {
+	t.Parallel()
+	for code, want := range map[int]bool{
+		http.StatusTooManyRequests:     true,
+		llm.StatusOverloaded:           true,
+		http.StatusInternalServerError: true,
+		http.StatusBadRequest:          false,
+		http.StatusUnauthorized:        false,
+	} {
+		err := &llm.StatusError{StatusCode: code}
+		if err.Temporary() != want {
+			t.Errorf("status %d: expected temporary %t", code, want)
+		}
+	}
+}
+
+func TestStream_MergesUsageReportedAcrossDeltas(t *testing.T) {
+	t.Parallel()
+	deltas := []llm.Delta{
+		{Usage: llm.Usage{InputTokens: 10, OutputTokens: 1}},
+		{Text: "hi"},
+		{Usage: llm.Usage{OutputTokens: 7}},
+	}
+	s := llm.NewStream(nil, func() (llm.Delta, error) {
+		if len(deltas) == 0 {
+			return llm.Delta{}, io.EOF
+		}
+		d := deltas[0]
+		deltas = deltas[1:]
+		return d, nil
+	})
+	_, err := io.ReadAll(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := llm.Usage{InputTokens: 10, OutputTokens: 7}
+	if got := s.Usage(); got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestPricing_CostsTokensPerMillion(t *testing.T) {
+	t.Parallel()
+	p := llm.Pricing{InputPerMillion: 3, OutputPerMillion: 15}
+	got := p.Cost(llm.Usage{InputTokens: 1_000_000, OutputTokens: 200_000})
+	if got != 6 {
+		t.Errorf("expected $6, got $%f", got)
+	}
+}
+
+func TestOptions_MergeOverridesOnlyFieldsThatAreSet(t *testing.T) {
+	t.Parallel()
+	base := llm.Options{
+		Temperature:   llm.Ptr(0.7),
+		TopP:          llm.Ptr(0.9),
+		StopSequences: []string{"END"},
+	}
+	got := base.Merge(llm.Options{Temperature: llm.Ptr(0.0), Seed: llm.Ptr(42)})
+	want := llm.Options{
+		Temperature:   llm.Ptr(0.0),
+		TopP:          llm.Ptr(0.9),
+		StopSequences: []string{"END"},
+		Seed:          llm.Ptr(42),
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestOptions_RejectReportsTheFirstUnsupportedOptionSet(t *testing.T) {
+	t.Parallel()
+	opts := llm.Options{Temperature: llm.Ptr(1.0), TopK: llm.Ptr(40)}
+	if err := opts.Reject("model", llm.OptionSeed); err != nil {
+		t.Errorf("expected no error for an unset option, got %v", err)
+	}
+	err := opts.Reject("model", llm.OptionSeed, llm.OptionTopK)
+	var unsupported *llm.UnsupportedOptionError
+	if !errors.As(err, &unsupported) || unsupported.Option != llm.OptionTopK {
+		t.Fatalf("expected top_k to be rejected, got %v", err)
+	}
+	if !errors.Is(err, llm.ErrUnsupportedOption) {
+		t.Error("expected the error to wrap ErrUnsupportedOption")
+	}
+}
+
+func TestImageType_SniffsSupportedImageFormats(t *testing.T) {
+	t.Parallel()
+	tcs := map[string][]byte{
+		llm.ImagePNG:  []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"),
+		llm.ImageJPEG: []byte("\xff\xd8\xff\xe0\x00\x10JFIF"),
+		llm.ImageGIF:  []byte("GIF89a\x01\x00\x01\x00"),
+		llm.ImageWebP: []byte("RIFF\x24\x00\x00\x00WEBPVP8 "),
+		"":            []byte("just some text"),
+	}
+	for want, data := range tcs {
+		if got := llm.ImageType(data); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
+
+func TestImageFor_ConvertsOnlyWhenTheFormatIsNotAccepted(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	err := gif.Encode(buf, image.NewGray(image.Rect(0, 0, 2, 2)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, mimeType, err := llm.ImageFor(buf.Bytes(), llm.ImageGIF, llm.ImagePNG)
+	if err != nil || mimeType != llm.ImageGIF || !bytes.Equal(data, buf.Bytes()) {
+		t.Errorf("expected the GIF unchanged, got %q, %v", mimeType, err)
+	}
+	data, mimeType, err = llm.ImageFor(buf.Bytes(), llm.ImagePNG, llm.ImageJPEG)
+	if err != nil || mimeType != llm.ImagePNG || llm.ImageType(data) != llm.ImagePNG {
+		t.Errorf("expected a PNG, got %q, %v", mimeType, err)
+	}
+	_, _, err = llm.ImageFor([]byte("RIFF\x24\x00\x00\x00WEBPVP8 "), llm.ImagePNG)
+	if err == nil {
+		t.Error("expected an error converting WebP, which can't be decoded")
+	}
+}
+
+func TestImagePolicy_PrepareScalesImagesDownToTheLimits(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 400, 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := llm.ImagePolicy{Formats: []string{llm.ImagePNG}, MaxLongSide: 200}
+	data, mimeType, err := policy.Prepare(buf.Bytes())
+	if err != nil || mimeType != llm.ImagePNG {
+		t.Fatalf("expected a PNG, got %q, %v", mimeType, err)
+	}
+	config, err := png.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Width != 200 || config.Height != 50 {
+		t.Errorf("expected 200x50, got %dx%d", config.Width, config.Height)
+	}
+	policy.MaxLongSide = 400
+	data, _, err = policy.Prepare(buf.Bytes())
+	if err != nil || !bytes.Equal(data, buf.Bytes()) {
+		t.Errorf("expected an image within the limits unchanged, got %v", err)
+	}
+}
+
+func TestImagePolicy_PrepareReencodesAsJPEGToFitTheByteLimit(t *testing.T) {
+	t.Parallel()
+	img := image.NewRGBA(image.Rect(0, 0, 256, 256))
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(rng.IntN(256))
+	}
+	buf := new(bytes.Buffer)
+	err := png.Encode(buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := llm.ImagePolicy{
+		Formats:  []string{llm.ImagePNG, llm.ImageJPEG},
+		MaxBytes: 20_000,
+	}
+	data, mimeType, err := policy.Prepare(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mimeType != llm.ImageJPEG || llm.ImageType(data) != llm.ImageJPEG {
+		t.Errorf("expected a JPEG, got %q", mimeType)
+	}
+	if len(data) > policy.MaxBytes {
+		t.Errorf("expected at most %d bytes, got %d", policy.MaxBytes, len(data))
+	}
+	policy.Formats = []string{llm.ImagePNG}
+	_, _, err = policy.Prepare(buf.Bytes())
+	if err == nil {
+		t.Error("expected an error when the image can't fit without JPEG")
+	}
+}
+
+func TestImagePolicy_EstimateTokensCountsTheScaledImage(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 1000, 500)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := llm.ImagePolicy{
+		MaxLongSide: 500,
+		Tokens: func(width, height int) int {
+			return width * height
+		},
+	}
+	got, err := policy.EstimateTokens(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 500*250 {
+		t.Errorf("expected tokens for a 500x250 image, got %d", got)
+	}
+}
+
+func TestNormalize_ScalesVectorsToUnitLength(t *testing.T) {
+	t.Parallel()
+	got := llm.Normalize([]float32{3, 4})
+	if !cmp.Equal([]float32{0.6, 0.8}, got) {
+		t.Errorf("expected [0.6 0.8], got %v", got)
+	}
+	got = llm.Normalize([]float32{0, 0})
+	if !cmp.Equal([]float32{0, 0}, got) {
+		t.Errorf("expected the zero vector unchanged, got %v", got)
+	}
+}
+
+func TestCosineSimilarity_ComparesDirectionsNotLengths(t *testing.T) {
+	t.Parallel()
+	tcs := []struct {
+		a, b []float32
+		want float32
+	}{
+		{[]float32{1, 0}, []float32{5, 0}, 1},
+		{[]float32{1, 0}, []float32{0, 2}, 0},
+		{[]float32{1, 1}, []float32{-1, -1}, -1},
+		{[]float32{1, 0}, []float32{1, 0, 0}, 0},
+		{[]float32{0, 0}, []float32{1, 0}, 0},
+	}
+	for _, tc := range tcs {
+		got := llm.CosineSimilarity(tc.a, tc.b)
+		if math.Abs(float64(got-tc.want)) > 1e-6 {
+			t.Errorf("CosineSimilarity(%v, %v): expected %v, got %v", tc.a, tc.b, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Package llm holds the provider neutral building blocks shared by the
+// goracle root package and the individual provider clients.
+package llm
+
+import (
+	"errors"
+	"io"
+)
+
+// Delta is an incremental piece of a model response, as decoded from a
+// provider's streaming wire format.
+type Delta struct {
+	Text      string
+	ToolCalls []ToolCall
+	Usage     Usage
+}
+
+// Stream adapts a provider's incremental response into a lazy [io.Reader].
+// Each call to Read pulls just enough of the underlying response to satisfy
+// the caller, so text can be rendered as the model produces it.
+type Stream struct {
+	next   func() (Delta, error)
+	closer io.Closer
+	buf    []byte
+	calls  []ToolCall
+	usage  Usage
+	err    error
+	closed bool
+}
+
+// NewStream returns a Stream that calls next for each Delta until next returns
+// an error. [io.EOF] signals a clean end of the response. The closer, usually
+// an HTTP response body, is closed once the stream ends or is closed early.
+func NewStream(closer io.Closer, next func() (Delta, error)) *Stream {
+	return &Stream{
+		next:   next,
+		closer: closer,
+	}
+}
+
+// Text returns a Stream that yields a single, already complete answer.
+// Useful for providers that answer with a non-streaming payload.
+func Text(text string) *Stream {
+	return Once(Delta{Text: text})
+}
+
+// Once returns a Stream that yields a single, already complete Delta.
+func Once(d Delta) *Stream {
+	done := false
+	return NewStream(nil, func() (Delta, error) {
+		if done {
+			return Delta{}, io.EOF
+		}
+		done = true
+		return d, nil
+	})
+}
+
+func (s *Stream) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		d, err := s.next()
+		if err != nil {
+			s.err = err
+			if cerr := s.Close(); cerr != nil && !errors.Is(err, io.EOF) {
+				s.err = errors.Join(err, cerr)
+			}
+			continue
+		}
+		s.buf = append(s.buf, d.Text...)
+		s.calls = append(s.calls, d.ToolCalls...)
+		s.usage.merge(d.Usage)
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// ToolCalls returns the tool calls the model has requested so far. The list is
+// complete once Read has returned [io.EOF].
+func (s *Stream) ToolCalls() []ToolCall {
+	return s.calls
+}
+
+// Usage returns the tokens the completion has consumed so far, as reported
+// by the provider. It is complete once Read has returned [io.EOF].
+func (s *Stream) Usage() Usage {
+	return s.usage
+}
+
+// Close releases the underlying response. It is safe to call more than once.
+func (s *Stream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.buf = nil
+	if s.err == nil {
+		s.err = io.ErrClosedPipe
+	}
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
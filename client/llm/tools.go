@@ -0,0 +1,42 @@
+package llm
+
+import "encoding/json"
+
+// Tool describes a function the model may ask to have called on its behalf.
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters is the JSON schema of the object the tool accepts.
+	Parameters map[string]any
+}
+
+// ToolCall is a model's request to invoke a [Tool].
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments json.RawMessage
+}
+
+// ToolResult is the outcome of running a [ToolCall], fed back to the model.
+type ToolResult struct {
+	ID      string
+	Name    string
+	Content string
+	IsError bool
+}
+
+// ToolTurn is a single round trip of the agent loop. It records any text the
+// model produced alongside its tool calls, the calls themselves, and the
+// results of running them.
+type ToolTurn struct {
+	Text    string
+	Calls   []ToolCall
+	Results []ToolResult
+}
+
+// ToolCaller is implemented by completions that may finish with tool calls
+// rather than (or as well as) text. ToolCalls is only meaningful once the
+// completion has been read to the end.
+type ToolCaller interface {
+	ToolCalls() []ToolCall
+}
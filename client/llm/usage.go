@@ -0,0 +1,51 @@
+package llm
+
+// Usage is the number of tokens a completion consumed, and what they cost in
+// US dollars where the price of the model is known.
+type Usage struct {
+	InputTokens  int     `json:"input_tokens"`
+	OutputTokens int     `json:"output_tokens"`
+	Cost         float64 `json:"cost"`
+}
+
+// Add returns the sum of two usages.
+func (u Usage) Add(v Usage) Usage {
+	return Usage{
+		InputTokens:  u.InputTokens + v.InputTokens,
+		OutputTokens: u.OutputTokens + v.OutputTokens,
+		Cost:         u.Cost + v.Cost,
+	}
+}
+
+// merge updates u with the fields reported in v. Providers report usage
+// cumulatively, and often spread over several events, so the latest non-zero
+// count wins.
+func (u *Usage) merge(v Usage) {
+	if v.InputTokens != 0 {
+		u.InputTokens = v.InputTokens
+	}
+	if v.OutputTokens != 0 {
+		u.OutputTokens = v.OutputTokens
+	}
+	if v.Cost != 0 {
+		u.Cost = v.Cost
+	}
+}
+
+// UsageReporter is implemented by completions that know how many tokens they
+// consumed. Usage is only complete once the completion has been read to the
+// end.
+type UsageReporter interface {
+	Usage() Usage
+}
+
+// Pricing is the price of a model in US dollars per million tokens.
+type Pricing struct {
+	InputPerMillion  float64
+	OutputPerMillion float64
+}
+
+// Cost returns what the tokens in u cost at this price.
+func (p Pricing) Cost(u Usage) float64 {
+	return (float64(u.InputTokens)*p.InputPerMillion + float64(u.OutputTokens)*p.OutputPerMillion) / 1e6
+}
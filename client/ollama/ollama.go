@@ -2,79 +2,187 @@ package ollama
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+	"github.com/mr-joshcrane/goracle/client/pdf"
 )
 
 type Prompt interface {
 	GetPurpose() string
 	GetHistory() ([]string, []string)
 	GetQuestion() string
-	GetReferences() [][]byte
+	GetReferences() []llm.Reference
+	GetResponseSchema() map[string]any
+	GetTools() []llm.Tool
+	GetToolTurns() []llm.ToolTurn
+	GetOptions() llm.Options
 }
 
-func DoChatCompletion(model string, endpoint string, prompt Prompt) (string, error) {
-	body := NewChatCompletionRequest(model, prompt)
+func DoChatCompletion(ctx context.Context, httpClient *http.Client, model string, endpoint string, prompt Prompt) (io.Reader, error) {
+	err := prompt.GetOptions().Reject(model, llm.OptionReasoningEffort)
+	if err != nil {
+		return nil, err
+	}
+	refs, err := prepareReferences(prompt.GetReferences())
+	if err != nil {
+		return nil, err
+	}
+	body := NewChatCompletionRequest(model, withReferences{Prompt: prompt, references: refs})
 	data, err := json.Marshal(body)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	endpoint = fmt.Sprintf("%s/api/chat", endpoint)
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(data))
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewBuffer(data))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := llm.HTTPClient(httpClient).Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	return ParseChatCompletionResponse(resp)
 }
 
-func GetEmbedding(model string, endpoint string, prompt Prompt) ([]float64, error) {
-	body := struct {
-		Model  string `json:"model"`
-		Prompt string `json:"prompt"`
-	}{
-		Model:  model,
-		Prompt: prompt.GetQuestion(),
+// prepareReferences puts references into a form Ollama models can read.
+// They can't read PDF documents, so they're given the text, and images are
+// converted to PNG unless they're already PNG or JPEG.
+func prepareReferences(refs []llm.Reference) ([]llm.Reference, error) {
+	refs, err := pdf.TextReferences(refs)
+	if err != nil {
+		return nil, err
 	}
-	data, err := json.Marshal(body)
+	for i, ref := range refs {
+		if llm.ImageType(ref.Data) == "" {
+			continue
+		}
+		refs[i].Data, refs[i].MIMEType, err = llm.ImageFor(ref.Data, llm.ImagePNG, llm.ImageJPEG)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return refs, nil
+}
+
+// withReferences replaces the references of a prompt.
+type withReferences struct {
+	Prompt
+	references []llm.Reference
+}
+
+func (p withReferences) GetReferences() []llm.Reference {
+	return p.references
+}
+
+// GetEmbedding returns a vector for the prompt's question.
+//
+// Deprecated: Use [Embed], which embeds many texts at once.
+func GetEmbedding(model string, endpoint string, prompt Prompt) ([]float64, error) {
+	vectors, err := Embed(context.Background(), nil, model, endpoint, 0, []string{prompt.GetQuestion()})
 	if err != nil {
 		return nil, err
 	}
-	endpoint = fmt.Sprintf("%s/api/embeddings", endpoint)
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBuffer(data))
+	embedding := make([]float64, len(vectors[0]))
+	for i, x := range vectors[0] {
+		embedding[i] = float64(x)
+	}
+	return embedding, nil
+}
+
+type EmbedRequest struct {
+	Model      string   `json:"model"`
+	Input      []string `json:"input"`
+	Dimensions int      `json:"dimensions,omitempty"`
+	Truncate   bool     `json:"truncate"`
+}
+
+type EmbedResponse struct {
+	Model      string      `json:"model"`
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+// Embed returns a vector for each of texts, in order, using the /api/embed
+// endpoint. Zero dimensions means the model's full size, and other sizes are
+// only supported by some models. Ollama's vectors are already normalised. A
+// nil httpClient means [http.DefaultClient].
+func Embed(ctx context.Context, httpClient *http.Client, model string, endpoint string, dimensions int, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(EmbedRequest{
+		Model:      model,
+		Input:      texts,
+		Dimensions: dimensions,
+		Truncate:   true,
+	})
 	if err != nil {
 		return nil, err
 	}
-	resp, err := http.DefaultClient.Do(req)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/api/embed", bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("ollama response status code: %d", resp.StatusCode)
+	resp, err := llm.HTTPClient(httpClient).Do(req)
+	if err != nil {
+		return nil, err
 	}
 	defer resp.Body.Close()
-	var embeddings struct {
-		Embeddings []float64 `json:"embeddings"`
+	if resp.StatusCode != http.StatusOK {
+		return nil, llm.NewStatusError(resp, "")
 	}
-	data, err = io.ReadAll(resp.Body)
+	var embeddings EmbedResponse
+	err = json.NewDecoder(resp.Body).Decode(&embeddings)
 	if err != nil {
 		return nil, err
 	}
-	err = json.Unmarshal(data, &embeddings)
-	if err != nil {
-		return nil, err
+	if len(embeddings.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("asked for %d embeddings, got %d", len(texts), len(embeddings.Embeddings))
 	}
 	return embeddings.Embeddings, nil
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"`
+	// Images are base64 encoded, for models that can see them.
+	Images []string `json:"images,omitempty"`
+}
+
+type ToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+// ToolDefinition advertises a function the model may call.
+type ToolDefinition struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string         `json:"name"`
+		Description string         `json:"description,omitempty"`
+		Parameters  map[string]any `json:"parameters"`
+	} `json:"function"`
+}
+
+func toolDefinitions(tools []llm.Tool) []ToolDefinition {
+	var definitions []ToolDefinition
+	for _, t := range tools {
+		d := ToolDefinition{Type: "function"}
+		d.Function.Name = t.Name
+		d.Function.Description = t.Description
+		d.Function.Parameters = t.Parameters
+		definitions = append(definitions, d)
+	}
+	return definitions
 }
 
 type Messages []Message
@@ -84,16 +192,49 @@ func (m *Messages) Add(role string, content string) {
 	*m = append(*m, message)
 }
 
-func referenceFormatter(reference []byte, refNo int) string {
-	return fmt.Sprintf("Reference %d: %s", refNo, reference)
+func referenceFormatter(reference llm.Reference, refNo int) string {
+	return fmt.Sprintf("%s: %s", reference.Label(refNo), reference.Data)
 }
 
 type ChatCompletion struct {
-	Model    string    `json:"model"`
-	Messages []Message `json:"messages"`
-	Images   []string  `json:"images,omitempty"`
-	Stream   bool      `json:"stream"`
-	Raw      bool      `json:"raw"`
+	Model    string           `json:"model"`
+	Messages []Message        `json:"messages"`
+	Images   []string         `json:"images,omitempty"`
+	Tools    []ToolDefinition `json:"tools,omitempty"`
+	Format   map[string]any   `json:"format,omitempty"`
+	Options  *ModelOptions    `json:"options,omitempty"`
+	Stream   bool             `json:"stream"`
+	Raw      bool             `json:"raw"`
+}
+
+// ModelOptions are the generation settings Ollama accepts, mapped from
+// [llm.Options]. Unset fields are left out so that the model's defaults
+// apply.
+type ModelOptions struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	TopK             *int     `json:"top_k,omitempty"`
+	NumPredict       *int     `json:"num_predict,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+}
+
+func modelOptions(opts llm.Options) *ModelOptions {
+	if len(opts.Names()) == 0 {
+		return nil
+	}
+	return &ModelOptions{
+		Temperature:      opts.Temperature,
+		TopP:             opts.TopP,
+		TopK:             opts.TopK,
+		NumPredict:       opts.MaxOutputTokens,
+		Stop:             opts.StopSequences,
+		Seed:             opts.Seed,
+		PresencePenalty:  opts.PresencePenalty,
+		FrequencyPenalty: opts.FrequencyPenalty,
+	}
 }
 
 func PromptToMessages(prompt Prompt) Messages {
@@ -105,10 +246,32 @@ func PromptToMessages(prompt Prompt) Messages {
 		messages.Add("assistant", prevOutputs[i])
 	}
 	for i, ref := range prompt.GetReferences() {
+		if llm.ImageType(ref.Data) != "" {
+			messages = append(messages, Message{
+				Role:    "user",
+				Content: ref.Label(i+1) + ":",
+				Images:  []string{base64.StdEncoding.EncodeToString(ref.Data)},
+			})
+			messages.Add("assistant", "Reference added")
+			continue
+		}
 		messages.Add("user", referenceFormatter(ref, i+1))
 		messages.Add("assistant", "Reference added")
 	}
-	messages.Add("assistant", prompt.GetQuestion())
+	messages.Add("user", prompt.GetQuestion())
+	for _, turn := range prompt.GetToolTurns() {
+		call := Message{Role: "assistant", Content: turn.Text}
+		for _, c := range turn.Calls {
+			var tc ToolCall
+			tc.Function.Name = c.Name
+			tc.Function.Arguments = c.Arguments
+			call.ToolCalls = append(call.ToolCalls, tc)
+		}
+		messages = append(messages, call)
+		for _, r := range turn.Results {
+			messages = append(messages, Message{Role: "tool", Content: r.Content, ToolName: r.Name})
+		}
+	}
 	return messages
 }
 
@@ -117,38 +280,56 @@ func NewChatCompletionRequest(model string, prompt Prompt) ChatCompletion {
 	return ChatCompletion{
 		Model:    model,
 		Messages: messages,
+		Tools:    toolDefinitions(prompt.GetTools()),
+		Format:   prompt.GetResponseSchema(),
+		Options:  modelOptions(prompt.GetOptions()),
 		Stream:   true,
 		Raw:      false,
 	}
 }
 
-func ParseChatCompletionResponse(resp *http.Response) (string, error) {
-	fmt.Println("Parsing...")
+// ChatCompletionChunk is a single line of Ollama's newline delimited JSON
+// chat stream.
+type ChatCompletionChunk struct {
+	Message Message `json:"message"`
+	Done    bool    `json:"done"`
+	Error   string  `json:"error"`
+	// PromptEvalCount and EvalCount are reported on the final chunk.
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
+}
+
+// ParseChatCompletionResponse returns a reader that decodes the chat stream
+// lazily, one chunk at a time, as it is consumed.
+func ParseChatCompletionResponse(resp *http.Response) (io.Reader, error) {
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("ollama response status code: %d", resp.StatusCode)
+		resp.Body.Close()
+		return nil, llm.NewStatusError(resp, "")
 	}
-	defer resp.Body.Close()
-
-	content := new(bytes.Buffer)
 	decoder := json.NewDecoder(resp.Body)
-	for {
-		var body struct {
-			Message Message `json:"message"`
-		}
-		err := decoder.Decode(&body)
+	return llm.NewStream(resp.Body, func() (llm.Delta, error) {
+		var chunk ChatCompletionChunk
+		err := decoder.Decode(&chunk)
 		if err != nil {
-			if err == io.EOF {
-				break // end of Stream
-			}
-			return "", err
+			return llm.Delta{}, err
 		}
-		fmt.Print(body.Message.Content)
-
-		_, err = content.WriteString(body.Message.Content)
-		if err != nil {
-			return "", err
+		if chunk.Error != "" {
+			return llm.Delta{}, fmt.Errorf("ollama error: %s", chunk.Error)
 		}
-
-	}
-	return content.String(), nil
+		delta := llm.Delta{
+			Text: chunk.Message.Content,
+			Usage: llm.Usage{
+				InputTokens:  chunk.PromptEvalCount,
+				OutputTokens: chunk.EvalCount,
+			},
+		}
+		for _, c := range chunk.Message.ToolCalls {
+			delta.ToolCalls = append(delta.ToolCalls, llm.ToolCall{
+				ID:        c.Function.Name,
+				Name:      c.Function.Name,
+				Arguments: c.Function.Arguments,
+			})
+		}
+		return delta, nil
+	}), nil
 }
@@ -3,9 +3,11 @@ package openai_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"image"
+	"image/jpeg"
 	"image/png"
 	"io"
 	"net/http"
@@ -19,6 +21,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/mr-joshcrane/goracle"
 	"github.com/mr-joshcrane/goracle/client"
+	"github.com/mr-joshcrane/goracle/client/llm"
 	"github.com/mr-joshcrane/goracle/client/openai"
 )
 
@@ -28,7 +31,7 @@ func testPrompt() goracle.Prompt {
 		InputHistory:  []string{"GivenInput", "GivenInput2"},
 		OutputHistory: []string{"IdealOutput", "IdealOutput2"},
 		Question:      "A test question",
-		References:    [][]byte{[]byte("page1"), []byte("page2")},
+		References:    []goracle.Reference{{Data: []byte("page1")}, {Data: []byte("page2")}},
 	}
 }
 
@@ -60,7 +63,7 @@ func TestCreateTextCompletionRequest(t *testing.T) {
 	if err != nil {
 		t.Errorf("Error creating request: %s", err)
 	}
-	want := fmt.Sprintf(`{"model":"%s","messages":[{"role":"system","content":"A test purpose"},{"role":"user","content":"GivenInput"},{"role":"assistant","content":"IdealOutput"},{"role":"user","content":"GivenInput2"},{"role":"assistant","content":"IdealOutput2"},{"role":"user","content":"A test question"},{"role":"user","content":"Reference 1: page1"},{"role":"user","content":"Reference 2: page2"}],"response_format":null}%v`, openai.GPT4o, "\n")
+	want := fmt.Sprintf(`{"model":"%s","messages":[{"role":"system","content":"A test purpose"},{"role":"user","content":"GivenInput"},{"role":"assistant","content":"IdealOutput"},{"role":"user","content":"GivenInput2"},{"role":"assistant","content":"IdealOutput2"},{"role":"user","content":"A test question"},{"role":"user","content":"Reference 1: page1"},{"role":"user","content":"Reference 2: page2"}],"response_format":null,"stream":true,"stream_options":{"include_usage":true}}%v`, openai.GPT4o, "\n")
 	data, err := io.ReadAll(req.Body)
 	if err != nil {
 		t.Errorf("Error reading request body: %s", err)
@@ -71,6 +74,82 @@ func TestCreateTextCompletionRequest(t *testing.T) {
 	}
 }
 
+func TestTextCompletionRequest_SendsOnlyTheSamplingSettingsThatAreSet(t *testing.T) {
+	t.Parallel()
+	data, err := json.Marshal(openai.TextCompletionRequest{
+		Model: openai.GPT4o,
+		Sampling: openai.Sampling{
+			Temperature: llm.Ptr(0.0),
+			Stop:        []string{"END"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"model":"gpt-4o","messages":null,"response_format":null,"temperature":0,"stop":["END"]}`
+	if got := string(data); got != want {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestDo_RejectsOptionsTheModelCannotHonour(t *testing.T) {
+	t.Parallel()
+	tcs := []struct {
+		model  string
+		opts   llm.Options
+		option string
+	}{
+		{"gpt-4o", llm.Options{TopK: llm.Ptr(40)}, llm.OptionTopK},
+		{"gpt-4o", llm.Options{ReasoningEffort: "high"}, llm.OptionReasoningEffort},
+		{"o1-mini", llm.Options{Temperature: llm.Ptr(0.5)}, llm.OptionTemperature},
+	}
+	for _, tc := range tcs {
+		prompt := goracle.Prompt{Question: "Hello?", Options: tc.opts}
+		_, err := openai.Do(context.Background(), nil, "dummy-token", openai.Models[tc.model], prompt)
+		var unsupported *llm.UnsupportedOptionError
+		if !errors.As(err, &unsupported) || unsupported.Option != tc.option {
+			t.Errorf("%s: expected %s to be rejected, got %v", tc.model, tc.option, err)
+		}
+	}
+}
+
+func TestCreateTextCompletionRequest_ResponseFormatDescribesItemProperties(t *testing.T) {
+	t.Parallel()
+	req, err := openai.CreateTextCompletionRequest("dummy-token-openai", openai.GPT4o, testMessages(), "name:The name of the cheese")
+	if err != nil {
+		t.Fatalf("Error creating request: %s", err)
+	}
+	var body struct {
+		ResponseFormat struct {
+			JSONSchema struct {
+				Schema struct {
+					Properties struct {
+						Response struct {
+							Items map[string]any `json:"items"`
+						} `json:"response"`
+					} `json:"properties"`
+				} `json:"schema"`
+			} `json:"json_schema"`
+		} `json:"response_format"`
+	}
+	err = json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"name": map[string]any{"type": "string", "description": "The name of the cheese"},
+		},
+		"required":             []any{"name"},
+		"additionalProperties": false,
+	}
+	got := body.ResponseFormat.JSONSchema.Schema.Properties.Response.Items
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
 func TestParseTextCompletionResponse(t *testing.T) {
 	t.Parallel()
 	req := &http.Response{
@@ -92,6 +171,117 @@ func TestParseTextCompletionResponse(t *testing.T) {
 	}
 }
 
+func TestParseTextCompletionResponse_StreamsEventsLazily(t *testing.T) {
+	t.Parallel()
+	body, w := io.Pipe()
+	req := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       body,
+	}
+	content, err := openai.ParseTextCompletionRequest(req)
+	if err != nil {
+		t.Fatalf("Error parsing response: %s", err)
+	}
+	go func() {
+		_, _ = io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"A wood\"}}]}\n\n")
+	}()
+	buf := make([]byte, 64)
+	n, err := content.Read(buf)
+	if err != nil {
+		t.Fatalf("Error reading first chunk: %s", err)
+	}
+	if got := string(buf[:n]); got != "A wood" {
+		t.Errorf("Expected first chunk %q before the stream finished, got %q", "A wood", got)
+	}
+	go func() {
+		_, _ = io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"chuck\"}}]}\n\ndata: [DONE]\n\n")
+		w.Close()
+	}()
+	rest, err := io.ReadAll(content)
+	if err != nil {
+		t.Fatalf("Error reading response: %s", err)
+	}
+	if got := string(rest); got != "chuck" {
+		t.Errorf("Expected chuck, got %q", got)
+	}
+}
+
+func TestParseTextCompletionResponse_AssemblesStreamedToolCalls(t *testing.T) {
+	t.Parallel()
+	body := strings.Join([]string{
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_1","function":{"name":"weather","arguments":""}}]}}]}`,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]}}]}`,
+		`data: {"choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Perth\"}"}}]}}]}`,
+		`data: [DONE]`,
+	}, "\n\n")
+	req := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	content, err := openai.ParseTextCompletionRequest(req)
+	if err != nil {
+		t.Fatalf("Error parsing response: %s", err)
+	}
+	_, err = io.ReadAll(content)
+	if err != nil {
+		t.Fatalf("Error reading response: %s", err)
+	}
+	got := content.(llm.ToolCaller).ToolCalls()
+	want := []llm.ToolCall{{ID: "call_1", Name: "weather", Arguments: json.RawMessage(`{"city":"Perth"}`)}}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestParseTextCompletionResponse_ReportsStreamedUsage(t *testing.T) {
+	t.Parallel()
+	body := strings.Join([]string{
+		`data: {"choices":[{"delta":{"content":"Hi"}}]}`,
+		`data: {"choices":[],"usage":{"prompt_tokens":12,"completion_tokens":3,"total_tokens":15}}`,
+		`data: [DONE]`,
+	}, "\n\n")
+	req := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	content, err := openai.ParseTextCompletionRequest(req)
+	if err != nil {
+		t.Fatalf("Error parsing response: %s", err)
+	}
+	_, err = io.ReadAll(content)
+	if err != nil {
+		t.Fatalf("Error reading response: %s", err)
+	}
+	got := content.(llm.UsageReporter).Usage()
+	want := llm.Usage{InputTokens: 12, OutputTokens: 3}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestMessageFromPrompt_RendersToolTurns(t *testing.T) {
+	t.Parallel()
+	prompt := goracle.Prompt{
+		Question: "What's the weather in Perth?",
+		ToolTurns: []llm.ToolTurn{{
+			Calls:   []llm.ToolCall{{ID: "call_1", Name: "weather", Arguments: json.RawMessage(`{"city":"Perth"}`)}},
+			Results: []llm.ToolResult{{ID: "call_1", Name: "weather", Content: "sunny"}},
+		}},
+	}
+	messages := openai.MessageFromPrompt(prompt)
+	data, err := json.Marshal(messages[2:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Perth\"}"}}]},{"role":"tool","tool_call_id":"call_1","content":"sunny"}]`
+	if got := string(data); got != want {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
 func TestNewChatGPTToken(t *testing.T) {
 	t.Parallel()
 	c := client.NewChatGPT("dummy-token-openai")
@@ -146,6 +336,82 @@ func TestMessageFromPrompt(t *testing.T) {
 	}
 }
 
+func TestMessageFromPrompt_LabelsNamedReferences(t *testing.T) {
+	t.Parallel()
+	prompt := goracle.Prompt{
+		Question:   "Where is main?",
+		References: []goracle.Reference{{Name: "cmd/main.go", Data: []byte("package main")}},
+	}
+	messages := openai.MessageFromPrompt(prompt)
+	want := openai.TextMessage{Role: openai.RoleUser, Content: "Reference 1 (cmd/main.go): package main"}
+	if got := messages[len(messages)-1]; !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestMessageFromPrompt_SendsPDFsAsFiles(t *testing.T) {
+	t.Parallel()
+	prompt := goracle.Prompt{
+		Question:   "What does the report say?",
+		References: []goracle.Reference{{Name: "report.pdf", MIMEType: "application/pdf", Data: []byte("%PDF-1.4")}},
+	}
+	messages := openai.MessageFromPrompt(prompt)
+	got, ok := messages[len(messages)-1].(openai.FileMessage)
+	if !ok {
+		t.Fatalf("Expected FileMessage, got %v", messages[len(messages)-1])
+	}
+	want := openai.FileContent{Type: "file"}
+	want.File.Filename = "report.pdf"
+	want.File.FileData = "data:application/pdf;base64,JVBERi0xLjQ="
+	if !cmp.Equal([]openai.FileContent{want}, got.Content) {
+		t.Error(cmp.Diff([]openai.FileContent{want}, got.Content))
+	}
+}
+
+func TestMessageFromPrompt_SendsImagesFromTheWebByURL(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+	}))
+	defer server.Close()
+	buf := new(bytes.Buffer)
+	err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prompt := goracle.Prompt{
+		References: []goracle.Reference{{Source: server.URL + "/logo.png", Data: buf.Bytes()}},
+	}
+	messages := openai.MessageFromPrompt(prompt)
+	got, ok := messages[len(messages)-1].(openai.VisionMessage)
+	if !ok {
+		t.Fatalf("Expected VisionMessage, got %v", messages[len(messages)-1])
+	}
+	if want := server.URL + "/logo.png"; got.GetContent() != want {
+		t.Errorf("Expected %s, got %s", want, got.GetContent())
+	}
+}
+
+func TestMessageFromPrompt_SendsJPEGsInTheirOwnFormat(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	err := jpeg.Encode(buf, image.NewGray(image.Rect(0, 0, 1, 1)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prompt := goracle.Prompt{
+		References: []goracle.Reference{{Data: buf.Bytes()}},
+	}
+	messages := openai.MessageFromPrompt(prompt)
+	got, ok := messages[len(messages)-1].(openai.VisionMessage)
+	if !ok {
+		t.Fatalf("Expected VisionMessage, got %v", messages[len(messages)-1])
+	}
+	if !strings.HasPrefix(got.GetContent(), "data:image/jpeg;base64,/9j/") {
+		t.Errorf("Expected a JPEG data URI, got %.40s", got.GetContent())
+	}
+}
+
 func TestMessageFromPromptWithImages(t *testing.T) {
 	t.Parallel()
 	testImage := image.NewGray(image.Rect(0, 0, 1, 1))
@@ -155,7 +421,7 @@ func TestMessageFromPromptWithImages(t *testing.T) {
 		t.Errorf("Error encoding test image: %s", err)
 	}
 	prompt := goracle.Prompt{
-		References: [][]byte{buf.Bytes()},
+		References: []goracle.Reference{{Data: buf.Bytes()}},
 	}
 	messages := openai.MessageFromPrompt(prompt)
 	if len(messages) != 3 {
@@ -244,6 +510,45 @@ func TestErrorRateLimitsHitsRetryLimitsSignalsTryAfterRequestsReset(t *testing.T
 	}
 }
 
+func TestNewClientError_ReportsRetryableStatus(t *testing.T) {
+	t.Parallel()
+	resp := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Status:     "429 Too Many Requests",
+		Header:     http.Header{"Retry-After": []string{"3"}},
+		Body:       io.NopCloser(strings.NewReader(`{}`)),
+	}
+	err := openai.NewClientError(resp)
+	var status *llm.StatusError
+	if !errors.As(err, &status) {
+		t.Fatalf("expected a StatusError, got %v", err)
+	}
+	if !status.Temporary() || status.RetryAfter != 3*time.Second {
+		t.Errorf("expected a temporary error retrying after 3s, got %+v", status)
+	}
+}
+
+func TestErrorBadRequest_ReportsContextLengthExceeded(t *testing.T) {
+	t.Parallel()
+	body := `{"error":{"message":"This model's maximum context length is 8192 tokens. However, your messages resulted in 9000 tokens. Please reduce the length of the messages.","type":"invalid_request_error","param":"messages","code":"context_length_exceeded"}}`
+	resp := http.Response{
+		StatusCode: http.StatusBadRequest,
+		Status:     "400 Bad Request",
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	err := openai.ErrorBadRequest(resp)
+	if !errors.Is(err, llm.ErrContextLength) {
+		t.Errorf("expected ErrContextLength, got %v", err)
+	}
+	want := openai.BadRequestError{}
+	if !errors.As(err, &want) {
+		t.Fatalf("expected BadRequestError, got %v", err)
+	}
+	if want.TokenLimit != 8192 || want.PromptTokens != 9000 {
+		t.Errorf("expected limit 8192 and 9000 prompt tokens, got %d and %d", want.TokenLimit, want.PromptTokens)
+	}
+}
+
 func TestCreateVisionRequest(t *testing.T) {
 	t.Parallel()
 	messages := testMessages()
@@ -256,7 +561,7 @@ func TestCreateVisionRequest(t *testing.T) {
 		t.Errorf("Error reading request body: %s", err)
 	}
 	got := string(data)
-	want := `{"model":"gpt-4o","messages":[{"role":"system","content":"A test purpose"},{"role":"user","content":"GivenInput"},{"role":"assistant","content":"IdealOutput"},{"role":"user","content":"GivenInput2"},{"role":"assistant","content":"IdealOutput2"},{"role":"user","content":"A test question"},{"role":"user","content":"Reference 1: page1"},{"role":"user","content":"Reference 2: page2"}],"max_tokens":300}` + "\n"
+	want := `{"model":"gpt-4o","messages":[{"role":"system","content":"A test purpose"},{"role":"user","content":"GivenInput"},{"role":"assistant","content":"IdealOutput"},{"role":"user","content":"GivenInput2"},{"role":"assistant","content":"IdealOutput2"},{"role":"user","content":"A test question"},{"role":"user","content":"Reference 1: page1"},{"role":"user","content":"Reference 2: page2"}],"stream":true,"stream_options":{"include_usage":true}}` + "\n"
 	if err != nil {
 		t.Errorf("Error unmarshalling request body: %s", err)
 	}
@@ -301,12 +606,16 @@ func TestParseVisionResponse_ReturnsClientErrorIfNotOK(t *testing.T) {
 
 func TestURLToURI(t *testing.T) {
 	t.Parallel()
-	pngURL, _ := url.Parse("https://www.google.com/images/branding/googlelogo/1x/googlelogo_color_272x92dp.png")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+	}))
+	defer server.Close()
+	pngURL, _ := url.Parse(server.URL + "/logo.png")
 	got, err := openai.URLToURI(*pngURL)
 	if err != nil {
 		t.Errorf("Error converting url to data uri: %s", err)
 	}
-	want := "https://www.google.com/images/branding/googlelogo/1x/googlelogo_color_272x92dp.png"
+	want := server.URL + "/logo.png"
 	if want != got {
 		t.Fatalf("Expected %s, got %s", want, got)
 	}
@@ -446,3 +755,94 @@ func TestSpeechToTextRequest(t *testing.T) {
 		t.Errorf("Expected non-empty body, got empty body")
 	}
 }
+
+func TestMessageFromPrompt_SendsSmallImagesAtLowDetail(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prompt := goracle.Prompt{
+		References: []goracle.Reference{{Data: buf.Bytes()}},
+	}
+	messages := openai.MessageFromPrompt(prompt)
+	got, ok := messages[len(messages)-1].(openai.VisionMessage)
+	if !ok {
+		t.Fatalf("Expected VisionMessage, got %v", messages[len(messages)-1])
+	}
+	if got.Content[0].ImageURL.Detail != openai.DetailLow {
+		t.Errorf("Expected low detail, got %q", got.Content[0].ImageURL.Detail)
+	}
+}
+
+func TestImagePolicy_EstimatesTokensByDetail(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	err := png.Encode(buf, image.NewGray(image.Rect(0, 0, 1024, 1024)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcs := map[string]int{
+		// Scaled to 768x768, which takes four 512px tiles.
+		openai.DetailHigh: 85 + 170*4,
+		openai.DetailLow:  85,
+	}
+	for detail, want := range tcs {
+		got, err := openai.ImagePolicy(detail).EstimateTokens(buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: expected %d tokens, got %d", detail, want, got)
+		}
+	}
+}
+
+func TestCreateEmbeddingRequest_AsksForShortenedVectorsOnlyWhenSupported(t *testing.T) {
+	t.Parallel()
+	req, err := openai.CreateEmbeddingRequest("token", openai.EmbeddingModels["text-embedding-3-large"], 256, []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL.String() != "https://api.openai.com/v1/embeddings" {
+		t.Errorf("unexpected URL %s", req.URL)
+	}
+	var got openai.EmbeddingRequest
+	err = json.NewDecoder(req.Body).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := openai.EmbeddingRequest{
+		Model:          "text-embedding-3-large",
+		Input:          []string{"a", "b"},
+		Dimensions:     256,
+		EncodingFormat: "float",
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	_, err = openai.Embed(context.Background(), nil, "token", openai.EmbeddingModels["text-embedding-ada-002"], 256, []string{"a"})
+	if err == nil {
+		t.Error("expected an error shortening text-embedding-ada-002")
+	}
+}
+
+func TestParseEmbeddingResponse_OrdersVectorsByIndex(t *testing.T) {
+	t.Parallel()
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`{"data":[
+			{"index":1,"embedding":[0,1]},
+			{"index":0,"embedding":[1,0]}
+		],"usage":{"prompt_tokens":2}}`)),
+	}
+	got, err := openai.ParseEmbeddingResponse(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]float32{{1, 0}, {0, 1}}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
@@ -0,0 +1,80 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/base64"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+	"github.com/mr-joshcrane/goracle/client/pdf"
+)
+
+// // Document Capability
+
+type FileContent struct {
+	Type string `json:"type"`
+	File struct {
+		Filename string `json:"filename"`
+		FileData string `json:"file_data"`
+	} `json:"file"`
+}
+
+// FileMessage gives the model a PDF document, which it reads page by page,
+// text and images alike.
+type FileMessage struct {
+	Role    string        `json:"role"`
+	Content []FileContent `json:"content"`
+}
+
+func (m FileMessage) GetFormat() string {
+	return "File"
+}
+
+func fileMessage(ref llm.Reference, n int) FileMessage {
+	content := FileContent{Type: "file"}
+	content.File.Filename = ref.Name
+	if content.File.Filename == "" {
+		content.File.Filename = ref.Label(n)
+	}
+	content.File.FileData = "data:" + pdf.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(ref.Data)
+	return FileMessage{Role: RoleUser, Content: []FileContent{content}}
+}
+
+// prepareReferences puts the prompt's references into a form the model can
+// read. PDF documents are replaced with their text if the model can't read
+// them itself, and images are scaled down to the size worth sending.
+func prepareReferences(model ModelConfig, prompt Prompt) (Prompt, error) {
+	refs := prompt.GetReferences()
+	if !model.SupportsDocuments {
+		var err error
+		refs, err = pdf.TextReferences(refs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	prepared := make([]llm.Reference, len(refs))
+	for i, ref := range refs {
+		if isImage(ref.Data) {
+			data, mimeType, err := ImagePolicy(imageDetail(model.ImageDetail, ref.Data)).Prepare(ref.Data)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(data, ref.Data) {
+				// Send the smaller copy, rather than the original by its URL.
+				ref.Source = ""
+			}
+			ref.Data, ref.MIMEType = data, mimeType
+		}
+		prepared[i] = ref
+	}
+	return withReferences{Prompt: prompt, references: prepared}, nil
+}
+
+// withReferences replaces the references of a prompt.
+type withReferences struct {
+	Prompt
+	references []llm.Reference
+}
+
+func (p withReferences) GetReferences() []llm.Reference {
+	return p.references
+}
@@ -0,0 +1,136 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+// EmbeddingModelConfig describes a model that turns text into vectors.
+type EmbeddingModelConfig struct {
+	Name string
+	// Dimensions is the length of the vectors the model returns.
+	Dimensions int
+	// Shortenable models can return shorter vectors than their full
+	// dimensions, at some cost to accuracy.
+	Shortenable bool
+	// Price is what the model costs per million input tokens.
+	Price llm.Pricing
+}
+
+var EmbeddingModels = map[string]EmbeddingModelConfig{
+	"text-embedding-3-small": {
+		Name:        "text-embedding-3-small",
+		Dimensions:  1536,
+		Shortenable: true,
+		Price:       llm.Pricing{InputPerMillion: 0.02},
+	},
+	"text-embedding-3-large": {
+		Name:        "text-embedding-3-large",
+		Dimensions:  3072,
+		Shortenable: true,
+		Price:       llm.Pricing{InputPerMillion: 0.13},
+	},
+	"text-embedding-ada-002": {
+		Name:       "text-embedding-ada-002",
+		Dimensions: 1536,
+		Price:      llm.Pricing{InputPerMillion: 0.1},
+	},
+}
+
+// maxEmbeddingInputs is the most texts the API embeds in a single request.
+const maxEmbeddingInputs = 2048
+
+type EmbeddingRequest struct {
+	Model          string   `json:"model"`
+	Input          []string `json:"input"`
+	Dimensions     int      `json:"dimensions,omitempty"`
+	EncodingFormat string   `json:"encoding_format"`
+}
+
+type EmbeddingResponse struct {
+	Data []struct {
+		Index     int       `json:"index"`
+		Embedding []float32 `json:"embedding"`
+	} `json:"data"`
+	Usage struct {
+		PromptTokens int `json:"prompt_tokens"`
+	} `json:"usage"`
+}
+
+// Embed returns a vector for each of texts, in order, of the given
+// dimensions. Zero dimensions means the model's full size. OpenAI's vectors
+// are already normalised. A nil httpClient means [http.DefaultClient].
+func Embed(ctx context.Context, httpClient *http.Client, token string, model EmbeddingModelConfig, dimensions int, texts []string) ([][]float32, error) {
+	if dimensions != 0 && dimensions != model.Dimensions && !model.Shortenable {
+		return nil, fmt.Errorf("model %s only returns vectors of %d dimensions", model.Name, model.Dimensions)
+	}
+	vectors := make([][]float32, 0, len(texts))
+	for batch := range slices.Chunk(texts, maxEmbeddingInputs) {
+		req, err := CreateEmbeddingRequest(token, model, dimensions, batch)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := llm.HTTPClient(httpClient).Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		embedded, err := ParseEmbeddingResponse(resp)
+		if err != nil {
+			return nil, err
+		}
+		if len(embedded) != len(batch) {
+			return nil, fmt.Errorf("asked for %d embeddings, got %d", len(batch), len(embedded))
+		}
+		vectors = append(vectors, embedded...)
+	}
+	return vectors, nil
+}
+
+func CreateEmbeddingRequest(token string, model EmbeddingModelConfig, dimensions int, texts []string) (*http.Request, error) {
+	body := EmbeddingRequest{
+		Model:          model.Name,
+		Input:          texts,
+		EncodingFormat: "float",
+	}
+	if model.Shortenable {
+		body.Dimensions = dimensions
+	}
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, "https://api.openai.com/v1/embeddings", buf)
+	if err != nil {
+		return nil, err
+	}
+	return addDefaultHeaders(token, req), nil
+}
+
+// ParseEmbeddingResponse returns the vectors in the order their texts were
+// given.
+func ParseEmbeddingResponse(resp *http.Response) ([][]float32, error) {
+	if resp.StatusCode != http.StatusOK {
+		return nil, NewClientError(resp)
+	}
+	defer resp.Body.Close()
+	var embeddings EmbeddingResponse
+	err := json.NewDecoder(resp.Body).Decode(&embeddings)
+	if err != nil {
+		return nil, err
+	}
+	vectors := make([][]float32, len(embeddings.Data))
+	for _, d := range embeddings.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	return vectors, nil
+}
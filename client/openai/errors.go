@@ -4,8 +4,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"regexp"
+	"strconv"
 	"time"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
 )
 
 type ClientError struct {
@@ -27,6 +32,7 @@ func (e RateLimitError) Error() string {
 
 type BadRequestError struct {
 	Reason       string
+	Code         string
 	PromptTokens int
 	TotalTokens  int
 	TokenLimit   int
@@ -36,6 +42,11 @@ func (e BadRequestError) Error() string {
 	return fmt.Sprintf("Bad request. %s", e.Reason)
 }
 
+// Is reports a context_length_exceeded error as [llm.ErrContextLength].
+func (e BadRequestError) Is(target error) bool {
+	return target == llm.ErrContextLength && e.Code == "context_length_exceeded"
+}
+
 type rateLimit struct {
 	RemainingRequests string
 	RemainingTokens   string
@@ -71,33 +82,50 @@ func ErrorRateLimitExceeded(r http.Response) error {
 	} else if rateLimit.RemainingRequests == "0" {
 		err.RetryAfter = rateLimit.ResetRequests
 	}
+	if err.RetryAfter == 0 {
+		err.RetryAfter = llm.ParseRetryAfter(r.Header)
+	}
 	return errors.Join(ClientError{
 		Status:     r.Status,
 		StatusCode: http.StatusTooManyRequests,
-	}, err)
+	}, err, &llm.StatusError{
+		StatusCode: http.StatusTooManyRequests,
+		Message:    err.Error(),
+		RetryAfter: err.RetryAfter,
+	})
 }
 
+var (
+	tokenLimitPattern   = regexp.MustCompile(`maximum context length is (\d+) tokens`)
+	promptTokensPattern = regexp.MustCompile(`resulted in (\d+) tokens`)
+)
+
 func ErrorBadRequest(r http.Response) error {
-	usage := struct {
-		usage struct {
-			PromptTokens int `json:"prompt_tokens"`
-			TotalTokens  int `json:"total_tokens"`
-		} `json:"usage"`
+	defer r.Body.Close()
+	body := struct {
+		Error struct {
+			Message string `json:"message"`
+			Type    string `json:"type"`
+			Code    string `json:"code"`
+		} `json:"error"`
 	}{}
-	data, err := json.Marshal(r.Body)
+	data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
 	if err != nil {
 		return err
 	}
-	err = json.NewDecoder(r.Body).Decode(&usage)
-	if err != nil {
-		return err
-	}
-	defer r.Body.Close()
 	brqe := BadRequestError{
-		Reason:       string(data),
-		PromptTokens: 0,
-		TotalTokens:  0,
-		TokenLimit:   8192,
+		Reason: string(data),
+	}
+	if json.Unmarshal(data, &body) == nil && body.Error.Message != "" {
+		brqe.Reason = body.Error.Message
+		brqe.Code = body.Error.Code
+	}
+	if m := tokenLimitPattern.FindStringSubmatch(brqe.Reason); m != nil {
+		brqe.TokenLimit, _ = strconv.Atoi(m[1])
+	}
+	if m := promptTokensPattern.FindStringSubmatch(brqe.Reason); m != nil {
+		brqe.PromptTokens, _ = strconv.Atoi(m[1])
+		brqe.TotalTokens = brqe.PromptTokens
 	}
 	ce := ClientError{
 		Status:     r.Status,
@@ -113,8 +141,8 @@ func NewClientError(r *http.Response) error {
 	if r.StatusCode == http.StatusTooManyRequests {
 		return ErrorRateLimitExceeded(*r)
 	}
-	return fmt.Errorf("%w", ClientError{
+	return errors.Join(ClientError{
 		Status:     r.Status,
 		StatusCode: r.StatusCode,
-	})
+	}, llm.NewStatusError(r, ""))
 }
@@ -5,20 +5,28 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+	"github.com/mr-joshcrane/goracle/client/pdf"
 )
 
 const (
 	RoleUser      = "user"
 	RoleAssistant = "assistant"
 	RoleSystem    = "system"
+	RoleTool      = "tool"
 )
 
 type Prompt interface {
 	GetPurpose() string
 	GetHistory() ([]string, []string)
 	GetQuestion() string
-	GetReferences() [][]byte
+	GetReferences() []llm.Reference
 	GetResponseFormat() []string
+	GetResponseSchema() map[string]any
+	GetTools() []llm.Tool
+	GetToolTurns() []llm.ToolTurn
+	GetOptions() llm.Options
 }
 
 type Messages []Message
@@ -36,7 +44,97 @@ func (m TextMessage) GetFormat() string {
 	return ""
 }
 
+// ToolDefinition advertises a function the model may call.
+type ToolDefinition struct {
+	Type     string       `json:"type"`
+	Function FunctionSpec `json:"function"`
+}
+
+type FunctionSpec struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Parameters  map[string]any `json:"parameters"`
+}
+
+type ToolCallPayload struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// ToolCallMessage is an assistant turn in which the model asked for tools to
+// be called.
+type ToolCallMessage struct {
+	Role      string            `json:"role"`
+	Content   string            `json:"content"`
+	ToolCalls []ToolCallPayload `json:"tool_calls"`
+}
+
+func (m ToolCallMessage) GetFormat() string {
+	return "ToolCall"
+}
+
+// ToolResultMessage carries the result of a single tool call back to the model.
+type ToolResultMessage struct {
+	Role       string `json:"role"`
+	ToolCallID string `json:"tool_call_id"`
+	Content    string `json:"content"`
+}
+
+func (m ToolResultMessage) GetFormat() string {
+	return "ToolResult"
+}
+
+func toolDefinitions(tools []llm.Tool) []ToolDefinition {
+	var definitions []ToolDefinition
+	for _, t := range tools {
+		definitions = append(definitions, ToolDefinition{
+			Type: "function",
+			Function: FunctionSpec{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  t.Parameters,
+			},
+		})
+	}
+	return definitions
+}
+
+func toolTurnMessages(turns []llm.ToolTurn) Messages {
+	messages := Messages{}
+	for _, turn := range turns {
+		call := ToolCallMessage{
+			Role:    RoleAssistant,
+			Content: turn.Text,
+		}
+		for _, c := range turn.Calls {
+			payload := ToolCallPayload{ID: c.ID, Type: "function"}
+			payload.Function.Name = c.Name
+			payload.Function.Arguments = string(c.Arguments)
+			call.ToolCalls = append(call.ToolCalls, payload)
+		}
+		messages = append(messages, call)
+		for _, r := range turn.Results {
+			messages = append(messages, ToolResultMessage{
+				Role:       RoleTool,
+				ToolCallID: r.ID,
+				Content:    r.Content,
+			})
+		}
+	}
+	return messages
+}
+
 func MessageFromPrompt(prompt Prompt) Messages {
+	return messagesFromPrompt(context.Background(), nil, prompt, "")
+}
+
+// messagesFromPrompt builds the messages for prompt, sending images at the
+// given detail level, or one picked for each image if it is empty.
+func messagesFromPrompt(ctx context.Context, httpClient *http.Client, prompt Prompt, detail string) Messages {
 	messages := []Message{}
 	messages = append(messages, TextMessage{
 		Role:    RoleSystem,
@@ -60,17 +158,25 @@ func MessageFromPrompt(prompt Prompt) Messages {
 	refs := prompt.GetReferences()
 	for i, ref := range refs {
 		i++
-		if isPNG(ref) {
-			uri := ConvertPNGToDataURI(ref)
+		if pdf.IsPDF(ref) {
+			messages = append(messages, fileMessage(ref, i))
+			continue
+		}
+		if isImage(ref.Data) {
+			if ref.Name != "" {
+				messages = append(messages, TextMessage{
+					Role:    RoleUser,
+					Content: ref.Label(i) + ":",
+				})
+			}
 			messages = append(messages, VisionMessage{
 				Role: RoleUser,
 				Content: []VisionImageURL{
 					{
 						Type: "image_url",
-						ImageURL: struct {
-							URL string `json:"url"`
-						}{
-							URL: uri,
+						ImageURL: ImageURL{
+							URL:    imageURI(ctx, httpClient, ref),
+							Detail: imageDetail(detail, ref.Data),
 						},
 					},
 				}})
@@ -78,23 +184,36 @@ func MessageFromPrompt(prompt Prompt) Messages {
 		}
 		messages = append(messages, TextMessage{
 			Role:    RoleUser,
-			Content: fmt.Sprintf("Reference %d: %s", i, ref),
+			Content: fmt.Sprintf("%s: %s", ref.Label(i), ref.Data),
 		})
 	}
+	messages = append(messages, toolTurnMessages(prompt.GetToolTurns())...)
 	return messages
 }
 
-func Do(ctx context.Context, token string, model ModelConfig, prompt Prompt) (io.Reader, error) {
-	format := prompt.GetResponseFormat()
+func Do(ctx context.Context, httpClient *http.Client, token string, model ModelConfig, prompt Prompt) (io.Reader, error) {
+	format := createFormatResponse(prompt.GetResponseFormat()...)
+	if schema := prompt.GetResponseSchema(); schema != nil {
+		format = createSchemaResponse(schema)
+	}
+	prompt, err := prepareReferences(model, prompt)
+	if err != nil {
+		return nil, err
+	}
 	strategy := textCompletion
 	refs := prompt.GetReferences()
 	for _, ref := range refs {
-		if isPNG(ref) {
+		if isImage(ref.Data) {
 			strategy = visionCompletion
 		}
 	}
-	messages := MessageFromPrompt(prompt)
-	return strategy(ctx, token, model, messages, format...)
+	s, err := sampling(model, prompt.GetOptions())
+	if err != nil {
+		return nil, err
+	}
+	messages := messagesFromPrompt(ctx, httpClient, prompt, model.ImageDetail)
+	tools := toolDefinitions(prompt.GetTools())
+	return strategy(ctx, httpClient, token, model, messages, tools, format, s)
 }
 
 func addDefaultHeaders(token string, r *http.Request) *http.Request {
@@ -1,9 +1,25 @@
 package openai
 
+import "github.com/mr-joshcrane/goracle/client/llm"
+
 type ModelConfig struct {
 	Name                   string
 	SupportsSystemMessages bool
 	SupportsVision         bool
+	// SupportsDocuments models read PDF documents natively. Others are given
+	// the text of them instead.
+	SupportsDocuments bool
+	// ImageDetail is the detail level images are sent at, such as
+	// [DetailLow]. If it is empty, low detail is used for small images and
+	// high detail for the rest.
+	ImageDetail string
+	// Reasoning models think before they answer. They accept a reasoning
+	// effort, but not the usual sampling settings.
+	Reasoning bool
+	// ContextWindow is the most tokens the model accepts in a single request.
+	ContextWindow int
+	// Price is what the model costs per million tokens.
+	Price llm.Pricing
 }
 
 var Models = map[string]ModelConfig{
@@ -11,25 +27,40 @@ var Models = map[string]ModelConfig{
 		Name:                   "gpt-4.1",
 		SupportsSystemMessages: true,
 		SupportsVision:         true,
+		SupportsDocuments:      true,
+		ContextWindow:          1047576,
+		Price:                  llm.Pricing{InputPerMillion: 2, OutputPerMillion: 8},
 	},
 	"gpt-4o": {
 		Name:                   "gpt-4o",
 		SupportsSystemMessages: true,
 		SupportsVision:         true,
+		SupportsDocuments:      true,
+		ContextWindow:          128000,
+		Price:                  llm.Pricing{InputPerMillion: 2.5, OutputPerMillion: 10},
 	},
 	"gpt-4o-mini": {
 		Name:                   "gpt-4o-mini",
 		SupportsSystemMessages: true,
 		SupportsVision:         true,
+		SupportsDocuments:      true,
+		ContextWindow:          128000,
+		Price:                  llm.Pricing{InputPerMillion: 0.15, OutputPerMillion: 0.6},
 	},
 	"o1-preview": {
 		Name:                   "o1-preview",
 		SupportsSystemMessages: false,
 		SupportsVision:         false,
+		Reasoning:              true,
+		ContextWindow:          128000,
+		Price:                  llm.Pricing{InputPerMillion: 15, OutputPerMillion: 60},
 	},
 	"o1-mini": {
 		Name:                   "o1-mini",
 		SupportsSystemMessages: false,
 		SupportsVision:         false,
+		Reasoning:              true,
+		ContextWindow:          128000,
+		Price:                  llm.Pricing{InputPerMillion: 3, OutputPerMillion: 12},
 	},
 }
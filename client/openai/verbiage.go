@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
 )
 
 const (
@@ -18,26 +21,128 @@ const (
 )
 
 type TextCompletionRequest struct {
-	Model          string         `json:"model"`
-	Messages       Messages       `json:"messages"`
-	ResponseFormat map[string]any `json:"response_format"`
+	Model          string           `json:"model"`
+	Messages       Messages         `json:"messages"`
+	ResponseFormat map[string]any   `json:"response_format"`
+	Tools          []ToolDefinition `json:"tools,omitempty"`
+	Stream         bool             `json:"stream,omitempty"`
+	StreamOptions  *StreamOptions   `json:"stream_options,omitempty"`
+	Sampling
+}
+
+// Sampling holds the generation settings a completion request may carry,
+// mapped from [llm.Options]. Unset fields are left out so that OpenAI's
+// defaults apply.
+type Sampling struct {
+	Temperature         *float64 `json:"temperature,omitempty"`
+	TopP                *float64 `json:"top_p,omitempty"`
+	MaxCompletionTokens *int     `json:"max_completion_tokens,omitempty"`
+	Stop                []string `json:"stop,omitempty"`
+	Seed                *int     `json:"seed,omitempty"`
+	PresencePenalty     *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty    *float64 `json:"frequency_penalty,omitempty"`
+	ReasoningEffort     string   `json:"reasoning_effort,omitempty"`
+}
+
+// sampling maps opts onto the settings OpenAI accepts for model, refusing
+// any it can't honour. There is no top_k, and reasoning models take a
+// reasoning effort in place of the other sampling settings.
+func sampling(model ModelConfig, opts llm.Options) (Sampling, error) {
+	unsupported := []string{llm.OptionTopK}
+	if model.Reasoning {
+		unsupported = append(unsupported, llm.OptionTemperature, llm.OptionTopP, llm.OptionPresencePenalty, llm.OptionFrequencyPenalty)
+	} else {
+		unsupported = append(unsupported, llm.OptionReasoningEffort)
+	}
+	err := opts.Reject(model.Name, unsupported...)
+	if err != nil {
+		return Sampling{}, err
+	}
+	return Sampling{
+		Temperature:         opts.Temperature,
+		TopP:                opts.TopP,
+		MaxCompletionTokens: opts.MaxOutputTokens,
+		Stop:                opts.StopSequences,
+		Seed:                opts.Seed,
+		PresencePenalty:     opts.PresencePenalty,
+		FrequencyPenalty:    opts.FrequencyPenalty,
+		ReasoningEffort:     opts.ReasoningEffort,
+	}, nil
+}
+
+// StreamOptions asks for extras in a streamed completion. With IncludeUsage
+// set, a final chunk reports the tokens used.
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// includeUsage is sent with every streamed request, so that cost can be
+// tracked.
+var includeUsage = &StreamOptions{IncludeUsage: true}
+
+// TokenUsage is the usage block OpenAI returns with a completion.
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func (u *TokenUsage) usage() llm.Usage {
+	if u == nil {
+		return llm.Usage{}
+	}
+	return llm.Usage{
+		InputTokens:  u.PromptTokens,
+		OutputTokens: u.CompletionTokens,
+	}
 }
 
 type TextCompletionResponse struct {
 	Choices []struct {
-		Message TextMessage `json:"message"`
+		Message struct {
+			Role      string            `json:"role"`
+			Content   string            `json:"content"`
+			ToolCalls []ToolCallPayload `json:"tool_calls"`
+		} `json:"message"`
 	} `json:"choices"`
+	Usage *TokenUsage `json:"usage"`
 }
 
-func textCompletion(ctx context.Context, token string, model ModelConfig, messages Messages, format ...string) (io.Reader, error) {
+// TextCompletionChunk is a single server-sent event of a streamed completion.
+type TextCompletionChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Index    int    `json:"index"`
+				ID       string `json:"id"`
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *TokenUsage `json:"usage"`
+}
+
+func textCompletion(ctx context.Context, httpClient *http.Client, token string, model ModelConfig, messages Messages, tools []ToolDefinition, format map[string]any, s Sampling) (io.Reader, error) {
 	if !model.SupportsSystemMessages {
 		messages = messages[1:]
 	}
-	req, err := CreateTextCompletionRequest(token, model.Name, messages, format...)
+	req, err := newTextCompletionRequest(token, TextCompletionRequest{
+		Model:          model.Name,
+		Messages:       messages,
+		ResponseFormat: format,
+		Tools:          tools,
+		Stream:         true,
+		StreamOptions:  includeUsage,
+		Sampling:       s,
+	})
 	if err != nil {
 		return nil, err
 	}
-	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	resp, err := llm.HTTPClient(httpClient).Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -56,26 +161,38 @@ func createFormatResponse(args ...string) map[string]any {
 	if len(args) < 1 {
 		return nil
 	}
+	properties := map[string]any{}
+	required := []string{}
+	for _, arg := range args {
+		name, description := parseResponseArg(arg)
+		properties[name] = map[string]any{
+			"description": description,
+			"type":        "string",
+		}
+		required = append(required, name)
+	}
 	schema := map[string]any{
 		"type": "object",
 		"properties": map[string]any{
 			"response": map[string]any{
-				"type":  "array",
-				"items": map[string]any{},
+				"type": "array",
+				"items": map[string]any{
+					"type":                 "object",
+					"properties":           properties,
+					"required":             required,
+					"additionalProperties": false,
+				},
 			},
 		},
+		"required":             []string{"response"},
 		"additionalProperties": false,
 	}
+	return createSchemaResponse(schema)
+}
 
-	items := schema["properties"].(map[string]any)["response"].(map[string]any)["items"].(map[string]any)
-	for _, arg := range args {
-		name, description := parseResponseArg(arg)
-		items[name] = map[string]any{
-			"description": description,
-			"type":        "string",
-		}
-	}
-
+// createSchemaResponse asks for an answer in JSON that conforms to schema,
+// using the json_schema response format.
+func createSchemaResponse(schema map[string]any) map[string]any {
 	return map[string]any{
 		"type": "json_schema",
 		"json_schema": map[string]any{
@@ -86,12 +203,18 @@ func createFormatResponse(args ...string) map[string]any {
 }
 
 func CreateTextCompletionRequest(token string, model string, messages Messages, outputs ...string) (*http.Request, error) {
-	buf := new(bytes.Buffer)
-	err := json.NewEncoder(buf).Encode(TextCompletionRequest{
+	return newTextCompletionRequest(token, TextCompletionRequest{
 		Model:          model,
 		Messages:       messages,
 		ResponseFormat: createFormatResponse(outputs...),
+		Stream:         true,
+		StreamOptions:  includeUsage,
 	})
+}
+
+func newTextCompletionRequest(token string, body TextCompletionRequest) (*http.Request, error) {
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(body)
 	if err != nil {
 		return nil, err
 	}
@@ -104,10 +227,16 @@ func CreateTextCompletionRequest(token string, model string, messages Messages,
 	return req, nil
 }
 
+// ParseTextCompletionRequest turns a chat completion response into a reader
+// of the answer. Streamed responses are decoded lazily as the reader is
+// consumed, while plain JSON responses are decoded up front.
 func ParseTextCompletionRequest(resp *http.Response) (io.Reader, error) {
 	if http.StatusOK != resp.StatusCode {
 		return nil, NewClientError(resp)
 	}
+	if llm.IsEventStream(resp.Header.Get("Content-Type")) {
+		return streamCompletion(resp.Body), nil
+	}
 	defer resp.Body.Close()
 	var completion TextCompletionResponse
 	err := json.NewDecoder(resp.Body).Decode(&completion)
@@ -117,6 +246,63 @@ func ParseTextCompletionRequest(resp *http.Response) (io.Reader, error) {
 	if len(completion.Choices) < 1 {
 		return nil, fmt.Errorf("no choices returned")
 	}
-	output := strings.NewReader(completion.Choices[0].Message.Content)
-	return output, nil
+	message := completion.Choices[0].Message
+	delta := llm.Delta{Text: message.Content, Usage: completion.Usage.usage()}
+	for _, c := range message.ToolCalls {
+		delta.ToolCalls = append(delta.ToolCalls, llm.ToolCall{
+			ID:        c.ID,
+			Name:      c.Function.Name,
+			Arguments: json.RawMessage(c.Function.Arguments),
+		})
+	}
+	return llm.Once(delta), nil
+}
+
+// streamCompletion decodes a streamed completion. Tool calls arrive in
+// fragments keyed by index, so they are assembled as the stream is read and
+// handed over in full once it ends.
+func streamCompletion(body io.ReadCloser) *llm.Stream {
+	events := llm.NewEventScanner(body)
+	var calls []llm.ToolCall
+	var arguments []strings.Builder
+	done := false
+	return llm.NewStream(body, func() (llm.Delta, error) {
+		if done {
+			return llm.Delta{}, io.EOF
+		}
+		event, err := events.Next()
+		if errors.Is(err, io.EOF) || event.Data == "[DONE]" {
+			done = true
+			for i := range calls {
+				calls[i].Arguments = json.RawMessage(arguments[i].String())
+			}
+			return llm.Delta{ToolCalls: calls}, nil
+		}
+		if err != nil {
+			return llm.Delta{}, err
+		}
+		var chunk TextCompletionChunk
+		err = json.Unmarshal([]byte(event.Data), &chunk)
+		if err != nil {
+			return llm.Delta{}, fmt.Errorf("failed to decode completion chunk: %w", err)
+		}
+		delta := llm.Delta{Usage: chunk.Usage.usage()}
+		for _, choice := range chunk.Choices {
+			delta.Text += choice.Delta.Content
+			for _, c := range choice.Delta.ToolCalls {
+				for len(calls) <= c.Index {
+					calls = append(calls, llm.ToolCall{})
+					arguments = append(arguments, strings.Builder{})
+				}
+				if c.ID != "" {
+					calls[c.Index].ID = c.ID
+				}
+				if c.Function.Name != "" {
+					calls[c.Index].Name = c.Function.Name
+				}
+				arguments[c.Index].WriteString(c.Function.Arguments)
+			}
+		}
+		return delta, nil
+	})
 }
@@ -6,10 +6,13 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"image"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
-	"strings"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
 )
 
 const (
@@ -32,12 +35,12 @@ type ImageResponse struct {
 	} `json:"data"`
 }
 
-func DoImageRequest(ctx context.Context, token string, prompt string) ([]byte, error) {
+func DoImageRequest(ctx context.Context, httpClient *http.Client, token string, prompt string) ([]byte, error) {
 	req, err := CreateImageRequest(token, prompt)
 	if err != nil {
 		return nil, err
 	}
-	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	resp, err := llm.HTTPClient(httpClient).Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -45,7 +48,7 @@ func DoImageRequest(ctx context.Context, token string, prompt string) ([]byte, e
 	if err != nil {
 		return nil, err
 	}
-	return ParseLinkToImage(link)
+	return downloadImage(ctx, httpClient, link)
 }
 
 func CreateImageRequest(token string, prompt string) (*http.Request, error) {
@@ -85,7 +88,15 @@ func ParseCreateImageResponse(resp *http.Response) (string, error) {
 }
 
 func ParseLinkToImage(link string) ([]byte, error) {
-	resp, err := http.DefaultClient.Get(link)
+	return downloadImage(context.Background(), nil, link)
+}
+
+func downloadImage(ctx context.Context, httpClient *http.Client, link string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := llm.HTTPClient(httpClient).Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -96,10 +107,62 @@ func ParseLinkToImage(link string) ([]byte, error) {
 // // Vision Capability
 
 type VisionImageURL struct {
-	Type     string `json:"type"`
-	ImageURL struct {
-		URL string `json:"url"`
-	} `json:"image_url"`
+	Type     string   `json:"type"`
+	ImageURL ImageURL `json:"image_url"`
+}
+
+type ImageURL struct {
+	URL string `json:"url"`
+	// Detail is how closely the model looks at the image.
+	Detail string `json:"detail,omitempty"`
+}
+
+// The detail levels images can be sent at. At low detail, the model sees the
+// image at 512px for a fixed 85 tokens, while at high detail it also reads
+// it in 512px tiles of 170 tokens each. Auto leaves the choice to OpenAI.
+const (
+	DetailAuto = "auto"
+	DetailLow  = "low"
+	DetailHigh = "high"
+)
+
+// ImagePolicy returns how images are prepared at the given detail level, and
+// what they cost. OpenAI accepts images of up to 20MB, but has no use for
+// more than 2048px on the long side and 768px on the short side, or 512px
+// at low detail.
+func ImagePolicy(detail string) llm.ImagePolicy {
+	if detail == DetailLow {
+		return llm.ImagePolicy{
+			Formats:      []string{llm.ImagePNG, llm.ImageJPEG, llm.ImageGIF, llm.ImageWebP},
+			MaxLongSide:  512,
+			MaxShortSide: 512,
+			MaxBytes:     20 << 20,
+			Tokens:       func(width, height int) int { return 85 },
+		}
+	}
+	return llm.ImagePolicy{
+		Formats:      []string{llm.ImagePNG, llm.ImageJPEG, llm.ImageGIF, llm.ImageWebP},
+		MaxLongSide:  2048,
+		MaxShortSide: 768,
+		MaxBytes:     20 << 20,
+		Tokens: func(width, height int) int {
+			tiles := ((width + 511) / 512) * ((height + 511) / 512)
+			return 85 + 170*tiles
+		},
+	}
+}
+
+// imageDetail picks the detail level for an image. Unless one is given, low
+// detail is used for images small enough to lose nothing by it.
+func imageDetail(detail string, data []byte) string {
+	if detail != "" {
+		return detail
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil && config.Width <= 512 && config.Height <= 512 {
+		return DetailLow
+	}
+	return DetailHigh
 }
 
 type VisionMessage struct {
@@ -119,9 +182,14 @@ func (m VisionMessage) GetContent() string {
 }
 
 type VisionRequest struct {
-	Model     string   `json:"model"`
-	Messages  Messages `json:"messages"`
-	MaxTokens int      `json:"max_tokens"`
+	Model          string           `json:"model"`
+	Messages       Messages         `json:"messages"`
+	MaxTokens      int              `json:"max_tokens,omitempty"`
+	ResponseFormat map[string]any   `json:"response_format,omitempty"`
+	Tools          []ToolDefinition `json:"tools,omitempty"`
+	Stream         bool             `json:"stream,omitempty"`
+	StreamOptions  *StreamOptions   `json:"stream_options,omitempty"`
+	Sampling
 }
 type VisionCompletionResponse struct {
 	Choices []struct {
@@ -129,15 +197,22 @@ type VisionCompletionResponse struct {
 			Content string `json:"content"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage *TokenUsage `json:"usage"`
 }
 
-func CreateVisionRequest(token string, model ModelConfig, messages Messages) (*http.Request, error) {
-	buf := new(bytes.Buffer)
-	err := json.NewEncoder(buf).Encode(VisionRequest{
-		Model:     model.Name,
-		Messages:  messages,
-		MaxTokens: 300,
+func CreateVisionRequest(token string, model ModelConfig, messages Messages, tools ...ToolDefinition) (*http.Request, error) {
+	return newVisionRequest(token, VisionRequest{
+		Model:         model.Name,
+		Messages:      messages,
+		Tools:         tools,
+		Stream:        true,
+		StreamOptions: includeUsage,
 	})
+}
+
+func newVisionRequest(token string, body VisionRequest) (*http.Request, error) {
+	buf := new(bytes.Buffer)
+	err := json.NewEncoder(buf).Encode(body)
 	if err != nil {
 		return nil, err
 	}
@@ -154,6 +229,9 @@ func ParseVisionResponse(resp *http.Response) (io.Reader, error) {
 	if resp.StatusCode != http.StatusOK {
 		return nil, NewClientError(resp)
 	}
+	if llm.IsEventStream(resp.Header.Get("Content-Type")) {
+		return streamCompletion(resp.Body), nil
+	}
 	defer resp.Body.Close()
 	err := json.NewDecoder(resp.Body).Decode(&completion)
 	if err != nil {
@@ -162,27 +240,39 @@ func ParseVisionResponse(resp *http.Response) (io.Reader, error) {
 	if len(completion.Choices) < 1 {
 		return nil, fmt.Errorf("no choices returned")
 	}
-	answer := strings.NewReader(completion.Choices[0].Message.Content)
-	return answer, nil
+	return llm.Once(llm.Delta{
+		Text:  completion.Choices[0].Message.Content,
+		Usage: completion.Usage.usage(),
+	}), nil
 }
 
-func visionCompletion(ctx context.Context, token string, model ModelConfig, message Messages, format ...string) (io.Reader, error) {
+func visionCompletion(ctx context.Context, httpClient *http.Client, token string, model ModelConfig, messages Messages, tools []ToolDefinition, format map[string]any, s Sampling) (io.Reader, error) {
 	if !model.SupportsVision {
 		return nil, fmt.Errorf("current model %s does not support visual input", model.Name)
 	}
-	req, err := CreateVisionRequest(token, model, message)
+	req, err := newVisionRequest(token, VisionRequest{
+		Model:          model.Name,
+		Messages:       messages,
+		ResponseFormat: format,
+		Tools:          tools,
+		Stream:         true,
+		StreamOptions:  includeUsage,
+		Sampling:       s,
+	})
 	if err != nil {
 		return nil, err
 	}
-	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	resp, err := llm.HTTPClient(httpClient).Do(req.WithContext(ctx))
 	if err != nil {
 		return nil, err
 	}
 	return ParseVisionResponse(resp)
 }
 
-func isPNG(a []byte) bool {
-	return len(a) > 8 && bytes.Equal(a[:8], []byte("\x89PNG\x0d\x0a\x1a\x0a"))
+// isImage reports whether data is an image in a format the model can see:
+// PNG, JPEG, WebP or GIF.
+func isImage(data []byte) bool {
+	return llm.ImageType(data) != ""
 }
 
 func ConvertPNGToDataURI(data []byte) string {
@@ -191,18 +281,49 @@ func ConvertPNGToDataURI(data []byte) string {
 	return dataURI
 }
 
+// ConvertImageToDataURI encodes an image as a data URI, keeping its format.
+func ConvertImageToDataURI(data []byte) string {
+	return "data:" + llm.ImageType(data) + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// imageURI gives the model an image by its URL, if it came from the web and
+// is still served there, saving sending the image itself.
+func imageURI(ctx context.Context, httpClient *http.Client, ref llm.Reference) string {
+	u, err := url.Parse(ref.Source)
+	if err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		if uri, err := servedImage(ctx, httpClient, *u); err == nil {
+			return uri
+		}
+	}
+	return ConvertImageToDataURI(ref.Data)
+}
+
+// URLToURI checks that url serves an image the model can see, returning it
+// if so.
 func URLToURI(url url.URL) (string, error) {
+	return servedImage(context.Background(), nil, url)
+}
+
+func servedImage(ctx context.Context, httpClient *http.Client, url url.URL) (string, error) {
 	visionMimeType := []string{
 		"image/png",
 		"image/jpeg",
 		"image/jpg",
+		"image/gif",
+		"image/webp",
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url.String(), nil)
+	if err != nil {
+		return "", err
 	}
-	resp, err := http.DefaultClient.Head(url.String())
+	resp, err := llm.HTTPClient(httpClient).Do(req)
 	if err != nil {
 		return "", err
 	}
+	resp.Body.Close()
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
 	for _, mimeType := range visionMimeType {
-		if resp.Header.Get("Content-Type") == mimeType {
+		if contentType == mimeType {
 			return url.String(), nil
 		}
 	}
@@ -9,6 +9,8 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
 )
 
 type Voice string
@@ -43,13 +45,13 @@ func WithVoice(voice Voice) TTSReqOptions {
 	}
 }
 
-func TextToSpeech(ctx context.Context, token string, text string) ([]byte, error) {
+func TextToSpeech(ctx context.Context, httpClient *http.Client, token string, text string) ([]byte, error) {
 	req, err := CreateTextToSpeechRequest(token, text)
 	if err != nil {
 		return nil, err
 	}
 	req = req.WithContext(ctx)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := llm.HTTPClient(httpClient).Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -60,13 +62,13 @@ func TextToSpeech(ctx context.Context, token string, text string) ([]byte, error
 	return io.ReadAll(resp.Body)
 }
 
-func SpeechToText(ctx context.Context, token string, audio []byte) (string, error) {
+func SpeechToText(ctx context.Context, httpClient *http.Client, token string, audio []byte) (string, error) {
 	req, err := CreateSpeechToTextRequest(token, audio)
 	if err != nil {
 		return "", err
 	}
 	req = req.WithContext(ctx)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := llm.HTTPClient(httpClient).Do(req)
 	if err != nil {
 		return "", err
 	}
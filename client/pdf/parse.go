@@ -0,0 +1,545 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+// The kinds of object a PDF is made of. Integers are int64 and reals are
+// float64, while null, booleans and strings are nil, bool and []byte.
+type (
+	name    string
+	keyword string
+	dict    map[name]any
+	array   []any
+	ref     struct{ num, gen int64 }
+	stream  struct {
+		dict dict
+		raw  []byte
+	}
+)
+
+// delim is a closing delimiter met while parsing a compound object.
+type delim byte
+
+// lexer reads objects from PDF syntax. In object mode, "n g R" is read as a
+// reference; content streams have no references, so it is left off there.
+type lexer struct {
+	data    []byte
+	pos     int
+	objects bool
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return isSpace(c)
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+			continue
+		}
+		if !isSpace(c) {
+			return
+		}
+		l.pos++
+	}
+}
+
+// next reads the next object, or a delim at the end of a compound object. It
+// returns io.EOF once the data is used up.
+func (l *lexer) next() (any, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, io.EOF
+	}
+	c := l.data[l.pos]
+	switch {
+	case c == '/':
+		return l.name(), nil
+	case c == '(':
+		return l.literal(), nil
+	case c == '<' && l.peek(1) == '<':
+		l.pos += 2
+		return l.dict()
+	case c == '<':
+		return l.hex(), nil
+	case c == '>' && l.peek(1) == '>':
+		l.pos += 2
+		return delim('>'), nil
+	case c == '[':
+		l.pos++
+		return l.array()
+	case c == ']' || c == '}' || c == ')' || c == '>':
+		l.pos++
+		return delim(c), nil
+	case c == '{':
+		l.pos++
+		return keyword("{"), nil
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		return l.number(), nil
+	}
+	start := l.pos
+	for l.pos < len(l.data) && !isDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	if l.pos == start {
+		l.pos++
+	}
+	switch word := string(l.data[start:l.pos]); word {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	default:
+		return keyword(word), nil
+	}
+}
+
+func (l *lexer) peek(n int) byte {
+	if l.pos+n >= len(l.data) {
+		return 0
+	}
+	return l.data[l.pos+n]
+}
+
+func (l *lexer) name() name {
+	l.pos++
+	var b []byte
+	for l.pos < len(l.data) && !isDelimiter(l.data[l.pos]) {
+		c := l.data[l.pos]
+		if c == '#' && l.pos+2 < len(l.data) {
+			if v, err := strconv.ParseUint(string(l.data[l.pos+1:l.pos+3]), 16, 8); err == nil {
+				b = append(b, byte(v))
+				l.pos += 3
+				continue
+			}
+		}
+		b = append(b, c)
+		l.pos++
+	}
+	return name(b)
+}
+
+func (l *lexer) literal() []byte {
+	l.pos++
+	var b []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return b
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return b
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if l.peek(0) == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					v := int(c - '0')
+					for range 2 {
+						d := l.peek(0)
+						if d < '0' || d > '7' {
+							break
+						}
+						v = v*8 + int(d-'0')
+						l.pos++
+					}
+					c = byte(v)
+				}
+			}
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+func (l *lexer) hex() []byte {
+	l.pos++
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; !isSpace(c) {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	b := make([]byte, len(digits)/2)
+	n, _ := hex.Decode(b, digits)
+	return b[:n]
+}
+
+func (l *lexer) number() any {
+	start := l.pos
+	l.pos++
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if c != '.' && (c < '0' || c > '9') {
+			break
+		}
+		l.pos++
+	}
+	text := string(l.data[start:l.pos])
+	if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+		if l.objects {
+			if r, ok := l.reference(i); ok {
+				return r
+			}
+		}
+		return i
+	}
+	f, _ := strconv.ParseFloat(text, 64)
+	return f
+}
+
+// reference reads the rest of "num gen R", if that's what follows num.
+func (l *lexer) reference(num int64) (ref, bool) {
+	m := referenceTail.FindSubmatch(l.data[l.pos:min(len(l.data), l.pos+32)])
+	if m == nil {
+		return ref{}, false
+	}
+	gen, _ := strconv.ParseInt(string(m[1]), 10, 64)
+	// The match ends with the delimiter after the R, which is left unread.
+	l.pos += len(m[0]) - len(m[2])
+	return ref{num: num, gen: gen}, true
+}
+
+var referenceTail = regexp.MustCompile(`^\s+(\d+)\s+R([\s/\[\]<>()%]?)`)
+
+func (l *lexer) array() (array, error) {
+	a := array{}
+	for {
+		v, err := l.next()
+		if err != nil {
+			return a, err
+		}
+		if d, ok := v.(delim); ok {
+			if d == ']' {
+				return a, nil
+			}
+			continue
+		}
+		a = append(a, v)
+	}
+}
+
+func (l *lexer) dict() (dict, error) {
+	d := dict{}
+	for {
+		k, err := l.next()
+		if err != nil {
+			return d, err
+		}
+		if dl, ok := k.(delim); ok {
+			if dl == '>' {
+				return d, nil
+			}
+			continue
+		}
+		key, ok := k.(name)
+		if !ok {
+			continue
+		}
+		v, err := l.next()
+		if err != nil {
+			return d, err
+		}
+		if dl, ok := v.(delim); ok && dl == '>' {
+			return d, nil
+		}
+		d[key] = v
+	}
+}
+
+// --- Documents
+
+// document holds the objects of a PDF by number.
+type document struct {
+	objects  map[int64]any
+	trailers []dict
+}
+
+var (
+	objectHeader = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+	trailerStart = regexp.MustCompile(`trailer\s*<<`)
+)
+
+// parse finds every object in data. Rather than trusting the cross-reference
+// table, which is often damaged, it scans for the objects themselves, so
+// that later revisions of an object replace earlier ones.
+func parse(data []byte) (*document, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte("%PDF-")) {
+		return nil, ErrNotPDF
+	}
+	doc := &document{objects: map[int64]any{}}
+	pos := 0
+	for {
+		if pos > len(data) {
+			return nil, fmt.Errorf("%w: object runs past the end of the file", ErrMalformed)
+		}
+		m := objectHeader.FindSubmatchIndex(data[pos:])
+		if m == nil {
+			break
+		}
+		num, _ := strconv.ParseInt(string(data[pos+m[2]:pos+m[3]]), 10, 64)
+		l := &lexer{data: data, pos: pos + m[1], objects: true}
+		v, err := l.next()
+		if err != nil {
+			break
+		}
+		if d, ok := v.(dict); ok {
+			v, l.pos = streamAfter(data, l.pos, d)
+		}
+		doc.objects[num] = v
+		pos = l.pos
+	}
+	for _, m := range trailerStart.FindAllIndex(data, -1) {
+		l := &lexer{data: data, pos: m[1] - 2, objects: true}
+		if v, err := l.next(); err == nil {
+			if d, ok := v.(dict); ok {
+				doc.trailers = append(doc.trailers, d)
+			}
+		}
+	}
+	for _, v := range doc.objects {
+		s, ok := v.(stream)
+		if !ok {
+			continue
+		}
+		switch s.dict["Type"] {
+		case name("XRef"):
+			doc.trailers = append(doc.trailers, s.dict)
+		case name("ObjStm"):
+			doc.unpackObjectStream(s)
+		}
+	}
+	if len(doc.objects) == 0 {
+		return nil, fmt.Errorf("%w: no objects found", ErrMalformed)
+	}
+	return doc, nil
+}
+
+// streamAfter reads the stream that follows d at pos, if there is one,
+// returning d as it is otherwise.
+func streamAfter(data []byte, pos int, d dict) (any, int) {
+	l := &lexer{data: data, pos: pos}
+	l.skipSpace()
+	if !bytes.HasPrefix(data[l.pos:], []byte("stream")) {
+		return d, pos
+	}
+	start := l.pos + len("stream")
+	if bytes.HasPrefix(data[start:], []byte("\r\n")) {
+		start += 2
+	} else if start < len(data) && (data[start] == '\n' || data[start] == '\r') {
+		start++
+	}
+	if n, ok := d["Length"].(int64); ok && n >= 0 && start+int(n) <= len(data) {
+		rest := bytes.TrimLeft(data[start+int(n):], " \t\r\n")
+		if bytes.HasPrefix(rest, []byte("endstream")) {
+			return stream{dict: d, raw: data[start : start+int(n)]}, start + int(n)
+		}
+	}
+	end := bytes.Index(data[start:], []byte("endstream"))
+	if end < 0 {
+		return stream{dict: d, raw: data[start:]}, len(data)
+	}
+	raw := bytes.TrimRight(data[start:start+end], "\r\n")
+	return stream{dict: d, raw: raw}, start + end
+}
+
+// unpackObjectStream adds the objects compressed into s, unless they are
+// already known.
+func (doc *document) unpackObjectStream(s stream) {
+	data, err := doc.decode(s)
+	if err != nil {
+		return
+	}
+	n, _ := s.dict["N"].(int64)
+	first, _ := s.dict["First"].(int64)
+	header := &lexer{data: data}
+	for range n {
+		num, err1 := header.next()
+		offset, err2 := header.next()
+		if err1 != nil || err2 != nil {
+			return
+		}
+		num64, ok1 := num.(int64)
+		off64, ok2 := offset.(int64)
+		if !ok1 || !ok2 || int(first+off64) >= len(data) {
+			return
+		}
+		if _, ok := doc.objects[num64]; ok {
+			continue
+		}
+		l := &lexer{data: data, pos: int(first + off64), objects: true}
+		if v, err := l.next(); err == nil {
+			doc.objects[num64] = v
+		}
+	}
+}
+
+// resolve follows references until it reaches a direct object.
+func (doc *document) resolve(v any) any {
+	for range 32 {
+		r, ok := v.(ref)
+		if !ok {
+			return v
+		}
+		v = doc.objects[r.num]
+	}
+	return nil
+}
+
+func (doc *document) dict(v any) dict {
+	switch v := doc.resolve(v).(type) {
+	case dict:
+		return v
+	case stream:
+		return v.dict
+	}
+	return nil
+}
+
+func (doc *document) array(v any) array {
+	a, _ := doc.resolve(v).(array)
+	return a
+}
+
+// root returns the document catalog.
+func (doc *document) root() (dict, error) {
+	for i := len(doc.trailers) - 1; i >= 0; i-- {
+		if doc.trailers[i]["Encrypt"] != nil {
+			return nil, ErrEncrypted
+		}
+	}
+	for i := len(doc.trailers) - 1; i >= 0; i-- {
+		if root := doc.dict(doc.trailers[i]["Root"]); root != nil {
+			return root, nil
+		}
+	}
+	for _, v := range doc.objects {
+		if d, ok := v.(dict); ok && d["Type"] == name("Catalog") {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no document catalog", ErrMalformed)
+}
+
+// decode returns the contents of s with its filters undone.
+func (doc *document) decode(s stream) ([]byte, error) {
+	data := s.raw
+	var filters []any
+	switch f := doc.resolve(s.dict["Filter"]).(type) {
+	case name:
+		filters = []any{f}
+	case array:
+		filters = f
+	}
+	for _, f := range filters {
+		var err error
+		switch doc.resolve(f) {
+		case name("FlateDecode"), name("Fl"):
+			data, err = inflate(data)
+		case name("ASCIIHexDecode"), name("AHx"):
+			data = (&lexer{data: append(append([]byte("<"), data...), '>')}).hex()
+		case name("ASCII85Decode"), name("A85"):
+			data, err = decodeASCII85(data)
+		default:
+			err = fmt.Errorf("unsupported filter %v", f)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// inflate undoes FlateDecode, keeping whatever could be read from a
+// truncated stream.
+func inflate(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	out, err := io.ReadAll(r)
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	return out, nil
+}
+
+func decodeASCII85(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	data = bytes.TrimPrefix(data, []byte("<~"))
+	if i := bytes.Index(data, []byte("~>")); i >= 0 {
+		data = data[:i]
+	}
+	out := make([]byte, 4*len(data)/5+4)
+	n, _, err := ascii85.Decode(out, data, true)
+	if err != nil {
+		return nil, err
+	}
+	return out[:n], nil
+}
+
+// Errors returned when a PDF can't be read.
+var (
+	ErrNotPDF    = errors.New("not a PDF")
+	ErrEncrypted = errors.New("PDF is encrypted")
+	ErrMalformed = errors.New("malformed PDF")
+)
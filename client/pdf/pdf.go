@@ -0,0 +1,68 @@
+// Package pdf extracts the text of PDF documents, for models that can't read
+// them natively. It is written in pure Go and handles the text of most
+// documents, but not scanned pages, which hold images rather than text.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+// MIMEType is the media type of PDF documents.
+const MIMEType = "application/pdf"
+
+// IsPDF reports whether ref holds a PDF document.
+func IsPDF(ref llm.Reference) bool {
+	return strings.HasPrefix(ref.MIMEType, MIMEType) || bytes.HasPrefix(ref.Data, []byte("%PDF-"))
+}
+
+// Text extracts the text of a PDF document, introducing each page with a
+// marker such as "--- Page 1 ---".
+func Text(data []byte) (string, error) {
+	doc, err := parse(data)
+	if err != nil {
+		return "", err
+	}
+	pages, err := doc.pages()
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	for i, p := range pages {
+		w := &textWriter{}
+		doc.extract(w, doc.content(p), p.resources, 0)
+		fmt.Fprintf(&b, "--- Page %d ---\n", i+1)
+		if text := strings.TrimSpace(w.b.String()); text != "" {
+			b.WriteString(text)
+			b.WriteString("\n")
+		}
+	}
+	return b.String(), nil
+}
+
+// TextReferences replaces the PDF documents among refs with their text, for
+// models that can't read PDFs themselves. Other references are returned
+// unchanged.
+func TextReferences(refs []llm.Reference) ([]llm.Reference, error) {
+	converted := make([]llm.Reference, len(refs))
+	for i, ref := range refs {
+		if !IsPDF(ref) {
+			converted[i] = ref
+			continue
+		}
+		text, err := Text(ref.Data)
+		if err != nil {
+			return nil, fmt.Errorf("extracting text from %s: %w", ref.Label(i), err)
+		}
+		converted[i] = llm.Reference{
+			Name:     ref.Name,
+			Source:   ref.Source,
+			MIMEType: "text/plain; charset=utf-8",
+			Data:     []byte(text),
+		}
+	}
+	return converted, nil
+}
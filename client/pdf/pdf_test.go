@@ -0,0 +1,86 @@
+package pdf_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mr-joshcrane/goracle/client/llm"
+	"github.com/mr-joshcrane/goracle/client/pdf"
+)
+
+func TestText_ExtractsEachPageWithAMarker(t *testing.T) {
+	t.Parallel()
+	data, err := os.ReadFile("testdata/report.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := pdf.Text(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--- Page 1 ---\nQuarterly report\nRevenue grew\n--- Page 2 ---\nPage two\nRésumé\n"
+	if !cmp.Equal(want, got) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestText_RejectsDataThatIsNotAPDF(t *testing.T) {
+	t.Parallel()
+	_, err := pdf.Text([]byte("just some text"))
+	if !errors.Is(err, pdf.ErrNotPDF) {
+		t.Fatalf("want ErrNotPDF, got %v", err)
+	}
+}
+
+func TestText_RejectsEncryptedDocuments(t *testing.T) {
+	t.Parallel()
+	data := []byte("%PDF-1.4\n1 0 obj\n<< /Type /Catalog >>\nendobj\n" +
+		"trailer\n<< /Root 1 0 R /Encrypt << /Filter /Standard >> >>\n%%EOF\n")
+	_, err := pdf.Text(data)
+	if !errors.Is(err, pdf.ErrEncrypted) {
+		t.Fatalf("want ErrEncrypted, got %v", err)
+	}
+}
+
+func TestTextReferences_ReplacesOnlyPDFs(t *testing.T) {
+	t.Parallel()
+	data, err := os.ReadFile("testdata/report.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := []llm.Reference{
+		{Name: "notes.txt", MIMEType: "text/plain", Data: []byte("notes")},
+		{Name: "report.pdf", Source: "testdata/report.pdf", MIMEType: pdf.MIMEType, Data: data},
+	}
+	got, err := pdf.TextReferences(refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(refs[0], got[0]) {
+		t.Error(cmp.Diff(refs[0], got[0]))
+	}
+	want := llm.Reference{
+		Name:     "report.pdf",
+		Source:   "testdata/report.pdf",
+		MIMEType: "text/plain; charset=utf-8",
+		Data:     []byte("--- Page 1 ---\nQuarterly report\nRevenue grew\n--- Page 2 ---\nPage two\nRésumé\n"),
+	}
+	if !cmp.Equal(want, got[1]) {
+		t.Error(cmp.Diff(want, got[1]))
+	}
+}
+
+func FuzzText(f *testing.F) {
+	data, err := os.ReadFile("testdata/report.pdf")
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(data)
+	f.Add(data[:len(data)/2])
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Damaged documents may fail, but never panic.
+		pdf.Text(data)
+	})
+}
@@ -0,0 +1,479 @@
+package pdf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// pages returns the page objects of the document in order, along with the
+// resources each one inherits.
+func (doc *document) pages() ([]page, error) {
+	root, err := doc.root()
+	if err != nil {
+		return nil, err
+	}
+	var pages []page
+	seen := map[any]bool{}
+	var walk func(node any, resources dict)
+	walk = func(node any, resources dict) {
+		if r, ok := node.(ref); ok {
+			if seen[r] {
+				return
+			}
+			seen[r] = true
+		}
+		d := doc.dict(node)
+		if d == nil {
+			return
+		}
+		if res := doc.dict(d["Resources"]); res != nil {
+			resources = res
+		}
+		if d["Type"] == name("Page") || (d["Kids"] == nil && d["Contents"] != nil) {
+			pages = append(pages, page{dict: d, resources: resources})
+			return
+		}
+		for _, kid := range doc.array(d["Kids"]) {
+			walk(kid, resources)
+		}
+	}
+	walk(root["Pages"], nil)
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("%w: no pages", ErrMalformed)
+	}
+	return pages, nil
+}
+
+type page struct {
+	dict      dict
+	resources dict
+}
+
+// content returns the page's content streams joined together.
+func (doc *document) content(p page) []byte {
+	var streams []any
+	switch c := doc.resolve(p.dict["Contents"]).(type) {
+	case stream:
+		streams = []any{c}
+	case array:
+		streams = c
+	}
+	var data []byte
+	for _, v := range streams {
+		s, ok := doc.resolve(v).(stream)
+		if !ok {
+			continue
+		}
+		decoded, err := doc.decode(s)
+		if err != nil {
+			continue
+		}
+		data = append(data, decoded...)
+		data = append(data, '\n')
+	}
+	return data
+}
+
+// --- Fonts
+
+// font turns the codes shown by a content stream into text.
+type font struct {
+	// codeBytes is how many bytes each character code takes.
+	codeBytes int
+	toUnicode map[uint32]string
+	// encoding maps the codes of a simple font without a ToUnicode map.
+	encoding *[256]rune
+}
+
+func (doc *document) font(v any) *font {
+	d := doc.dict(v)
+	f := &font{codeBytes: 1, encoding: &winAnsi}
+	if d == nil {
+		return f
+	}
+	if d["Subtype"] == name("Type0") {
+		f.codeBytes = 2
+		f.encoding = nil
+	}
+	if s, ok := doc.resolve(d["ToUnicode"]).(stream); ok {
+		if data, err := doc.decode(s); err == nil {
+			f.toUnicode, f.codeBytes = parseCMap(data, f.codeBytes)
+		}
+	}
+	if f.encoding != nil {
+		f.encoding = doc.encoding(d["Encoding"])
+	}
+	return f
+}
+
+// encoding builds the encoding of a simple font, starting from its base
+// encoding and applying any differences.
+func (doc *document) encoding(v any) *[256]rune {
+	switch e := doc.resolve(v).(type) {
+	case name:
+		if e == "MacRomanEncoding" {
+			return &macRoman
+		}
+	case dict:
+		base := doc.encoding(e["BaseEncoding"])
+		differences := doc.array(e["Differences"])
+		if len(differences) == 0 {
+			return base
+		}
+		enc := *base
+		code := 0
+		for _, d := range differences {
+			switch d := doc.resolve(d).(type) {
+			case int64:
+				code = int(d)
+			case name:
+				if code >= 0 && code < 256 {
+					if r, ok := glyphRune(string(d)); ok {
+						enc[code] = r
+					}
+				}
+				code++
+			}
+		}
+		return &enc
+	}
+	return &winAnsi
+}
+
+// decode turns the bytes of a string shown in this font into text.
+func (f *font) decode(s []byte) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		n := min(f.codeBytes, len(s))
+		var code uint32
+		for _, c := range s[:n] {
+			code = code<<8 | uint32(c)
+		}
+		s = s[n:]
+		if text, ok := f.toUnicode[code]; ok {
+			b.WriteString(text)
+			continue
+		}
+		if f.encoding != nil && code < 256 {
+			if r := f.encoding[code]; r != 0 {
+				b.WriteRune(r)
+			}
+		}
+	}
+	return b.String()
+}
+
+// parseCMap reads the bfchar and bfrange mappings of a ToUnicode CMap, and
+// the code length set by its codespace range.
+func parseCMap(data []byte, codeBytes int) (map[uint32]string, int) {
+	mapping := map[uint32]string{}
+	l := &lexer{data: data}
+	var operands []any
+	section := ""
+	for {
+		v, err := l.next()
+		if err != nil {
+			return mapping, codeBytes
+		}
+		k, ok := v.(keyword)
+		if !ok {
+			operands = append(operands, v)
+			continue
+		}
+		switch k {
+		case "begincodespacerange", "beginbfchar", "beginbfrange":
+			section = string(k)
+		case "endcodespacerange":
+			if lo, ok := firstString(operands); ok && len(lo) > 0 {
+				codeBytes = len(lo)
+			}
+		case "endbfchar":
+			for i := 0; i+1 < len(operands); i += 2 {
+				src, ok1 := operands[i].([]byte)
+				dst, ok2 := operands[i+1].([]byte)
+				if ok1 && ok2 {
+					mapping[code(src)] = utf16Text(dst)
+				}
+			}
+		case "endbfrange":
+			for i := 0; i+2 < len(operands); i += 3 {
+				lo, ok1 := operands[i].([]byte)
+				hi, ok2 := operands[i+1].([]byte)
+				if !ok1 || !ok2 || code(hi) < code(lo) || code(hi)-code(lo) > 0xFFFF {
+					continue
+				}
+				switch dst := operands[i+2].(type) {
+				case []byte:
+					units := utf16.Decode(utf16Units(dst))
+					for c := code(lo); c <= code(hi); c++ {
+						mapping[c] = string(units)
+						if len(units) > 0 {
+							units[len(units)-1]++
+						}
+					}
+				case array:
+					for j, d := range dst {
+						if b, ok := d.([]byte); ok {
+							mapping[code(lo)+uint32(j)] = utf16Text(b)
+						}
+					}
+				}
+			}
+		}
+		if strings.HasPrefix(string(k), "end") {
+			section = ""
+		}
+		if section == "" || strings.HasPrefix(string(k), "begin") {
+			operands = operands[:0]
+		}
+	}
+}
+
+func firstString(operands []any) ([]byte, bool) {
+	for _, o := range operands {
+		if b, ok := o.([]byte); ok {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+func code(b []byte) uint32 {
+	var c uint32
+	for _, x := range b {
+		c = c<<8 | uint32(x)
+	}
+	return c
+}
+
+func utf16Units(b []byte) []uint16 {
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		units = append(units, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return units
+}
+
+func utf16Text(b []byte) string {
+	if len(b) == 1 {
+		return string(rune(b[0]))
+	}
+	return string(utf16.Decode(utf16Units(b)))
+}
+
+// --- Content streams
+
+// textWriter collects the text of a page, breaking lines where the text
+// moves down the page.
+type textWriter struct {
+	b       strings.Builder
+	pending string
+}
+
+func (w *textWriter) write(s string) {
+	if s == "" {
+		return
+	}
+	if w.b.Len() > 0 {
+		w.b.WriteString(w.pending)
+	}
+	w.pending = ""
+	w.b.WriteString(s)
+}
+
+func (w *textWriter) newline() {
+	w.pending = "\n"
+}
+
+func (w *textWriter) space() {
+	if w.pending == "" && !strings.HasSuffix(w.b.String(), " ") {
+		w.pending = " "
+	}
+}
+
+// maxFormDepth limits how deeply form XObjects may draw one another.
+const maxFormDepth = 8
+
+// extract interprets a content stream, writing out the text it shows.
+func (doc *document) extract(w *textWriter, content []byte, resources dict, depth int) {
+	fonts := map[name]*font{}
+	current := &font{codeBytes: 1, encoding: &winAnsi}
+	var lineY float64
+	var operands []any
+	l := &lexer{data: content}
+	for {
+		v, err := l.next()
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		op, ok := v.(keyword)
+		if !ok {
+			if _, isDelim := v.(delim); !isDelim {
+				operands = append(operands, v)
+			}
+			continue
+		}
+		switch op {
+		case "BI":
+			// Skip inline image data, which isn't text.
+			end := strings.Index(string(content[l.pos:]), "EI")
+			if end < 0 {
+				return
+			}
+			l.pos += end + 2
+		case "Tf":
+			if len(operands) >= 2 {
+				if n, ok := operands[len(operands)-2].(name); ok {
+					if fonts[n] == nil {
+						fonts[n] = doc.font(doc.dict(resources["Font"])[n])
+					}
+					current = fonts[n]
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 {
+				if number(operands[len(operands)-1]) != 0 {
+					w.newline()
+				} else if number(operands[len(operands)-2]) != 0 {
+					w.space()
+				}
+			}
+		case "Tm":
+			if len(operands) >= 6 {
+				y := number(operands[len(operands)-1])
+				if y != lineY {
+					w.newline()
+				}
+				lineY = y
+			}
+		case "T*":
+			w.newline()
+		case "Tj":
+			if s, ok := last(operands).([]byte); ok {
+				w.write(current.decode(s))
+			}
+		case "'", "\"":
+			w.newline()
+			if s, ok := last(operands).([]byte); ok {
+				w.write(current.decode(s))
+			}
+		case "TJ":
+			a, _ := last(operands).(array)
+			for _, item := range a {
+				switch item := item.(type) {
+				case []byte:
+					w.write(current.decode(item))
+				case int64, float64:
+					// Large gaps, in thousandths of an em, separate words.
+					if number(item) < -200 {
+						w.space()
+					}
+				}
+			}
+		case "Do":
+			n, _ := last(operands).(name)
+			form, ok := doc.resolve(doc.dict(resources["XObject"])[n]).(stream)
+			if ok && form.dict["Subtype"] == name("Form") && depth < maxFormDepth {
+				data, err := doc.decode(form)
+				if err == nil {
+					formResources := doc.dict(form.dict["Resources"])
+					if formResources == nil {
+						formResources = resources
+					}
+					doc.extract(w, data, formResources, depth+1)
+				}
+			}
+		}
+		operands = operands[:0]
+	}
+}
+
+func last(operands []any) any {
+	if len(operands) == 0 {
+		return nil
+	}
+	return operands[len(operands)-1]
+}
+
+func number(v any) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// --- Encodings
+
+// winAnsi is the Windows code page 1252, which most simple fonts use.
+var winAnsi = func() [256]rune {
+	var enc [256]rune
+	for i := 32; i < 256; i++ {
+		enc[i] = rune(i)
+	}
+	enc['\t'], enc['\n'], enc['\r'] = '\t', '\n', '\r'
+	for i := 0x7F; i < 0xA0; i++ {
+		enc[i] = 0
+	}
+	for i, r := range []rune("€\x00‚ƒ„…†‡ˆ‰Š‹Œ\x00Ž\x00\x00‘’“”•–—˜™š›œ\x00žŸ") {
+		enc[0x80+i] = r
+	}
+	return enc
+}()
+
+// macRoman is the classic Mac OS character set.
+var macRoman = func() [256]rune {
+	enc := winAnsi
+	for i, r := range []rune("ÄÅÇÉÑÖÜáàâäãåçéèêëíìîïñóòôöõúùûü†°¢£§•¶ß®©™´¨≠ÆØ∞±≤≥¥µ∂∑∏π∫ªºΩæø¿¡¬√ƒ≈∆«»… ÀÃÕŒœ–—“”‘’÷◊ÿŸ⁄€‹›ﬁﬂ‡·‚„‰ÂÊÁËÈÍÎÏÌÓÔÒÚÛÙıˆ˜¯˘˙˚¸˝˛ˇ") {
+		enc[0x80+i] = r
+	}
+	return enc
+}()
+
+// glyphNames maps the names of common glyphs that aren't a single letter.
+var glyphNames = map[string]rune{
+	"space": ' ', "exclam": '!', "quotedbl": '"', "numbersign": '#',
+	"dollar": '$', "percent": '%', "ampersand": '&', "quotesingle": '\'',
+	"parenleft": '(', "parenright": ')', "asterisk": '*', "plus": '+',
+	"comma": ',', "hyphen": '-', "period": '.', "slash": '/',
+	"zero": '0', "one": '1', "two": '2', "three": '3', "four": '4',
+	"five": '5', "six": '6', "seven": '7', "eight": '8', "nine": '9',
+	"colon": ':', "semicolon": ';', "less": '<', "equal": '=',
+	"greater": '>', "question": '?', "at": '@', "bracketleft": '[',
+	"backslash": '\\', "bracketright": ']', "asciicircum": '^',
+	"underscore": '_', "grave": '`', "braceleft": '{', "bar": '|',
+	"braceright": '}', "asciitilde": '~', "quoteleft": '‘',
+	"quoteright": '’', "quotedblleft": '“', "quotedblright": '”',
+	"bullet": '•', "endash": '–', "emdash": '—', "ellipsis": '…',
+	"fi": 'ﬁ', "fl": 'ﬂ', "ff": 'ﬀ', "ffi": 'ﬃ', "ffl": 'ﬄ',
+	"copyright": '©', "registered": '®', "trademark": '™',
+	"degree": '°', "Euro": '€', "minus": '−', "multiply": '×',
+	"divide": '÷', "section": '§', "paragraph": '¶', "dagger": '†',
+	"daggerdbl": '‡', "eacute": 'é', "egrave": 'è', "agrave": 'à',
+	"ccedilla": 'ç', "udieresis": 'ü', "odieresis": 'ö', "adieresis": 'ä',
+	"germandbls": 'ß', "nbspace": ' ',
+}
+
+// glyphRune finds the character a glyph name stands for.
+func glyphRune(glyph string) (rune, bool) {
+	if r, ok := glyphNames[glyph]; ok {
+		return r, true
+	}
+	if len(glyph) == 1 {
+		return rune(glyph[0]), true
+	}
+	for _, prefix := range []string{"uni", "u"} {
+		if hexCode, ok := strings.CutPrefix(glyph, prefix); ok && len(hexCode) >= 4 {
+			if v, err := strconv.ParseUint(hexCode[:4], 16, 32); err == nil {
+				return rune(v), true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,295 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+// --- Router
+
+// Completer is anything that can complete a prompt, such as the clients in
+// this package.
+type Completer interface {
+	Completion(ctx context.Context, prompt Prompt) (io.Reader, error)
+}
+
+// Strategy decides how a [Router] spreads completions across its backends.
+type Strategy int
+
+const (
+	// Fallback tries each backend in order until one succeeds.
+	Fallback Strategy = iota
+	// Race asks every backend at once and takes the first to succeed.
+	Race
+	// RoundRobin spreads completions across the backends in proportion to
+	// their weights, falling back to the others if the chosen one fails.
+	RoundRobin
+)
+
+// Backend is a named client for a [Router] to route completions to.
+type Backend struct {
+	Name   string
+	Client Completer
+	// Weight sets the backend's share of completions under RoundRobin.
+	// Weights below 1 count as 1.
+	Weight int
+}
+
+// BackendStats reports how a backend of a [Router] has fared.
+type BackendStats struct {
+	Successes int
+	Failures  int
+	// CoolingUntil is when the backend will next be tried, if it has
+	// failed too often recently.
+	CoolingUntil time.Time
+}
+
+// Router is a client that routes each completion to one of several backends,
+// so that an outage at one provider doesn't take the whole application down.
+// Backends that fail MaxFailures times in a row are skipped for Cooldown,
+// unless every backend is cooling down. Completions from a Router report the
+// backend that answered through their Backend method.
+type Router struct {
+	Strategy    Strategy
+	MaxFailures int
+	Cooldown    time.Duration
+
+	mu       sync.Mutex
+	backends []*backend
+}
+
+type backend struct {
+	Backend
+	stats    BackendStats
+	failures int
+	current  int
+}
+
+// NewRouter returns a Router that spreads completions across backends using
+// the given strategy. By default, a backend is skipped for 30 seconds after
+// 3 failures in a row.
+func NewRouter(strategy Strategy, backends ...Backend) *Router {
+	r := &Router{
+		Strategy:    strategy,
+		MaxFailures: 3,
+		Cooldown:    30 * time.Second,
+	}
+	for _, b := range backends {
+		b.Weight = max(b.Weight, 1)
+		r.backends = append(r.backends, &backend{Backend: b})
+	}
+	return r
+}
+
+// Stats returns how each backend has fared, by name.
+func (r *Router) Stats() map[string]BackendStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make(map[string]BackendStats, len(r.backends))
+	for _, b := range r.backends {
+		stats[b.Name] = b.stats
+	}
+	return stats
+}
+
+// Pricing returns the highest price any backend charges for each kind of
+// token, so that a budget holds whichever backend answers.
+func (r *Router) Pricing() llm.Pricing {
+	var price llm.Pricing
+	for _, b := range r.backends {
+		if c, ok := b.Client.(interface{ Pricing() llm.Pricing }); ok {
+			p := c.Pricing()
+			price.InputPerMillion = max(price.InputPerMillion, p.InputPerMillion)
+			price.OutputPerMillion = max(price.OutputPerMillion, p.OutputPerMillion)
+		}
+	}
+	return price
+}
+
+// ContextWindow returns the smallest context window of the backends that
+// report one, so that a prompt fits whichever backend answers. It is 0 if
+// none of them do.
+func (r *Router) ContextWindow() int {
+	window := 0
+	for _, b := range r.backends {
+		if c, ok := b.Client.(interface{ ContextWindow() int }); ok && c.ContextWindow() > 0 {
+			if window == 0 || c.ContextWindow() < window {
+				window = c.ContextWindow()
+			}
+		}
+	}
+	return window
+}
+
+// ModelName lists the models behind the backends, in order, using the
+// backend's name for any that doesn't report its model.
+func (r *Router) ModelName() string {
+	names := make([]string, 0, len(r.backends))
+	for _, b := range r.backends {
+		name := b.Name
+		if c, ok := b.Client.(interface{ ModelName() string }); ok {
+			name = c.ModelName()
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (r *Router) Completion(ctx context.Context, prompt Prompt) (io.Reader, error) {
+	if len(r.backends) == 0 {
+		return nil, errors.New("router has no backends")
+	}
+	candidates := r.candidates()
+	if r.Strategy == Race {
+		return r.race(ctx, prompt, candidates)
+	}
+	var errs []error
+	for _, b := range candidates {
+		data, err := b.Client.Completion(ctx, prompt)
+		if err == nil {
+			r.record(b, nil)
+			return routed(data, b.Name), nil
+		}
+		if ctx.Err() != nil {
+			return nil, errors.Join(append(errs, err)...)
+		}
+		r.record(b, err)
+		errs = append(errs, fmt.Errorf("%s: %w", b.Name, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// candidates returns the backends to try, in order, leaving out those that
+// are cooling down. Under RoundRobin, the next backend by weight goes first.
+func (r *Router) candidates() []*backend {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	var available []*backend
+	for _, b := range r.backends {
+		if now.After(b.stats.CoolingUntil) {
+			available = append(available, b)
+		}
+	}
+	if len(available) == 0 {
+		available = append(available, r.backends...)
+	}
+	if r.Strategy != RoundRobin {
+		return available
+	}
+	// Smooth weighted round robin: every backend gains its weight, and the
+	// one with the most is chosen and pays back the total.
+	total := 0
+	chosen := 0
+	for i, b := range available {
+		b.current += b.Weight
+		total += b.Weight
+		if b.current > available[chosen].current {
+			chosen = i
+		}
+	}
+	available[chosen].current -= total
+	ordered := []*backend{available[chosen]}
+	ordered = append(ordered, available[:chosen]...)
+	return append(ordered, available[chosen+1:]...)
+}
+
+// race asks every candidate at once, returning the first success and
+// cancelling the rest.
+func (r *Router) race(ctx context.Context, prompt Prompt, candidates []*backend) (io.Reader, error) {
+	type result struct {
+		backend *backend
+		data    io.Reader
+		err     error
+		cancel  context.CancelFunc
+	}
+	results := make(chan result, len(candidates))
+	for _, b := range candidates {
+		raceCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			data, err := b.Client.Completion(raceCtx, prompt)
+			results <- result{backend: b, data: data, err: err, cancel: cancel}
+		}()
+	}
+	var winner *result
+	var errs []error
+	for range candidates {
+		res := <-results
+		switch {
+		case res.err != nil:
+			if ctx.Err() == nil {
+				r.record(res.backend, res.err)
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", res.backend.Name, res.err))
+			res.cancel()
+		default:
+			r.record(res.backend, nil)
+			winner = &res
+			// Release whatever the slower backends return.
+			go func(remaining int) {
+				for range remaining {
+					late := <-results
+					if c, ok := late.data.(io.Closer); ok {
+						c.Close()
+					}
+					late.cancel()
+				}
+			}(len(candidates) - len(errs) - 1)
+		}
+		if winner != nil {
+			break
+		}
+	}
+	if winner == nil {
+		return nil, errors.Join(errs...)
+	}
+	return routedWithCancel(winner.data, winner.backend.Name, winner.cancel), nil
+}
+
+// record updates a backend's stats after a completion, putting it into
+// cooldown if it has failed too many times in a row.
+func (r *Router) record(b *backend, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		b.stats.Successes++
+		b.failures = 0
+		return
+	}
+	b.stats.Failures++
+	b.failures++
+	if r.MaxFailures > 0 && b.failures >= r.MaxFailures {
+		b.stats.CoolingUntil = time.Now().Add(r.Cooldown)
+		b.failures = 0
+	}
+}
+
+// Routed is a completion from a [Router], which knows the backend that
+// answered.
+type Routed struct {
+	*llm.Observed
+	backend string
+}
+
+// Backend returns the name of the backend that answered.
+func (r *Routed) Backend() string {
+	return r.backend
+}
+
+func routed(data io.Reader, name string) *Routed {
+	return routedWithCancel(data, name, func() {})
+}
+
+func routedWithCancel(data io.Reader, name string, cancel context.CancelFunc) *Routed {
+	return &Routed{
+		Observed: llm.Observe(data, func(int, error) { cancel() }),
+		backend:  name,
+	}
+}
@@ -0,0 +1,216 @@
+package client_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mr-joshcrane/goracle"
+	"github.com/mr-joshcrane/goracle/client"
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// vertexRequest returns the body Vertex is sent for prompt.
+func vertexRequest(t *testing.T, prompt goracle.Prompt) []byte {
+	t.Helper()
+	var sent []byte
+	c := client.NewVertex()
+	c.ProjectID, c.Token = "goracle-test", "test-token"
+	c.HTTPClient = &http.Client{Transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent, _ = io.ReadAll(req.Body)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`[{"candidates":[{"content":{"role":"model","parts":[{"text":"{\"animal\":\"quokka\"}"}]}}]}]`)),
+		}, nil
+	})}
+	data, err := c.Completion(t.Context(), prompt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, data)
+	return sent
+}
+
+func TestVertex_SendsToolsAndSchemaWithImageReferences(t *testing.T) {
+	t.Parallel()
+	image, err := os.ReadFile("testdata/quokka.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := vertexRequest(t, goracle.Prompt{
+		Purpose:    "Identify animals.",
+		Question:   "What animal is this?",
+		References: []llm.Reference{{Name: "quokka.jpg", Data: image}},
+		Tools: []llm.Tool{{
+			Name:       "lookup",
+			Parameters: map[string]any{"type": "object", "properties": map[string]any{}},
+		}},
+		ResponseSchema: map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"animal": map[string]any{"type": "string"}},
+		},
+	})
+	var body struct {
+		Contents []struct {
+			Parts []struct {
+				InlineData *struct {
+					MimeType string `json:"mimeType"`
+				} `json:"inlineData"`
+			} `json:"parts"`
+		} `json:"contents"`
+		Tools            []json.RawMessage `json:"tools"`
+		GenerationConfig struct {
+			ResponseSchema map[string]any `json:"responseSchema"`
+		} `json:"generation_config"`
+	}
+	err = json.NewDecoder(bytes.NewReader(sent)).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := 0
+	for _, content := range body.Contents {
+		for _, part := range content.Parts {
+			if part.InlineData != nil {
+				images++
+			}
+		}
+	}
+	if images != 1 {
+		t.Errorf("expected the image inline, got %d images in %s", images, sent)
+	}
+	if len(body.Tools) != 1 {
+		t.Errorf("expected the tools to be sent, got %s", sent)
+	}
+	if body.GenerationConfig.ResponseSchema == nil {
+		t.Errorf("expected the response schema to be sent, got %s", sent)
+	}
+}
+
+func TestVertex_DescribesMapsInSchemasGeminiCannotExpress(t *testing.T) {
+	t.Parallel()
+	counts := map[string]any{
+		"type":                 "object",
+		"additionalProperties": map[string]any{"type": "integer"},
+	}
+	sent := vertexRequest(t, goracle.Prompt{
+		Question: "Count the animals.",
+		Tools: []llm.Tool{{
+			Name: "record",
+			Parameters: map[string]any{
+				"type":                 "object",
+				"properties":           map[string]any{"counts": counts},
+				"required":             []string{"counts"},
+				"additionalProperties": false,
+			},
+		}},
+		ResponseSchema: map[string]any{
+			"type":                 "object",
+			"properties":           map[string]any{"counts": counts},
+			"required":             []string{"counts"},
+			"additionalProperties": false,
+		},
+	})
+	var body struct {
+		Tools []struct {
+			FunctionDeclarations []struct {
+				Parameters map[string]any `json:"parameters"`
+			} `json:"functionDeclarations"`
+		} `json:"tools"`
+		GenerationConfig struct {
+			ResponseSchema map[string]any `json:"responseSchema"`
+		} `json:"generation_config"`
+	}
+	err := json.Unmarshal(sent, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Tools) != 1 || len(body.Tools[0].FunctionDeclarations) != 1 {
+		t.Fatalf("expected one tool, got %s", sent)
+	}
+	for _, schema := range []map[string]any{body.GenerationConfig.ResponseSchema, body.Tools[0].FunctionDeclarations[0].Parameters} {
+		properties, _ := schema["properties"].(map[string]any)
+		field, _ := properties["counts"].(map[string]any)
+		description, _ := field["description"].(string)
+		if _, typed := field["type"]; typed || !strings.Contains(description, `{"type":"integer"}`) {
+			t.Errorf("expected the map to be described rather than typed, got %v", field)
+		}
+	}
+	if bytes.Contains(sent, []byte("additionalProperties")) {
+		t.Errorf("expected no additionalProperties, which Gemini rejects, got %s", sent)
+	}
+}
+
+func TestVertex_SendsOnlyTheOptionsThatAreSet(t *testing.T) {
+	t.Parallel()
+	sent := vertexRequest(t, goracle.Prompt{
+		Question: "Name an animal.",
+		Options:  llm.Options{Temperature: llm.Ptr(0.0)},
+	})
+	var body struct {
+		GenerationConfig map[string]any `json:"generation_config"`
+	}
+	err := json.Unmarshal(sent, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"temperature": 0.0}
+	if !cmp.Equal(want, body.GenerationConfig) {
+		t.Error(cmp.Diff(want, body.GenerationConfig))
+	}
+}
+
+func TestVertex_GroupsParallelToolCallsAndResults(t *testing.T) {
+	t.Parallel()
+	sent := vertexRequest(t, goracle.Prompt{
+		Question: "How many quokkas and wombats are there?",
+		ToolTurns: []llm.ToolTurn{{
+			Text: "Let me count them.",
+			Calls: []llm.ToolCall{
+				{Name: "count", Arguments: json.RawMessage(`{"animal":"quokka"}`)},
+				{Name: "count", Arguments: json.RawMessage(`{"animal":"wombat"}`)},
+			},
+			Results: []llm.ToolResult{
+				{Name: "count", Content: "3"},
+				{Name: "count", Content: "2"},
+			},
+		}},
+	})
+	var body struct {
+		Contents []struct {
+			Role  string `json:"role"`
+			Parts []struct {
+				Text         string          `json:"text"`
+				FunctionCall json.RawMessage `json:"functionCall"`
+				Response     json.RawMessage `json:"functionResponse"`
+			} `json:"parts"`
+		} `json:"contents"`
+	}
+	err := json.Unmarshal(sent, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Contents) < 2 {
+		t.Fatalf("expected the tool turn to be sent, got %s", sent)
+	}
+	calls, results := body.Contents[len(body.Contents)-2], body.Contents[len(body.Contents)-1]
+	if calls.Role != "model" || len(calls.Parts) != 3 || calls.Parts[0].Text != "Let me count them." ||
+		calls.Parts[1].FunctionCall == nil || calls.Parts[2].FunctionCall == nil {
+		t.Errorf("expected one model content with the text and both calls, got %s", sent)
+	}
+	if results.Role != "user" || len(results.Parts) != 2 ||
+		results.Parts[0].Response == nil || results.Parts[1].Response == nil {
+		t.Errorf("expected one user content with both results, got %s", sent)
+	}
+}
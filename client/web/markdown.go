@@ -0,0 +1,423 @@
+// Package web turns web pages into text a model can read. Its HTML parser is
+// forgiving rather than complete: it keeps the words, headings, lists, links
+// and code of a page, and leaves out its scripts, styles and navigation.
+package web
+
+import (
+	"html"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// skipped elements hold nothing worth reading, so everything inside them is
+// left out.
+var skipped = []string{
+	"script", "style", "noscript", "template", "svg", "canvas", "iframe",
+	"nav", "aside", "footer", "form", "button", "select", "dialog",
+}
+
+// void elements never have any content or a closing tag.
+var void = []string{
+	"area", "base", "br", "col", "embed", "hr", "img", "input", "link",
+	"meta", "param", "source", "track", "wbr",
+}
+
+// blocks start on a line of their own.
+var blocks = []string{
+	"p", "div", "section", "article", "main", "header", "blockquote",
+	"table", "ul", "ol", "dl", "dt", "dd", "figure", "figcaption",
+	"address", "details", "summary", "hgroup",
+}
+
+// Markdown converts an HTML page into Markdown-ish text, returning its title
+// separately. Relative links are resolved against base, if it isn't nil.
+func Markdown(page []byte, base *url.URL) (title string, text string) {
+	c := &converter{base: base}
+	c.parse(strings.ToValidUTF8(string(page), "�"))
+	return strings.TrimSpace(collapse(c.title.String())), c.w.String()
+}
+
+type converter struct {
+	base *url.URL
+	w    writer
+	// skipping is the skipped element we're inside, and skipDepth how
+	// deeply it is nested within itself.
+	skipping  string
+	skipDepth int
+	inTitle   bool
+	title     strings.Builder
+	pre       int
+	links     []string
+	lists     []list
+}
+
+type list struct {
+	ordered bool
+	n       int
+}
+
+func (c *converter) parse(page string) {
+	for len(page) > 0 {
+		lt := strings.IndexByte(page, '<')
+		if lt < 0 {
+			c.text(page)
+			break
+		}
+		c.text(page[:lt])
+		page = page[lt:]
+		switch {
+		case strings.HasPrefix(page, "<!--"):
+			end := strings.Index(page, "-->")
+			if end < 0 {
+				return
+			}
+			page = page[end+3:]
+		case strings.HasPrefix(page, "<!") || strings.HasPrefix(page, "<?"):
+			end := strings.IndexByte(page, '>')
+			if end < 0 {
+				return
+			}
+			page = page[end+1:]
+		default:
+			name, attrs, closing, rest, ok := tag(page)
+			if !ok {
+				c.text("<")
+				page = page[1:]
+				continue
+			}
+			page = rest
+			if closing {
+				c.end(name)
+				continue
+			}
+			if name == "script" || name == "style" {
+				// Their contents aren't HTML, so skip straight to the end tag.
+				end := closingTag(page, name)
+				if end < 0 {
+					return
+				}
+				page = page[end:]
+				continue
+			}
+			c.start(name, attrs)
+		}
+	}
+}
+
+// closingTag returns the index of the first end tag for name in s, matched
+// without regard to case, or -1. It compares bytes in place, since lowering
+// the whole of s can change its length and so the offsets into it.
+func closingTag(s, name string) int {
+	for i := 0; ; {
+		j := strings.Index(s[i:], "</")
+		if j < 0 {
+			return -1
+		}
+		i += j
+		if end := i + 2 + len(name); end <= len(s) && strings.EqualFold(s[i+2:end], name) {
+			return i
+		}
+		i += 2
+	}
+}
+
+// tag reads the tag at the start of s, which begins with '<'.
+func tag(s string) (name string, attrs map[string]string, closing bool, rest string, ok bool) {
+	i := 1
+	if i < len(s) && s[i] == '/' {
+		closing = true
+		i++
+	}
+	start := i
+	for i < len(s) && (isLetter(s[i]) || (i > start && (s[i] == '-' || (s[i] >= '0' && s[i] <= '9')))) {
+		i++
+	}
+	if i == start {
+		return "", nil, false, s, false
+	}
+	name = strings.ToLower(s[start:i])
+	attrs = map[string]string{}
+	for {
+		for i < len(s) && (isSpace(s[i]) || s[i] == '/') {
+			i++
+		}
+		if i >= len(s) {
+			return name, attrs, closing, "", true
+		}
+		if s[i] == '>' {
+			return name, attrs, closing, s[i+1:], true
+		}
+		keyStart := i
+		for i < len(s) && !isSpace(s[i]) && s[i] != '=' && s[i] != '>' && s[i] != '/' {
+			i++
+		}
+		key := strings.ToLower(s[keyStart:i])
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		if i >= len(s) || s[i] != '=' {
+			attrs[key] = ""
+			continue
+		}
+		i++
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		var value string
+		if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+			quote := s[i]
+			end := strings.IndexByte(s[i+1:], quote)
+			if end < 0 {
+				return name, attrs, closing, "", true
+			}
+			value = s[i+1 : i+1+end]
+			i += end + 2
+		} else {
+			valueStart := i
+			for i < len(s) && !isSpace(s[i]) && s[i] != '>' {
+				i++
+			}
+			value = s[valueStart:i]
+		}
+		attrs[key] = html.UnescapeString(value)
+	}
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+func (c *converter) start(name string, attrs map[string]string) {
+	if c.skipping != "" {
+		if name == c.skipping {
+			c.skipDepth++
+		}
+		return
+	}
+	if slices.Contains(skipped, name) {
+		c.skipping, c.skipDepth = name, 1
+		return
+	}
+	switch {
+	case name == "title":
+		c.inTitle = true
+	case len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6':
+		c.w.block()
+		c.w.raw(strings.Repeat("#", int(name[1]-'0')) + " ")
+	case name == "br":
+		c.w.newline()
+	case name == "hr":
+		c.w.block()
+		c.w.raw("---")
+		c.w.block()
+	case name == "pre":
+		c.w.block()
+		c.w.raw("```\n")
+		c.pre++
+	case name == "code" && c.pre == 0:
+		c.w.inline("`")
+	case name == "strong" || name == "b":
+		c.w.inline("**")
+	case name == "em" || name == "i":
+		c.w.inline("_")
+	case name == "ul" || name == "ol":
+		c.listBreak()
+		c.lists = append(c.lists, list{ordered: name == "ol"})
+	case name == "li":
+		c.w.newline()
+		c.w.raw(strings.Repeat("  ", max(len(c.lists)-1, 0)))
+		if n := len(c.lists); n > 0 && c.lists[n-1].ordered {
+			c.lists[n-1].n++
+			c.w.raw(strconv.Itoa(c.lists[n-1].n) + ". ")
+		} else {
+			c.w.raw("- ")
+		}
+	case name == "tr":
+		c.w.newline()
+	case name == "td" || name == "th":
+		c.w.inline("| ")
+	case name == "a":
+		href := c.resolve(attrs["href"])
+		c.links = append(c.links, href)
+		if href != "" {
+			c.w.inline("[")
+		}
+	case name == "img":
+		if alt := collapse(attrs["alt"]); alt != "" {
+			c.w.inline("![" + alt + "](" + c.resolve(attrs["src"]) + ")")
+		}
+	case slices.Contains(blocks, name):
+		c.w.block()
+	}
+}
+
+func (c *converter) end(name string) {
+	if c.skipping != "" {
+		if name == c.skipping {
+			c.skipDepth--
+			if c.skipDepth == 0 {
+				c.skipping = ""
+			}
+		}
+		return
+	}
+	switch {
+	case name == "title":
+		c.inTitle = false
+	case len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6':
+		c.w.block()
+	case name == "pre":
+		if c.pre > 0 {
+			c.pre--
+			c.w.newline()
+			c.w.raw("```")
+			c.w.block()
+		}
+	case name == "code" && c.pre == 0:
+		c.w.raw("`")
+	case name == "strong" || name == "b":
+		c.w.raw("**")
+	case name == "em" || name == "i":
+		c.w.raw("_")
+	case name == "ul" || name == "ol":
+		if len(c.lists) > 0 {
+			c.lists = c.lists[:len(c.lists)-1]
+		}
+		c.listBreak()
+	case name == "a":
+		if len(c.links) == 0 {
+			return
+		}
+		href := c.links[len(c.links)-1]
+		c.links = c.links[:len(c.links)-1]
+		if href != "" {
+			c.w.raw("](" + href + ")")
+		}
+	case slices.Contains(blocks, name):
+		c.w.block()
+	}
+}
+
+// listBreak separates a list from what's around it, with a blank line unless
+// it is nested in another list.
+func (c *converter) listBreak() {
+	if len(c.lists) > 0 {
+		c.w.newline()
+		return
+	}
+	c.w.block()
+}
+
+func (c *converter) text(s string) {
+	if s == "" || c.skipping != "" {
+		return
+	}
+	s = html.UnescapeString(s)
+	if c.inTitle {
+		c.title.WriteString(s)
+		return
+	}
+	if c.pre > 0 {
+		c.w.raw(s)
+		return
+	}
+	c.w.text(s)
+}
+
+// resolve makes a link absolute, leaving out those that only make sense
+// within the page.
+func (c *converter) resolve(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") || strings.HasPrefix(strings.ToLower(href), "javascript:") {
+		return ""
+	}
+	if c.base == nil {
+		return href
+	}
+	u, err := c.base.Parse(href)
+	if err != nil {
+		return href
+	}
+	return u.String()
+}
+
+// writer builds up the text, collapsing whitespace as a browser would.
+type writer struct {
+	b     strings.Builder
+	space bool
+	// lines is how many line breaks the text currently ends with.
+	lines int
+}
+
+func (w *writer) String() string {
+	return strings.TrimSpace(w.b.String()) + "\n"
+}
+
+// text writes s with its whitespace collapsed.
+func (w *writer) text(s string) {
+	if s != "" && isSpace(s[0]) {
+		w.space = true
+	}
+	words := strings.Fields(s)
+	for i, word := range words {
+		if i > 0 {
+			w.space = true
+		}
+		w.inline(word)
+	}
+	if len(words) > 0 && isSpace(s[len(s)-1]) {
+		w.space = true
+	}
+}
+
+// inline writes s within the current line, after a space if one is due.
+func (w *writer) inline(s string) {
+	if w.space && w.lines == 0 && w.b.Len() > 0 {
+		w.b.WriteByte(' ')
+	}
+	w.space = false
+	w.raw(s)
+}
+
+// raw writes s as it is.
+func (w *writer) raw(s string) {
+	if s == "" {
+		return
+	}
+	w.b.WriteString(s)
+	trimmed := strings.TrimRight(s, "\n")
+	if trimmed == "" {
+		w.lines += len(s)
+	} else {
+		w.lines = len(s) - len(trimmed)
+	}
+}
+
+// newline starts a new line, unless one has just started.
+func (w *writer) newline() {
+	w.space = false
+	if w.b.Len() > 0 && w.lines == 0 {
+		w.raw("\n")
+	}
+}
+
+// block leaves a blank line before what follows.
+func (w *writer) block() {
+	w.space = false
+	if w.b.Len() == 0 {
+		return
+	}
+	for w.lines < 2 {
+		w.raw("\n")
+	}
+}
+
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
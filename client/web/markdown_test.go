@@ -0,0 +1,74 @@
+package web_test
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mr-joshcrane/goracle/client/web"
+)
+
+func TestMarkdown_KeepsContentAndDropsScriptsStylesAndNavigation(t *testing.T) {
+	t.Parallel()
+	page := `<!DOCTYPE html>
+<html>
+<head>
+  <title> Getting started &amp; more </title>
+  <style>body { color: red }</style>
+  <script>if (a < b) { document.write("<p>hidden</p>") }</script>
+</head>
+<body>
+  <nav><a href="/">Home</a> <a href="/docs/">Docs</a></nav>
+  <h1>Getting   started</h1>
+  <p>Install <code>goracle</code> with <a href="install">the guide</a>.<br>Then <strong>ask</strong>.</p>
+  <ul>
+    <li>One</li>
+    <li>Two<ol><li>a</li><li>b</li></ol></li>
+  </ul>
+  <pre>x := 1
+y := 2</pre>
+  <img src="diagram.png" alt="A diagram">
+  <!-- <p>commented out</p> -->
+  <footer>Copyright</footer>
+</body>
+</html>`
+	base, _ := url.Parse("https://example.com/docs/")
+	title, got := web.Markdown([]byte(page), base)
+	if title != "Getting started & more" {
+		t.Errorf("expected the page title, got %q", title)
+	}
+	want := "# Getting started\n\n" +
+		"Install `goracle` with [the guide](https://example.com/docs/install).\n" +
+		"Then **ask**.\n\n" +
+		"- One\n" +
+		"- Two\n" +
+		"  1. a\n" +
+		"  2. b\n\n" +
+		"```\nx := 1\ny := 2\n```\n\n" +
+		"![A diagram](https://example.com/docs/diagram.png)\n"
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestMarkdown_ToleratesMalformedHTML(t *testing.T) {
+	t.Parallel()
+	_, got := web.Markdown([]byte("<p>a < b<p>unclosed <b>bold"), nil)
+	want := "a < b\n\nunclosed **bold\n"
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestMarkdown_SkipsScriptsAndStylesWithNonASCIIContent(t *testing.T) {
+	t.Parallel()
+	page := "<script>" + strings.Repeat("Ⱥ", 20) + "</SCRIPT>" +
+		"<style>İ { content: 'İİ' }</Style>" +
+		"<p>kept</p>"
+	_, got := web.Markdown([]byte(page), nil)
+	want := "kept\n"
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
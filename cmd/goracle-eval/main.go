@@ -0,0 +1,193 @@
+// Command goracle-eval runs a dataset of questions through one or more
+// models and reports how each did, exiting with status 1 if a regression
+// threshold is breached.
+//
+//	goracle-eval -data cases.jsonl -config fast=chatgpt:gpt-4o-mini -config careful=anthropic:claude-sonnet-4-20250514 \
+//		-scorers exact,judge -judge chatgpt:gpt-4o -baseline last.json -json report.json
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mr-joshcrane/goracle"
+	"github.com/mr-joshcrane/goracle/eval"
+)
+
+type configFlags []string
+
+func (c *configFlags) String() string {
+	return strings.Join(*c, ",")
+}
+
+func (c *configFlags) Set(value string) error {
+	*c = append(*c, value)
+	return nil
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("goracle-eval", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var configs configFlags
+	fs.Var(&configs, "config", "a configuration to evaluate, as `[name=]provider:model`, where provider is chatgpt, anthropic, gemini or ollama; may be repeated")
+	data := fs.String("data", "", "the dataset of cases, as JSON Lines")
+	purpose := fs.String("purpose", "", "the purpose to give every configuration")
+	scorers := fs.String("scorers", "exact", "the scorers to use: exact, regex, json, similarity and judge, separated by commas")
+	judge := fs.String("judge", "chatgpt:gpt-4o", "the `provider:model` that grades answers for the judge scorer")
+	threshold := fs.Float64("similarity", 0.8, "the least cosine similarity the similarity scorer passes")
+	ollama := fs.String("ollama", "http://localhost:11434", "the endpoint of the Ollama server")
+	workers := fs.Int("workers", 4, "how many cases to ask at once")
+	jsonOut := fs.String("json", "", "a file to write the report to as JSON, for use as a later baseline")
+	baseline := fs.String("baseline", "", "a JSON report from an earlier run to compare against")
+	minPassRate := fs.Float64("min-pass-rate", 0, "the lowest pass rate, from 0 to 1, any configuration may have")
+	maxDrop := fs.Float64("max-drop", 0, "how far a pass rate may fall below the baseline")
+	maxLatency := fs.Float64("max-latency-increase", 0, "how much mean latency may grow on the baseline, as a fraction; 0 is unchecked")
+	maxCost := fs.Float64("max-cost-increase", 0, "how much cost may grow on the baseline, as a fraction; 0 is unchecked")
+	err := fs.Parse(args)
+	if err != nil {
+		return 2
+	}
+	if *data == "" || len(configs) == 0 {
+		fmt.Fprintln(stderr, "goracle-eval: -data and at least one -config are required")
+		fs.Usage()
+		return 2
+	}
+	cases, err := eval.LoadFile(*data)
+	if err != nil {
+		fmt.Fprintln(stderr, "goracle-eval:", err)
+		return 2
+	}
+	var evalConfigs []eval.Config
+	for _, c := range configs {
+		name, spec, ok := strings.Cut(c, "=")
+		if !ok {
+			name, spec = c, c
+		}
+		o, err := newOracle(spec, *ollama)
+		if err != nil {
+			fmt.Fprintln(stderr, "goracle-eval:", err)
+			return 2
+		}
+		o.Forget()
+		if *purpose != "" {
+			o.SetPurpose(*purpose)
+		}
+		evalConfigs = append(evalConfigs, eval.Config{Name: name, Oracle: o})
+	}
+	opts := eval.Options{Workers: *workers}
+	for _, name := range strings.Split(*scorers, ",") {
+		switch strings.TrimSpace(name) {
+		case "exact":
+			opts.Scorers = append(opts.Scorers, eval.ExactMatch())
+		case "regex":
+			opts.Scorers = append(opts.Scorers, eval.Regex())
+		case "json":
+			opts.Scorers = append(opts.Scorers, eval.JSONFields())
+		case "similarity":
+			opts.Scorers = append(opts.Scorers, eval.Similarity(goracle.NewChatGPTEmbedder(os.Getenv("OPENAI_API_KEY")), *threshold))
+		case "judge":
+			o, err := newOracle(*judge, *ollama)
+			if err != nil {
+				fmt.Fprintln(stderr, "goracle-eval:", err)
+				return 2
+			}
+			opts.Scorers = append(opts.Scorers, eval.Judge(o))
+		default:
+			fmt.Fprintf(stderr, "goracle-eval: unknown scorer %q\n", name)
+			return 2
+		}
+	}
+	thresholds := eval.Thresholds{
+		MinPassRate:        *minPassRate,
+		MaxPassRateDrop:    *maxDrop,
+		MaxLatencyIncrease: *maxLatency,
+		MaxCostIncrease:    *maxCost,
+	}
+	if *baseline != "" {
+		f, err := os.Open(*baseline)
+		if err != nil {
+			fmt.Fprintln(stderr, "goracle-eval:", err)
+			return 2
+		}
+		base, err := eval.ReadReport(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintln(stderr, "goracle-eval:", err)
+			return 2
+		}
+		thresholds.Baseline = &base
+	}
+	report, err := eval.Run(context.Background(), cases, evalConfigs, opts)
+	if err != nil {
+		fmt.Fprintln(stderr, "goracle-eval:", err)
+		return 2
+	}
+	err = report.WriteMarkdown(stdout)
+	if err != nil {
+		fmt.Fprintln(stderr, "goracle-eval:", err)
+		return 2
+	}
+	if *jsonOut != "" {
+		err = writeReport(*jsonOut, report)
+		if err != nil {
+			fmt.Fprintln(stderr, "goracle-eval:", err)
+			return 2
+		}
+	}
+	err = report.Check(thresholds)
+	var regression eval.RegressionError
+	if errors.As(err, &regression) {
+		for _, b := range regression.Breaches {
+			fmt.Fprintln(stderr, "regression:", b)
+		}
+		return 1
+	}
+	return 0
+}
+
+// newOracle makes an Oracle from a spec such as "chatgpt:gpt-4o".
+func newOracle(spec, ollamaEndpoint string) (*goracle.Oracle, error) {
+	provider, model, _ := strings.Cut(spec, ":")
+	var o *goracle.Oracle
+	switch provider {
+	case "chatgpt":
+		o = goracle.NewChatGPTOracle(os.Getenv("OPENAI_API_KEY"))
+	case "anthropic":
+		o = goracle.NewAnthropicOracle(os.Getenv("ANTHROPIC_API_KEY"))
+	case "gemini":
+		o = goracle.NewGoogleGeminiOracle()
+	case "ollama":
+		return goracle.NewOllamaOracle(model, ollamaEndpoint), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q in %q", provider, spec)
+	}
+	if model != "" {
+		err := o.WithModel(model)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+func writeReport(path string, report eval.Report) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = report.WriteJSON(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,57 @@
+package goracle
+
+import (
+	"context"
+
+	"github.com/mr-joshcrane/goracle/client"
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+// Embedder turns texts into vectors, so that texts with similar meanings can
+// be found by comparing their vectors. Embed returns one vector for each
+// text, in the same order.
+//
+// The embedders in the client package also report the length of their
+// vectors with a Dimensions method, and switch models with WithModel.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// NewChatGPTEmbedder takes an OpenAI API token and sets up an Embedder using
+// text-embedding-3-small.
+func NewChatGPTEmbedder(token string) *client.ChatGPTEmbedder {
+	return client.NewChatGPTEmbedder(token)
+}
+
+// NewVertexEmbedder sets up an Embedder using Google's text-embedding-005,
+// authenticating with gcloud when first used.
+func NewVertexEmbedder() *client.VertexEmbedder {
+	return client.NewVertexEmbedder()
+}
+
+// NewOllamaEmbedder sets up an Embedder using an embedding model served by
+// Ollama at endpoint.
+func NewOllamaEmbedder(model string, endpoint string) *client.OllamaEmbedder {
+	return client.NewOllamaEmbedder(model, endpoint)
+}
+
+// Dimensions returns the length of the vectors e returns, or 0 if it doesn't
+// say.
+func Dimensions(e Embedder) int {
+	if d, ok := e.(interface{ Dimensions() int }); ok {
+		return d.Dimensions()
+	}
+	return 0
+}
+
+// Normalize returns v scaled to unit length, so that the dot product of two
+// normalised vectors is their cosine similarity.
+func Normalize(v []float32) []float32 {
+	return llm.Normalize(v)
+}
+
+// CosineSimilarity measures how alike two vectors are, from -1 for opposite
+// to 1 for the same direction.
+func CosineSimilarity(a, b []float32) float32 {
+	return llm.CosineSimilarity(a, b)
+}
@@ -0,0 +1,151 @@
+// Package eval measures how well Oracles answer a dataset of questions, so
+// that changes to a purpose or a model can be compared against each other and
+// against earlier runs. Cases are read from JSON Lines, asked of each
+// configuration, scored, and summarised in a [Report] of pass rates, latency
+// and cost.
+package eval
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mr-joshcrane/goracle"
+)
+
+// Case is a question to ask, along with what a good answer looks like.
+type Case struct {
+	// ID names the case in reports. Cases read by [Load] without one are
+	// named after their line.
+	ID         string
+	Question   string
+	References []CaseReference
+	// Expected is what the scorers compare answers against: the answer
+	// itself, a pattern, JSON fields or a description for a judge.
+	Expected string
+	// Scorers, if given, names which of the run's scorers apply to this
+	// case. The default is all of them.
+	Scorers []string
+}
+
+// CaseReference is a reference given along with a case's question, either
+// as text or read from a file or URL.
+type CaseReference struct {
+	Name string `json:"name,omitempty"`
+	Text string `json:"text,omitempty"`
+	File string `json:"file,omitempty"`
+	URL  string `json:"url,omitempty"`
+}
+
+// caseLine is a case as written in a dataset. The expected answer may be a
+// string, or any other JSON value, such as the fields of an object for
+// [JSONFields], which is kept as JSON.
+type caseLine struct {
+	ID         string          `json:"id"`
+	Question   string          `json:"question"`
+	References []CaseReference `json:"references"`
+	Expected   json.RawMessage `json:"expected"`
+	Scorers    []string        `json:"scorers"`
+}
+
+// Load reads cases from r, one JSON object per line, such as:
+//
+//	{"id": "capital", "question": "What is the capital of France?", "expected": "Paris"}
+//
+// Blank lines are skipped.
+func Load(r io.Reader) ([]Case, error) {
+	var cases []Case
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var l caseLine
+		err := json.Unmarshal(data, &l)
+		if err != nil {
+			return nil, fmt.Errorf("invalid case on line %d: %w", line, err)
+		}
+		if l.Question == "" {
+			return nil, fmt.Errorf("invalid case on line %d: no question", line)
+		}
+		c := Case{
+			ID:         l.ID,
+			Question:   l.Question,
+			References: l.References,
+			Scorers:    l.Scorers,
+		}
+		if c.ID == "" {
+			c.ID = fmt.Sprintf("line %d", line)
+		}
+		if len(l.Expected) > 0 {
+			err = json.Unmarshal(l.Expected, &c.Expected)
+			if err != nil {
+				c.Expected = string(l.Expected)
+			}
+		}
+		cases = append(cases, c)
+	}
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+	return cases, nil
+}
+
+// LoadFile reads cases from the file at path, as [Load] does. Files the cases
+// refer to are found relative to the dataset.
+func LoadFile(path string) ([]Case, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cases, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	dir := filepath.Dir(path)
+	for _, c := range cases {
+		for n, r := range c.References {
+			if r.File != "" && !filepath.IsAbs(r.File) {
+				c.References[n].File = filepath.Join(dir, r.File)
+			}
+		}
+	}
+	return cases, nil
+}
+
+// references reads the case's references, ready to be asked with.
+func (c Case) references(ctx context.Context) ([]any, error) {
+	refs := make([]any, 0, len(c.References))
+	for _, r := range c.References {
+		var ref goracle.Reference
+		var err error
+		switch {
+		case r.File != "":
+			ref, err = goracle.ReadFile(r.File)
+		case r.URL != "":
+			ref, err = goracle.ReadURL(ctx, r.URL, goracle.URLOptions{})
+		default:
+			ref = goracle.Reference{
+				MIMEType: "text/plain; charset=utf-8",
+				Data:     []byte(r.Text),
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading reference: %w", err)
+		}
+		if r.Name != "" {
+			ref.Name = r.Name
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
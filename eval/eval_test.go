@@ -0,0 +1,292 @@
+package eval_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mr-joshcrane/goracle"
+	"github.com/mr-joshcrane/goracle/client"
+	"github.com/mr-joshcrane/goracle/client/llm"
+	"github.com/mr-joshcrane/goracle/eval"
+)
+
+const dataset = `{"id": "capital", "question": "What is the capital of France?", "expected": "Paris"}
+
+{"question": "Describe Paris as JSON.", "expected": {"city": "Paris", "country": "France"}, "scorers": ["json"]}
+{"id": "notes", "question": "What do the notes say?", "references": [{"file": "notes.txt"}, {"name": "extra", "text": "More notes"}], "expected": "(?i)quokka"}
+`
+
+func TestLoad_ReadsCasesFromJSONLines(t *testing.T) {
+	t.Parallel()
+	cases, err := eval.Load(strings.NewReader(dataset))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []eval.Case{
+		{ID: "capital", Question: "What is the capital of France?", Expected: "Paris"},
+		{ID: "line 3", Question: "Describe Paris as JSON.", Expected: `{"city": "Paris", "country": "France"}`, Scorers: []string{"json"}},
+		{ID: "notes", Question: "What do the notes say?", Expected: "(?i)quokka", References: []eval.CaseReference{
+			{File: "notes.txt"},
+			{Name: "extra", Text: "More notes"},
+		}},
+	}
+	if !cmp.Equal(want, cases) {
+		t.Error(cmp.Diff(want, cases))
+	}
+}
+
+func TestLoad_RejectsCasesWithoutAQuestion(t *testing.T) {
+	t.Parallel()
+	_, err := eval.Load(strings.NewReader(`{"expected": "Paris"}`))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestLoadFile_FindsReferencedFilesNextToTheDataset(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cases.jsonl")
+	err := os.WriteFile(path, []byte(dataset), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases, err := eval.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(dir, "notes.txt")
+	if cases[2].References[0].File != want {
+		t.Errorf("expected %s, got %s", want, cases[2].References[0].File)
+	}
+}
+
+func score(t *testing.T, s eval.Scorer, expected, answer string) eval.Score {
+	t.Helper()
+	got, err := s.Score(context.Background(), eval.Case{ID: "test", Question: "Q?", Expected: expected}, answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestExactMatch_IgnoresSurroundingSpace(t *testing.T) {
+	t.Parallel()
+	if !score(t, eval.ExactMatch(), "Paris", " Paris\n").Pass {
+		t.Error("expected Paris to match")
+	}
+	if score(t, eval.ExactMatch(), "Paris", "paris").Pass {
+		t.Error("expected paris not to match")
+	}
+}
+
+func TestRegex_MatchesThePattern(t *testing.T) {
+	t.Parallel()
+	if !score(t, eval.Regex(), `(?i)\bparis\b`, "It's PARIS.").Pass {
+		t.Error("expected the pattern to match")
+	}
+	_, err := eval.Regex().Score(context.Background(), eval.Case{Expected: "("}, "")
+	if err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestJSONFields_ScoresTheShareOfMatchingFields(t *testing.T) {
+	t.Parallel()
+	expected := `{"city": "Paris", "country": "France"}`
+	got := score(t, eval.JSONFields(), expected, "```json\n{\"city\": \"Paris\", \"country\": \"Spain\", \"extra\": 1}\n```")
+	if got.Pass || got.Value != 0.5 || !strings.Contains(got.Reason, "country") {
+		t.Errorf("expected half the fields to match, got %+v", got)
+	}
+	got = score(t, eval.JSONFields(), expected, `{"country": "France", "city": "Paris"}`)
+	if !got.Pass || got.Value != 1 {
+		t.Errorf("expected every field to match, got %+v", got)
+	}
+	if score(t, eval.JSONFields(), expected, "Paris, France").Pass {
+		t.Error("expected an answer without JSON to fail")
+	}
+}
+
+// letterEmbedder embeds text by counting its letters, so that texts with the
+// same letters are similar.
+type letterEmbedder struct{}
+
+func (letterEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, 26)
+		for _, r := range strings.ToLower(text) {
+			if r >= 'a' && r <= 'z' {
+				vectors[i][r-'a']++
+			}
+		}
+	}
+	return vectors, nil
+}
+
+func TestSimilarity_PassesAnswersCloseToTheExpectedOne(t *testing.T) {
+	t.Parallel()
+	s := eval.Similarity(letterEmbedder{}, 0.9)
+	if got := score(t, s, "the capital is Paris", "Paris is the capital"); !got.Pass || got.Value < 0.99 {
+		t.Errorf("expected a close match, got %+v", got)
+	}
+	if got := score(t, s, "the capital is Paris", "xyzzy"); got.Pass {
+		t.Errorf("expected no match, got %+v", got)
+	}
+}
+
+func TestJudge_GradesWithAFreshForkOfTheJudge(t *testing.T) {
+	t.Parallel()
+	c := client.NewDummyClient(`{"pass": true, "score": 0.8, "reason": "Close enough"}`, nil)
+	judge := goracle.NewOracle(c)
+	judge.GiveExample("Should not be seen", "By the judge")
+	got := score(t, eval.Judge(judge), "Names Paris", "Paris")
+	want := eval.Score{Value: 0.8, Pass: true, Reason: "Close enough"}
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	inputs, _ := c.P.GetHistory()
+	if len(inputs) != 0 || !strings.Contains(c.P.GetQuestion(), "Names Paris") {
+		t.Errorf("unexpected judge prompt: %+v", c.P)
+	}
+}
+
+// answers gives each question's answer, or fails questions it doesn't know.
+func answers(byQuestion map[string]string) goracle.LanguageModel {
+	return goracle.LanguageModelFunc(func(ctx context.Context, prompt client.Prompt) (io.Reader, error) {
+		answer, ok := byQuestion[prompt.GetQuestion()]
+		if !ok {
+			return nil, errors.New("no idea")
+		}
+		return llm.Once(llm.Delta{Text: answer, Usage: llm.Usage{InputTokens: 10, OutputTokens: 2, Cost: 0.01}}), nil
+	})
+}
+
+func TestRun_ScoresEachConfigurationSeparately(t *testing.T) {
+	t.Parallel()
+	cases := []eval.Case{
+		{ID: "capital", Question: "Capital of France?", Expected: "Paris", Scorers: []string{"exact"}},
+		{ID: "json", Question: "Paris as JSON?", Expected: `{"city": "Paris"}`, Scorers: []string{"json"}},
+		{ID: "quokka", Question: "Best animal?", Expected: "Quokka", Scorers: []string{"exact"}},
+	}
+	good := goracle.NewOracle(answers(map[string]string{
+		"Capital of France?": "Paris",
+		"Paris as JSON?":     `{"city": "Paris"}`,
+		"Best animal?":       "Quokka",
+	}))
+	bad := goracle.NewOracle(answers(map[string]string{
+		"Capital of France?": "Lyon",
+		"Paris as JSON?":     `{"city": "Paris"}`,
+	}))
+	report, err := eval.Run(context.Background(), cases, []eval.Config{
+		{Name: "good", Oracle: good},
+		{Name: "bad", Oracle: bad},
+	}, eval.Options{Scorers: []eval.Scorer{eval.ExactMatch(), eval.JSONFields()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	goodReport, _ := report.Config("good")
+	if goodReport.Passed != 3 || goodReport.PassRate != 1 || goodReport.Errors != 0 {
+		t.Errorf("expected every case to pass, got %+v", goodReport)
+	}
+	if goodReport.Usage != (llm.Usage{InputTokens: 30, OutputTokens: 6, Cost: 0.03}) {
+		t.Errorf("expected usage to add up across cases, got %+v", goodReport.Usage)
+	}
+	badReport, _ := report.Config("bad")
+	if badReport.Passed != 1 || badReport.Errors != 1 || badReport.Scores["exact"] != 0 {
+		t.Errorf("expected one pass and one error, got %+v", badReport)
+	}
+	if _, ok := badReport.Results[1].Scores["exact"]; ok {
+		t.Error("expected the json case to be scored only by the json scorer")
+	}
+	if good.Usage() != (llm.Usage{}) {
+		t.Errorf("expected cases to be asked of forks, but the Oracle used %+v", good.Usage())
+	}
+}
+
+func TestRun_RejectsUnknownScorers(t *testing.T) {
+	t.Parallel()
+	cases := []eval.Case{{ID: "a", Question: "Q?", Scorers: []string{"vibes"}}}
+	_, err := eval.Run(context.Background(), cases, nil, eval.Options{Scorers: []eval.Scorer{eval.ExactMatch()}})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestReport_WritesMarkdownAndJSONThatReadsBack(t *testing.T) {
+	t.Parallel()
+	cases := []eval.Case{
+		{ID: "capital", Question: "Capital of France?", Expected: "Paris"},
+		{ID: "quokka", Question: "Best animal?", Expected: "Quokka"},
+	}
+	o := goracle.NewOracle(answers(map[string]string{"Capital of France?": "Paris", "Best animal?": "Wombat"}))
+	report, err := eval.Run(context.Background(), cases, []eval.Config{{Name: "fast", Oracle: o}},
+		eval.Options{Scorers: []eval.Scorer{eval.ExactMatch()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := new(bytes.Buffer)
+	err = report.WriteMarkdown(md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"| fast | 1/2 | 50.0% | 0 |", "| 0.50 |", "## Failures: fast", `- **quokka**; exact 0.00: expected "Quokka", got "Wombat"`} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("expected %q in report:\n%s", want, md)
+		}
+	}
+	data := new(bytes.Buffer)
+	err = report.WriteJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := eval.ReadReport(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(report, got) {
+		t.Error(cmp.Diff(report, got))
+	}
+}
+
+func TestReadReport_RejectsUnknownVersions(t *testing.T) {
+	t.Parallel()
+	_, err := eval.ReadReport(strings.NewReader(`{"version": 99}`))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestReport_CheckFindsRegressions(t *testing.T) {
+	t.Parallel()
+	baseline := eval.Report{Version: eval.ReportVersion, Configs: []eval.ConfigReport{
+		{Name: "fast", PassRate: 0.9, MeanLatency: 100, Usage: llm.Usage{Cost: 1}},
+	}}
+	report := eval.Report{Version: eval.ReportVersion, Configs: []eval.ConfigReport{
+		{Name: "fast", PassRate: 0.85, MeanLatency: 150, Usage: llm.Usage{Cost: 1.05}},
+		{Name: "new", PassRate: 0.5},
+	}}
+	err := report.Check(eval.Thresholds{Baseline: &baseline, MaxPassRateDrop: 0.1, MaxLatencyIncrease: 0.2, MaxCostIncrease: 0.1})
+	var regression eval.RegressionError
+	if !errors.As(err, &regression) || len(regression.Breaches) != 1 || !strings.Contains(regression.Breaches[0], "latency") {
+		t.Errorf("expected only a latency regression, got %v", err)
+	}
+	err = report.Check(eval.Thresholds{Baseline: &baseline})
+	if !errors.As(err, &regression) || !strings.Contains(regression.Breaches[0], "pass rate fell") {
+		t.Errorf("expected any drop in pass rate to be a regression, got %v", err)
+	}
+	err = report.Check(eval.Thresholds{MinPassRate: 0.6})
+	if !errors.As(err, &regression) || len(regression.Breaches) != 1 || !strings.Contains(regression.Breaches[0], "new") {
+		t.Errorf("expected the new configuration to fall below the minimum, got %v", err)
+	}
+	if err := report.Check(eval.Thresholds{}); err != nil {
+		t.Errorf("expected no regression without thresholds, got %v", err)
+	}
+}
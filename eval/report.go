@@ -0,0 +1,247 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+// ReportVersion is the version of the format written by
+// [Report.WriteJSON]. [ReadReport] rejects reports from newer versions.
+const ReportVersion = 1
+
+// Report summarises a [Run], one configuration at a time.
+type Report struct {
+	Version int            `json:"version"`
+	Configs []ConfigReport `json:"configs"`
+}
+
+// ConfigReport is how one configuration did across every case.
+type ConfigReport struct {
+	Name     string  `json:"name"`
+	Cases    int     `json:"cases"`
+	Passed   int     `json:"passed"`
+	Errors   int     `json:"errors"`
+	PassRate float64 `json:"pass_rate"`
+	// Scores is the mean score given by each scorer.
+	Scores      map[string]float64 `json:"scores"`
+	MeanLatency time.Duration      `json:"mean_latency"`
+	P95Latency  time.Duration      `json:"p95_latency"`
+	// Usage is the tokens used, and what they cost, across every case.
+	Usage   llm.Usage `json:"usage"`
+	Results []Result  `json:"results"`
+}
+
+// summarise adds up the results of one configuration.
+func summarise(name string, results []Result) ConfigReport {
+	r := ConfigReport{
+		Name:    name,
+		Cases:   len(results),
+		Scores:  map[string]float64{},
+		Results: results,
+	}
+	if len(results) == 0 {
+		return r
+	}
+	counts := map[string]int{}
+	latencies := make([]time.Duration, len(results))
+	var total time.Duration
+	for n, result := range results {
+		if result.Pass {
+			r.Passed++
+		}
+		if result.Error != "" {
+			r.Errors++
+		}
+		for scorer, s := range result.Scores {
+			r.Scores[scorer] += s.Value
+			counts[scorer]++
+		}
+		latencies[n] = result.Latency
+		total += result.Latency
+		r.Usage = r.Usage.Add(result.Usage)
+	}
+	for scorer, count := range counts {
+		r.Scores[scorer] /= float64(count)
+	}
+	r.PassRate = float64(r.Passed) / float64(r.Cases)
+	r.MeanLatency = total / time.Duration(len(results))
+	slices.Sort(latencies)
+	r.P95Latency = latencies[(len(latencies)*95+99)/100-1]
+	return r
+}
+
+// Config returns the report for the configuration called name.
+func (r Report) Config(name string) (ConfigReport, bool) {
+	for _, c := range r.Configs {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return ConfigReport{}, false
+}
+
+// WriteJSON writes the report to w as versioned JSON, which [ReadReport] can
+// read back as a baseline for later runs.
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// ReadReport reads a report written by [Report.WriteJSON].
+func ReadReport(rd io.Reader) (Report, error) {
+	var r Report
+	err := json.NewDecoder(rd).Decode(&r)
+	if err != nil {
+		return Report{}, fmt.Errorf("invalid report: %w", err)
+	}
+	if r.Version < 1 || r.Version > ReportVersion {
+		return Report{}, fmt.Errorf("unsupported report version %d", r.Version)
+	}
+	return r, nil
+}
+
+// WriteMarkdown writes the report to w as a Markdown table comparing the
+// configurations, followed by the cases each one failed.
+func (r Report) WriteMarkdown(w io.Writer) error {
+	var scorers []string
+	for _, c := range r.Configs {
+		for name := range c.Scores {
+			if !slices.Contains(scorers, name) {
+				scorers = append(scorers, name)
+			}
+		}
+	}
+	slices.Sort(scorers)
+	var b strings.Builder
+	b.WriteString("# Evaluation Report\n\n")
+	b.WriteString("| Configuration | Passed | Pass rate | Errors | Mean latency | P95 latency | Tokens | Cost |")
+	for _, s := range scorers {
+		fmt.Fprintf(&b, " %s |", s)
+	}
+	b.WriteString("\n|---|---:|---:|---:|---:|---:|---:|---:|")
+	b.WriteString(strings.Repeat("---:|", len(scorers)))
+	b.WriteString("\n")
+	for _, c := range r.Configs {
+		fmt.Fprintf(&b, "| %s | %d/%d | %.1f%% | %d | %s | %s | %d | $%.4f |",
+			markdownEscape(c.Name), c.Passed, c.Cases, c.PassRate*100, c.Errors,
+			c.MeanLatency.Round(time.Millisecond), c.P95Latency.Round(time.Millisecond),
+			c.Usage.InputTokens+c.Usage.OutputTokens, c.Usage.Cost)
+		for _, s := range scorers {
+			score, ok := c.Scores[s]
+			if !ok {
+				b.WriteString(" - |")
+				continue
+			}
+			fmt.Fprintf(&b, " %.2f |", score)
+		}
+		b.WriteString("\n")
+	}
+	for _, c := range r.Configs {
+		if c.Passed == c.Cases {
+			continue
+		}
+		fmt.Fprintf(&b, "\n## Failures: %s\n\n", c.Name)
+		for _, result := range c.Results {
+			if result.Pass {
+				continue
+			}
+			fmt.Fprintf(&b, "- **%s**", markdownEscape(result.Case))
+			if result.Error != "" {
+				fmt.Fprintf(&b, ": error: %s", oneLine(result.Error))
+			}
+			names := make([]string, 0, len(result.Scores))
+			for name := range result.Scores {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			for _, name := range names {
+				s := result.Scores[name]
+				if !s.Pass {
+					fmt.Fprintf(&b, "; %s %.2f: %s", name, s.Value, oneLine(s.Reason))
+				}
+			}
+			b.WriteString("\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownEscape stops text from breaking out of a table cell or adding
+// emphasis.
+func markdownEscape(text string) string {
+	return strings.NewReplacer("|", `\|`, "*", `\*`, "_", `\_`).Replace(oneLine(text))
+}
+
+func oneLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// Thresholds decide when a report shows a regression. Zero values are not
+// checked, apart from the drop in pass rate, which allows no drop at all.
+type Thresholds struct {
+	// MinPassRate is the lowest pass rate, between 0 and 1, any
+	// configuration may have.
+	MinPassRate float64
+	// Baseline is an earlier report to compare configurations with, by
+	// name. Configurations missing from the baseline aren't compared.
+	Baseline *Report
+	// MaxPassRateDrop is how far a configuration's pass rate may fall
+	// below its baseline.
+	MaxPassRateDrop float64
+	// MaxLatencyIncrease is the most a configuration's mean latency may
+	// grow on its baseline, as a fraction, such as 0.2 for 20%.
+	MaxLatencyIncrease float64
+	// MaxCostIncrease is the most a configuration's cost may grow on its
+	// baseline, as a fraction.
+	MaxCostIncrease float64
+}
+
+// RegressionError lists the thresholds a report breached.
+type RegressionError struct {
+	Breaches []string
+}
+
+func (e RegressionError) Error() string {
+	return fmt.Sprintf("regression: %s", strings.Join(e.Breaches, "; "))
+}
+
+// Check returns a [RegressionError] if any configuration in the report
+// breaches the thresholds.
+func (r Report) Check(t Thresholds) error {
+	var breaches []string
+	for _, c := range r.Configs {
+		if c.PassRate < t.MinPassRate {
+			breaches = append(breaches, fmt.Sprintf("%s pass rate %.1f%% is below %.1f%%", c.Name, c.PassRate*100, t.MinPassRate*100))
+		}
+		if t.Baseline == nil {
+			continue
+		}
+		base, ok := t.Baseline.Config(c.Name)
+		if !ok {
+			continue
+		}
+		if drop := base.PassRate - c.PassRate; drop > t.MaxPassRateDrop+1e-9 {
+			breaches = append(breaches, fmt.Sprintf("%s pass rate fell from %.1f%% to %.1f%%", c.Name, base.PassRate*100, c.PassRate*100))
+		}
+		if t.MaxLatencyIncrease > 0 && base.MeanLatency > 0 &&
+			float64(c.MeanLatency) > float64(base.MeanLatency)*(1+t.MaxLatencyIncrease) {
+			breaches = append(breaches, fmt.Sprintf("%s mean latency rose from %s to %s", c.Name, base.MeanLatency.Round(time.Millisecond), c.MeanLatency.Round(time.Millisecond)))
+		}
+		if t.MaxCostIncrease > 0 && base.Usage.Cost > 0 &&
+			c.Usage.Cost > base.Usage.Cost*(1+t.MaxCostIncrease) {
+			breaches = append(breaches, fmt.Sprintf("%s cost rose from $%.4f to $%.4f", c.Name, base.Usage.Cost, c.Usage.Cost))
+		}
+	}
+	if len(breaches) > 0 {
+		return RegressionError{Breaches: breaches}
+	}
+	return nil
+}
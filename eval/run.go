@@ -0,0 +1,121 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/mr-joshcrane/goracle"
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+const defaultWorkers = 4
+
+// Config is an Oracle to evaluate, such as one with a new purpose or model,
+// named so that it can be told apart in reports.
+type Config struct {
+	Name   string
+	Oracle *goracle.Oracle
+}
+
+// Options control a [Run].
+type Options struct {
+	// Scorers judge each answer. A case passes if every scorer that applies
+	// to it passes.
+	Scorers []Scorer
+	// Workers is how many cases are asked at once for each configuration.
+	// The default is 4.
+	Workers int
+}
+
+// Result is how one configuration did on one case.
+type Result struct {
+	Case    string           `json:"case"`
+	Answer  string           `json:"answer"`
+	Error   string           `json:"error,omitempty"`
+	Pass    bool             `json:"pass"`
+	Scores  map[string]Score `json:"scores,omitempty"`
+	Latency time.Duration    `json:"latency"`
+	Usage   llm.Usage        `json:"usage"`
+}
+
+// Run asks every case of each configuration and scores the answers. Each case
+// is asked of a fork of the configuration's Oracle, so that cases don't see
+// each other in its history, and its usage can be told apart. A case that
+// can't be asked or scored fails, with the error in its result; Run only
+// fails if a case asks for a scorer that isn't given, or ctx ends.
+func Run(ctx context.Context, cases []Case, configs []Config, opts Options) (Report, error) {
+	scorers := map[string]Scorer{}
+	for _, s := range opts.Scorers {
+		scorers[s.Name()] = s
+	}
+	for _, c := range cases {
+		for _, name := range c.Scorers {
+			if scorers[name] == nil {
+				return Report{}, fmt.Errorf("case %s asks for unknown scorer %s", c.ID, name)
+			}
+		}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	report := Report{Version: ReportVersion}
+	for _, config := range configs {
+		results := make([]Result, len(cases))
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, workers)
+		for n, c := range cases {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				results[n] = runCase(ctx, config.Oracle, c, opts.Scorers)
+			}()
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			return Report{}, context.Cause(ctx)
+		}
+		report.Configs = append(report.Configs, summarise(config.Name, results))
+	}
+	return report, nil
+}
+
+// runCase asks c of a fork of o and scores the answer.
+func runCase(ctx context.Context, o *goracle.Oracle, c Case, scorers []Scorer) Result {
+	r := Result{Case: c.ID}
+	refs, err := c.references(ctx)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	fork := o.Fork()
+	start := time.Now()
+	r.Answer, err = fork.AskWithContext(ctx, c.Question, refs...)
+	r.Latency = time.Since(start)
+	r.Usage = fork.Usage()
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.Pass = true
+	r.Scores = map[string]Score{}
+	for _, s := range scorers {
+		if len(c.Scorers) > 0 && !slices.Contains(c.Scorers, s.Name()) {
+			continue
+		}
+		score, err := s.Score(ctx, c, r.Answer)
+		if err != nil {
+			r.Error = fmt.Sprintf("%s: %s", s.Name(), err)
+			r.Pass = false
+			continue
+		}
+		r.Scores[s.Name()] = score
+		r.Pass = r.Pass && score.Pass
+	}
+	return r
+}
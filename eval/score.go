@@ -0,0 +1,161 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mr-joshcrane/goracle"
+)
+
+// Score is how well an answer met a case's expectations, by one scorer.
+type Score struct {
+	// Value is between 0 and 1, with 1 the best.
+	Value  float64 `json:"value"`
+	Pass   bool    `json:"pass"`
+	Reason string  `json:"reason,omitempty"`
+}
+
+// Scorer judges an answer to a case.
+type Scorer interface {
+	// Name identifies the scorer in reports, and in the scorers a case
+	// asks for.
+	Name() string
+	Score(ctx context.Context, c Case, answer string) (Score, error)
+}
+
+// ScorerFunc makes an ordinary function a [Scorer] called name.
+func ScorerFunc(name string, fn func(ctx context.Context, c Case, answer string) (Score, error)) Scorer {
+	return scorerFunc{name: name, fn: fn}
+}
+
+type scorerFunc struct {
+	name string
+	fn   func(ctx context.Context, c Case, answer string) (Score, error)
+}
+
+func (s scorerFunc) Name() string {
+	return s.name
+}
+
+func (s scorerFunc) Score(ctx context.Context, c Case, answer string) (Score, error) {
+	return s.fn(ctx, c, answer)
+}
+
+// passIf gives a score of 1 if pass is true, or 0 with reason if not.
+func passIf(pass bool, reason string) Score {
+	if pass {
+		return Score{Value: 1, Pass: true}
+	}
+	return Score{Reason: reason}
+}
+
+// ExactMatch passes answers that are the expected answer, ignoring the space
+// around them.
+func ExactMatch() Scorer {
+	return ScorerFunc("exact", func(ctx context.Context, c Case, answer string) (Score, error) {
+		got := strings.TrimSpace(answer)
+		want := strings.TrimSpace(c.Expected)
+		return passIf(got == want, fmt.Sprintf("expected %q, got %q", want, got)), nil
+	})
+}
+
+// Regex passes answers matching the expected answer as a regular expression.
+func Regex() Scorer {
+	return ScorerFunc("regex", func(ctx context.Context, c Case, answer string) (Score, error) {
+		re, err := regexp.Compile(c.Expected)
+		if err != nil {
+			return Score{}, fmt.Errorf("invalid pattern for case %s: %w", c.ID, err)
+		}
+		return passIf(re.MatchString(answer), fmt.Sprintf("answer does not match %s", c.Expected)), nil
+	})
+}
+
+// JSONFields passes answers holding a JSON object with every field of the
+// expected answer, itself a JSON object, set to the same value. Fields the
+// answer has besides are ignored, and any text around the object, such as a
+// Markdown code fence, is skipped. The score is the share of fields that
+// match.
+func JSONFields() Scorer {
+	return ScorerFunc("json", func(ctx context.Context, c Case, answer string) (Score, error) {
+		var want map[string]any
+		err := json.Unmarshal([]byte(c.Expected), &want)
+		if err != nil {
+			return Score{}, fmt.Errorf("expected answer for case %s is not a JSON object: %w", c.ID, err)
+		}
+		start, end := strings.Index(answer, "{"), strings.LastIndex(answer, "}")
+		var got map[string]any
+		if start < 0 || end < start || json.Unmarshal([]byte(answer[start:end+1]), &got) != nil {
+			return Score{Reason: "answer is not a JSON object"}, nil
+		}
+		var wrong []string
+		for field, value := range want {
+			if !reflect.DeepEqual(got[field], value) {
+				wrong = append(wrong, field)
+			}
+		}
+		if len(want) == 0 {
+			return Score{Value: 1, Pass: true}, nil
+		}
+		slices.Sort(wrong)
+		s := passIf(len(wrong) == 0, fmt.Sprintf("fields differ: %s", strings.Join(wrong, ", ")))
+		s.Value = float64(len(want)-len(wrong)) / float64(len(want))
+		return s, nil
+	})
+}
+
+// Similarity passes answers whose meaning is close to the expected answer's,
+// with a cosine similarity of their embeddings of at least threshold. The
+// score is the similarity.
+func Similarity(e goracle.Embedder, threshold float64) Scorer {
+	return ScorerFunc("similarity", func(ctx context.Context, c Case, answer string) (Score, error) {
+		vectors, err := e.Embed(ctx, []string{c.Expected, answer})
+		if err != nil {
+			return Score{}, fmt.Errorf("embedding answers: %w", err)
+		}
+		if len(vectors) != 2 {
+			return Score{}, fmt.Errorf("embedding answers: asked for 2 embeddings, got %d", len(vectors))
+		}
+		similarity := float64(goracle.CosineSimilarity(vectors[0], vectors[1]))
+		s := passIf(similarity >= threshold, fmt.Sprintf("similarity %.3f is below %.3f", similarity, threshold))
+		s.Value = max(0, similarity)
+		return s, nil
+	})
+}
+
+// judgePurpose frames the judge's task.
+const judgePurpose = `You grade answers to questions. You are given a question, the answer ` +
+	`being graded, and what a good answer looks like. Decide whether the answer ` +
+	`meets that standard, give a score between 0 and 1, and briefly explain why.`
+
+// verdict is the judge's decision.
+type verdict struct {
+	Pass   bool    `json:"pass"`
+	Score  float64 `json:"score" description:"How well the answer meets the standard, from 0 to 1."`
+	Reason string  `json:"reason"`
+}
+
+// Judge asks judge, an Oracle usually with a capable model, to grade answers
+// against the expected answer, which may describe a good answer rather than
+// give one. Each answer is graded by a fork of judge, so that grading one
+// doesn't affect the next.
+func Judge(judge *goracle.Oracle) Scorer {
+	return ScorerFunc("judge", func(ctx context.Context, c Case, answer string) (Score, error) {
+		o := judge.Fork().Forget()
+		o.SetPurpose(judgePurpose)
+		question := fmt.Sprintf("Question:\n%s\n\nAnswer being graded:\n%s\n\nA good answer:\n%s", c.Question, answer, c.Expected)
+		v, err := goracle.AskIntoWithContext[verdict](ctx, o, question)
+		if err != nil {
+			return Score{}, fmt.Errorf("judging answer: %w", err)
+		}
+		return Score{
+			Value:  min(1, max(0, v.Score)),
+			Pass:   v.Pass,
+			Reason: v.Reason,
+		}, nil
+	})
+}
@@ -0,0 +1,380 @@
+package goracle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"iter"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// FolderOptions controls which files [ReadFolder] and [ReadFolderFS] read.
+// Zero fields take their defaults.
+type FolderOptions struct {
+	// Include lists glob patterns for the files to read. If it is empty,
+	// every file is read. Patterns follow .gitignore rules: one without a
+	// slash, such as "*.go", matches a file's name at any depth, while one
+	// with a slash, such as "cmd/*/main.go", matches its path from the root.
+	// "**" matches any number of directories.
+	Include []string
+	// Exclude lists glob patterns for files and directories to leave out,
+	// in the same form as Include.
+	Exclude []string
+	// IgnoreGitignore reads files even if a .gitignore says to skip them.
+	IgnoreGitignore bool
+	// MaxFileSize is the size in bytes above which files are skipped. The
+	// default is 1MiB, and a negative size means no limit.
+	MaxFileSize int64
+	// MaxTotalSize is the most bytes of files to read in all. The default is
+	// 8MiB, and a negative size means no limit.
+	MaxTotalSize int64
+}
+
+const (
+	defaultMaxFileSize  = 1 << 20
+	defaultMaxTotalSize = 8 << 20
+)
+
+// skippedDirs are never read, as they hold version control metadata or
+// third party code rather than anything worth asking about.
+var skippedDirs = []string{".git", ".hg", ".svn", "vendor", "node_modules"}
+
+// ErrReferenceTooLarge is returned when a reference would be larger than its
+// size limit.
+var ErrReferenceTooLarge = errors.New("reference too large")
+
+// A Reference helper that takes a folder in a filesystem and returns the contents
+// of all files in that folder as a single reference named after the folder,
+// with each file introduced by its path. Content will be a snapshot of the
+// files at the time of calling. Call is recursive, so be careful with what you
+// include. Consider adding one of more filters to the includeFilter such as
+// ".go" to only include files whose names contain them, or globs such as
+// "*_test.go". There is no limit on the total size, but as with [ReadFolder],
+// files over 1MiB, binary files and those a .gitignore says to skip are left
+// out. Errors are ignored, leaving out whatever couldn't be read; use
+// [ReadFolder] to see them.
+func Folder(root string, includeFilter ...string) Reference {
+	ref, _ := ReadFolder(root, legacyOptions(includeFilter))
+	return ref
+}
+
+// FolderFS is like [Folder], but reads the files of fsys, such as an
+// [embed.FS].
+func FolderFS(fsys fs.FS, includeFilter ...string) Reference {
+	ref, _ := ReadFolderFS(fsys, legacyOptions(includeFilter))
+	return ref
+}
+
+// legacyOptions are the options [Folder] and [FolderFS] read with. They have
+// never had a limit on the total size, so they still don't.
+func legacyOptions(includeFilter []string) FolderOptions {
+	return FolderOptions{
+		Include:      legacyFilters(includeFilter),
+		MaxTotalSize: -1,
+	}
+}
+
+// legacyFilters turns filters without any wildcards into globs matching any
+// name that contains them, as Folder has always done.
+func legacyFilters(filters []string) []string {
+	globs := make([]string, 0, len(filters))
+	for _, f := range filters {
+		if !strings.ContainsAny(f, "*?[") {
+			f = "*" + f + "*"
+		}
+		globs = append(globs, f)
+	}
+	return globs
+}
+
+// ReadFolder reads the files in the folder at root into a single reference
+// named after the folder, with each file introduced by its path. Version
+// control directories, vendor and node_modules are always skipped, as are
+// binary files and files a .gitignore says to skip. If the files are larger
+// than the total size limit, ReadFolder returns those that fit along with an
+// error wrapping [ErrReferenceTooLarge]. Files that can't be read are left
+// out, and their errors are joined into the one returned.
+func ReadFolder(root string, opts FolderOptions) (Reference, error) {
+	ref, err := ReadFolderFS(os.DirFS(root), opts)
+	ref.Name = root
+	ref.Source = root
+	if err != nil {
+		return ref, fmt.Errorf("reading folder %s: %w", root, err)
+	}
+	return ref, nil
+}
+
+// ReadFolderFS is like [ReadFolder], but reads the files of fsys, such as
+// an [embed.FS].
+func ReadFolderFS(fsys fs.FS, opts FolderOptions) (Reference, error) {
+	if opts.MaxFileSize == 0 {
+		opts.MaxFileSize = defaultMaxFileSize
+	}
+	if opts.MaxTotalSize == 0 {
+		opts.MaxTotalSize = defaultMaxTotalSize
+	}
+	include, err := compilePatterns(".", opts.Include)
+	if err != nil {
+		return Reference{}, err
+	}
+	exclude, err := compilePatterns(".", opts.Exclude)
+	if err != nil {
+		return Reference{}, err
+	}
+	ref := Reference{
+		Name:     ".",
+		MIMEType: "text/plain; charset=utf-8",
+		Data:     []byte{},
+	}
+	gitignores := map[string][]globPattern{}
+	var errs []error
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		if d.IsDir() {
+			if name != "." && (isSkippedDir(d.Name()) || matchAny(exclude, name, true)) {
+				return fs.SkipDir
+			}
+			if !opts.IgnoreGitignore && gitignored(gitignores, name, true) {
+				return fs.SkipDir
+			}
+			if !opts.IgnoreGitignore {
+				gitignores[name], err = readGitignore(fsys, name)
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || matchAny(exclude, name, false) {
+			return nil
+		}
+		if len(include) > 0 && !matchAny(include, name, false) {
+			return nil
+		}
+		if !opts.IgnoreGitignore && gitignored(gitignores, name, false) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		if opts.MaxFileSize > 0 && info.Size() > opts.MaxFileSize {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		if isBinary(data) {
+			return nil
+		}
+		entry := fileEntry(name, data)
+		if opts.MaxTotalSize > 0 && int64(len(ref.Data)+len(entry)) > opts.MaxTotalSize {
+			return fmt.Errorf("%w: more than %d bytes at %s", ErrReferenceTooLarge, opts.MaxTotalSize, name)
+		}
+		ref.Data = append(ref.Data, entry...)
+		return nil
+	})
+	return ref, errors.Join(append(errs, err)...)
+}
+
+// fileEntry introduces the contents of a file with its path.
+func fileEntry(name string, data []byte) []byte {
+	entry := fmt.Appendf(nil, "==> %s <==\n", name)
+	entry = append(entry, data...)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		entry = append(entry, '\n')
+	}
+	return entry
+}
+
+func isSkippedDir(name string) bool {
+	return slices.Contains(skippedDirs, name)
+}
+
+// isBinary reports whether data looks like something other than text, by
+// the same rule as git: it contains a NUL byte early on. Text that isn't
+// valid UTF-8 is treated as binary too.
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data[:min(len(data), 8000)], 0) >= 0 || !utf8.Valid(data)
+}
+
+// --- Globs
+
+// globPattern is a compiled glob, following the rules of .gitignore.
+type globPattern struct {
+	re *regexp.Regexp
+	// dir is the directory the pattern is relative to.
+	dir string
+	// anchored patterns match a path from dir, and the rest match a name.
+	anchored bool
+	dirOnly  bool
+	negate   bool
+}
+
+func compilePatterns(dir string, patterns []string) ([]globPattern, error) {
+	var compiled []globPattern
+	for _, p := range patterns {
+		g, ok, err := compilePattern(dir, p)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			compiled = append(compiled, g)
+		}
+	}
+	return compiled, nil
+}
+
+// compilePattern compiles a single glob, reporting false for blank lines
+// and comments.
+func compilePattern(dir string, pattern string) (globPattern, bool, error) {
+	pattern = strings.TrimRight(pattern, " \t\r")
+	if pattern == "" || strings.HasPrefix(pattern, "#") {
+		return globPattern{}, false, nil
+	}
+	g := globPattern{dir: dir}
+	if strings.HasPrefix(pattern, "!") {
+		g.negate = true
+		pattern = pattern[1:]
+	}
+	pattern = strings.TrimPrefix(pattern, `\`)
+	if strings.HasSuffix(pattern, "/") {
+		g.dirOnly = true
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	if strings.Contains(pattern, "/") {
+		g.anchored = true
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+	re, err := regexp.Compile("^" + globRegexp(pattern) + "$")
+	if err != nil {
+		return globPattern{}, false, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	g.re = re
+	return g, true, nil
+}
+
+// globRegexp translates a glob into a regular expression, where "*" and "?"
+// don't match a slash but "**" matches any number of directories.
+func globRegexp(glob string) string {
+	var re strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			re.WriteString("(?:/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return re.String()
+}
+
+// match reports whether the pattern matches name, a slash-separated path
+// from the root.
+func (g globPattern) match(name string, isDir bool) bool {
+	if g.dirOnly && !isDir {
+		return false
+	}
+	if !g.anchored {
+		return g.re.MatchString(path.Base(name))
+	}
+	if g.dir != "." {
+		rel, ok := strings.CutPrefix(name, g.dir+"/")
+		if !ok {
+			return false
+		}
+		name = rel
+	}
+	return g.re.MatchString(name)
+}
+
+func matchAny(patterns []globPattern, name string, isDir bool) bool {
+	for _, p := range patterns {
+		if p.match(name, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// --- .gitignore
+
+// readGitignore reads the patterns of the .gitignore in dir, if there is one.
+func readGitignore(fsys fs.FS, dir string) ([]globPattern, error) {
+	data, err := fs.ReadFile(fsys, path.Join(dir, ".gitignore"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return compilePatterns(dir, strings.Split(string(data), "\n"))
+}
+
+// gitignored reports whether the .gitignore files in the directories above
+// name say to skip it. As with git, the last matching pattern wins, and
+// patterns in deeper directories take precedence.
+func gitignored(gitignores map[string][]globPattern, name string, isDir bool) bool {
+	ignored := false
+	for dir := range ancestors(name) {
+		for _, p := range gitignores[dir] {
+			if p.match(name, isDir) {
+				ignored = !p.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// ancestors yields the directories above name, from the root down.
+func ancestors(name string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		if name == "." || !yield(".") {
+			return
+		}
+		for i := range len(name) {
+			if name[i] == '/' && !yield(name[:i]) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package goracle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mr-joshcrane/goracle/client"
+	"github.com/mr-joshcrane/goracle/client/llm"
+	"github.com/mr-joshcrane/goracle/internal/chunk"
+)
+
+// Exchange is a single input and output pair in the Oracle's history.
+// Examples are given explicitly to guide the model, while the rest are turns
+// recorded from the conversation itself.
+type Exchange struct {
+	Input   string
+	Output  string
+	Example bool
+}
+
+// HistoryPolicy decides how an Oracle's history is shortened once it no longer
+// fits in the model's context window. The Oracle applies its policy before
+// each request that would overflow the window, and again when a provider
+// rejects a request for being too long.
+type HistoryPolicy interface {
+	// Fit returns a history whose estimated size is at most budget tokens.
+	// The model may be used to condense older turns.
+	Fit(ctx context.Context, model LanguageModel, history []Exchange, budget int) ([]Exchange, error)
+}
+
+// HistoryPolicyFunc adapts an ordinary function to a [HistoryPolicy].
+type HistoryPolicyFunc func(ctx context.Context, model LanguageModel, history []Exchange, budget int) ([]Exchange, error)
+
+// Fit calls f(ctx, model, history, budget).
+func (f HistoryPolicyFunc) Fit(ctx context.Context, model LanguageModel, history []Exchange, budget int) ([]Exchange, error) {
+	return f(ctx, model, history, budget)
+}
+
+// SlidingWindow returns a policy that drops the oldest exchanges, examples
+// included, until the history fits.
+func SlidingWindow() HistoryPolicy {
+	return HistoryPolicyFunc(func(ctx context.Context, model LanguageModel, history []Exchange, budget int) ([]Exchange, error) {
+		used := historyTokens(history)
+		start := 0
+		for start < len(history) && used > budget {
+			used -= exchangeTokens(history[start])
+			start++
+		}
+		return slices.Clone(history[start:]), nil
+	})
+}
+
+// KeepExamples returns a policy that drops the oldest conversation turns until
+// the history fits, but always keeps the examples given with
+// [*Oracle.GiveExample]. This is the default policy.
+func KeepExamples() HistoryPolicy {
+	return HistoryPolicyFunc(func(ctx context.Context, model LanguageModel, history []Exchange, budget int) ([]Exchange, error) {
+		return dropOldestTurns(history, budget), nil
+	})
+}
+
+// Summarize returns a policy that asks the model to summarise the older
+// conversation turns, replacing them with a single summary turn. Examples and
+// as many recent turns as fit in half the budget are kept as they are.
+func Summarize() HistoryPolicy {
+	return HistoryPolicyFunc(func(ctx context.Context, model LanguageModel, history []Exchange, budget int) ([]Exchange, error) {
+		var examples, turns []Exchange
+		for _, e := range history {
+			if e.Example {
+				examples = append(examples, e)
+			} else {
+				turns = append(turns, e)
+			}
+		}
+		remaining := (budget - historyTokens(examples)) / 2
+		keep := len(turns)
+		for keep > 0 {
+			tokens := exchangeTokens(turns[keep-1])
+			if tokens > remaining {
+				break
+			}
+			remaining -= tokens
+			keep--
+		}
+		if keep == 0 {
+			return dropOldestTurns(history, budget), nil
+		}
+		summary, err := summarise(ctx, model, turns[:keep])
+		if err != nil {
+			return nil, fmt.Errorf("summarising history: %w", err)
+		}
+		fitted := slices.Concat(examples, []Exchange{{
+			Input:  summaryQuestion,
+			Output: summary,
+		}}, turns[keep:])
+		return dropOldestTurns(fitted, budget), nil
+	})
+}
+
+// summaryQuestion stands in for the question a summary turn answers.
+const summaryQuestion = "Summarise our conversation so far."
+
+// summarise asks the model for a summary of the given conversation turns.
+func summarise(ctx context.Context, model LanguageModel, turns []Exchange) (string, error) {
+	transcript := new(strings.Builder)
+	for _, t := range turns {
+		fmt.Fprintf(transcript, "User: %s\nAssistant: %s\n\n", t.Input, t.Output)
+	}
+	data, err := model.Completion(ctx, Prompt{
+		Purpose:  "You summarise conversations between a user and an assistant. Keep every fact, decision and open question needed to continue the conversation, and nothing else.",
+		Question: transcript.String(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return readChunks(data, func(string) bool { return true })
+}
+
+// dropOldestTurns removes conversation turns, oldest first, until the history
+// fits in budget tokens or only examples remain.
+func dropOldestTurns(history []Exchange, budget int) []Exchange {
+	used := historyTokens(history)
+	fitted := make([]Exchange, 0, len(history))
+	for _, e := range history {
+		if !e.Example && used > budget {
+			used -= exchangeTokens(e)
+			continue
+		}
+		fitted = append(fitted, e)
+	}
+	return fitted
+}
+
+// SetHistoryPolicy sets how the Oracle shortens its history once it outgrows
+// the model's context window. A nil policy turns automatic management off.
+func (o *Oracle) SetHistoryPolicy(policy HistoryPolicy) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.historyPolicy = policy
+}
+
+// SetContextWindow overrides the number of tokens the model accepts in a
+// single request. By default this is taken from the client's model
+// configuration, where it is known.
+func (o *Oracle) SetContextWindow(tokens int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.contextWindow = tokens
+}
+
+// window returns the context window in tokens, or 0 if it isn't known. The
+// caller must hold the lock.
+func (o *Oracle) window() int {
+	if o.contextWindow > 0 {
+		return o.contextWindow
+	}
+	if c, ok := o.client.(interface{ ContextWindow() int }); ok {
+		return c.ContextWindow()
+	}
+	return 0
+}
+
+// fitHistory applies the history policy when p won't fit in the context
+// window, updating both the Oracle's history and p. With shrink set, the
+// history is cut to at most half its size regardless, as the provider has
+// already rejected it. It reports whether the history got any shorter.
+//
+// The policy runs on a snapshot of the history without the lock held, so the
+// Oracle stays usable while a summary is written, and any completions it
+// makes count towards the budget. Only one policy runs at a time: concurrent
+// questions wait for it and use the history it leaves, rather than each
+// shortening the history again.
+func (o *Oracle) fitHistory(ctx context.Context, p *Prompt, shrink bool) (bool, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	started := historyTokens(o.history)
+	waited := false
+	for o.fitting != nil {
+		fitting := o.fitting
+		o.mu.Unlock()
+		select {
+		case <-fitting:
+		case <-ctx.Done():
+			o.mu.Lock()
+			return false, ctx.Err()
+		}
+		o.mu.Lock()
+		waited = true
+	}
+	if waited {
+		p.InputHistory, p.OutputHistory = o.historyPrompt()
+	}
+	if o.historyPolicy == nil || len(o.history) == 0 {
+		return false, nil
+	}
+	window := o.window()
+	used := historyTokens(o.history)
+	if shrink && used < started {
+		return true, nil
+	}
+	budget := window - promptTokens(*p) - window/responseShare
+	switch {
+	case shrink && window > 0:
+		budget = min(budget, used/2)
+	case shrink:
+		budget = used / 2
+	case window == 0 || used <= budget:
+		return false, nil
+	}
+	snapshot := slices.Clone(o.history)
+	policy, model := o.historyPolicy, o.budgeted(o.languageModel())
+	done := make(chan struct{})
+	o.fitting = done
+	o.mu.Unlock()
+	fitted, err := policy.Fit(ctx, model, snapshot, max(budget, 0))
+	o.mu.Lock()
+	o.fitting = nil
+	close(done)
+	if err != nil {
+		return false, err
+	}
+	// Turns remembered while the policy ran are kept after the fitted
+	// history. If the history was replaced altogether, the result is stale.
+	if len(o.history) < len(snapshot) || !slices.Equal(o.history[:len(snapshot)], snapshot) {
+		p.InputHistory, p.OutputHistory = o.historyPrompt()
+		return historyTokens(o.history) < used, nil
+	}
+	tail := o.history[len(snapshot):]
+	o.history = slices.Concat(fitted, tail)
+	p.InputHistory, p.OutputHistory = o.historyPrompt()
+	return historyTokens(o.history) < used+historyTokens(tail), nil
+}
+
+// budgeted wraps model so that its completions are refused once they would
+// take the Oracle past its budget, and their usage is recorded once read.
+func (o *Oracle) budgeted(model LanguageModel) LanguageModel {
+	return LanguageModelFunc(func(ctx context.Context, prompt client.Prompt) (io.Reader, error) {
+		err := o.checkBudget(promptFrom(prompt))
+		if err != nil {
+			return nil, err
+		}
+		data, err := model.Completion(ctx, prompt)
+		if err != nil {
+			return nil, err
+		}
+		return llm.Observe(data, func(int, error) {
+			o.recordUsage(data)
+		}), nil
+	})
+}
+
+// retryWithShorterHistory asks again with a shortened history after the
+// provider rejected p for exceeding the context window. If the history can't
+// be shortened, the original error is returned.
+func (o *Oracle) retryWithShorterHistory(ctx context.Context, p *Prompt, cause error) (io.Reader, error) {
+	shorter, err := o.fitHistory(ctx, p, true)
+	if err != nil {
+		return nil, errors.Join(cause, err)
+	}
+	if !shorter {
+		return nil, cause
+	}
+	return o.completion(ctx, *p)
+}
+
+// responseShare reserves a fraction of the context window for the answer.
+const responseShare = 8
+
+// binaryReferenceTokens is a rough allowance for references, such as images,
+// that aren't text.
+const binaryReferenceTokens = 1000
+
+// EstimateTokens gives a rough token count for text, at about four characters
+// per token. It errs on the side of caution for most English text.
+func EstimateTokens(text string) int {
+	return chunk.Tokens(text)
+}
+
+func exchangeTokens(e Exchange) int {
+	return EstimateTokens(e.Input) + EstimateTokens(e.Output)
+}
+
+func historyTokens(history []Exchange) int {
+	total := 0
+	for _, e := range history {
+		total += exchangeTokens(e)
+	}
+	return total
+}
+
+// promptTokens estimates everything in p except its history.
+func promptTokens(p Prompt) int {
+	total := EstimateTokens(p.Purpose) + EstimateTokens(p.Question)
+	for _, ref := range p.References {
+		if utf8.Valid(ref.Data) {
+			total += EstimateTokens(ref.Label(0)) + EstimateTokens(string(ref.Data))
+		} else {
+			total += binaryReferenceTokens
+		}
+	}
+	for _, f := range p.ResponseFormat {
+		total += EstimateTokens(f)
+	}
+	if len(p.Tools) > 0 {
+		data, _ := json.Marshal(p.Tools)
+		total += EstimateTokens(string(data))
+	}
+	return total
+}
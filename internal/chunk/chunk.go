@@ -0,0 +1,279 @@
+// Package chunk splits text into pieces small enough to give a model, by
+// paragraph and keeping code blocks whole, remembering where each came from.
+// It is shared by Oracle.AskLarge and the rag package.
+package chunk
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+	"github.com/mr-joshcrane/goracle/client/pdf"
+)
+
+// Chunk is a piece of a larger text, along with where it came from.
+type Chunk struct {
+	// Source is where the text came from, such as a path or URL.
+	Source string `json:"source"`
+	// StartLine and EndLine are the first and last lines of the chunk
+	// within its source, counting from 1.
+	StartLine int    `json:"start_line"`
+	EndLine   int    `json:"end_line"`
+	Text      string `json:"text"`
+}
+
+// Name identifies the chunk to the model by its source and lines, such as
+// "main.go:10-24".
+func (c Chunk) Name() string {
+	if c.StartLine == 0 {
+		return c.Source
+	}
+	if c.StartLine == c.EndLine {
+		return fmt.Sprintf("%s:%d", c.Source, c.StartLine)
+	}
+	return fmt.Sprintf("%s:%d-%d", c.Source, c.StartLine, c.EndLine)
+}
+
+// Reference gives the chunk to the model, named after where it came from.
+func (c Chunk) Reference() llm.Reference {
+	return llm.Reference{
+		Name:     c.Name(),
+		Source:   c.Source,
+		MIMEType: "text/plain; charset=utf-8",
+		Data:     []byte(c.Text),
+	}
+}
+
+// Options controls how text is split. Zero fields take their defaults.
+type Options struct {
+	// MaxTokens is the largest chunk, as estimated by [Tokens]. The default
+	// is 400.
+	MaxTokens int
+	// Overlap is how many tokens from the end of each chunk may be repeated
+	// at the start of the next, in whole paragraphs, so that text near a
+	// boundary keeps some of its context. The default is none.
+	Overlap int
+}
+
+const defaultMaxTokens = 400
+
+// Tokens gives a rough token count for text, at about four characters per
+// token.
+func Tokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// block is a paragraph or fenced code block, which chunks are built from.
+type block struct {
+	text       string
+	start, end int
+}
+
+// Split divides text into chunks of up to the maximum size, breaking it
+// between paragraphs where possible. Fenced code blocks are kept whole
+// unless they are too large for a chunk on their own, in which case they,
+// like overlong paragraphs, are broken between lines.
+func Split(source, text string, opts Options) []Chunk {
+	if opts.MaxTokens <= 0 {
+		opts.MaxTokens = defaultMaxTokens
+	}
+	var chunks []Chunk
+	var current []block
+	tokens := 0
+	// fresh is set once current holds more than overlap from the last chunk.
+	fresh := false
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		texts := make([]string, len(current))
+		for i, b := range current {
+			texts[i] = b.text
+		}
+		chunks = append(chunks, Chunk{
+			Source:    source,
+			StartLine: current[0].start,
+			EndLine:   current[len(current)-1].end,
+			Text:      strings.Join(texts, "\n\n"),
+		})
+		// Carry the last few paragraphs over into the next chunk.
+		kept := 0
+		carried := 0
+		for i := len(current) - 1; i > 0; i-- {
+			t := Tokens(current[i].text)
+			if carried+t > opts.Overlap {
+				break
+			}
+			carried += t
+			kept++
+		}
+		current = current[len(current)-kept:]
+		tokens = carried
+		fresh = false
+	}
+	for _, b := range blocks(text) {
+		for _, piece := range b.split(opts.MaxTokens) {
+			t := Tokens(piece.text)
+			if len(current) > 0 && tokens+t > opts.MaxTokens {
+				flush()
+				// Drop overlap that would leave no room for the next piece.
+				for len(current) > 0 && tokens+t > opts.MaxTokens {
+					tokens -= Tokens(current[0].text)
+					current = current[1:]
+				}
+			}
+			current = append(current, piece)
+			tokens += t
+			fresh = true
+		}
+	}
+	if fresh {
+		flush()
+	}
+	return chunks
+}
+
+// fence opens or closes a fenced code block.
+var fence = regexp.MustCompile("^\\s*(```|~~~)")
+
+// blocks breaks text into paragraphs and fenced code blocks, dropping the
+// blank lines between them.
+func blocks(text string) []block {
+	var result []block
+	var lines []string
+	start := 0
+	inCode := ""
+	end := func(n int) {
+		if len(lines) > 0 {
+			result = append(result, block{text: strings.Join(lines, "\n"), start: start, end: n})
+		}
+		lines = nil
+	}
+	for i, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		n := i + 1
+		line = strings.TrimRight(line, "\r")
+		if m := fence.FindStringSubmatch(line); m != nil {
+			switch {
+			case inCode == "":
+				end(n - 1)
+				inCode = m[1]
+				start = n
+				lines = append(lines, line)
+				continue
+			case inCode == m[1]:
+				lines = append(lines, line)
+				inCode = ""
+				end(n)
+				continue
+			}
+		}
+		if inCode != "" {
+			lines = append(lines, line)
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			end(n - 1)
+			continue
+		}
+		if len(lines) == 0 {
+			start = n
+		}
+		lines = append(lines, line)
+	}
+	end(strings.Count(strings.TrimRight(text, "\n"), "\n") + 1)
+	return result
+}
+
+// split breaks a block too large for a chunk between its lines, and any line
+// that is too large on its own into pieces of maxTokens.
+func (b block) split(maxTokens int) []block {
+	if Tokens(b.text) <= maxTokens {
+		return []block{b}
+	}
+	var pieces []block
+	var lines []string
+	start, last := b.start, b.start
+	tokens := 0
+	for i, line := range strings.Split(b.text, "\n") {
+		n := b.start + i
+		for _, part := range splitLine(line, maxTokens) {
+			t := Tokens(part) + 1
+			if len(lines) > 0 && tokens+t > maxTokens {
+				pieces = append(pieces, block{text: strings.Join(lines, "\n"), start: start, end: last})
+				lines, tokens = nil, 0
+			}
+			if len(lines) == 0 {
+				start = n
+			}
+			lines = append(lines, part)
+			tokens += t
+			last = n
+		}
+	}
+	if len(lines) > 0 {
+		pieces = append(pieces, block{text: strings.Join(lines, "\n"), start: start, end: last})
+	}
+	return pieces
+}
+
+// splitLine breaks a line into pieces of at most maxTokens, between words
+// where it can.
+func splitLine(line string, maxTokens int) []string {
+	maxRunes := maxTokens * 4
+	var parts []string
+	for utf8.RuneCountInString(line) > maxRunes {
+		cut := len(string([]rune(line)[:maxRunes]))
+		if space := strings.LastIndexByte(line[:cut], ' '); space > 0 {
+			cut = space + 1
+		}
+		parts = append(parts, strings.TrimRight(line[:cut], " "))
+		line = line[cut:]
+	}
+	return append(parts, line)
+}
+
+// fileHeader introduces each file of a folder reference.
+var fileHeader = regexp.MustCompile(`(?m)^==> (.+) <==\n`)
+
+// SplitReference divides a reference into chunks. The files of a folder
+// reference are split separately, each with its own path as their
+// source, and PDF documents are split by their text. Other references that
+// aren't text, such as images, give an error.
+func SplitReference(ref llm.Reference, opts Options) ([]Chunk, error) {
+	source := ref.Source
+	if source == "" {
+		source = ref.Name
+	}
+	data := ref.Data
+	if pdf.IsPDF(ref) {
+		text, err := pdf.Text(ref.Data)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", source, err)
+		}
+		data = []byte(text)
+	}
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("can't split %s: not text", source)
+	}
+	text := string(data)
+	headers := fileHeader.FindAllStringSubmatchIndex(text, -1)
+	if len(headers) == 0 || headers[0][0] != 0 {
+		return Split(source, text, opts), nil
+	}
+	var chunks []Chunk
+	for i, h := range headers {
+		end := len(text)
+		if i+1 < len(headers) {
+			end = headers[i+1][0]
+		}
+		name := text[h[2]:h[3]]
+		if source != "" && source != "." {
+			name = path.Join(source, name)
+		}
+		chunks = append(chunks, Split(name, text[h[1]:end], opts)...)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,433 @@
+package goracle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+	"github.com/mr-joshcrane/goracle/client/pdf"
+	"github.com/mr-joshcrane/goracle/internal/chunk"
+)
+
+// Strategy is how [*Oracle.AskLarge] works through material too large to
+// send in one request.
+type Strategy int
+
+const (
+	// MapReduce asks about each part of the material separately, several at
+	// once, and then combines the partial answers. It is the default.
+	MapReduce Strategy = iota
+	// Refine works through the parts in order, improving a single answer
+	// with each. It is slower, but each step sees the answer so far.
+	Refine
+)
+
+// LargeOptions controls [*Oracle.AskLarge], and is given among its
+// references. Zero fields take their defaults.
+type LargeOptions struct {
+	Strategy Strategy
+	// Workers is how many parts MapReduce asks about at once. The default is
+	// 4.
+	Workers int
+	// PartTokens is the most tokens of material sent in each request. The
+	// default is whatever the context window leaves after the question, or
+	// 8000 if the window isn't known.
+	PartTokens int
+	// Progress, if set, is called each time a request finishes, whether it
+	// succeeded or not. Calls are never made at the same time.
+	Progress func(LargeProgress)
+}
+
+// LargeProgress reports a request [*Oracle.AskLarge] has finished.
+type LargeProgress struct {
+	// Stage is "map" or "reduce" for MapReduce, and "refine" for Refine.
+	Stage string
+	// Done counts the requests of this stage finished so far, out of Total.
+	Done  int
+	Total int
+	// Part names the material asked about, such as "main.go:1-80".
+	Part string
+	Err  error
+}
+
+// PartError is a part of the material that couldn't be asked about.
+type PartError struct {
+	Part string
+	Err  error
+}
+
+func (e PartError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Part, e.Err)
+}
+
+func (e PartError) Unwrap() error {
+	return e.Err
+}
+
+// PartialError is returned by [*Oracle.AskLarge] when some parts of the
+// material couldn't be asked about. If any could, it comes with an answer
+// based on those.
+type PartialError struct {
+	Failed []PartError
+	Total  int
+}
+
+func (e PartialError) Error() string {
+	return fmt.Sprintf("%d of %d parts failed, first %v", len(e.Failed), e.Total, e.Failed[0])
+}
+
+func (e PartialError) Unwrap() []error {
+	errs := make([]error, len(e.Failed))
+	for i, f := range e.Failed {
+		errs[i] = f
+	}
+	return errs
+}
+
+const (
+	defaultWorkers    = 4
+	defaultPartTokens = 8000
+	// instructionTokens allows for the instructions given with each part.
+	instructionTokens = 200
+	// nothingRelevant is how the model says a part has nothing to add.
+	nothingRelevant = "NOTHING RELEVANT"
+	// maxReduceRounds limits how many times notes are combined before the
+	// final answer is asked for regardless.
+	maxReduceRounds = 4
+)
+
+// AskLarge is similar to [*Oracle.Ask], but handles references too large to
+// send in one request. If they fit, it is the same as Ask. Otherwise text
+// references, including folders and PDFs, are split into parts that fit the
+// context window, and the question is asked about each in turn, as set by a
+// [LargeOptions] among the references. References that can't be split, such
+// as images, are given with the combined answer for MapReduce, and with
+// every step for Refine.
+//
+// A part that fails doesn't stop the rest. The answer is based on the parts
+// that succeeded, and comes with a [PartialError] listing those that didn't.
+// Only running out of budget, or ctx ending, stops the whole job.
+func (o *Oracle) AskLarge(question string, references ...any) (string, error) {
+	return o.AskLargeWithContext(context.Background(), question, references...)
+}
+
+// AskLargeWithContext is similar to [*Oracle.AskLarge] but allows for a
+// context to be passed in.
+func (o *Oracle) AskLargeWithContext(ctx context.Context, question string, references ...any) (string, error) {
+	var opts LargeOptions
+	var rest []any
+	for _, r := range references {
+		if lo, ok := r.(LargeOptions); ok {
+			opts = lo
+			continue
+		}
+		rest = append(rest, r)
+	}
+	p, err := o.prompt(ctx, question, rest...)
+	if err != nil {
+		return "", err
+	}
+	o.mu.Lock()
+	window := o.window()
+	o.mu.Unlock()
+	if opts.Workers <= 0 {
+		opts.Workers = defaultWorkers
+	}
+	if opts.PartTokens <= 0 {
+		opts.PartTokens = defaultPartTokens
+		if window > 0 {
+			opts.PartTokens = max(window-window/responseShare-EstimateTokens(p.Purpose)-EstimateTokens(question)-instructionTokens, instructionTokens)
+		}
+	}
+	parts, others, err := splitReferences(p.References, opts.PartTokens)
+	if err != nil {
+		return "", err
+	}
+	if len(parts) <= 1 {
+		answer, err := o.generate(ctx, p, func(string) bool { return true })
+		if err != nil {
+			return "", err
+		}
+		o.remember(question, answer)
+		return answer, nil
+	}
+	j := &largeJob{
+		oracle:   o,
+		question: question,
+		options:  p.Options,
+		others:   others,
+		opts:     opts,
+	}
+	var answer string
+	if opts.Strategy == Refine {
+		answer, err = j.refine(ctx, parts)
+	} else {
+		answer, err = j.mapReduce(ctx, parts)
+	}
+	if err != nil {
+		return "", err
+	}
+	o.remember(question, answer)
+	if len(j.failed) > 0 {
+		return answer, PartialError{Failed: j.failed, Total: len(parts)}
+	}
+	return answer, nil
+}
+
+// part is a group of references sent together in one request.
+type part struct {
+	name string
+	refs []Reference
+}
+
+// splitReferences breaks text references into chunks and packs them into
+// parts of up to maxTokens, in order. References that aren't text are
+// returned separately.
+func splitReferences(refs []Reference, maxTokens int) ([]part, []Reference, error) {
+	var parts []part
+	var others []Reference
+	var current []Reference
+	tokens := 0
+	flush := func() {
+		if len(current) > 0 {
+			parts = append(parts, part{name: partName(current), refs: current})
+		}
+		current, tokens = nil, 0
+	}
+	for n, ref := range refs {
+		if !pdf.IsPDF(ref) && !utf8.Valid(ref.Data) {
+			others = append(others, ref)
+			continue
+		}
+		if ref.Name == "" && ref.Source == "" {
+			ref.Name = fmt.Sprintf("Reference %d", n+1)
+		}
+		// Leave room for the label each reference is given.
+		chunks, err := chunk.SplitReference(ref, chunk.Options{MaxTokens: max(maxTokens-EstimateTokens(ref.Label(0)), 1)})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, c := range chunks {
+			r := c.Reference()
+			t := EstimateTokens(r.Label(0)) + EstimateTokens(c.Text)
+			if len(current) > 0 && tokens+t > maxTokens {
+				flush()
+			}
+			current = append(current, r)
+			tokens += t
+		}
+	}
+	flush()
+	return parts, others, nil
+}
+
+// partName names the references of a part, for reporting progress.
+func partName(refs []Reference) string {
+	names := make([]string, 0, 3)
+	for i, r := range refs {
+		if i == 2 && len(refs) > 3 {
+			names = append(names, fmt.Sprintf("%d more", len(refs)-2))
+			break
+		}
+		names = append(names, r.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// largeJob is the state of a single [*Oracle.AskLarge] call.
+type largeJob struct {
+	oracle   *Oracle
+	question string
+	options  llm.Options
+	others   []Reference
+	opts     LargeOptions
+
+	mu     sync.Mutex
+	failed []PartError
+}
+
+// ask puts a question about refs to the model, apart from the conversation,
+// and reports progress when it's done.
+func (j *largeJob) ask(ctx context.Context, question string, refs []Reference) (string, error) {
+	o := j.oracle
+	o.mu.Lock()
+	p := Prompt{
+		Purpose:    o.purpose,
+		Question:   question,
+		References: refs,
+		Options:    j.options,
+	}
+	o.mu.Unlock()
+	return o.generate(ctx, p, func(string) bool { return true })
+}
+
+// report records how a request went, and passes it on to the progress
+// callback.
+func (j *largeJob) report(progress LargeProgress) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if progress.Err != nil && progress.Stage != "reduce" {
+		j.failed = append(j.failed, PartError{Part: progress.Part, Err: progress.Err})
+	}
+	if j.opts.Progress != nil {
+		j.opts.Progress(progress)
+	}
+}
+
+// fatal reports whether err should stop the whole job, rather than only the
+// part it came from.
+func fatal(ctx context.Context, err error) bool {
+	var budget BudgetExceededError
+	return ctx.Err() != nil || errors.As(err, &budget)
+}
+
+// mapReduce asks about every part, up to the worker limit at once, and then
+// combines the notes taken from each into a single answer.
+func (j *largeJob) mapReduce(ctx context.Context, parts []part) (string, error) {
+	notes := make([]string, len(parts))
+	errs := make([]error, len(parts))
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	done := 0
+	for range min(j.opts.Workers, len(parts)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				question := fmt.Sprintf("The references are part %d of %d of the material for a question. "+
+					"Using only them, note everything that helps answer the question, saying which reference each point comes from. "+
+					"If nothing in them is relevant, reply only with %q.\n\nQuestion: %s",
+					i+1, len(parts), nothingRelevant, j.question)
+				notes[i], errs[i] = j.ask(ctx, question, parts[i].refs)
+				if errs[i] != nil && fatal(ctx, errs[i]) {
+					cancel(errs[i])
+				}
+				j.mu.Lock()
+				done++
+				progress := LargeProgress{Stage: "map", Done: done, Total: len(parts), Part: parts[i].name, Err: errs[i]}
+				j.mu.Unlock()
+				j.report(progress)
+			}
+		}()
+	}
+	for i := range parts {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if err := context.Cause(ctx); err != nil {
+		return "", err
+	}
+	var found []Reference
+	for i, note := range notes {
+		if errs[i] != nil || strings.TrimSpace(note) == nothingRelevant {
+			continue
+		}
+		found = append(found, Reference{
+			Name:     fmt.Sprintf("notes on part %d (%s)", i+1, parts[i].name),
+			MIMEType: "text/plain; charset=utf-8",
+			Data:     []byte(note),
+		})
+	}
+	if len(j.failed) == len(parts) {
+		return "", PartialError{Failed: j.failed, Total: len(parts)}
+	}
+	found, err := j.reduce(ctx, found)
+	if err != nil {
+		return "", err
+	}
+	question := "The references are notes taken from different parts of the material for this question. " +
+		"Combine them into one complete answer, keeping track of where each point came from. " +
+		"If there are no notes, none of the material was relevant.\n\nQuestion: " + j.question
+	refs := make([]any, 0, len(found)+len(j.others)+1)
+	for _, r := range append(found, j.others...) {
+		refs = append(refs, r)
+	}
+	refs = append(refs, j.options)
+	p, err := j.oracle.prompt(ctx, question, refs...)
+	if err != nil {
+		return "", err
+	}
+	return j.oracle.generate(ctx, p, func(string) bool { return true })
+}
+
+// reduce combines notes in groups until they fit in a single request, or
+// for at most maxReduceRounds rounds.
+func (j *largeJob) reduce(ctx context.Context, notes []Reference) ([]Reference, error) {
+	for range maxReduceRounds {
+		groups, _, err := splitReferences(notes, j.opts.PartTokens)
+		if err != nil {
+			return nil, err
+		}
+		if len(groups) <= 1 {
+			return notes, nil
+		}
+		var combined []Reference
+		done := 0
+		for i, g := range groups {
+			if len(g.refs) == 1 {
+				if i+1 < len(groups) {
+					// Combine at least two sets of notes, so each round shrinks them.
+					groups[i+1].refs = append(g.refs, groups[i+1].refs...)
+				} else {
+					combined = append(combined, g.refs[0])
+				}
+				continue
+			}
+			question := "The references are notes taken from different parts of the material for a question. " +
+				"Combine them into a single set of notes, keeping every point that helps answer it and where it came from." +
+				"\n\nQuestion: " + j.question
+			note, err := j.ask(ctx, question, g.refs)
+			done++
+			j.report(LargeProgress{Stage: "reduce", Done: done, Total: len(groups), Part: partName(g.refs), Err: err})
+			if err != nil {
+				return nil, err
+			}
+			combined = append(combined, Reference{
+				Name:     fmt.Sprintf("combined notes %d", len(combined)+1),
+				MIMEType: "text/plain; charset=utf-8",
+				Data:     []byte(note),
+			})
+		}
+		notes = combined
+	}
+	return notes, nil
+}
+
+// refine works through the parts in order, asking the model to improve its
+// answer with each.
+func (j *largeJob) refine(ctx context.Context, parts []part) (string, error) {
+	answer := ""
+	for i, p := range parts {
+		question := fmt.Sprintf("The references are part %d of %d of the material for this question. "+
+			"Answer it as well as they allow, saying which reference each point comes from; later parts will follow.\n\nQuestion: %s",
+			i+1, len(parts), j.question)
+		if answer != "" {
+			question = fmt.Sprintf("Here is an answer to a question, based on earlier parts of the material:\n\n%s\n\n"+
+				"The references are part %d of %d. Improve the answer with anything relevant in them, keeping what is still right, "+
+				"and reply with the whole improved answer.\n\nQuestion: %s",
+				answer, i+1, len(parts), j.question)
+		}
+		refined, err := j.ask(ctx, question, append(p.refs, j.others...))
+		if err != nil && fatal(ctx, err) {
+			return "", err
+		}
+		j.report(LargeProgress{Stage: "refine", Done: i + 1, Total: len(parts), Part: p.name, Err: err})
+		if err == nil {
+			answer = refined
+		}
+	}
+	if len(j.failed) == len(parts) {
+		return "", PartialError{Failed: j.failed, Total: len(parts)}
+	}
+	return answer, nil
+}
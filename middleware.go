@@ -0,0 +1,323 @@
+package goracle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mr-joshcrane/goracle/client"
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+// Middleware wraps a LanguageModel with cross-cutting behaviour, such as
+// logging, retries or caching, without the need to change each provider.
+type Middleware func(LanguageModel) LanguageModel
+
+// LanguageModelFunc adapts an ordinary function to a [LanguageModel].
+type LanguageModelFunc func(ctx context.Context, prompt client.Prompt) (io.Reader, error)
+
+// Completion calls f(ctx, prompt).
+func (f LanguageModelFunc) Completion(ctx context.Context, prompt client.Prompt) (io.Reader, error) {
+	return f(ctx, prompt)
+}
+
+// Chain wraps model in the given middleware. The first middleware is the
+// outermost, so it sees each request first and each completion last. The
+// model's name, if it reports one, stays visible through the chain.
+func Chain(model LanguageModel, middleware ...Middleware) LanguageModel {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		wrapped := middleware[i](model)
+		if n, ok := model.(modelNamer); ok {
+			if _, ok := wrapped.(modelNamer); !ok {
+				wrapped = namedModel{LanguageModel: wrapped, namer: n}
+			}
+		}
+		model = wrapped
+	}
+	return model
+}
+
+// modelNamer is implemented by clients that can report which model they use.
+type modelNamer interface {
+	ModelName() string
+}
+
+// modelName returns the name of the model behind m, or "" if it's unknown.
+func modelName(m LanguageModel) string {
+	if n, ok := m.(modelNamer); ok {
+		return n.ModelName()
+	}
+	return ""
+}
+
+// namedModel keeps a wrapped client's model name visible to the middleware
+// further out in a chain.
+type namedModel struct {
+	LanguageModel
+	namer modelNamer
+}
+
+func (m namedModel) ModelName() string {
+	return m.namer.ModelName()
+}
+
+// Use wraps the Oracle's client in the given middleware, in addition to any
+// middleware already in use. The first middleware given is the outermost.
+// The client itself is kept, so [*Oracle.WithModel] still works.
+func (o *Oracle) Use(middleware ...Middleware) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.middleware = append(o.middleware, middleware...)
+	o.model = Chain(o.client, o.middleware...)
+}
+
+// languageModel returns the client wrapped in any middleware in use. The
+// caller must hold the lock.
+func (o *Oracle) languageModel() LanguageModel {
+	if o.model != nil {
+		return o.model
+	}
+	return o.client
+}
+
+// Logging returns middleware that logs each completion to logger once it has
+// been read to the end, along with its size, duration and any error, and the
+// template the prompt came from, if any. The prompt's contents are not
+// logged, as they may be sensitive.
+func Logging(logger *slog.Logger) Middleware {
+	return func(next LanguageModel) LanguageModel {
+		return LanguageModelFunc(func(ctx context.Context, prompt client.Prompt) (io.Reader, error) {
+			start := time.Now()
+			inputs, _ := prompt.GetHistory()
+			attrs := []slog.Attr{
+				slog.Int("history", len(inputs)),
+				slog.Int("references", len(prompt.GetReferences())),
+				slog.Int("tools", len(prompt.GetTools())),
+				slog.Int("tool_turns", len(prompt.GetToolTurns())),
+			}
+			if p, ok := prompt.(Prompt); ok && p.Template != "" {
+				attrs = append(attrs, slog.String("template", p.Template), slog.String("template_version", p.TemplateVersion))
+			}
+			data, err := next.Completion(ctx, prompt)
+			if err != nil {
+				logger.LogAttrs(ctx, slog.LevelError, "completion failed",
+					append(attrs, slog.Duration("duration", time.Since(start)), slog.String("error", err.Error()))...)
+				return nil, err
+			}
+			return llm.Observe(data, func(n int, err error) {
+				attrs = append(attrs, slog.Duration("duration", time.Since(start)), slog.Int("bytes", n))
+				if err != nil {
+					logger.LogAttrs(ctx, slog.LevelError, "completion failed", append(attrs, slog.String("error", err.Error()))...)
+					return
+				}
+				logger.LogAttrs(ctx, slog.LevelInfo, "completion", attrs...)
+			}), nil
+		})
+	}
+}
+
+// Metrics collects request counts and latencies from the [CollectMetrics]
+// middleware. It is safe for concurrent use.
+type Metrics struct {
+	mu       sync.Mutex
+	requests int
+	failures int
+	latency  time.Duration
+}
+
+// MetricsSnapshot is a point in time copy of [Metrics].
+type MetricsSnapshot struct {
+	Requests       int
+	Failures       int
+	TotalLatency   time.Duration
+	AverageLatency time.Duration
+}
+
+// Snapshot returns the metrics collected so far.
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := MetricsSnapshot{
+		Requests:     m.requests,
+		Failures:     m.failures,
+		TotalLatency: m.latency,
+	}
+	if m.requests > 0 {
+		s.AverageLatency = m.latency / time.Duration(m.requests)
+	}
+	return s
+}
+
+func (m *Metrics) record(latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests++
+	m.latency += latency
+	if err != nil {
+		m.failures++
+	}
+}
+
+// CollectMetrics returns middleware that records every completion in m. The
+// latency of a completion runs until it has been read to the end.
+func CollectMetrics(m *Metrics) Middleware {
+	return func(next LanguageModel) LanguageModel {
+		return LanguageModelFunc(func(ctx context.Context, prompt client.Prompt) (io.Reader, error) {
+			start := time.Now()
+			data, err := next.Completion(ctx, prompt)
+			if err != nil {
+				m.record(time.Since(start), err)
+				return nil, err
+			}
+			return llm.Observe(data, func(_ int, err error) {
+				m.record(time.Since(start), err)
+			}), nil
+		})
+	}
+}
+
+// Redacted replaces any text removed by the [Redact] middleware.
+const Redacted = "[REDACTED]"
+
+// Redact returns middleware that replaces every match of the given patterns
+// with [Redacted] before the prompt leaves the program. The purpose, question,
+// history, text references and tool turns are all redacted. Tool call
+// arguments are redacted within their JSON string values, so they stay valid
+// JSON.
+func Redact(patterns ...*regexp.Regexp) Middleware {
+	redact := func(s string) string {
+		for _, p := range patterns {
+			s = p.ReplaceAllString(s, Redacted)
+		}
+		return s
+	}
+	return func(next LanguageModel) LanguageModel {
+		return LanguageModelFunc(func(ctx context.Context, prompt client.Prompt) (io.Reader, error) {
+			p := promptFrom(prompt)
+			p.Purpose = redact(p.Purpose)
+			p.Question = redact(p.Question)
+			p.InputHistory = mapStrings(p.InputHistory, redact)
+			p.OutputHistory = mapStrings(p.OutputHistory, redact)
+			if p.References != nil {
+				references := make([]Reference, 0, len(p.References))
+				for _, ref := range p.References {
+					if utf8.Valid(ref.Data) {
+						ref.Data = []byte(redact(string(ref.Data)))
+					}
+					references = append(references, ref)
+				}
+				p.References = references
+			}
+			if p.ToolTurns != nil {
+				turns := make([]llm.ToolTurn, 0, len(p.ToolTurns))
+				for _, turn := range p.ToolTurns {
+					turn.Text = redact(turn.Text)
+					calls := make([]llm.ToolCall, 0, len(turn.Calls))
+					for _, c := range turn.Calls {
+						c.Arguments = redactJSON(c.Arguments, redact)
+						calls = append(calls, c)
+					}
+					turn.Calls = calls
+					results := make([]llm.ToolResult, 0, len(turn.Results))
+					for _, r := range turn.Results {
+						r.Content = redact(r.Content)
+						results = append(results, r)
+					}
+					turn.Results = results
+					turns = append(turns, turn)
+				}
+				p.ToolTurns = turns
+			}
+			return next.Completion(ctx, p)
+		})
+	}
+}
+
+// redactJSON redacts the string values in data, which is redacted as plain
+// text if it isn't valid JSON.
+func redactJSON(data json.RawMessage, redact func(string) string) json.RawMessage {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v any
+	err := decoder.Decode(&v)
+	if err != nil {
+		return json.RawMessage(redact(string(data)))
+	}
+	redacted, err := json.Marshal(redactValue(v, redact))
+	if err != nil {
+		return json.RawMessage(redact(string(data)))
+	}
+	return redacted
+}
+
+func redactValue(v any, redact func(string) string) any {
+	switch v := v.(type) {
+	case string:
+		return redact(v)
+	case []any:
+		for i := range v {
+			v[i] = redactValue(v[i], redact)
+		}
+	case map[string]any:
+		for k := range v {
+			v[k] = redactValue(v[k], redact)
+		}
+	}
+	return v
+}
+
+// Timeout returns middleware that gives up on a completion that hasn't been
+// read to the end within d.
+func Timeout(d time.Duration) Middleware {
+	return func(next LanguageModel) LanguageModel {
+		return LanguageModelFunc(func(ctx context.Context, prompt client.Prompt) (io.Reader, error) {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			data, err := next.Completion(ctx, prompt)
+			if err != nil {
+				cancel()
+				return nil, err
+			}
+			return llm.Observe(data, func(int, error) {
+				cancel()
+			}), nil
+		})
+	}
+}
+
+// promptFrom copies any client.Prompt into a Prompt that middleware can
+// safely modify.
+func promptFrom(prompt client.Prompt) Prompt {
+	if p, ok := prompt.(Prompt); ok {
+		return p
+	}
+	inputs, outputs := prompt.GetHistory()
+	return Prompt{
+		Purpose:        prompt.GetPurpose(),
+		InputHistory:   inputs,
+		OutputHistory:  outputs,
+		References:     prompt.GetReferences(),
+		Question:       prompt.GetQuestion(),
+		ResponseFormat: prompt.GetResponseFormat(),
+		ResponseSchema: prompt.GetResponseSchema(),
+		Tools:          prompt.GetTools(),
+		ToolTurns:      prompt.GetToolTurns(),
+		Options:        prompt.GetOptions(),
+	}
+}
+
+func mapStrings(s []string, f func(string) string) []string {
+	if s == nil {
+		return nil
+	}
+	mapped := make([]string, 0, len(s))
+	for _, v := range s {
+		mapped = append(mapped, f(v))
+	}
+	return mapped
+}
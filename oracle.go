@@ -3,17 +3,30 @@ package goracle
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/png"
 	"io"
+	"iter"
+	"maps"
+	"mime"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 
 	"github.com/mr-joshcrane/goracle/client"
+	"github.com/mr-joshcrane/goracle/client/llm"
+	"github.com/mr-joshcrane/goracle/client/pdf"
 )
 
+// Reference is material given to the model alongside a question, carrying
+// a name the model can refer to and the MIME type of its data. [File],
+// [Folder], [Image] and [PDF] all return one.
+type Reference = llm.Reference
+
 // Prompt is a struct that scaffolds a well formed prompt, designed in a way
 // that are ideal for Large Language Models. This is the abstraction we will pass
 // through to the client library so it can be handled appropriately
@@ -21,9 +34,17 @@ type Prompt struct {
 	Purpose        string
 	InputHistory   []string
 	OutputHistory  []string
-	References     [][]byte
+	References     []Reference
 	Question       string
 	ResponseFormat []string
+	ResponseSchema map[string]any
+	Tools          []llm.Tool
+	ToolTurns      []llm.ToolTurn
+	Options        llm.Options
+	// Template and TemplateVersion name the template the prompt was rendered
+	// from by [*Oracle.AskTemplate], if any.
+	Template        string
+	TemplateVersion string
 }
 
 // GetPurpose returns the purpose of the prompt, which frames the models response.
@@ -42,7 +63,8 @@ func (p Prompt) GetQuestion() string {
 	return p.Question
 }
 
-func (p Prompt) GetReferences() [][]byte {
+// GetReferences returns the material given alongside the question.
+func (p Prompt) GetReferences() []llm.Reference {
 	return p.References
 }
 
@@ -50,6 +72,27 @@ func (p Prompt) GetResponseFormat() []string {
 	return p.ResponseFormat
 }
 
+// GetResponseSchema returns the JSON schema the answer must conform to, if any.
+func (p Prompt) GetResponseSchema() map[string]any {
+	return p.ResponseSchema
+}
+
+// GetTools returns the tools the model may call while answering.
+func (p Prompt) GetTools() []llm.Tool {
+	return p.Tools
+}
+
+// GetToolTurns returns the tool calls made so far while answering the current
+// question, along with their results, in the order they happened.
+func (p Prompt) GetToolTurns() []llm.ToolTurn {
+	return p.ToolTurns
+}
+
+// GetOptions returns the settings the model should generate its answer with.
+func (p Prompt) GetOptions() llm.Options {
+	return p.Options
+}
+
 // LanguageModel is an interface that abstracts a concrete implementation of our
 // language model API call.
 type LanguageModel interface {
@@ -59,13 +102,32 @@ type LanguageModel interface {
 // Oracle is a struct that scaffolds a well formed Oracle, designed in a way
 // that facilitates the asking of one or many questions to an underlying Large
 // Language Model.
+//
+// An Oracle is safe for concurrent use. Each question sees the conversation
+// history as it stood when the question was asked, and answers are added to
+// the history in the order they complete. To hold several independent
+// conversations, [*Oracle.Fork] a primed Oracle instead.
 type Oracle struct {
-	purpose         string
-	previousInputs  []string
-	previousOutputs []string
-	client          LanguageModel
-	responseFormat  []string
-	stateful        bool
+	mu             sync.Mutex
+	purpose        string
+	history        []Exchange
+	client         LanguageModel
+	model          LanguageModel
+	middleware     []Middleware
+	responseFormat []string
+	stateful       bool
+	tools          map[string]tool
+	toolOrder      []string
+	maxSteps       int
+	repairAttempts int
+	historyPolicy  HistoryPolicy
+	contextWindow  int
+	budget         float64
+	usage          llm.Usage
+	options        llm.Options
+	// fitting is closed once the history policy in progress, if any, has
+	// finished.
+	fitting chan struct{}
 }
 
 // Remember [Oracles Oracle] remember the conversation history and keep track
@@ -75,6 +137,8 @@ type Oracle struct {
 // oracle.GiveExample like so:
 // oracle.GiveExample(oracle.File("path/to/file", "<your preferred bot response>"))
 func (o *Oracle) Remember() *Oracle {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	o.stateful = true
 	return o
 }
@@ -83,7 +147,9 @@ func (o *Oracle) Remember() *Oracle {
 // track of the context of the conversation. This is useful for when you want
 // to ask a single question without previous context affecting the answers.
 func (o *Oracle) Forget() *Oracle {
-	o.Reset()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.history = []Exchange{}
 	o.stateful = false
 	return o
 }
@@ -92,34 +158,115 @@ func (o *Oracle) Forget() *Oracle {
 // Useful for when you hit a context limit
 // Doesn't affect the Oracle's purpose, or whether it's stateful or not
 func (o *Oracle) Reset() {
-	o.previousInputs = []string{}
-	o.previousOutputs = []string{}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.history = []Exchange{}
 }
 
 // NewOracle returns a new Oracle with sensible defaults.
 func NewOracle(client LanguageModel) *Oracle {
 	return &Oracle{
-		client:   client,
-		purpose:  "You are a helpful assistant",
-		stateful: true,
+		client:         client,
+		purpose:        "You are a helpful assistant",
+		stateful:       true,
+		maxSteps:       defaultMaxSteps,
+		repairAttempts: defaultRepairAttempts,
+		historyPolicy:  KeepExamples(),
 	}
 }
 
 // SetPurpose sets the purpose of the Oracle, which frames the models response.
 func (o *Oracle) SetPurpose(purpose string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	o.purpose = purpose
 }
 
+// SetOptions sets how the model generates its answers, such as its
+// temperature or the most tokens it may produce. Options can also be passed
+// to a single call of [*Oracle.Ask] alongside its references, where any
+// fields they set take precedence. Clients refuse options their model can't
+// honour with an [llm.UnsupportedOptionError].
+func (o *Oracle) SetOptions(opts llm.Options) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.options = llm.Options{}.Merge(opts)
+}
+
+// Fork returns an independent copy of the Oracle that shares its client but
+// has its own copy of the purpose, examples, conversation history and
+// settings. This allows one primed Oracle to serve many parallel
+// conversations without them affecting each other. A fork keeps the budget,
+// but counts its usage from zero.
+func (o *Oracle) Fork() *Oracle {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	fork := &Oracle{
+		purpose:        o.purpose,
+		history:        slices.Clone(o.history),
+		client:         o.client,
+		model:          o.model,
+		middleware:     slices.Clone(o.middleware),
+		responseFormat: slices.Clone(o.responseFormat),
+		stateful:       o.stateful,
+		tools:          maps.Clone(o.tools),
+		toolOrder:      slices.Clone(o.toolOrder),
+		maxSteps:       o.maxSteps,
+		repairAttempts: o.repairAttempts,
+		historyPolicy:  o.historyPolicy,
+		contextWindow:  o.contextWindow,
+		budget:         o.budget,
+		options:        o.options,
+	}
+	return fork
+}
+
 // GiveExample adds an example to the list of examples. These examples used to guide the models
 // response. Quality of the examples is more important than quantity here.
 // Calling this method on a stateless Oracle will have no effect.
 // This allows for stateless oracles to still benefit from n-shot learning.
 func (o *Oracle) GiveExample(givenInput string, idealCompletion string) {
-	o.previousInputs = append(o.previousInputs, givenInput)
-	o.previousOutputs = append(o.previousOutputs, idealCompletion)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.history = append(o.history, Exchange{
+		Input:   givenInput,
+		Output:  idealCompletion,
+		Example: true,
+	})
+}
+
+// remember records a question and its answer as a turn in the conversation,
+// if the Oracle is stateful.
+func (o *Oracle) remember(question string, answer string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.stateful {
+		return
+	}
+	o.history = append(o.history, Exchange{
+		Input:  question,
+		Output: answer,
+	})
+}
+
+// historyPrompt splits the Oracle's history into the parallel inputs and
+// outputs a Prompt expects. The caller must hold the lock.
+func (o *Oracle) historyPrompt() ([]string, []string) {
+	if o.history == nil {
+		return nil, nil
+	}
+	inputs := make([]string, 0, len(o.history))
+	outputs := make([]string, 0, len(o.history))
+	for _, e := range o.history {
+		inputs = append(inputs, e.Input)
+		outputs = append(outputs, e.Output)
+	}
+	return inputs, outputs
 }
 
 func (o *Oracle) SetResponseFormat(fieldname, description string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	if o.responseFormat == nil {
 		o.responseFormat = []string{}
 	}
@@ -135,98 +282,243 @@ func (o *Oracle) Ask(question string, references ...any) (string, error) {
 
 // AskWithContext is similar to [*Oracle.Ask] but allows for a context to be passed in.
 func (o *Oracle) AskWithContext(ctx context.Context, question string, references ...any) (string, error) {
+	p, err := o.prompt(ctx, question, references...)
+	if err != nil {
+		return "", err
+	}
+	answer, err := o.generate(ctx, p, func(string) bool { return true })
+	if err != nil {
+		return "", err
+	}
+	o.remember(question, answer)
+	return answer, nil
+}
+
+// AskStream is similar to [*Oracle.AskWithContext] but yields the answer in
+// chunks as the underlying Large Language Model produces them. Once the stream
+// has been fully consumed, the complete answer is added to the conversation
+// history of a stateful Oracle. Stopping early discards the partial answer.
+func (o *Oracle) AskStream(ctx context.Context, question string, references ...any) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		p, err := o.prompt(ctx, question, references...)
+		if err != nil {
+			yield("", err)
+			return
+		}
+		answer, err := o.generate(ctx, p, func(chunk string) bool {
+			return yield(chunk, nil)
+		})
+		if errors.Is(err, errStopped) {
+			return
+		}
+		if err != nil {
+			yield("", err)
+			return
+		}
+		o.remember(question, answer)
+	}
+}
+
+// errStopped signals that the consumer of a stream stopped reading early.
+var errStopped = errors.New("stream stopped by consumer")
+
+// generate runs the prompt against the underlying Large Language Model, passing
+// each chunk of the answer to emit as it arrives. When the model asks for
+// registered tools to be called, generate runs them and asks again with the
+// results, until the model gives a final answer or the step limit is reached.
+func (o *Oracle) generate(ctx context.Context, p Prompt, emit func(string) bool) (string, error) {
+	for step := 0; ; step++ {
+		err := o.checkBudget(p)
+		if err != nil {
+			return "", err
+		}
+		data, err := o.completion(ctx, p)
+		if errors.Is(err, llm.ErrContextLength) && step == 0 {
+			data, err = o.retryWithShorterHistory(ctx, &p, err)
+		}
+		if err != nil {
+			return "", err
+		}
+		answer, err := readChunks(data, emit)
+		o.recordUsage(data)
+		if err != nil {
+			return "", err
+		}
+		var calls []llm.ToolCall
+		if tc, ok := data.(llm.ToolCaller); ok {
+			calls = tc.ToolCalls()
+		}
+		if len(calls) == 0 {
+			return answer, nil
+		}
+		o.mu.Lock()
+		maxSteps := o.maxSteps
+		o.mu.Unlock()
+		if step >= maxSteps {
+			return "", fmt.Errorf("%w: %d", ErrMaxSteps, maxSteps)
+		}
+		p.ToolTurns = append(p.ToolTurns, llm.ToolTurn{
+			Text:    answer,
+			Calls:   calls,
+			Results: o.runTools(ctx, calls),
+		})
+	}
+}
+
+// readChunks reads a completion to the end, passing each chunk to emit.
+func readChunks(data io.Reader, emit func(string) bool) (string, error) {
+	if c, ok := data.(io.Closer); ok {
+		defer c.Close()
+	}
+	answer := new(strings.Builder)
+	buf := make([]byte, 4096)
+	for {
+		n, err := data.Read(buf)
+		if n > 0 {
+			answer.Write(buf[:n])
+			if !emit(string(buf[:n])) {
+				return "", errStopped
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return answer.String(), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// prompt assembles the Prompt for a question, converting each of the supported
+// reference types into a form the client library can handle. The history is
+// shortened first if the prompt would not fit in the model's context window.
+func (o *Oracle) prompt(ctx context.Context, question string, references ...any) (Prompt, error) {
+	o.mu.Lock()
+	inputs, outputs := o.historyPrompt()
 	p := Prompt{
 		Purpose:        o.purpose,
-		InputHistory:   o.previousInputs,
-		OutputHistory:  o.previousOutputs,
+		InputHistory:   inputs,
+		OutputHistory:  outputs,
 		Question:       question,
-		ResponseFormat: o.responseFormat,
+		ResponseFormat: slices.Clone(o.responseFormat),
+		Tools:          o.toolDefinitions(),
+		Options:        o.options,
 	}
+	o.mu.Unlock()
 	for _, reference := range references {
 		switch r := reference.(type) {
-		case []byte:
+		case Reference:
 			p.References = append(p.References, r)
+		case []byte:
+			p.References = append(p.References, Reference{
+				MIMEType: llm.DetectMIMEType(r),
+				Data:     r,
+			})
 		case string:
-			p.References = append(p.References, []byte(r))
+			p.References = append(p.References, Reference{
+				MIMEType: "text/plain; charset=utf-8",
+				Data:     []byte(r),
+			})
 		case image.Image:
 			p.References = append(p.References, Image(r))
+		case llm.Options:
+			p.Options = p.Options.Merge(r)
 		default:
-			return "", fmt.Errorf("unprocessable reference type: %T", r)
+			return Prompt{}, fmt.Errorf("unprocessable reference type: %T", r)
 		}
 	}
-	data, err := o.completion(ctx, p)
-	if err != nil {
-		return "", err
-	}
-	answer, err := io.ReadAll(data)
+	_, err := o.fitHistory(ctx, &p, false)
 	if err != nil {
-		return "", err
-	}
-	if o.stateful {
-		o.GiveExample(question, string(answer))
+		return Prompt{}, err
 	}
-	return string(answer), nil
+	return p, nil
 }
 
 // Completion is a wrapper around the underlying Large Language Model API call.
-func (o Oracle) completion(ctx context.Context, prompt Prompt) (io.Reader, error) {
-	return o.client.Completion(ctx, prompt)
+func (o *Oracle) completion(ctx context.Context, prompt Prompt) (io.Reader, error) {
+	o.mu.Lock()
+	c := o.languageModel()
+	o.mu.Unlock()
+	return c.Completion(ctx, prompt)
 }
 
-// A Reference helper that reads a file from disk and returns the contents as
-// an array of bytes. Content will be a snapshot of the file at the time of
+// A Reference helper that reads a file from disk and returns its contents,
+// named after its path. Content will be a snapshot of the file at the time of
 // calling. Consider calling inside the Ask method or using a closure to ensure
-// lazy evaluation if you're going to be editing read file in place.
-func File(path string) []byte {
+// lazy evaluation if you're going to be editing read file in place. If the
+// file can't be read, its contents are empty; use [ReadFile] to see why.
+func File(path string) Reference {
+	ref, _ := ReadFile(path)
+	return ref
+}
+
+// ReadFile is like [File], but returns an error if the file can't be read.
+func ReadFile(path string) (Reference, error) {
+	ref := Reference{
+		Name:   path,
+		Source: path,
+		Data:   []byte{},
+	}
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return []byte{}
+		return ref, err
 	}
-	return data
+	ref.MIMEType = mimeType(path, data)
+	ref.Data = data
+	return ref, nil
 }
 
-// A Reference helper that takes a folder in a filesystem and returns the contents
-// of all files in that folder as an array of bytes. Content will be a snapshot
-// of the files at the time of calling. Call is recursive, so be careful with
-// what you include. Consider adding one of more filters to the includeFilter
-// such as ".go" to only include certain files or similar globs.
-func Folder(root string, includeFilter ...string) []byte {
-	contents := []byte{}
-	_ = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil
-		}
-		if info.IsDir() {
-			return nil
-		}
-		var filter bool
-		if len(includeFilter) != 0 {
-			filter = true
-		}
-		for _, f := range includeFilter {
-			if strings.Contains(info.Name(), f) {
-				filter = false
-			}
-		}
-		if !filter {
-			content := append(File(path), byte('\n'))
-			contents = append(contents, content...)
-		}
-		return nil
-	})
-	return contents
+// A Reference helper that reads a PDF document from disk, named after its
+// path. Models that read PDFs natively are given the document as it is, while
+// for the rest its text is extracted locally, page by page. If the file can't
+// be read or isn't a PDF, its contents are empty; use [ReadPDF] to see why.
+func PDF(path string) Reference {
+	ref, _ := ReadPDF(path)
+	return ref
 }
 
-// A Reference helper that takes an image and returns it's contents as an array
-// of bytes. Currently encodes to PNGs to be passed to the upstream client.
-// Content will be a snapshot of the image at the time of calling.
-func Image(i image.Image) []byte {
+// ReadPDF is like [PDF], but returns an error if the file can't be read or
+// isn't a PDF.
+func ReadPDF(path string) (Reference, error) {
+	ref, err := ReadFile(path)
+	if err != nil {
+		return ref, err
+	}
+	ref.MIMEType = pdf.MIMEType
+	if !bytes.HasPrefix(ref.Data, []byte("%PDF-")) {
+		ref.Data = []byte{}
+		return ref, fmt.Errorf("reading %s: %w", path, pdf.ErrNotPDF)
+	}
+	return ref, nil
+}
+
+// mimeType works out the MIME type of a file from its extension, or failing
+// that from its contents.
+func mimeType(name string, data []byte) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return t
+	}
+	return llm.DetectMIMEType(data)
+}
+
+// A Reference helper that takes an image and returns it as a reference named
+// "image.png". It is encoded as a PNG, which loses nothing; images that are
+// already encoded, such as a JPEG file, keep their format when given with
+// [File] or as bytes. Content will be a snapshot of the image at the time of
+// calling.
+func Image(i image.Image) Reference {
+	ref := Reference{
+		Name:     "image.png",
+		MIMEType: "image/png",
+		Data:     []byte{},
+	}
 	buf := new(bytes.Buffer)
 	err := png.Encode(buf, i)
 	if err != nil {
-		return []byte{}
+		return ref
 	}
-	return buf.Bytes()
+	ref.Data = buf.Bytes()
+	return ref
 }
 
 // NewChatGPTOracle takes an OpenAI API token and sets up a new ChatGPT Oracle
@@ -249,6 +541,8 @@ func NewOllamaOracle(model string, endpoint string) *Oracle {
 }
 
 func (o *Oracle) WithModel(model string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
 	switch c := o.client.(type) {
 	case *client.ChatGPT:
 		return c.WithModel(model)
@@ -3,17 +3,33 @@ package goracle_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
+	"image/jpeg"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/mr-joshcrane/goracle"
 	"github.com/mr-joshcrane/goracle/client"
+	"github.com/mr-joshcrane/goracle/client/llm"
+	"github.com/mr-joshcrane/goracle/client/pdf"
 	"golang.org/x/tools/cover"
 )
 
@@ -130,6 +146,1316 @@ func TestResetReturnsABlankOracle(t *testing.T) {
 	}
 }
 
+func TestAskStream_YieldsAnswerAndRemembersIt(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("Hello World", nil)
+	var got strings.Builder
+	for chunk, err := range o.AskStream(context.Background(), "Say hello") {
+		if err != nil {
+			t.Fatalf("Error streaming answer: %s", err)
+		}
+		got.WriteString(chunk)
+	}
+	if got.String() != "Hello World" {
+		t.Errorf("Expected Hello World, got %s", got.String())
+	}
+	_, err := o.Ask("Again")
+	if err != nil {
+		t.Fatalf("Error asking question: %s", err)
+	}
+	inputs, outputs := c.P.GetHistory()
+	if !cmp.Equal(inputs, []string{"Say hello"}) || !cmp.Equal(outputs, []string{"Hello World"}) {
+		t.Errorf("Expected streamed answer in history, got %v %v", inputs, outputs)
+	}
+}
+
+func TestAskStream_StoppingEarlyDiscardsPartialAnswer(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("Hello World", nil)
+	for range o.AskStream(context.Background(), "Say hello") {
+		break
+	}
+	_, err := o.Ask("Again")
+	if err != nil {
+		t.Fatalf("Error asking question: %s", err)
+	}
+	inputs, _ := c.P.GetHistory()
+	if len(inputs) != 0 {
+		t.Errorf("Expected no history after abandoned stream, got %v", inputs)
+	}
+}
+
+func TestAskStream_YieldsClientError(t *testing.T) {
+	t.Parallel()
+	o, _ := createTestOracle("", fmt.Errorf("boom"))
+	for _, err := range o.AskStream(context.Background(), "Say hello") {
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+	}
+}
+
+type weatherQuery struct {
+	City  string `json:"city" description:"The city to get the forecast for"`
+	Units string `json:"units,omitempty" enum:"metric,imperial"`
+}
+
+func TestRegisterTool_RunsRequestedToolsAndFeedsBackResults(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("It's sunny in Perth", nil)
+	c.ToolCalls = []llm.ToolCall{{ID: "call_1", Name: "weather", Arguments: json.RawMessage(`{"city":"Perth"}`)}}
+	var asked string
+	err := o.RegisterTool("weather", "Gets the forecast", func(ctx context.Context, q weatherQuery) (string, error) {
+		asked = q.City
+		return "sunny", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := o.Ask("What's the weather in Perth?")
+	if err != nil {
+		t.Fatalf("Error asking question: %s", err)
+	}
+	if got != "It's sunny in Perth" {
+		t.Errorf("Expected final answer, got %s", got)
+	}
+	if asked != "Perth" {
+		t.Errorf("Expected tool to be called with Perth, got %q", asked)
+	}
+	want := []llm.ToolTurn{{
+		Calls:   c.ToolCalls,
+		Results: []llm.ToolResult{{ID: "call_1", Name: "weather", Content: "sunny"}},
+	}}
+	if !cmp.Equal(want, c.P.GetToolTurns()) {
+		t.Error(cmp.Diff(want, c.P.GetToolTurns()))
+	}
+}
+
+func TestRegisterTool_DerivesSchemaFromArgumentStruct(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("", nil)
+	err := o.RegisterTool("weather", "Gets the forecast", func(q weatherQuery) (string, error) {
+		return "", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = o.Ask("What's the weather?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []llm.Tool{{
+		Name:        "weather",
+		Description: "Gets the forecast",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"city":  map[string]any{"type": "string", "description": "The city to get the forecast for"},
+				"units": map[string]any{"type": "string", "enum": []any{"metric", "imperial"}},
+			},
+			"required":             []string{"city"},
+			"additionalProperties": false,
+		},
+	}}
+	if !cmp.Equal(want, c.P.GetTools()) {
+		t.Error(cmp.Diff(want, c.P.GetTools()))
+	}
+}
+
+func TestRegisterTool_ReportsToolErrorsBackToModel(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("Sorry, I couldn't find that", nil)
+	c.ToolCalls = []llm.ToolCall{{ID: "call_1", Name: "weather", Arguments: json.RawMessage(`{}`)}}
+	err := o.RegisterTool("weather", "Gets the forecast", func(q weatherQuery) (string, error) {
+		return "", fmt.Errorf("city is required")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = o.Ask("What's the weather?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := c.P.GetToolTurns()[0].Results
+	if !results[0].IsError || results[0].Content != "city is required" {
+		t.Errorf("Expected error result, got %+v", results[0])
+	}
+}
+
+func TestRegisterTool_RejectsUnsupportedFunctions(t *testing.T) {
+	t.Parallel()
+	o, _ := createTestOracle("", nil)
+	for _, fn := range []any{
+		"not a function",
+		func(s string) (string, error) { return s, nil },
+		func(q weatherQuery) string { return "" },
+		func(a, b weatherQuery) (string, error) { return "", nil },
+	} {
+		if err := o.RegisterTool("bad", "", fn); err == nil {
+			t.Errorf("Expected error registering %T", fn)
+		}
+	}
+}
+
+func TestAsk_StopsAfterMaxToolSteps(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("", nil)
+	c.ToolCalls = []llm.ToolCall{{ID: "call_1", Name: "weather"}}
+	o.SetMaxSteps(0)
+	_, err := o.Ask("What's the weather?")
+	if !errors.Is(err, goracle.ErrMaxSteps) {
+		t.Errorf("Expected ErrMaxSteps, got %v", err)
+	}
+}
+
+type cheese struct {
+	Name    string   `json:"name"`
+	Origin  string   `json:"origin" enum:"France,Switzerland,Italy"`
+	AgeDays int      `json:"age_days"`
+	Pairing []string `json:"pairings"`
+	Rating  float64  `json:"rating,omitempty"`
+}
+
+func TestAskInto_DecodesAnswerIntoType(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("```json\n{\"name\":\"Gruyère\",\"origin\":\"Switzerland\",\"age_days\":300,\"pairings\":[\"pear\"]}\n```", nil)
+	got, err := goracle.AskInto[cheese](o, "Tell me about a cheese")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := cheese{Name: "Gruyère", Origin: "Switzerland", AgeDays: 300, Pairing: []string{"pear"}}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	schema := c.P.GetResponseSchema()
+	if schema["type"] != "object" || schema["required"] == nil {
+		t.Errorf("Expected object schema to be sent to client, got %v", schema)
+	}
+}
+
+func TestAskInto_ReasksWithValidationErrorsUntilValid(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle(`{"name":"Brie","origin":"France","age_days":60,"pairings":[]}`, nil)
+	c.Responses = []string{`{"name":"Brie","origin":"Spain","age_days":"sixty","pairings":[]}`}
+	got, err := goracle.AskInto[cheese](o, "Tell me about a cheese")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Brie" || got.AgeDays != 60 {
+		t.Errorf("Expected repaired answer, got %+v", got)
+	}
+	for _, problem := range []string{"$.age_days: expected an integer", "$.origin: Spain is not one of"} {
+		if !strings.Contains(c.P.GetQuestion(), problem) {
+			t.Errorf("Expected repair question to mention %q, got %s", problem, c.P.GetQuestion())
+		}
+	}
+}
+
+func TestAskInto_ReturnsSchemaErrorWhenRepairsRunOut(t *testing.T) {
+	t.Parallel()
+	o, _ := createTestOracle(`not json at all`, nil)
+	o.SetRepairAttempts(1)
+	_, err := goracle.AskInto[cheese](o, "Tell me about a cheese")
+	var schemaErr goracle.SchemaError
+	if !errors.As(err, &schemaErr) {
+		t.Fatalf("Expected SchemaError, got %v", err)
+	}
+}
+
+func TestAskInto_WrapsNonObjectTypes(t *testing.T) {
+	t.Parallel()
+	o, _ := createTestOracle(`{"response":["Brie","Cheddar"]}`, nil)
+	got, err := goracle.AskInto[[]string](o, "List two cheeses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal([]string{"Brie", "Cheddar"}, got) {
+		t.Error(cmp.Diff([]string{"Brie", "Cheddar"}, got))
+	}
+}
+
+func TestSaveAndLoad_ResumesConversation(t *testing.T) {
+	t.Parallel()
+	o, _ := createTestOracle("Paris", nil)
+	o.GiveExample("Capital of Italy?", "Rome")
+	o.SetResponseFormat("city", "The name of the city")
+	_, err := o.Ask("Capital of France?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	err = o.Save(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client.NewDummyClient("Berlin", nil)
+	restored, err := goracle.Load(buf, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = restored.Ask("Capital of Germany?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := goracle.Prompt{
+		Purpose:        "You are a test Oracle",
+		InputHistory:   []string{"Capital of Italy?", "Capital of France?"},
+		OutputHistory:  []string{"Rome", "Paris"},
+		Question:       "Capital of Germany?",
+		ResponseFormat: []string{"city:The name of the city"},
+	}
+	if !cmp.Equal(want, c.P) {
+		t.Error(cmp.Diff(want, c.P))
+	}
+}
+
+func TestSave_WritesVersionedTranscript(t *testing.T) {
+	t.Parallel()
+	o, _ := createTestOracle("", nil)
+	o.GiveExample("2", "even")
+	buf := new(bytes.Buffer)
+	err := o.Save(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	err = json.Unmarshal(buf.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"version":         float64(goracle.TranscriptVersion),
+		"purpose":         "You are a test Oracle",
+		"stateful":        true,
+		"options":         map[string]any{},
+		"max_steps":       float64(10),
+		"repair_attempts": float64(2),
+		"usage":           map[string]any{"input_tokens": float64(0), "output_tokens": float64(0), "cost": float64(0)},
+		"history":         []any{map[string]any{"input": "2", "output": "even", "example": true}},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestSaveAndLoad_KeepsExamplesAndTurnsInOrder(t *testing.T) {
+	t.Parallel()
+	o, _ := createTestOracle("Paris", nil)
+	_, err := o.Ask("Capital of France?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.GiveExample("Capital of Italy?", "Rome")
+	buf := new(bytes.Buffer)
+	err = o.Save(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := client.NewDummyClient("Berlin", nil)
+	restored, err := goracle.Load(buf, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = restored.Ask("Capital of Germany?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs, outputs := c.P.GetHistory()
+	wantInputs := []string{"Capital of France?", "Capital of Italy?"}
+	wantOutputs := []string{"Paris", "Rome"}
+	if !cmp.Equal(wantInputs, inputs) || !cmp.Equal(wantOutputs, outputs) {
+		t.Errorf("expected the history in its original order, got %v and %v", inputs, outputs)
+	}
+}
+
+func TestSaveAndLoad_KeepsTheOraclesSettings(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("ok", nil)
+	c.TokenUsage = llm.Usage{InputTokens: 1000, OutputTokens: 1000}
+	c.Price = llm.Pricing{InputPerMillion: 1000, OutputPerMillion: 1000}
+	o.SetOptions(llm.Options{Temperature: llm.Ptr(0.0), StopSequences: []string{"END"}})
+	o.SetBudget(2.005)
+	_, err := o.Ask("Hello?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := new(bytes.Buffer)
+	err = o.Save(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := goracle.Load(buf, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.Usage(); got != o.Usage() {
+		t.Errorf("expected usage %+v, got %+v", o.Usage(), got)
+	}
+	_, err = restored.Ask("Hello again?")
+	var budgetErr goracle.BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("expected the budget to carry over, got %v", err)
+	}
+	restored.SetBudget(0)
+	_, err = restored.Ask("Hello again?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := llm.Options{Temperature: llm.Ptr(0.0), StopSequences: []string{"END"}}
+	if got := c.P.GetOptions(); !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestLoad_RejectsUnknownTranscriptVersions(t *testing.T) {
+	t.Parallel()
+	_, err := goracle.Load(strings.NewReader(`{"version": 99}`), client.NewDummyClient("", nil))
+	if err == nil {
+		t.Fatal("Expected error loading unknown version, got nil")
+	}
+}
+
+func TestAsk_IsSafeForConcurrentUse(t *testing.T) {
+	t.Parallel()
+	o, _ := createTestOracle("answer", nil)
+	o.Remember()
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := o.Ask(fmt.Sprintf("question %d", i))
+			if err != nil {
+				t.Error(err)
+			}
+			o.GiveExample("example", "answer")
+		}()
+	}
+	wg.Wait()
+	buf := new(bytes.Buffer)
+	err := o.Save(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		History []struct {
+			Example bool `json:"example"`
+		} `json:"history"`
+	}
+	err = json.Unmarshal(buf.Bytes(), &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	examples := 0
+	for _, m := range got.History {
+		if m.Example {
+			examples++
+		}
+	}
+	if examples != 20 || len(got.History)-examples != 20 {
+		t.Errorf("want 20 examples and 20 turns, got %d and %d", examples, len(got.History)-examples)
+	}
+}
+
+func TestFork_HasIndependentHistory(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("Paris", nil)
+	o.Remember()
+	o.GiveExample("Capital of Italy?", "Rome")
+	fork := o.Fork()
+	_, err := fork.Ask("Capital of France?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = o.Ask("Capital of Spain?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := goracle.Prompt{
+		Purpose:       "You are a test Oracle",
+		InputHistory:  []string{"Capital of Italy?"},
+		OutputHistory: []string{"Rome"},
+		Question:      "Capital of Spain?",
+	}
+	if !cmp.Equal(want, c.P) {
+		t.Error(cmp.Diff(want, c.P))
+	}
+	_, err = fork.Ask("Capital of Germany?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = goracle.Prompt{
+		Purpose:       "You are a test Oracle",
+		InputHistory:  []string{"Capital of Italy?", "Capital of France?"},
+		OutputHistory: []string{"Rome", "Paris"},
+		Question:      "Capital of Germany?",
+	}
+	if !cmp.Equal(want, c.P) {
+		t.Error(cmp.Diff(want, c.P))
+	}
+}
+
+func TestAsk_DropsOldestTurnsButKeepsExamplesWhenContextIsFull(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("ok", nil)
+	o.SetContextWindow(100)
+	o.GiveExample("example", "ok")
+	for i := range 10 {
+		_, err := o.Ask(fmt.Sprintf("question number %d, padded to take up some room", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	inputs, _ := c.P.GetHistory()
+	if len(inputs) == 0 || inputs[0] != "example" {
+		t.Fatalf("expected the example to be kept, got %q", inputs)
+	}
+	if len(inputs) >= 10 {
+		t.Errorf("expected old turns to be dropped, got %d exchanges", len(inputs))
+	}
+	last := inputs[len(inputs)-1]
+	if last != "question number 8, padded to take up some room" {
+		t.Errorf("expected the most recent turn to be kept, got %q", last)
+	}
+}
+
+func TestAsk_SlidingWindowDropsExamplesToo(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("ok", nil)
+	o.SetHistoryPolicy(goracle.SlidingWindow())
+	o.SetContextWindow(100)
+	o.GiveExample("example", "ok")
+	for i := range 10 {
+		_, err := o.Ask(fmt.Sprintf("question number %d, padded to take up some room", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	inputs, _ := c.P.GetHistory()
+	if len(inputs) == 0 || inputs[0] == "example" {
+		t.Errorf("expected the example to be dropped, got %q", inputs)
+	}
+}
+
+func TestAsk_SummarizeReplacesOlderTurnsWithSummary(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("ok", nil)
+	o.SetHistoryPolicy(goracle.Summarize())
+	for i := range 4 {
+		_, err := o.Ask(fmt.Sprintf("question number %d, padded to take up some room", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	o.SetContextWindow(60)
+	c.Responses = []string{"We talked about numbers."}
+	_, err := o.Ask("final question")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs, outputs := c.P.GetHistory()
+	if len(outputs) == 0 || outputs[0] != "We talked about numbers." {
+		t.Fatalf("expected a summary turn first, got %q / %q", inputs, outputs)
+	}
+	if len(inputs) >= 4 {
+		t.Errorf("expected older turns to be replaced, got %d exchanges", len(inputs))
+	}
+}
+
+func TestAsk_CountsSummariesTowardsUsage(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("ok", nil)
+	c.TokenUsage = llm.Usage{InputTokens: 1000, OutputTokens: 1000}
+	o.SetHistoryPolicy(goracle.Summarize())
+	for i := range 4 {
+		_, err := o.Ask(fmt.Sprintf("question number %d, padded to take up some room", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	o.SetContextWindow(60)
+	_, err := o.Ask("final question")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Calls != 6 {
+		t.Fatalf("expected five questions and a summary, got %d calls", c.Calls)
+	}
+	want := llm.Usage{InputTokens: 6000, OutputTokens: 6000}
+	if got := o.Usage(); !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+// slowSummaries blocks every summary it is asked for until release is
+// closed, and answers everything else straight away.
+type slowSummaries struct {
+	summaries atomic.Int32
+	started   chan struct{}
+	release   chan struct{}
+}
+
+func newSlowSummaries() *slowSummaries {
+	return &slowSummaries{started: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (s *slowSummaries) Completion(ctx context.Context, prompt client.Prompt) (io.Reader, error) {
+	if strings.HasPrefix(prompt.GetPurpose(), "You summarise") {
+		if s.summaries.Add(1) == 1 {
+			close(s.started)
+		}
+		<-s.release
+		return strings.NewReader("We talked about numbers."), nil
+	}
+	return strings.NewReader("ok"), nil
+}
+
+// askUntilSummaryNeeded gives o enough history that its next question needs
+// a summary.
+func askUntilSummaryNeeded(t *testing.T, o *goracle.Oracle) {
+	t.Helper()
+	o.SetHistoryPolicy(goracle.Summarize())
+	for i := range 4 {
+		_, err := o.Ask(fmt.Sprintf("question number %d, padded to take up some room", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	o.SetContextWindow(60)
+}
+
+func TestAsk_LeavesOracleUsableWhileSummarising(t *testing.T) {
+	t.Parallel()
+	c := newSlowSummaries()
+	o := goracle.NewOracle(c)
+	askUntilSummaryNeeded(t, o)
+	errs := make(chan error, 1)
+	go func() {
+		_, err := o.Ask("final question")
+		errs <- err
+	}()
+	<-c.started
+	done := make(chan struct{})
+	go func() {
+		o.Usage()
+		o.SetPurpose("You are a test Oracle")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("expected the Oracle to stay usable while summarising")
+	}
+	close(c.release)
+	err := <-errs
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAsk_SummarisesOnceForConcurrentQuestions(t *testing.T) {
+	t.Parallel()
+	c := newSlowSummaries()
+	o := goracle.NewOracle(c)
+	askUntilSummaryNeeded(t, o)
+	errs := make(chan error, 2)
+	ask := func() {
+		_, err := o.Ask("final question")
+		errs <- err
+	}
+	go ask()
+	<-c.started
+	go ask()
+	time.Sleep(50 * time.Millisecond)
+	close(c.release)
+	for range 2 {
+		err := <-errs
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := c.summaries.Load(); n != 1 {
+		t.Errorf("expected one summary, got %d", n)
+	}
+}
+
+// contextLimited fails with a context-length error whenever the prompt has
+// more than limit exchanges of history.
+type contextLimited struct {
+	limit int
+	P     client.Prompt
+}
+
+func (c *contextLimited) Completion(ctx context.Context, prompt client.Prompt) (io.Reader, error) {
+	c.P = prompt
+	inputs, _ := prompt.GetHistory()
+	if len(inputs) > c.limit {
+		return nil, fmt.Errorf("too long: %w", llm.ErrContextLength)
+	}
+	return strings.NewReader("ok"), nil
+}
+
+func TestAsk_ShortensHistoryAndRetriesOnContextLengthError(t *testing.T) {
+	t.Parallel()
+	c := &contextLimited{limit: 3}
+	o := goracle.NewOracle(c)
+	for i := range 6 {
+		_, err := o.Ask(fmt.Sprintf("question %d", i))
+		if err != nil {
+			t.Fatalf("question %d: %v", i, err)
+		}
+	}
+	inputs, _ := c.P.GetHistory()
+	if len(inputs) > 3 {
+		t.Errorf("expected history to be shortened, got %q", inputs)
+	}
+}
+
+func TestAsk_ReturnsContextLengthErrorWhenHistoryCantShrink(t *testing.T) {
+	t.Parallel()
+	c := &contextLimited{limit: 0}
+	o := goracle.NewOracle(c)
+	o.GiveExample("example", "ok")
+	_, err := o.Ask("question")
+	if !errors.Is(err, llm.ErrContextLength) {
+		t.Errorf("expected ErrContextLength, got %v", err)
+	}
+}
+
+func TestChain_AppliesMiddlewareOutermostFirst(t *testing.T) {
+	t.Parallel()
+	var order []string
+	trace := func(name string) goracle.Middleware {
+		return func(next goracle.LanguageModel) goracle.LanguageModel {
+			return goracle.LanguageModelFunc(func(ctx context.Context, p client.Prompt) (io.Reader, error) {
+				order = append(order, name)
+				return next.Completion(ctx, p)
+			})
+		}
+	}
+	c := client.NewDummyClient("ok", nil)
+	model := goracle.Chain(c, trace("first"), trace("second"))
+	_, err := model.Completion(context.Background(), goracle.Prompt{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"first", "second"}
+	if !cmp.Equal(want, order) {
+		t.Error(cmp.Diff(want, order))
+	}
+	if c.Calls != 1 {
+		t.Errorf("expected 1 call to reach the client, got %d", c.Calls)
+	}
+}
+
+func TestUse_RedactsPromptBeforeItReachesClient(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("ok", nil)
+	o.Use(goracle.Redact(regexp.MustCompile(`sk-[a-z0-9]+`)))
+	o.GiveExample("my key is sk-abc123", "noted")
+	_, err := o.Ask("is sk-def456 valid?", "config: sk-ghi789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := goracle.Prompt{
+		Purpose:       "You are a test Oracle",
+		InputHistory:  []string{"my key is [REDACTED]"},
+		OutputHistory: []string{"noted"},
+		Question:      "is [REDACTED] valid?",
+		References: []goracle.Reference{{
+			MIMEType: "text/plain; charset=utf-8",
+			Data:     []byte("config: [REDACTED]"),
+		}},
+	}
+	if !cmp.Equal(want, c.P) {
+		t.Error(cmp.Diff(want, c.P))
+	}
+}
+
+func TestRedact_RedactsToolTurns(t *testing.T) {
+	t.Parallel()
+	var got client.Prompt
+	model := goracle.Redact(regexp.MustCompile(`sk-[a-z0-9]+`))(goracle.LanguageModelFunc(
+		func(ctx context.Context, prompt client.Prompt) (io.Reader, error) {
+			got = prompt
+			return strings.NewReader("ok"), nil
+		}))
+	_, err := model.Completion(context.Background(), goracle.Prompt{
+		Question: "Is my key valid?",
+		ToolTurns: []llm.ToolTurn{{
+			Text: "Checking sk-abc123 now.",
+			Calls: []llm.ToolCall{
+				{ID: "1", Name: "check", Arguments: json.RawMessage(`{"key":"sk-abc123","tries":3,"keys":["sk-def456"]}`)},
+				{ID: "2", Name: "check", Arguments: json.RawMessage(`sk-ghi789`)},
+			},
+			Results: []llm.ToolResult{{ID: "1", Name: "check", Content: "sk-abc123 is valid"}},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []llm.ToolTurn{{
+		Text: "Checking [REDACTED] now.",
+		Calls: []llm.ToolCall{
+			{ID: "1", Name: "check", Arguments: json.RawMessage(`{"key":"[REDACTED]","keys":["[REDACTED]"],"tries":3}`)},
+			{ID: "2", Name: "check", Arguments: json.RawMessage(`[REDACTED]`)},
+		},
+		Results: []llm.ToolResult{{ID: "1", Name: "check", Content: "[REDACTED] is valid"}},
+	}}
+	if !cmp.Equal(want, got.GetToolTurns()) {
+		t.Error(cmp.Diff(want, got.GetToolTurns()))
+	}
+}
+
+func TestUse_LogsAndCollectsMetricsForEachCompletion(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	metrics := &goracle.Metrics{}
+	o, _ := createTestOracle("Hello World", nil)
+	o.Use(
+		goracle.Logging(slog.New(slog.NewTextHandler(buf, nil))),
+		goracle.CollectMetrics(metrics),
+	)
+	for range 2 {
+		_, err := o.Ask("Hello?")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := metrics.Snapshot()
+	if got.Requests != 2 || got.Failures != 0 {
+		t.Errorf("expected 2 requests and no failures, got %+v", got)
+	}
+	if strings.Count(buf.String(), "msg=completion") != 2 || !strings.Contains(buf.String(), "bytes=11") {
+		t.Errorf("unexpected log output: %s", buf.String())
+	}
+	if strings.Contains(buf.String(), "Hello?") {
+		t.Errorf("expected the question not to be logged, got %s", buf.String())
+	}
+}
+
+func TestUse_CountsFailuresInMetrics(t *testing.T) {
+	t.Parallel()
+	metrics := &goracle.Metrics{}
+	o, _ := createTestOracle("", errors.New("boom"))
+	o.Use(goracle.CollectMetrics(metrics))
+	_, err := o.Ask("Hello?")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	got := metrics.Snapshot()
+	if got.Requests != 1 || got.Failures != 1 {
+		t.Errorf("expected 1 failed request, got %+v", got)
+	}
+}
+
+func TestTimeout_CancelsSlowCompletions(t *testing.T) {
+	t.Parallel()
+	slow := goracle.LanguageModelFunc(func(ctx context.Context, p client.Prompt) (io.Reader, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	o := goracle.NewOracle(slow)
+	o.Use(goracle.Timeout(10 * time.Millisecond))
+	_, err := o.Ask("Hello?")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestUse_KeepsModelSwitching(t *testing.T) {
+	t.Parallel()
+	o := goracle.NewChatGPTOracle("dummy-token")
+	o.Use(goracle.Timeout(time.Minute))
+	err := o.WithModel("gpt-4o")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRetry_RetriesTemporaryErrorsUntilSuccess(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("ok", nil)
+	c.Errors = []error{
+		&llm.StatusError{StatusCode: http.StatusTooManyRequests},
+		&llm.StatusError{StatusCode: llm.StatusOverloaded},
+		&llm.StatusError{StatusCode: http.StatusBadGateway},
+	}
+	o.Use(goracle.Retry(goracle.RetryPolicy{BaseDelay: time.Millisecond}))
+	got, err := o.Ask("Hello?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "ok" || c.Calls != 4 {
+		t.Errorf("expected ok after 4 calls, got %q after %d", got, c.Calls)
+	}
+}
+
+func TestRetry_GivesUpAfterMaxAttempts(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("", &llm.StatusError{StatusCode: http.StatusServiceUnavailable})
+	o.Use(goracle.Retry(goracle.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}))
+	_, err := o.Ask("Hello?")
+	var status *llm.StatusError
+	if !errors.As(err, &status) {
+		t.Fatalf("expected a StatusError, got %v", err)
+	}
+	if c.Calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", c.Calls)
+	}
+}
+
+func TestRetry_DoesNotRetryPermanentErrors(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("", &llm.StatusError{StatusCode: http.StatusUnauthorized})
+	o.Use(goracle.Retry(goracle.RetryPolicy{BaseDelay: time.Millisecond}))
+	_, err := o.Ask("Hello?")
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if c.Calls != 1 {
+		t.Errorf("expected a single attempt, got %d", c.Calls)
+	}
+}
+
+func TestRetry_WaitsForRetryAfter(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("ok", nil)
+	c.Errors = []error{&llm.StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: 50 * time.Millisecond}}
+	o.Use(goracle.Retry(goracle.RetryPolicy{BaseDelay: time.Millisecond}))
+	start := time.Now()
+	_, err := o.Ask("Hello?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected to wait at least 50ms, waited %s", elapsed)
+	}
+}
+
+func TestRetry_GivesUpRatherThanWaitPastDeadline(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("ok", nil)
+	c.Errors = []error{&llm.StatusError{StatusCode: http.StatusTooManyRequests, RetryAfter: time.Hour}}
+	o.Use(goracle.Retry(goracle.RetryPolicy{}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := o.AskWithContext(ctx, "Hello?")
+	var status *llm.StatusError
+	if !errors.As(err, &status) {
+		t.Fatalf("expected the StatusError, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected to give up straight away, took %s", elapsed)
+	}
+}
+
+// unwritableStore is a [goracle.CacheStore] that can't save anything.
+type unwritableStore struct{}
+
+var errStoreFull = errors.New("store is full")
+
+func (unwritableStore) Get(string) ([]byte, bool, error) { return nil, false, nil }
+func (unwritableStore) Put(string, []byte) error         { return errStoreFull }
+func (unwritableStore) Delete(string) error              { return nil }
+
+func TestCaching_AnswersEvenWhenTheStoreCannotSave(t *testing.T) {
+	t.Parallel()
+	cache := goracle.NewCache(unwritableStore{}, 0)
+	o, _ := createTestOracle("Paris", nil)
+	o.Use(goracle.Caching(cache))
+	got, err := o.Ask("Capital of France?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "Paris" {
+		t.Errorf("expected Paris, got %q", got)
+	}
+	stats := cache.Stats()
+	if stats.SaveErrors != 1 || !errors.Is(stats.LastSaveError, errStoreFull) {
+		t.Errorf("expected the failed save to be counted, got %+v", stats)
+	}
+}
+
+func TestCaching_ServesRepeatedPromptsFromMemory(t *testing.T) {
+	t.Parallel()
+	cache := goracle.NewCache(goracle.NewMemoryStore(0), 0)
+	o, c := createTestOracle("Paris", nil)
+	o.Forget()
+	o.Use(goracle.Caching(cache))
+	for range 3 {
+		got, err := o.Ask("Capital of France?")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "Paris" {
+			t.Errorf("expected Paris, got %q", got)
+		}
+	}
+	_, err := o.Ask("Capital of Spain?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := goracle.CacheStats{Hits: 2, Misses: 2}
+	if got := cache.Stats(); got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+	if c.Calls != 2 {
+		t.Errorf("expected 2 calls to reach the client, got %d", c.Calls)
+	}
+}
+
+func TestCaching_PersistsToDiskBetweenCaches(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	for i := range 2 {
+		store, err := goracle.NewDiskStore(dir, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache := goracle.NewCache(store, time.Hour)
+		o, _ := createTestOracle("Paris", nil)
+		o.Use(goracle.Caching(cache))
+		_, err = o.Ask("Capital of France?")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := goracle.CacheStats{Hits: i, Misses: 1 - i}
+		if got := cache.Stats(); got != want {
+			t.Errorf("run %d: expected %+v, got %+v", i, want, got)
+		}
+	}
+}
+
+func TestCaching_IgnoresExpiredEntries(t *testing.T) {
+	t.Parallel()
+	cache := goracle.NewCache(goracle.NewMemoryStore(0), time.Nanosecond)
+	o, c := createTestOracle("Paris", nil)
+	o.Forget()
+	o.Use(goracle.Caching(cache))
+	for range 2 {
+		_, err := o.Ask("Capital of France?")
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if c.Calls != 2 {
+		t.Errorf("expected expired entries to be fetched again, got %d calls", c.Calls)
+	}
+}
+
+func TestMemoryStore_EvictsLeastRecentlyUsedPastMaxSize(t *testing.T) {
+	t.Parallel()
+	s := goracle.NewMemoryStore(10)
+	s.Put("a", []byte("12345"))
+	s.Put("b", []byte("12345"))
+	s.Get("a")
+	s.Put("c", []byte("12345"))
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		_, ok, _ := s.Get(key)
+		if ok != want {
+			t.Errorf("%s: expected present %t", key, want)
+		}
+	}
+}
+
+func TestCaching_CollapsesIdenticalRequestsInFlight(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	var calls atomic.Int32
+	slow := goracle.LanguageModelFunc(func(ctx context.Context, p client.Prompt) (io.Reader, error) {
+		calls.Add(1)
+		<-release
+		return strings.NewReader("Paris"), nil
+	})
+	cache := goracle.NewCache(goracle.NewMemoryStore(0), 0)
+	model := goracle.Chain(slow, goracle.Caching(cache))
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := model.Completion(context.Background(), goracle.Prompt{Question: "Capital of France?"})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			got, _ := io.ReadAll(data)
+			if string(got) != "Paris" {
+				t.Errorf("expected Paris, got %q", got)
+			}
+		}()
+	}
+	for cache.Stats().Shared < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if calls.Load() != 1 {
+		t.Errorf("expected a single call to the model, got %d", calls.Load())
+	}
+}
+
+func TestCaching_KeysOnModelName(t *testing.T) {
+	t.Parallel()
+	cache := goracle.NewCache(goracle.NewMemoryStore(0), 0)
+	gpt := goracle.Chain(&namedDummy{Dummy: client.NewDummyClient("a", nil), name: "gpt"}, goracle.Caching(cache))
+	claude := goracle.Chain(&namedDummy{Dummy: client.NewDummyClient("b", nil), name: "claude"}, goracle.Caching(cache))
+	p := goracle.Prompt{Question: "Hello?"}
+	for _, m := range []goracle.LanguageModel{gpt, claude} {
+		_, err := m.Completion(context.Background(), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := cache.Stats(); got.Misses != 2 {
+		t.Errorf("expected each model to miss, got %+v", got)
+	}
+}
+
+// namedDummy is a Dummy client that reports a model name.
+type namedDummy struct {
+	*client.Dummy
+	name string
+}
+
+func (d *namedDummy) ModelName() string {
+	return d.name
+}
+
+func TestUsage_AddsUpTokensAndCostAcrossCompletions(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("ok", nil)
+	c.TokenUsage = llm.Usage{InputTokens: 1000, OutputTokens: 500}
+	c.Price = llm.Pricing{InputPerMillion: 2, OutputPerMillion: 8}
+	for range 2 {
+		_, err := o.Ask("Hello?")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := llm.Usage{InputTokens: 2000, OutputTokens: 1000, Cost: 0.012}
+	if got := o.Usage(); !cmp.Equal(want, got, cmpopts.EquateApprox(0, 1e-9)) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestSetBudget_RefusesRequestsOnceBudgetWouldBeExceeded(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("ok", nil)
+	c.TokenUsage = llm.Usage{InputTokens: 1000, OutputTokens: 1000}
+	c.Price = llm.Pricing{InputPerMillion: 1000, OutputPerMillion: 1000}
+	o.SetBudget(2.005)
+	_, err := o.Ask("Hello?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = o.Ask("Hello again?")
+	var budgetErr goracle.BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("expected a BudgetExceededError, got %v", err)
+	}
+	if budgetErr.Budget != 2.005 || budgetErr.Spent != 2 || budgetErr.Estimate <= 0 {
+		t.Errorf("expected $2 of $2.005 spent and a priced estimate, got %+v", budgetErr)
+	}
+	if c.Calls != 1 {
+		t.Errorf("expected the refused request not to reach the client, got %d calls", c.Calls)
+	}
+}
+
+func TestSetBudget_CountsCacheMissesButNotHits(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("ok", nil)
+	c.TokenUsage = llm.Usage{InputTokens: 1000, OutputTokens: 1000}
+	c.Price = llm.Pricing{InputPerMillion: 1000, OutputPerMillion: 1000}
+	o.Forget()
+	o.Use(goracle.Caching(goracle.NewCache(goracle.NewMemoryStore(0), 0)))
+	o.SetBudget(4.001)
+	for _, question := range []string{"Hello?", "Hello?", "Hello again?"} {
+		_, err := o.Ask(question)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := llm.Usage{InputTokens: 2000, OutputTokens: 2000, Cost: 4}
+	if got := o.Usage(); !cmp.Equal(want, got, cmpopts.EquateApprox(0, 1e-9)) {
+		t.Error(cmp.Diff(want, got))
+	}
+	_, err := o.Ask("Hello once more?")
+	var budgetErr goracle.BudgetExceededError
+	if !errors.As(err, &budgetErr) {
+		t.Fatalf("expected a BudgetExceededError, got %v", err)
+	}
+	if c.Calls != 2 {
+		t.Errorf("expected only the misses to reach the client, got %d calls", c.Calls)
+	}
+}
+
+func TestSetOptions_AreSentWithEachQuestionAndOverriddenPerCall(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("ok", nil)
+	o.SetOptions(llm.Options{Temperature: llm.Ptr(0.2), MaxOutputTokens: llm.Ptr(100)})
+	_, err := o.Ask("Hello?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := llm.Options{Temperature: llm.Ptr(0.2), MaxOutputTokens: llm.Ptr(100)}
+	if got := c.P.GetOptions(); !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	_, err = o.Ask("Hello again?", "a reference", llm.Options{Temperature: llm.Ptr(0.0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = llm.Options{Temperature: llm.Ptr(0.0), MaxOutputTokens: llm.Ptr(100)}
+	if got := c.P.GetOptions(); !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if refs := c.P.GetReferences(); len(refs) != 1 {
+		t.Errorf("expected options not to be sent as a reference, got %d references", len(refs))
+	}
+}
+
+func TestRouter_FallsBackToTheNextBackendOnError(t *testing.T) {
+	t.Parallel()
+	down := client.NewDummyClient("", &llm.StatusError{StatusCode: http.StatusServiceUnavailable})
+	up := client.NewDummyClient("ok", nil)
+	r := client.NewRouter(client.Fallback,
+		client.Backend{Name: "down", Client: down},
+		client.Backend{Name: "up", Client: up},
+	)
+	data, err := r.Completion(context.Background(), goracle.Prompt{Question: "Hello?"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ok" {
+		t.Errorf("expected ok, got %q", got)
+	}
+	if name := data.(*client.Routed).Backend(); name != "up" {
+		t.Errorf("expected the answer from up, got %q", name)
+	}
+	want := map[string]client.BackendStats{
+		"down": {Failures: 1},
+		"up":   {Successes: 1},
+	}
+	if got := r.Stats(); !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestRouter_ReportsTheModelsBehindItsBackends(t *testing.T) {
+	t.Parallel()
+	chatGPT := client.NewChatGPT("token")
+	claude := client.NewAnthropic("token")
+	dummy := client.NewDummyClient("ok", nil)
+	dummy.Price = llm.Pricing{InputPerMillion: 1000}
+	r := client.NewRouter(client.Fallback,
+		client.Backend{Name: "chatgpt", Client: chatGPT},
+		client.Backend{Name: "claude", Client: claude},
+		client.Backend{Name: "dummy", Client: dummy},
+	)
+	wantPrice := llm.Pricing{
+		InputPerMillion:  1000,
+		OutputPerMillion: max(chatGPT.Pricing().OutputPerMillion, claude.Pricing().OutputPerMillion),
+	}
+	if got := r.Pricing(); !cmp.Equal(wantPrice, got) {
+		t.Error(cmp.Diff(wantPrice, got))
+	}
+	wantWindow := min(chatGPT.ContextWindow(), claude.ContextWindow())
+	if got := r.ContextWindow(); got != wantWindow {
+		t.Errorf("expected the smallest context window, %d, got %d", wantWindow, got)
+	}
+	wantName := chatGPT.ModelName() + "," + claude.ModelName() + ",dummy"
+	if got := r.ModelName(); got != wantName {
+		t.Errorf("expected %q, got %q", wantName, got)
+	}
+}
+
+func TestRouter_ReportsEveryBackendsErrorWhenAllFail(t *testing.T) {
+	t.Parallel()
+	first := errors.New("first failed")
+	second := errors.New("second failed")
+	r := client.NewRouter(client.Fallback,
+		client.Backend{Name: "a", Client: client.NewDummyClient("", first)},
+		client.Backend{Name: "b", Client: client.NewDummyClient("", second)},
+	)
+	_, err := r.Completion(context.Background(), goracle.Prompt{Question: "Hello?"})
+	if !errors.Is(err, first) || !errors.Is(err, second) {
+		t.Errorf("expected both errors, got %v", err)
+	}
+}
+
+func TestRouter_RaceTakesTheFirstSuccess(t *testing.T) {
+	t.Parallel()
+	slow := goracle.LanguageModelFunc(func(ctx context.Context, prompt client.Prompt) (io.Reader, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	failing := client.NewDummyClient("", errors.New("failed"))
+	fast := client.NewDummyClient("fast", nil)
+	o := goracle.NewOracle(client.NewRouter(client.Race,
+		client.Backend{Name: "slow", Client: slow},
+		client.Backend{Name: "failing", Client: failing},
+		client.Backend{Name: "fast", Client: fast},
+	))
+	got, err := o.Ask("Hello?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "fast" {
+		t.Errorf("expected fast, got %q", got)
+	}
+}
+
+func TestRouter_RoundRobinFollowsWeights(t *testing.T) {
+	t.Parallel()
+	r := client.NewRouter(client.RoundRobin,
+		client.Backend{Name: "heavy", Client: client.NewDummyClient("heavy", nil), Weight: 2},
+		client.Backend{Name: "light", Client: client.NewDummyClient("light", nil)},
+	)
+	var got []string
+	for range 6 {
+		data, err := r.Completion(context.Background(), goracle.Prompt{Question: "Hello?"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, data.(*client.Routed).Backend())
+	}
+	want := []string{"heavy", "light", "heavy", "heavy", "light", "heavy"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestRouter_SkipsBackendsCoolingDownAfterRepeatedFailures(t *testing.T) {
+	t.Parallel()
+	down := client.NewDummyClient("", errors.New("down"))
+	up := client.NewDummyClient("ok", nil)
+	r := client.NewRouter(client.Fallback,
+		client.Backend{Name: "down", Client: down},
+		client.Backend{Name: "up", Client: up},
+	)
+	r.MaxFailures = 2
+	for range 4 {
+		_, err := r.Completion(context.Background(), goracle.Prompt{Question: "Hello?"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if down.Calls != 2 || up.Calls != 4 {
+		t.Errorf("expected down to be tried twice and up four times, got %d and %d", down.Calls, up.Calls)
+	}
+	if until := r.Stats()["down"].CoolingUntil; !until.After(time.Now()) {
+		t.Errorf("expected down to be cooling down, got %v", until)
+	}
+}
+
 func TestPromptAccessorMethods(t *testing.T) {
 	t.Parallel()
 	prompt := goracle.Prompt{
@@ -164,8 +1490,8 @@ func TestAskWithStringLiteralReferenceReturnsCorrectPrompt(t *testing.T) {
 	if len(got) != 1 {
 		t.Errorf("Expected 1 reference, got %d", len(got))
 	}
-	if string(got[0]) != "It's time to shine" {
-		t.Errorf("Expected It's time to shine, got %s", string(got[0]))
+	if string(got[0].Data) != "It's time to shine" {
+		t.Errorf("Expected It's time to shine, got %s", string(got[0].Data))
 	}
 }
 
@@ -179,140 +1505,458 @@ func TestAskWithStringLiteralReferneceProvidesCorrectPrompt(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error asking question: %s", err)
 	}
-	got := c.P.GetReferences()
-	if len(got) != 2 {
-		t.Errorf("Expected 2 references, got %d", len(got))
+	got := c.P.GetReferences()
+	if len(got) != 2 {
+		t.Errorf("Expected 2 references, got %d", len(got))
+	}
+	if string(got[0].Data) != "It's time to shine" {
+		t.Errorf("Expected It's time to shine, got %s", string(got[0].Data))
+	}
+	if string(got[1].Data) != "It's time to shine again" {
+		t.Errorf("Expected It's time to shine again, got %s", string(got[1].Data))
+	}
+}
+
+func TestAskWithBytesReferenceReturnsCorrectPrompt(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("", nil)
+	_, err := o.Ask("Hello World?",
+		[]byte("It's time to shine"),
+	)
+	if err != nil {
+		t.Fatalf("Error asking question: %s", err)
+	}
+	got := c.P.GetReferences()
+	if len(got) != 1 {
+		t.Errorf("Expected 1 reference, got %d", len(got))
+	}
+	if !bytes.Equal(got[0].Data, []byte("It's time to shine")) {
+		t.Errorf("Expected It's time to shine, got %s", string(got[0].Data))
+	}
+}
+
+func TestAskWithEncodedImageBytesKeepsTheirFormat(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("", nil)
+	picture := new(bytes.Buffer)
+	err := jpeg.Encode(picture, image.NewGray(image.Rect(0, 0, 2, 2)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = o.Ask("What is this?", picture.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []goracle.Reference{{MIMEType: "image/jpeg", Data: picture.Bytes()}}
+	if got := c.P.GetReferences(); !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestAskWithImageReferenceProvidesCorrectPrompt(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("", nil)
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	_, err := o.Ask("Whats in this image?", img)
+	if err != nil {
+		t.Fatalf("Error asking question: %s", err)
+	}
+	got := c.P.GetReferences()
+	if len(got) != 1 {
+		t.Errorf("Expected 1 reference, got %d", len(got))
+	}
+	if !cmp.Equal(got[0], goracle.Image(img)) {
+		t.Error(cmp.Diff(goracle.Image(img), got[0]))
+	}
+}
+
+func TestAskWithSomeUnknownReferenceReturnsError(t *testing.T) {
+	t.Parallel()
+	o, _ := createTestOracle("", nil)
+	type Unsupported struct{}
+	_, err := o.Ask("Whats in this image?", Unsupported{})
+	if err == nil {
+		t.Fatalf("Expected error asking question, got nil")
+	}
+}
+
+func TestFileReference_ValidFileReturnsByteContents(t *testing.T) {
+	t.Parallel()
+	want := []byte("cheese is made from milk")
+	path := t.TempDir() + "/text.txt"
+	err := os.WriteFile(path, want, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := goracle.File(path)
+	if !bytes.Equal(got.Data, want) {
+		t.Errorf("Expected %s, got %s", string(want), string(got.Data))
+	}
+}
+
+func TestFileReference_IsNamedAfterItsPath(t *testing.T) {
+	t.Parallel()
+	path := t.TempDir() + "/notes.json"
+	err := os.WriteFile(path, []byte(`{"notes":[]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := goracle.File(path)
+	if got.Name != path || got.Source != path {
+		t.Errorf("expected the reference to be named %s, got %+v", path, got)
+	}
+	if got.MIMEType != "application/json" {
+		t.Errorf("expected a JSON MIME type, got %q", got.MIMEType)
+	}
+}
+
+func TestAskWithReferenceProvidesItUnchanged(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("", nil)
+	ref := goracle.Reference{
+		Name:     "report.csv",
+		Source:   "https://example.com/report.csv",
+		MIMEType: "text/csv",
+		Data:     []byte("a,b\n1,2\n"),
+	}
+	_, err := o.Ask("What is b?", ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []goracle.Reference{ref}
+	if got := c.P.GetReferences(); !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestFileReference_InvalidFileReturnsEmptyBytes(t *testing.T) {
+	t.Parallel()
+	got := goracle.File("invalid/path")
+	if len(got.Data) != 0 {
+		t.Errorf("Expected empty bytes, got %s", string(got.Data))
+	}
+}
+
+func TestFolderReference_ValidFolderReturnsByteContentsOfFiles(t *testing.T) {
+	t.Parallel()
+	content1 := []byte("cheese is made from milk")
+	content2 := []byte("the sky is blue")
+	dir := t.TempDir()
+	err := os.WriteFile(dir+"/text1.txt", content1, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(dir+"/text2.txt", content2, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := goracle.Folder(dir).Data
+	want := []byte("==> text1.txt <==\ncheese is made from milk\n==> text2.txt <==\nthe sky is blue\n")
+	if !cmp.Equal(got, want) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+}
+
+func TestFolderReference_DirectoryContentsCanBeFiltered(t *testing.T) {
+	t.Parallel()
+	tempDir := t.TempDir()
+	for i := 0; i < 10; i++ {
+		var filepath string
+		if i%2 == 0 {
+			filepath = tempDir + fmt.Sprintf("/%d.even.txt", i)
+		} else {
+			filepath = tempDir + fmt.Sprintf("/%d.odd.txt", i)
+		}
+		err := os.WriteFile(filepath, []byte(fmt.Sprintf("i=%d", i)), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := goracle.Folder(tempDir, "even").Data
+	want := []byte("==> 0.even.txt <==\ni=0\n==> 2.even.txt <==\ni=2\n==> 4.even.txt <==\ni=4\n==> 6.even.txt <==\ni=6\n==> 8.even.txt <==\ni=8\n")
+	if !cmp.Equal(got, want) {
+		t.Fatal(cmp.Diff(want, got))
+	}
+
+}
+
+func TestFolderReference_InvalidFolderReturnsEmptyBytes(t *testing.T) {
+	t.Parallel()
+	got := goracle.Folder("invalid/path").Data
+	if len(got) != 0 {
+		t.Errorf("Expected empty bytes, got %s", string(got))
+	}
+}
+
+func TestReadFolderFS_FiltersByGlobs(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"main.go":              {Data: []byte("package main")},
+		"main_test.go":         {Data: []byte("package main_test")},
+		"README.md":            {Data: []byte("# Readme")},
+		"cmd/tool/main.go":     {Data: []byte("package tool")},
+		"internal/gen/gen.go":  {Data: []byte("package gen")},
+		"internal/gen/data.go": {Data: []byte("package gen // data")},
+	}
+	ref, err := goracle.ReadFolderFS(fsys, goracle.FolderOptions{
+		Include: []string{"*.go"},
+		Exclude: []string{"*_test.go", "internal/**/data.go"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "==> cmd/tool/main.go <==\npackage tool\n" +
+		"==> internal/gen/gen.go <==\npackage gen\n" +
+		"==> main.go <==\npackage main\n"
+	if got := string(ref.Data); got != want {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestReadFolderFS_SkipsGitignoredVendoredAndBinaryFiles(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		".gitignore":          {Data: []byte("# build output\n*.log\n/build/\n!keep.log\n")},
+		"app.log":             {Data: []byte("noise")},
+		"keep.log":            {Data: []byte("signal")},
+		"build/out.txt":       {Data: []byte("built")},
+		"docs/build/page.txt": {Data: []byte("page")},
+		"docs/.gitignore":     {Data: []byte("draft.txt\n")},
+		"docs/draft.txt":      {Data: []byte("draft")},
+		".git/config":         {Data: []byte("[core]")},
+		"vendor/lib/lib.go":   {Data: []byte("package lib")},
+		"logo.png":            {Data: []byte("\x89PNG\r\n\x1a\n\x00\x00")},
+	}
+	ref, err := goracle.ReadFolderFS(fsys, goracle.FolderOptions{Exclude: []string{".gitignore"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "==> docs/build/page.txt <==\npage\n" +
+		"==> keep.log <==\nsignal\n"
+	if got := string(ref.Data); got != want {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestReadFolderFS_EnforcesSizeLimits(t *testing.T) {
+	t.Parallel()
+	fsys := fstest.MapFS{
+		"a.txt":     {Data: []byte("small")},
+		"b.txt":     {Data: []byte("small too")},
+		"large.txt": {Data: bytes.Repeat([]byte("x"), 100)},
+	}
+	ref, err := goracle.ReadFolderFS(fsys, goracle.FolderOptions{MaxFileSize: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(ref.Data), "large.txt") {
+		t.Error("expected the file over the size limit to be skipped")
+	}
+	ref, err = goracle.ReadFolderFS(fsys, goracle.FolderOptions{MaxTotalSize: 30})
+	if !errors.Is(err, goracle.ErrReferenceTooLarge) {
+		t.Fatalf("expected ErrReferenceTooLarge, got %v", err)
+	}
+	want := "==> a.txt <==\nsmall\n"
+	if got := string(ref.Data); got != want {
+		t.Errorf("expected the files that fit to be returned, got %q", got)
+	}
+}
+
+// unreadableFS fails to open the file named bad.
+type unreadableFS struct {
+	fsys fs.FS
+	bad  string
+}
+
+func (u unreadableFS) Open(name string) (fs.File, error) {
+	if name == u.bad {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return u.fsys.Open(name)
+}
+
+func TestReadFolderFS_SkipsUnreadableFilesAndReportsThem(t *testing.T) {
+	t.Parallel()
+	fsys := unreadableFS{
+		fsys: fstest.MapFS{
+			"a.txt": {Data: []byte("first")},
+			"b.txt": {Data: []byte("secret")},
+			"c.txt": {Data: []byte("last")},
+		},
+		bad: "b.txt",
+	}
+	ref, err := goracle.ReadFolderFS(fsys, goracle.FolderOptions{})
+	if !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected fs.ErrPermission, got %v", err)
 	}
-	if string(got[0]) != "It's time to shine" {
-		t.Errorf("Expected It's time to shine, got %s", string(got[0]))
+	want := "==> a.txt <==\nfirst\n==> c.txt <==\nlast\n"
+	if got := string(ref.Data); got != want {
+		t.Error(cmp.Diff(want, got))
 	}
-	if string(got[1]) != "It's time to shine again" {
-		t.Errorf("Expected It's time to shine again, got %s", string(got[1]))
+	if got := string(goracle.FolderFS(fsys).Data); got != want {
+		t.Error(cmp.Diff(want, got))
 	}
 }
 
-func TestAskWithBytesReferenceReturnsCorrectPrompt(t *testing.T) {
+func TestFolderFS_HasNoLimitOnTotalSize(t *testing.T) {
 	t.Parallel()
-	o, c := createTestOracle("", nil)
-	_, err := o.Ask("Hello World?",
-		[]byte("It's time to shine"),
-	)
-	if err != nil {
-		t.Fatalf("Error asking question: %s", err)
-	}
-	got := c.P.GetReferences()
-	if len(got) != 1 {
-		t.Errorf("Expected 1 reference, got %d", len(got))
+	fsys := fstest.MapFS{}
+	for i := range 10 {
+		fsys[fmt.Sprintf("%d.txt", i)] = &fstest.MapFile{Data: bytes.Repeat([]byte("x"), 1<<20)}
 	}
-	if !bytes.Equal(got[0], []byte("It's time to shine")) {
-		t.Errorf("Expected It's time to shine, got %s", string(got[0]))
+	got := goracle.FolderFS(fsys).Data
+	if len(got) < 10<<20 {
+		t.Errorf("expected all 10MiB of files, got %d bytes", len(got))
 	}
 }
 
-func TestAskWithImageReferenceProvidesCorrectPrompt(t *testing.T) {
+func TestReadFolder_ReturnsErrorForMissingFolder(t *testing.T) {
 	t.Parallel()
-	o, c := createTestOracle("", nil)
-	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
-	_, err := o.Ask("Whats in this image?", img)
-	if err != nil {
-		t.Fatalf("Error asking question: %s", err)
-	}
-	got := c.P.GetReferences()
-	if len(got) != 1 {
-		t.Errorf("Expected 1 reference, got %d", len(got))
+	_, err := goracle.ReadFolder("invalid/path", goracle.FolderOptions{})
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
 	}
-	if !bytes.Equal(got[0], goracle.Image(img)) {
-		t.Errorf("Expected %v, got %v", goracle.Image(img), got[0])
+}
+
+func TestReadFile_ReturnsErrorForMissingFile(t *testing.T) {
+	t.Parallel()
+	_, err := goracle.ReadFile("invalid/path")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
 	}
 }
 
-func TestAskWithSomeUnknownReferenceReturnsError(t *testing.T) {
+func TestPDFReference_ReadsDocumentAsIs(t *testing.T) {
 	t.Parallel()
-	o, _ := createTestOracle("", nil)
-	type Unsupported struct{}
-	_, err := o.Ask("Whats in this image?", Unsupported{})
-	if err == nil {
-		t.Fatalf("Expected error asking question, got nil")
+	path := "client/pdf/testdata/report.pdf"
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := goracle.PDF(path)
+	if got.Name != path || got.MIMEType != "application/pdf" {
+		t.Errorf("expected a PDF named %s, got %s %q", path, got.Name, got.MIMEType)
+	}
+	if !bytes.Equal(want, got.Data) {
+		t.Error("expected the document's contents unchanged")
 	}
 }
 
-func TestFileReference_ValidFileReturnsByteContents(t *testing.T) {
+func TestReadPDF_RejectsFilesThatAreNotPDFs(t *testing.T) {
 	t.Parallel()
-	want := []byte("cheese is made from milk")
-	path := t.TempDir() + "/text.txt"
-	err := os.WriteFile(path, want, 0644)
+	path := t.TempDir() + "/notes.pdf"
+	err := os.WriteFile(path, []byte("just some notes"), 0644)
 	if err != nil {
 		t.Fatal(err)
 	}
-	got := goracle.File(path)
-	if !bytes.Equal(got, want) {
-		t.Errorf("Expected %s, got %s", string(want), string(got))
+	got, err := goracle.ReadPDF(path)
+	if !errors.Is(err, pdf.ErrNotPDF) {
+		t.Errorf("expected pdf.ErrNotPDF, got %v", err)
+	}
+	if len(got.Data) != 0 {
+		t.Errorf("expected empty bytes, got %s", got.Data)
 	}
 }
 
-func TestFileReference_InvalidFileReturnsEmptyBytes(t *testing.T) {
+func TestReadURL_ConvertsHTMLPagesToMarkdown(t *testing.T) {
 	t.Parallel()
-	got := goracle.File("invalid/path")
-	if len(got) != 0 {
-		t.Errorf("Expected empty bytes, got %s", string(got))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<html><head><title>Cheeses</title><script>track()</script></head>
+<body><nav><a href="/">Home</a></nav><p>Brie is <em>soft</em>.</p></body></html>`)
+	}))
+	defer server.Close()
+	got, err := goracle.ReadURL(context.Background(), server.URL+"/cheese", goracle.URLOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := goracle.Reference{
+		Name:     server.URL + "/cheese",
+		Source:   server.URL + "/cheese",
+		MIMEType: "text/markdown; charset=utf-8",
+		Data:     []byte("# Cheeses\n\nBrie is _soft_.\n"),
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
 	}
 }
 
-func TestFolderReference_ValidFolderReturnsByteContentsOfFiles(t *testing.T) {
+func TestReadURL_TurnsImagesAndPDFsIntoReferences(t *testing.T) {
 	t.Parallel()
-	content1 := []byte("cheese is made from milk")
-	content2 := []byte("the sky is blue")
-	dir := t.TempDir()
-	err := os.WriteFile(dir+"/text1.txt", content1, 0644)
+	document, err := os.ReadFile("client/pdf/testdata/report.pdf")
 	if err != nil {
 		t.Fatal(err)
 	}
-	err = os.WriteFile(dir+"/text2.txt", content2, 0644)
+	picture := new(bytes.Buffer)
+	err = jpeg.Encode(picture, image.NewGray(image.Rect(0, 0, 2, 2)), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
-	got := goracle.Folder(dir)
-	want := []byte("cheese is made from milk\nthe sky is blue\n")
-	if !cmp.Equal(got, want) {
-		t.Fatal(cmp.Diff(want, got))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/report.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write(document)
+		case "/photo":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(picture.Bytes())
+		}
+	}))
+	defer server.Close()
+	ref, err := goracle.ReadURL(context.Background(), server.URL+"/report.pdf", goracle.URLOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.MIMEType != "application/pdf" || !bytes.Equal(document, ref.Data) {
+		t.Errorf("expected the PDF unchanged, got %q", ref.MIMEType)
+	}
+	ref, err = goracle.ReadURL(context.Background(), server.URL+"/photo", goracle.URLOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ref.MIMEType != "image/jpeg" || !bytes.Equal(picture.Bytes(), ref.Data) {
+		t.Errorf("expected the JPEG image unchanged, got %q", ref.MIMEType)
 	}
 }
 
-func TestFolderReference_DirectoryContentsCanBeFiltered(t *testing.T) {
+func TestReadURL_EnforcesTheSizeLimitAndTimeout(t *testing.T) {
 	t.Parallel()
-	tempDir := t.TempDir()
-	for i := 0; i < 10; i++ {
-		var filepath string
-		if i%2 == 0 {
-			filepath = tempDir + fmt.Sprintf("/%d.even.txt", i)
-		} else {
-			filepath = tempDir + fmt.Sprintf("/%d.odd.txt", i)
-		}
-		err := os.WriteFile(filepath, []byte(fmt.Sprintf("i=%d", i)), 0644)
-		if err != nil {
-			t.Fatal(err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
 		}
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, strings.Repeat("a", 100))
+	}))
+	defer server.Close()
+	_, err := goracle.ReadURL(context.Background(), server.URL, goracle.URLOptions{MaxSize: 10})
+	if !errors.Is(err, goracle.ErrReferenceTooLarge) {
+		t.Errorf("expected ErrReferenceTooLarge, got %v", err)
 	}
-	got := goracle.Folder(tempDir, "even")
-	want := []byte("i=0\ni=2\ni=4\ni=6\ni=8\n")
-	if !cmp.Equal(got, want) {
-		t.Fatal(cmp.Diff(want, got))
+	_, err = goracle.ReadURL(context.Background(), server.URL+"/slow", goracle.URLOptions{Timeout: 10 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
 	}
-
 }
 
-func TestFolderReference_InvalidFolderReturnsEmptyBytes(t *testing.T) {
+func TestURLReference_FailedFetchReturnsEmptyBytes(t *testing.T) {
 	t.Parallel()
-	got := goracle.Folder("invalid/path")
-	if len(got) != 0 {
-		t.Errorf("Expected empty bytes, got %s", string(got))
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	got := goracle.URL(server.URL + "/missing")
+	if len(got.Data) != 0 {
+		t.Errorf("expected empty bytes, got %s", got.Data)
 	}
 }
 
 func TestImageReference_ValidImageReturnsPNGEncodingasBytes(t *testing.T) {
 	t.Parallel()
 	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
-	got := goracle.Image(img)
+	got := goracle.Image(img).Data
 	want := []byte{137, 80, 78, 71, 13, 10, 26, 10}
 	if !bytes.Equal(got[:8], want) {
 		t.Errorf("Expected %v, got %v", want, got[:8])
@@ -323,7 +1967,7 @@ func TestImageReference_InvalidImageReturnsPNGEncodingasBytes(t *testing.T) {
 	t.Parallel()
 	img := image.NewRGBA64(image.Rect(0, 0, 100, 100))
 	img.Rect = image.Rect(0, 0, 0, -1)
-	got := goracle.Image(img)
+	got := goracle.Image(img).Data
 	want := []byte{}
 	if !bytes.Equal(got, want) {
 		t.Errorf("Expected %v, got %v", want, got[:8])
@@ -418,6 +2062,41 @@ func ExampleOracle_Ask_withoutConversationMemory() {
 	// Output: Nothing so far
 }
 
+func ExampleOracle_AskStream() {
+	// Render the answer as it arrives rather than waiting for all of it
+	c := client.NewDummyClient("A friendly LLM response!", nil)
+	o := goracle.NewOracle(c)
+	for chunk, err := range o.AskStream(context.Background(), "A user question") {
+		if err != nil {
+			panic(err)
+		}
+		fmt.Print(chunk)
+	}
+	fmt.Println()
+	// Output: A friendly LLM response!
+}
+
+func ExampleOracle_RegisterTool() {
+	// Let the model call back into your Go code while it works out an answer
+	c := client.NewDummyClient("It's 21 degrees and sunny in Perth", nil)
+	o := goracle.NewOracle(c)
+	type Query struct {
+		City string `json:"city" description:"The city to get the forecast for"`
+	}
+	err := o.RegisterTool("forecast", "Gets today's weather forecast", func(q Query) (string, error) {
+		return "21 degrees and sunny", nil
+	})
+	if err != nil {
+		panic(err)
+	}
+	answer, err := o.Ask("What's the weather like in Perth?")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(answer)
+	// Output: It's 21 degrees and sunny in Perth
+}
+
 func ExampleOracle_AskWithContext_withTimeout() {
 	// For when you want to limit the amount of time the LLM has to respond
 	c := client.NewDummyClient("A friendly LLM response!", nil)
@@ -431,3 +2110,378 @@ func ExampleOracle_AskWithContext_withTimeout() {
 	fmt.Println(answer)
 	// Output: A friendly LLM response!
 }
+
+func TestOllamaEmbedder_EmbedsTextsInBatchWithTheEmbedEndpoint(t *testing.T) {
+	t.Parallel()
+	var got struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/embed" {
+			http.NotFound(w, r)
+			return
+		}
+		err := json.NewDecoder(r.Body).Decode(&got)
+		if err != nil {
+			t.Error(err)
+		}
+		fmt.Fprint(w, `{"model":"nomic-embed-text","embeddings":[[0.6,0.8,0],[0,0,1]]}`)
+	}))
+	defer srv.Close()
+	e := goracle.NewOllamaEmbedder("nomic-embed-text", srv.URL)
+	if goracle.Dimensions(e) != 0 {
+		t.Errorf("expected unknown dimensions before embedding, got %d", goracle.Dimensions(e))
+	}
+	vectors, err := e.Embed(context.Background(), []string{"first", "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Model != "nomic-embed-text" || !cmp.Equal(got.Input, []string{"first", "second"}) {
+		t.Errorf("unexpected request %+v", got)
+	}
+	want := [][]float32{{0.6, 0.8, 0}, {0, 0, 1}}
+	if !cmp.Equal(want, vectors) {
+		t.Error(cmp.Diff(want, vectors))
+	}
+	if goracle.Dimensions(e) != 3 {
+		t.Errorf("expected 3 dimensions, got %d", goracle.Dimensions(e))
+	}
+}
+
+func TestOllamaEmbedder_ReportsStatusErrors(t *testing.T) {
+	t.Parallel()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+	}))
+	defer srv.Close()
+	_, err := goracle.NewOllamaEmbedder("missing", srv.URL).Embed(context.Background(), []string{"text"})
+	var statusErr *llm.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a 404 status error, got %v", err)
+	}
+}
+
+func TestChatGPTEmbedder_WithDimensionsOnlyShortensModelsThatAllowIt(t *testing.T) {
+	t.Parallel()
+	e := goracle.NewChatGPTEmbedder("token")
+	if goracle.Dimensions(e) != 1536 {
+		t.Errorf("expected 1536 dimensions, got %d", goracle.Dimensions(e))
+	}
+	err := e.WithDimensions(256)
+	if err != nil || goracle.Dimensions(e) != 256 {
+		t.Errorf("expected 256 dimensions, got %d, %v", goracle.Dimensions(e), err)
+	}
+	err = e.WithModel("text-embedding-ada-002")
+	if err != nil || goracle.Dimensions(e) != 1536 {
+		t.Errorf("expected switching models to reset the dimensions, got %d, %v", goracle.Dimensions(e), err)
+	}
+	err = e.WithDimensions(256)
+	if err == nil {
+		t.Error("expected an error shortening text-embedding-ada-002")
+	}
+}
+
+type fixedRetriever struct {
+	question string
+	refs     []goracle.Reference
+	err      error
+}
+
+func (r *fixedRetriever) Retrieve(ctx context.Context, question string) ([]goracle.Reference, error) {
+	r.question = question
+	return r.refs, r.err
+}
+
+func TestAskWithRetrieval_GivesRetrievedReferencesAheadOfOthers(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("Rottnest Island", nil)
+	chunk := goracle.Reference{
+		Name:     "notes.md:3-4",
+		Source:   "notes.md",
+		MIMEType: "text/plain; charset=utf-8",
+		Data:     []byte("Quokkas live on Rottnest Island."),
+	}
+	store := &fixedRetriever{refs: []goracle.Reference{chunk}}
+	answer, err := o.AskWithRetrieval("Where do quokkas live?", store, "extra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "Rottnest Island" || store.question != "Where do quokkas live?" {
+		t.Errorf("unexpected answer %q to question %q", answer, store.question)
+	}
+	want := []goracle.Reference{chunk, {MIMEType: "text/plain; charset=utf-8", Data: []byte("extra")}}
+	if got := c.P.GetReferences(); !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestAskWithRetrieval_ReportsRetrievalErrorsWithoutAsking(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("answer", nil)
+	_, err := o.AskWithRetrieval("question", &fixedRetriever{err: errors.New("index unavailable")})
+	if err == nil || !strings.Contains(err.Error(), "index unavailable") {
+		t.Errorf("expected the retrieval error, got %v", err)
+	}
+	if c.Calls != 0 {
+		t.Errorf("expected no completion to be requested, got %d", c.Calls)
+	}
+}
+
+// largeMaterial is four paragraphs of about 40 tokens each, the third of
+// which mentions quokkas.
+func largeMaterial() goracle.Reference {
+	paragraphs := []string{
+		strings.Repeat("Ferries leave Fremantle often. ", 5),
+		strings.Repeat("The lighthouse is tall and white. ", 5),
+		strings.Repeat("Quokkas live on Rottnest Island. ", 5),
+		strings.Repeat("Bicycles can be hired by the day. ", 5),
+	}
+	return goracle.Reference{
+		Name: "guide.md",
+		Data: []byte(strings.Join(paragraphs, "\n\n")),
+	}
+}
+
+// largeModel takes notes on any part mentioning quokkas, fails on any part
+// mentioning fail, and combines notes by listing their names.
+func largeModel(calls *atomic.Int32) goracle.LanguageModel {
+	return goracle.LanguageModelFunc(func(ctx context.Context, p client.Prompt) (io.Reader, error) {
+		calls.Add(1)
+		var names []string
+		for _, r := range p.GetReferences() {
+			if strings.Contains(string(r.Data), "lighthouse") && strings.Contains(p.GetQuestion(), "fail") {
+				return nil, errors.New("part failed")
+			}
+			names = append(names, r.Name)
+		}
+		switch {
+		case strings.HasPrefix(p.GetQuestion(), "The references are notes"):
+			return strings.NewReader("combined " + strings.Join(names, "; ")), nil
+		case strings.Contains(string(p.GetReferences()[0].Data), "Quokkas"):
+			return strings.NewReader("Quokkas live on Rottnest, says " + names[0]), nil
+		default:
+			return strings.NewReader("NOTHING RELEVANT"), nil
+		}
+	})
+}
+
+func TestAskLarge_MapsOverPartsAndCombinesTheRelevantNotes(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int32
+	o := goracle.NewOracle(largeModel(&calls))
+	var progress []goracle.LargeProgress
+	opts := goracle.LargeOptions{
+		PartTokens: 60,
+		Workers:    2,
+		Progress: func(p goracle.LargeProgress) {
+			progress = append(progress, p)
+		},
+	}
+	answer, err := o.AskLarge("Where do quokkas live?", largeMaterial(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "combined notes on part 3 (guide.md:5)"
+	if answer != want {
+		t.Errorf("expected %q, got %q", want, answer)
+	}
+	if calls.Load() != 5 {
+		t.Errorf("expected 4 parts and a combining request, got %d requests", calls.Load())
+	}
+	if len(progress) != 4 || progress[3].Stage != "map" || progress[3].Done != 4 || progress[3].Total != 4 {
+		t.Errorf("expected progress for each of 4 parts, got %+v", progress)
+	}
+}
+
+func TestAskLarge_AnswersFromTheRestWhenAPartFails(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int32
+	o := goracle.NewOracle(largeModel(&calls))
+	answer, err := o.AskLarge("Where do quokkas live? Make the lighthouse part fail.", largeMaterial(), goracle.LargeOptions{PartTokens: 60})
+	var partial goracle.PartialError
+	if !errors.As(err, &partial) {
+		t.Fatalf("expected a PartialError, got %v", err)
+	}
+	if partial.Total != 4 || len(partial.Failed) != 1 || partial.Failed[0].Part != "guide.md:3" {
+		t.Errorf("expected part guide.md:3 of 4 to fail, got %+v", partial)
+	}
+	if answer != "combined notes on part 3 (guide.md:5)" {
+		t.Errorf("expected an answer from the other parts, got %q", answer)
+	}
+}
+
+func TestAskLarge_RefinesAnAnswerPartByPart(t *testing.T) {
+	t.Parallel()
+	var questions []string
+	model := goracle.LanguageModelFunc(func(ctx context.Context, p client.Prompt) (io.Reader, error) {
+		questions = append(questions, p.GetQuestion())
+		return strings.NewReader(fmt.Sprintf("answer %d", len(questions))), nil
+	})
+	o := goracle.NewOracle(model)
+	answer, err := o.AskLarge("Where do quokkas live?", largeMaterial(), goracle.LargeOptions{Strategy: goracle.Refine, PartTokens: 60})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "answer 4" || len(questions) != 4 {
+		t.Fatalf("expected 4 steps, got %q after %d", answer, len(questions))
+	}
+	if !strings.Contains(questions[3], "answer 3") || !strings.Contains(questions[3], "part 4 of 4") {
+		t.Errorf("expected the last step to refine the answer so far, got %q", questions[3])
+	}
+}
+
+func TestAskLarge_AsksOnceWhenTheReferencesFit(t *testing.T) {
+	t.Parallel()
+	o, c := createTestOracle("Rottnest", nil)
+	answer, err := o.AskLarge("Where do quokkas live?", largeMaterial())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer != "Rottnest" || c.Calls != 1 {
+		t.Errorf("expected a single request, got %q after %d", answer, c.Calls)
+	}
+	if got := c.P.GetReferences(); len(got) != 1 || got[0].Name != "guide.md" {
+		t.Errorf("expected the reference as given, got %+v", got)
+	}
+}
+
+func TestAskLarge_StopsTheWholeJobWhenTheContextEnds(t *testing.T) {
+	t.Parallel()
+	model := goracle.LanguageModelFunc(func(ctx context.Context, p client.Prompt) (io.Reader, error) {
+		return nil, ctx.Err()
+	})
+	o := goracle.NewOracle(model)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := o.AskLargeWithContext(ctx, "Where do quokkas live?", largeMaterial(), goracle.LargeOptions{PartTokens: 60})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the context's error, got %v", err)
+	}
+	var partial goracle.PartialError
+	if errors.As(err, &partial) {
+		t.Errorf("expected no partial answer, got %v", partial)
+	}
+}
+
+var templateFS = fstest.MapFS{
+	"review/code.tmpl": {Data: []byte(`{{/* version: 3 */}}
+{{define "purpose"}}You review {{.Language}} code.{{end}}
+{{define "example 1 input"}}x = x{{end}}
+{{define "example 1 output"}}This line does nothing.{{end}}
+Review this {{.Language}} code for {{.Focus}}.
+`)},
+	"summarise.tmpl": {Data: []byte(`Summarise {{range .Topics}}{{.}}, {{end}}in {{$.Words}} words.`)},
+	"README.md":      {Data: []byte("Not a template.")},
+}
+
+func TestLoadTemplatesFS_NamesTemplatesByPath(t *testing.T) {
+	t.Parallel()
+	lib, err := goracle.LoadTemplatesFS(templateFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"review/code", "summarise"}
+	if !cmp.Equal(want, lib.Names()) {
+		t.Error(cmp.Diff(want, lib.Names()))
+	}
+	_, err = lib.Template("missing")
+	if err == nil {
+		t.Error("expected an error for a missing template")
+	}
+}
+
+func TestTemplate_VersionsByCommentOrHash(t *testing.T) {
+	t.Parallel()
+	lib, err := goracle.LoadTemplatesFS(templateFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	review, _ := lib.Template("review/code")
+	if review.Version != "3" {
+		t.Errorf("expected version 3, got %q", review.Version)
+	}
+	a, _ := goracle.ParseTemplate("a", "Hello {{.Name}}")
+	b, _ := goracle.ParseTemplate("b", "Hello {{.Name}}!")
+	if !strings.HasPrefix(a.Version, "sha256:") || a.Version == b.Version {
+		t.Errorf("expected different hashed versions, got %q and %q", a.Version, b.Version)
+	}
+}
+
+func TestTemplate_ListsOnlyTopLevelVariables(t *testing.T) {
+	t.Parallel()
+	lib, err := goracle.LoadTemplatesFS(templateFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	summarise, _ := lib.Template("summarise")
+	want := []string{"Topics", "Words"}
+	if !cmp.Equal(want, summarise.Variables()) {
+		t.Error(cmp.Diff(want, summarise.Variables()))
+	}
+}
+
+func TestTemplate_RenderReportsMissingVariables(t *testing.T) {
+	t.Parallel()
+	lib, err := goracle.LoadTemplatesFS(templateFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	review, _ := lib.Template("review/code")
+	type reviewVars struct {
+		Language string
+	}
+	for _, vars := range []any{map[string]any{"Language": "Go"}, reviewVars{Language: "Go"}, nil} {
+		_, err = review.Render(vars)
+		var missing goracle.MissingVariablesError
+		if !errors.As(err, &missing) {
+			t.Fatalf("expected a MissingVariablesError for %#v, got %v", vars, err)
+		}
+		if !slices.Contains(missing.Missing, "Focus") {
+			t.Errorf("expected Focus to be missing, got %v", missing.Missing)
+		}
+	}
+}
+
+func TestAskTemplate_RendersPromptAndRecordsTemplate(t *testing.T) {
+	t.Parallel()
+	lib, err := goracle.LoadTemplatesFS(templateFS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	review, _ := lib.Template("review/code")
+	o, c := createTestOracle("Looks fine", nil)
+	_, err = o.AskTemplate(review, struct{ Language, Focus string }{"Go", "races"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := goracle.Prompt{
+		Purpose:         "You review Go code.",
+		InputHistory:    []string{"x = x"},
+		OutputHistory:   []string{"This line does nothing."},
+		Question:        "Review this Go code for races.",
+		Template:        "review/code",
+		TemplateVersion: "3",
+	}
+	if !cmp.Equal(want, c.P) {
+		t.Error(cmp.Diff(want, c.P))
+	}
+}
+
+func TestLogging_RecordsTheTemplate(t *testing.T) {
+	t.Parallel()
+	buf := new(bytes.Buffer)
+	greet, err := goracle.ParseTemplate("greet", "{{/* version: 2 */}}Say hello to {{.Name}}.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, _ := createTestOracle("Hello", nil)
+	o.Use(goracle.Logging(slog.New(slog.NewTextHandler(buf, nil))))
+	_, err = o.AskTemplate(greet, map[string]string{"Name": "Ada"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "template=greet template_version=2") {
+		t.Errorf("expected the template to be logged, got %s", buf.String())
+	}
+}
@@ -0,0 +1,96 @@
+package goracle
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+// TranscriptVersion is the version of the transcript format written by
+// [*Oracle.Save]. [Load] rejects transcripts from newer versions.
+const TranscriptVersion = 1
+
+// transcript is the on-disk form of an Oracle's conversation.
+type transcript struct {
+	Version        int         `json:"version"`
+	Purpose        string      `json:"purpose"`
+	Stateful       bool        `json:"stateful"`
+	ResponseFormat []string    `json:"response_format,omitempty"`
+	Options        llm.Options `json:"options"`
+	MaxSteps       int         `json:"max_steps"`
+	RepairAttempts int         `json:"repair_attempts"`
+	ContextWindow  int         `json:"context_window,omitempty"`
+	Budget         float64     `json:"budget,omitempty"`
+	Usage          llm.Usage   `json:"usage"`
+	History        []message   `json:"history"`
+}
+
+type message struct {
+	Input   string `json:"input"`
+	Output  string `json:"output"`
+	Example bool   `json:"example,omitempty"`
+}
+
+// Save writes the Oracle's purpose, response format, stateful flag, settings
+// and history of examples and conversation turns to w as a versioned JSON
+// transcript, so that the conversation can be resumed later with [Load]. The
+// settings are the generation options, step and repair limits, context
+// window, budget and the usage counted against it. The client, middleware,
+// history policy and any registered tools are not saved, as they can't be
+// meaningfully serialised.
+func (o *Oracle) Save(w io.Writer) error {
+	o.mu.Lock()
+	t := transcript{
+		Version:        TranscriptVersion,
+		Purpose:        o.purpose,
+		Stateful:       o.stateful,
+		ResponseFormat: o.responseFormat,
+		Options:        o.options,
+		MaxSteps:       o.maxSteps,
+		RepairAttempts: o.repairAttempts,
+		ContextWindow:  o.contextWindow,
+		Budget:         o.budget,
+		Usage:          o.usage,
+		History:        []message{},
+	}
+	for _, e := range o.history {
+		t.History = append(t.History, message{Input: e.Input, Output: e.Output, Example: e.Example})
+	}
+	o.mu.Unlock()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(t)
+}
+
+// Load reads a transcript written by [*Oracle.Save] and returns an Oracle that
+// resumes the conversation using the given client, with the same settings and
+// its history in the same order.
+func Load(r io.Reader, client LanguageModel) (*Oracle, error) {
+	t := transcript{
+		MaxSteps:       defaultMaxSteps,
+		RepairAttempts: defaultRepairAttempts,
+	}
+	err := json.NewDecoder(r).Decode(&t)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transcript: %w", err)
+	}
+	if t.Version < 1 || t.Version > TranscriptVersion {
+		return nil, fmt.Errorf("unsupported transcript version %d", t.Version)
+	}
+	o := NewOracle(client)
+	o.purpose = t.Purpose
+	o.stateful = t.Stateful
+	o.responseFormat = t.ResponseFormat
+	o.options = t.Options
+	o.maxSteps = t.MaxSteps
+	o.repairAttempts = t.RepairAttempts
+	o.contextWindow = t.ContextWindow
+	o.budget = t.Budget
+	o.usage = t.Usage
+	for _, m := range t.History {
+		o.history = append(o.history, Exchange{Input: m.Input, Output: m.Output, Example: m.Example})
+	}
+	return o, nil
+}
@@ -0,0 +1,37 @@
+// Package rag finds the parts of a body of text most relevant to a question,
+// so that only those need be given to the model. Text is split into
+// [Chunk]s, which an [Index] embeds and searches by cosine similarity. Where
+// there's no embedding model, a [KeywordIndex] ranks chunks by BM25 instead,
+// and a [HybridIndex] blends the two. Each is a [goracle.Retriever], for use
+// with [*goracle.Oracle.AskWithRetrieval].
+package rag
+
+import (
+	"github.com/mr-joshcrane/goracle"
+	"github.com/mr-joshcrane/goracle/internal/chunk"
+)
+
+// Chunk is a piece of a larger text, along with where it came from. Its Name
+// gives the source and lines, such as "main.go:10-24", and its Reference
+// gives it to the model under that name.
+type Chunk = chunk.Chunk
+
+// ChunkOptions controls how text is split. Zero fields take their defaults:
+// chunks of up to 400 tokens, with no overlap.
+type ChunkOptions = chunk.Options
+
+// Split divides text into chunks of up to the maximum size, breaking it
+// between paragraphs where possible. Fenced code blocks are kept whole
+// unless they are too large for a chunk on their own, in which case they,
+// like overlong paragraphs, are broken between lines.
+func Split(source, text string, opts ChunkOptions) []Chunk {
+	return chunk.Split(source, text, opts)
+}
+
+// SplitReference divides a reference into chunks. The files of a
+// [goracle.Folder] are split separately, each with its own path as their
+// source, and PDF documents are split by their text. Other references that
+// aren't text, such as images, give an error.
+func SplitReference(ref goracle.Reference, opts ChunkOptions) ([]Chunk, error) {
+	return chunk.SplitReference(ref, opts)
+}
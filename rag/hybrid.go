@@ -0,0 +1,159 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/mr-joshcrane/goracle"
+)
+
+const defaultVectorWeight = 0.5
+
+// HybridIndex ranks chunks by both their meaning, using an [Index], and the
+// words they share with the query, using a [KeywordIndex], so that exact
+// names and identifiers are found as well as paraphrases. It is safe for
+// concurrent use.
+type HybridIndex struct {
+	mu      sync.Mutex
+	vector  *Index
+	keyword *KeywordIndex
+	weight  float32
+	limit   int
+	budget  int
+}
+
+// NewHybridIndex searches the chunks of vector by keyword too. Chunks
+// already in vector, such as those of an index restored with [Load], are
+// indexed by keyword without being embedded again.
+func NewHybridIndex(vector *Index) *HybridIndex {
+	return &HybridIndex{
+		vector:  vector,
+		keyword: NewKeywordIndex(),
+		weight:  defaultVectorWeight,
+		limit:   defaultLimit,
+	}
+}
+
+// SetVectorWeight sets how much similarity of meaning counts towards a
+// chunk's score, from 0 for keywords alone to 1 for meaning alone. The
+// default is 0.5.
+func (h *HybridIndex) SetVectorWeight(weight float32) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.weight = min(1, max(0, weight))
+}
+
+// SetLimit sets how many chunks [*HybridIndex.Retrieve] returns. The default
+// is 5.
+func (h *HybridIndex) SetLimit(n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.limit = n
+}
+
+// SetTokenBudget limits the tokens [*HybridIndex.Retrieve] returns in all,
+// leaving out chunks that would go over. Zero means no limit.
+func (h *HybridIndex) SetTokenBudget(tokens int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.budget = tokens
+}
+
+// Len returns the number of chunks in the index.
+func (h *HybridIndex) Len() int {
+	return h.vector.Len()
+}
+
+// Add embeds chunks and adds them to the index.
+func (h *HybridIndex) Add(ctx context.Context, chunks ...Chunk) error {
+	err := h.vector.Add(ctx, chunks...)
+	if err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.catchUp()
+	return nil
+}
+
+// AddText splits text into chunks, named after source, and adds them.
+func (h *HybridIndex) AddText(ctx context.Context, source, text string, opts ChunkOptions) error {
+	return h.Add(ctx, Split(source, text, opts)...)
+}
+
+// AddReference splits a reference into chunks, as [SplitReference] does, and
+// adds them.
+func (h *HybridIndex) AddReference(ctx context.Context, ref goracle.Reference, opts ChunkOptions) error {
+	chunks, err := SplitReference(ref, opts)
+	if err != nil {
+		return err
+	}
+	return h.Add(ctx, chunks...)
+}
+
+// catchUp indexes by keyword any chunks added to the vector index since it
+// was last called. The caller must hold the lock.
+func (h *HybridIndex) catchUp() {
+	h.keyword.Add(h.vector.chunksFrom(h.keyword.Len())...)
+}
+
+// Search returns the k chunks best matching query, best first. Keyword
+// scores are scaled so that the best match scores 1, and blended with the
+// cosine similarity of each chunk, counting dissimilar chunks as 0.
+func (h *HybridIndex) Search(ctx context.Context, query string, k int) ([]Result, error) {
+	vectors, err := h.vector.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("embedding query: %w", err)
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("embedding query: asked for 1 embedding, got %d", len(vectors))
+	}
+	h.mu.Lock()
+	h.catchUp()
+	weight := h.weight
+	h.mu.Unlock()
+
+	h.vector.mu.RLock()
+	defer h.vector.mu.RUnlock()
+	h.keyword.mu.RLock()
+	defer h.keyword.mu.RUnlock()
+	n := min(len(h.vector.chunks), len(h.keyword.chunks))
+	if n == 0 || k <= 0 {
+		return nil, nil
+	}
+	similarity, err := h.vector.scores(vectors[0])
+	if err != nil {
+		return nil, err
+	}
+	keyword := h.keyword.scores(query)
+	best := slices.Max(keyword[:n])
+	results := make([]Result, n)
+	for i := range n {
+		score := weight * max(0, similarity[i])
+		if best > 0 {
+			score += (1 - weight) * keyword[i] / best
+		}
+		results[i] = Result{Chunk: h.vector.chunks[i], Score: score}
+	}
+	slices.SortStableFunc(results, func(a, b Result) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	return results[:min(k, len(results))], nil
+}
+
+// Retrieve returns the chunks best matching question as references, within
+// the index's limit and token budget. It makes a HybridIndex a
+// [goracle.Retriever].
+func (h *HybridIndex) Retrieve(ctx context.Context, question string) ([]goracle.Reference, error) {
+	h.mu.Lock()
+	limit, budget := h.limit, h.budget
+	h.mu.Unlock()
+	results, err := h.Search(ctx, question, h.Len())
+	if err != nil {
+		return nil, err
+	}
+	return selectReferences(results, limit, budget), nil
+}
@@ -0,0 +1,278 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/mr-joshcrane/goracle"
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+const defaultLimit = 5
+
+// Index holds chunks of text along with their embeddings, and finds those
+// closest in meaning to a query. It is safe for concurrent use.
+type Index struct {
+	mu         sync.RWMutex
+	embedder   goracle.Embedder
+	chunks     []Chunk
+	vectors    [][]float32
+	dimensions int
+	limit      int
+	budget     int
+}
+
+// Result is a chunk found by a search, with how well it matched the query:
+// its cosine similarity for an [Index], its BM25 score for a [KeywordIndex],
+// or a blend of the two for a [HybridIndex].
+type Result struct {
+	Chunk
+	Score float32
+}
+
+// NewIndex returns an empty Index that embeds its chunks and queries with e.
+func NewIndex(e goracle.Embedder) *Index {
+	return &Index{
+		embedder: e,
+		limit:    defaultLimit,
+	}
+}
+
+// SetLimit sets how many chunks [*Index.Retrieve] returns. The default is 5.
+func (i *Index) SetLimit(k int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.limit = k
+}
+
+// SetTokenBudget limits the tokens [*Index.Retrieve] returns in all, leaving
+// out chunks that would go over. Zero means no limit.
+func (i *Index) SetTokenBudget(tokens int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.budget = tokens
+}
+
+// Len returns the number of chunks in the index.
+func (i *Index) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return len(i.chunks)
+}
+
+// Add embeds chunks and adds them to the index.
+func (i *Index) Add(ctx context.Context, chunks ...Chunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+	texts := make([]string, len(chunks))
+	for n, c := range chunks {
+		texts[n] = c.Text
+	}
+	vectors, err := i.embedder.Embed(ctx, texts)
+	if err != nil {
+		return fmt.Errorf("embedding chunks: %w", err)
+	}
+	if len(vectors) != len(chunks) {
+		return fmt.Errorf("embedding chunks: asked for %d embeddings, got %d", len(chunks), len(vectors))
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for n, v := range vectors {
+		if i.dimensions == 0 {
+			i.dimensions = len(v)
+		}
+		if len(v) != i.dimensions {
+			return fmt.Errorf("embedding of %s has %d dimensions, not %d", chunks[n].Name(), len(v), i.dimensions)
+		}
+	}
+	for n, v := range vectors {
+		i.chunks = append(i.chunks, chunks[n])
+		i.vectors = append(i.vectors, llm.Normalize(v))
+	}
+	return nil
+}
+
+// AddText splits text into chunks, named after source, and adds them.
+func (i *Index) AddText(ctx context.Context, source, text string, opts ChunkOptions) error {
+	return i.Add(ctx, Split(source, text, opts)...)
+}
+
+// AddReference splits a reference into chunks, as [SplitReference] does, and
+// adds them.
+func (i *Index) AddReference(ctx context.Context, ref goracle.Reference, opts ChunkOptions) error {
+	chunks, err := SplitReference(ref, opts)
+	if err != nil {
+		return err
+	}
+	return i.Add(ctx, chunks...)
+}
+
+// Search returns the k chunks most similar to query, most similar first.
+func (i *Index) Search(ctx context.Context, query string, k int) ([]Result, error) {
+	vectors, err := i.embedder.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("embedding query: %w", err)
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("embedding query: asked for 1 embedding, got %d", len(vectors))
+	}
+	return i.SearchVector(vectors[0], k)
+}
+
+// SearchVector returns the k chunks most similar to an embedded query, most
+// similar first.
+func (i *Index) SearchVector(query []float32, k int) ([]Result, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	if len(i.chunks) == 0 || k <= 0 {
+		return nil, nil
+	}
+	scores, err := i.scores(query)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]Result, len(i.chunks))
+	for n, score := range scores {
+		results[n] = Result{Chunk: i.chunks[n], Score: score}
+	}
+	slices.SortStableFunc(results, func(a, b Result) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	return results[:min(k, len(results))], nil
+}
+
+// scores gives the cosine similarity of every chunk to an embedded query, in
+// the order they were added. The caller must hold the lock.
+func (i *Index) scores(query []float32) ([]float32, error) {
+	if len(query) != i.dimensions {
+		return nil, fmt.Errorf("query has %d dimensions, not %d", len(query), i.dimensions)
+	}
+	query = llm.Normalize(query)
+	scores := make([]float32, len(i.vectors))
+	for n, v := range i.vectors {
+		var dot float32
+		for d := range v {
+			dot += v[d] * query[d]
+		}
+		scores[n] = dot
+	}
+	return scores, nil
+}
+
+// chunksFrom returns the chunks added after the first n.
+func (i *Index) chunksFrom(n int) []Chunk {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return slices.Clone(i.chunks[min(n, len(i.chunks)):])
+}
+
+// Retrieve returns the chunks most relevant to question as references, each
+// named after the source and lines it came from, within the index's limit
+// and token budget. It makes an Index a [goracle.Retriever].
+func (i *Index) Retrieve(ctx context.Context, question string) ([]goracle.Reference, error) {
+	i.mu.RLock()
+	limit, budget := i.limit, i.budget
+	i.mu.RUnlock()
+	results, err := i.Search(ctx, question, i.Len())
+	if err != nil {
+		return nil, err
+	}
+	return selectReferences(results, limit, budget), nil
+}
+
+// IndexVersion is the version of the format written by [*Index.Save].
+// [Load] rejects indexes from newer versions.
+const IndexVersion = 1
+
+// savedIndex is the on-disk form of an Index.
+type savedIndex struct {
+	Version    int          `json:"version"`
+	Dimensions int          `json:"dimensions"`
+	Chunks     []savedChunk `json:"chunks"`
+}
+
+type savedChunk struct {
+	Chunk
+	Vector []float32 `json:"vector"`
+}
+
+// Save writes the index's chunks and their embeddings to w as versioned
+// JSON, so that it can be restored with [Load] without embedding them again.
+func (i *Index) Save(w io.Writer) error {
+	i.mu.RLock()
+	s := savedIndex{
+		Version:    IndexVersion,
+		Dimensions: i.dimensions,
+		Chunks:     make([]savedChunk, len(i.chunks)),
+	}
+	for n, c := range i.chunks {
+		s.Chunks[n] = savedChunk{Chunk: c, Vector: i.vectors[n]}
+	}
+	i.mu.RUnlock()
+	return json.NewEncoder(w).Encode(s)
+}
+
+// Load reads an index written by [*Index.Save], which will embed queries
+// with e. The embedder must be the one the index was built with, or at least
+// return vectors of the same dimensions.
+func Load(r io.Reader, e goracle.Embedder) (*Index, error) {
+	var s savedIndex
+	err := json.NewDecoder(r).Decode(&s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid index: %w", err)
+	}
+	if s.Version < 1 || s.Version > IndexVersion {
+		return nil, fmt.Errorf("unsupported index version %d", s.Version)
+	}
+	if d := goracle.Dimensions(e); d != 0 && len(s.Chunks) > 0 && d != s.Dimensions {
+		return nil, fmt.Errorf("index has %d dimensions, but the embedder returns %d", s.Dimensions, d)
+	}
+	i := NewIndex(e)
+	i.dimensions = s.Dimensions
+	for _, c := range s.Chunks {
+		if len(c.Vector) != s.Dimensions {
+			return nil, fmt.Errorf("invalid index: embedding of %s has %d dimensions, not %d", c.Name(), len(c.Vector), s.Dimensions)
+		}
+		i.chunks = append(i.chunks, c.Chunk)
+		i.vectors = append(i.vectors, c.Vector)
+	}
+	return i, nil
+}
+
+// SaveFile saves the index to the file at path, replacing it only once the
+// index has been written in full.
+func (i *Index) SaveFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = i.Save(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadFile loads an index saved to the file at path by [*Index.SaveFile].
+func LoadFile(path string, e goracle.Embedder) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f, e)
+}
@@ -0,0 +1,247 @@
+package rag
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/mr-joshcrane/goracle"
+)
+
+// BM25 parameters: how quickly repeated terms stop adding to a score, and
+// how much long chunks are penalised.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// KeywordIndex finds chunks by the words they share with a query, ranked by
+// BM25, so that references can be chosen without an embedding model. It is
+// safe for concurrent use.
+type KeywordIndex struct {
+	mu          sync.RWMutex
+	chunks      []Chunk
+	terms       []map[string]int
+	lengths     []int
+	totalLength int
+	docFreq     map[string]int
+	limit       int
+	budget      int
+}
+
+// NewKeywordIndex returns an empty KeywordIndex.
+func NewKeywordIndex() *KeywordIndex {
+	return &KeywordIndex{
+		docFreq: map[string]int{},
+		limit:   defaultLimit,
+	}
+}
+
+// SetLimit sets how many chunks [*KeywordIndex.Retrieve] returns. The default
+// is 5.
+func (ki *KeywordIndex) SetLimit(n int) {
+	ki.mu.Lock()
+	defer ki.mu.Unlock()
+	ki.limit = n
+}
+
+// SetTokenBudget limits the tokens [*KeywordIndex.Retrieve] returns in all,
+// leaving out chunks that would go over. Zero means no limit.
+func (ki *KeywordIndex) SetTokenBudget(tokens int) {
+	ki.mu.Lock()
+	defer ki.mu.Unlock()
+	ki.budget = tokens
+}
+
+// Len returns the number of chunks in the index.
+func (ki *KeywordIndex) Len() int {
+	ki.mu.RLock()
+	defer ki.mu.RUnlock()
+	return len(ki.chunks)
+}
+
+// Add adds chunks to the index.
+func (ki *KeywordIndex) Add(chunks ...Chunk) {
+	ki.mu.Lock()
+	defer ki.mu.Unlock()
+	for _, c := range chunks {
+		counts := map[string]int{}
+		words := terms(c.Text)
+		for _, w := range words {
+			counts[w]++
+		}
+		for w := range counts {
+			ki.docFreq[w]++
+		}
+		ki.chunks = append(ki.chunks, c)
+		ki.terms = append(ki.terms, counts)
+		ki.lengths = append(ki.lengths, len(words))
+		ki.totalLength += len(words)
+	}
+}
+
+// AddText splits text into chunks, named after source, and adds them.
+func (ki *KeywordIndex) AddText(source, text string, opts ChunkOptions) {
+	ki.Add(Split(source, text, opts)...)
+}
+
+// AddReference splits a reference into chunks, as [SplitReference] does, and
+// adds them.
+func (ki *KeywordIndex) AddReference(ref goracle.Reference, opts ChunkOptions) error {
+	chunks, err := SplitReference(ref, opts)
+	if err != nil {
+		return err
+	}
+	ki.Add(chunks...)
+	return nil
+}
+
+// Search returns up to k chunks sharing words with query, best match first.
+func (ki *KeywordIndex) Search(query string, k int) []Result {
+	ki.mu.RLock()
+	defer ki.mu.RUnlock()
+	if k <= 0 {
+		return nil
+	}
+	scores := ki.scores(query)
+	var results []Result
+	for i, score := range scores {
+		if score > 0 {
+			results = append(results, Result{Chunk: ki.chunks[i], Score: score})
+		}
+	}
+	slices.SortStableFunc(results, func(a, b Result) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	return results[:min(k, len(results))]
+}
+
+// Retrieve returns the chunks best matching question as references, within
+// the index's limit and token budget. It makes a KeywordIndex a
+// [goracle.Retriever].
+func (ki *KeywordIndex) Retrieve(ctx context.Context, question string) ([]goracle.Reference, error) {
+	ki.mu.RLock()
+	limit, budget := ki.limit, ki.budget
+	ki.mu.RUnlock()
+	return selectReferences(ki.Search(question, ki.Len()), limit, budget), nil
+}
+
+// scores gives the BM25 score of every chunk for query, in the order they
+// were added. The caller must hold the lock.
+func (ki *KeywordIndex) scores(query string) []float32 {
+	scores := make([]float32, len(ki.chunks))
+	if len(ki.chunks) == 0 {
+		return scores
+	}
+	n := float64(len(ki.chunks))
+	avgLength := float64(ki.totalLength) / n
+	if avgLength == 0 {
+		return scores
+	}
+	seen := map[string]bool{}
+	for _, w := range terms(query) {
+		if seen[w] || ki.docFreq[w] == 0 {
+			continue
+		}
+		seen[w] = true
+		df := float64(ki.docFreq[w])
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for i, counts := range ki.terms {
+			tf := float64(counts[w])
+			if tf == 0 {
+				continue
+			}
+			norm := 1 - bm25B + bm25B*float64(ki.lengths[i])/avgLength
+			scores[i] += float32(idf * tf * (bm25K1 + 1) / (tf + bm25K1*norm))
+		}
+	}
+	return scores
+}
+
+// stopWords are too common to say anything about which chunk is relevant.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "do": true, "does": true, "for": true,
+	"from": true, "how": true, "i": true, "if": true, "in": true,
+	"is": true, "it": true, "of": true, "on": true, "or": true,
+	"that": true, "the": true, "this": true, "to": true, "was": true,
+	"what": true, "when": true, "where": true, "which": true, "who": true,
+	"why": true, "with": true,
+}
+
+// terms breaks text into lower case words for matching, leaving out stop
+// words. Identifiers in camel case also give each of their words, so that
+// "RetryPolicy" matches "retry", and simple plurals are made singular.
+func terms(text string) []string {
+	var result []string
+	add := func(word string) {
+		word = strings.ToLower(word)
+		if stopWords[word] {
+			return
+		}
+		result = append(result, singular(word))
+	}
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		add(word)
+		parts := camelParts(word)
+		if len(parts) > 1 {
+			for _, p := range parts {
+				add(p)
+			}
+		}
+	}
+	return result
+}
+
+// camelParts splits an identifier such as "parseHTTPResponse" into "parse",
+// "HTTP" and "Response".
+func camelParts(word string) []string {
+	runes := []rune(word)
+	var parts []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		lowerToUpper := unicode.IsLower(runes[i-1]) && unicode.IsUpper(runes[i])
+		acronymEnd := i+1 < len(runes) && unicode.IsUpper(runes[i-1]) && unicode.IsUpper(runes[i]) && unicode.IsLower(runes[i+1])
+		if lowerToUpper || acronymEnd {
+			parts = append(parts, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(parts, string(runes[start:]))
+}
+
+// singular strips the "s" from simple plurals, such as "quokkas".
+func singular(word string) string {
+	if len(word) > 3 && strings.HasSuffix(word, "s") &&
+		!strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us") && !strings.HasSuffix(word, "is") {
+		return word[:len(word)-1]
+	}
+	return word
+}
+
+// selectReferences takes results, best first, up to limit of them and within
+// budget tokens in all, skipping any that would go over the budget. Zero
+// budget means no limit.
+func selectReferences(results []Result, limit int, budget int) []goracle.Reference {
+	refs := []goracle.Reference{}
+	used := 0
+	for _, r := range results {
+		if len(refs) == limit {
+			break
+		}
+		tokens := goracle.EstimateTokens(r.Text)
+		if budget > 0 && used+tokens > budget {
+			continue
+		}
+		used += tokens
+		refs = append(refs, r.Reference())
+	}
+	return refs
+}
@@ -0,0 +1,349 @@
+package rag_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/fnv"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mr-joshcrane/goracle"
+	"github.com/mr-joshcrane/goracle/rag"
+)
+
+// wordEmbedder embeds texts by counting their words, each of which lands in
+// one of 64 dimensions.
+type wordEmbedder struct {
+	calls int
+}
+
+func (e *wordEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, 64)
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			h := fnv.New32a()
+			h.Write([]byte(strings.Trim(word, ".,?!")))
+			vectors[i][h.Sum32()%64]++
+		}
+	}
+	return vectors, nil
+}
+
+func (e *wordEmbedder) Dimensions() int {
+	return 64
+}
+
+func TestSplit_PacksParagraphsAndKeepsCodeBlocksWhole(t *testing.T) {
+	t.Parallel()
+	text := "First paragraph here.\n" +
+		"Still the first.\n" +
+		"\n" +
+		"Second paragraph.\n" +
+		"\n" +
+		"```go\n" +
+		"func main() {\n" +
+		"\n" +
+		"}\n" +
+		"```\n"
+	got := rag.Split("notes.md", text, rag.ChunkOptions{MaxTokens: 10})
+	want := []rag.Chunk{
+		{Source: "notes.md", StartLine: 1, EndLine: 2, Text: "First paragraph here.\nStill the first."},
+		{Source: "notes.md", StartLine: 4, EndLine: 4, Text: "Second paragraph."},
+		{Source: "notes.md", StartLine: 6, EndLine: 10, Text: "```go\nfunc main() {\n\n}\n```"},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	if got[0].Name() != "notes.md:1-2" || got[1].Name() != "notes.md:4" {
+		t.Errorf("unexpected names %q and %q", got[0].Name(), got[1].Name())
+	}
+}
+
+func TestSplit_BreaksOverlongParagraphsBetweenLines(t *testing.T) {
+	t.Parallel()
+	text := strings.Repeat("0123456789abcdef\n", 10)
+	got := rag.Split("data.txt", text, rag.ChunkOptions{MaxTokens: 10})
+	if len(got) < 2 {
+		t.Fatalf("expected the paragraph to be split, got %d chunks", len(got))
+	}
+	for _, c := range got {
+		if tokens := goracle.EstimateTokens(c.Text); tokens > 10 {
+			t.Errorf("chunk %s has %d tokens, more than 10", c.Name(), tokens)
+		}
+	}
+	if got[0].StartLine != 1 || got[len(got)-1].EndLine != 10 {
+		t.Errorf("expected chunks to cover lines 1-10, got %s to %s", got[0].Name(), got[len(got)-1].Name())
+	}
+}
+
+func TestSplit_OverlapRepeatsTrailingParagraphs(t *testing.T) {
+	t.Parallel()
+	text := "alpha alpha\n\nbravo bravo\n\ncharlie charlie\n"
+	got := rag.Split("a.txt", text, rag.ChunkOptions{MaxTokens: 8, Overlap: 3})
+	want := []rag.Chunk{
+		{Source: "a.txt", StartLine: 1, EndLine: 3, Text: "alpha alpha\n\nbravo bravo"},
+		{Source: "a.txt", StartLine: 3, EndLine: 5, Text: "bravo bravo\n\ncharlie charlie"},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+}
+
+func TestSplitReference_SplitsFolderFilesSeparately(t *testing.T) {
+	t.Parallel()
+	ref := goracle.Reference{
+		Name:   ".",
+		Source: "project",
+		Data:   []byte("==> a.go <==\npackage a\n==> docs/b.md <==\n# B\n\nAbout b.\n"),
+	}
+	got, err := rag.SplitReference(ref, rag.ChunkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []rag.Chunk{
+		{Source: "project/a.go", StartLine: 1, EndLine: 1, Text: "package a"},
+		{Source: "project/docs/b.md", StartLine: 1, EndLine: 3, Text: "# B\n\nAbout b."},
+	}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	_, err = rag.SplitReference(goracle.Reference{Data: []byte{0xff, 0xfe}}, rag.ChunkOptions{})
+	if err == nil {
+		t.Error("expected an error splitting data that isn't text")
+	}
+}
+
+func TestIndex_RetrievesTheMostSimilarChunksWithTheirProvenance(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	index := rag.NewIndex(&wordEmbedder{})
+	err := index.Add(ctx,
+		rag.Chunk{Source: "pets.md", StartLine: 1, EndLine: 2, Text: "Cats purr and sleep all day."},
+		rag.Chunk{Source: "cars.md", StartLine: 4, EndLine: 9, Text: "Engines need oil changes."},
+		rag.Chunk{Source: "pets.md", StartLine: 5, EndLine: 5, Text: "Dogs bark at the postman."},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := index.Search(ctx, "why do engines need oil", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Source != "cars.md" {
+		t.Fatalf("expected cars.md first, got %+v", results)
+	}
+	if results[0].Score <= results[1].Score {
+		t.Errorf("expected results ordered by score, got %v then %v", results[0].Score, results[1].Score)
+	}
+	index.SetLimit(1)
+	refs, err := index.Retrieve(ctx, "do cats purr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []goracle.Reference{{
+		Name:     "pets.md:1-2",
+		Source:   "pets.md",
+		MIMEType: "text/plain; charset=utf-8",
+		Data:     []byte("Cats purr and sleep all day."),
+	}}
+	if !cmp.Equal(want, refs) {
+		t.Error(cmp.Diff(want, refs))
+	}
+}
+
+func TestIndex_SavesAndLoadsWithoutEmbeddingAgain(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	embedder := &wordEmbedder{}
+	index := rag.NewIndex(embedder)
+	err := index.AddText(ctx, "notes.md", "Quokkas live on Rottnest Island.\n\nWombats dig burrows.\n", rag.ChunkOptions{MaxTokens: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "index.json")
+	err = index.SaveFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	embedder.calls = 0
+	loaded, err := rag.LoadFile(path, embedder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Len() != 2 || embedder.calls != 0 {
+		t.Fatalf("expected 2 chunks loaded without embedding, got %d after %d calls", loaded.Len(), embedder.calls)
+	}
+	results, err := loaded.Search(ctx, "where do quokkas live", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Name() != "notes.md:1" {
+		t.Errorf("expected the quokka chunk, got %+v", results)
+	}
+}
+
+func TestLoad_RejectsUnknownVersionsAndMismatchedEmbedders(t *testing.T) {
+	t.Parallel()
+	_, err := rag.Load(strings.NewReader(`{"version":99}`), &wordEmbedder{})
+	if err == nil {
+		t.Error("expected an error loading a newer version")
+	}
+	buf := new(bytes.Buffer)
+	err = rag.NewIndex(&wordEmbedder{}).Save(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rag.Load(strings.NewReader(`{"version":1,"dimensions":3,"chunks":[{"source":"a","text":"a","vector":[1,0,0]}]}`), &wordEmbedder{})
+	if err == nil {
+		t.Error("expected an error loading an index of different dimensions")
+	}
+	_, err = rag.Load(buf, &wordEmbedder{})
+	if err != nil {
+		t.Errorf("expected an empty index to load, got %v", err)
+	}
+}
+
+type failingEmbedder struct{}
+
+func (failingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return nil, errors.New("embedding service down")
+}
+
+func TestIndex_AddReportsEmbeddingErrors(t *testing.T) {
+	t.Parallel()
+	err := rag.NewIndex(failingEmbedder{}).AddText(context.Background(), "a", "text", rag.ChunkOptions{})
+	if err == nil || !strings.Contains(err.Error(), "embedding service down") {
+		t.Errorf("expected the embedding error, got %v", err)
+	}
+}
+
+func TestKeywordIndex_RanksRareTermsAboveCommonOnes(t *testing.T) {
+	t.Parallel()
+	index := rag.NewKeywordIndex()
+	index.Add(
+		rag.Chunk{Source: "a.md", Text: "The island has beaches and the island has ferries."},
+		rag.Chunk{Source: "b.md", Text: "Quokkas live on the island."},
+		rag.Chunk{Source: "c.md", Text: "Ferries leave from Fremantle."},
+	)
+	results := index.Search("Which island do quokkas live on?", 3)
+	if len(results) != 2 {
+		t.Fatalf("expected the 2 chunks mentioning the query's words, got %+v", results)
+	}
+	if results[0].Source != "b.md" {
+		t.Errorf("expected the chunk about quokkas first, got %s", results[0].Source)
+	}
+}
+
+func TestKeywordIndex_MatchesWordsWithinIdentifiers(t *testing.T) {
+	t.Parallel()
+	index := rag.NewKeywordIndex()
+	index.AddText("retry.go", "func (p RetryPolicy) backoff(attempt int) time.Duration {}\n", rag.ChunkOptions{})
+	index.AddText("cache.go", "func NewDiskStore(dir string) (*DiskStore, error) {}\n", rag.ChunkOptions{})
+	results := index.Search("retry policies", 1)
+	if len(results) != 1 || results[0].Source != "retry.go" {
+		t.Errorf("expected retry.go, got %+v", results)
+	}
+}
+
+func TestKeywordIndex_SearchReturnsNothingForNonPositiveK(t *testing.T) {
+	t.Parallel()
+	index := rag.NewKeywordIndex()
+	index.AddText("retry.go", "func (p RetryPolicy) backoff(attempt int) time.Duration {}\n", rag.ChunkOptions{})
+	for _, k := range []int{0, -1} {
+		if results := index.Search("retry", k); len(results) != 0 {
+			t.Errorf("expected no results for k=%d, got %+v", k, results)
+		}
+	}
+}
+
+func TestKeywordIndex_RetrieveKeepsWithinTheTokenBudget(t *testing.T) {
+	t.Parallel()
+	index := rag.NewKeywordIndex()
+	index.Add(
+		rag.Chunk{Source: "long.md", Text: "quokka " + strings.Repeat("filler words here ", 20)},
+		rag.Chunk{Source: "short.md", Text: "A quokka smiles."},
+		rag.Chunk{Source: "other.md", Text: "A quokka hops."},
+	)
+	index.SetTokenBudget(10)
+	refs, err := index.Retrieve(context.Background(), "quokka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range refs {
+		got = append(got, r.Source)
+	}
+	want := []string{"short.md", "other.md"}
+	if !cmp.Equal(want, got) {
+		t.Error(cmp.Diff(want, got))
+	}
+	index.SetLimit(1)
+	refs, err = index.Retrieve(context.Background(), "quokka")
+	if err != nil || len(refs) != 1 {
+		t.Errorf("expected 1 reference within the limit, got %d, %v", len(refs), err)
+	}
+}
+
+// conceptEmbedder embeds texts by the concepts they mention, knowing nothing
+// of identifiers.
+type conceptEmbedder struct{}
+
+func (conceptEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	concepts := [][]string{{"engine", "vehicle", "car"}, {"cat", "dog", "pet"}}
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float32, len(concepts))
+		for d, words := range concepts {
+			for _, w := range words {
+				if strings.Contains(strings.ToLower(text), w) {
+					vectors[i][d]++
+				}
+			}
+		}
+	}
+	return vectors, nil
+}
+
+func TestHybridIndex_BlendsKeywordAndVectorScores(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	vector := rag.NewIndex(conceptEmbedder{})
+	err := vector.Add(ctx,
+		rag.Chunk{Source: "engines.md", Text: "Engines need oil."},
+		rag.Chunk{Source: "retry.go", Text: "RetryPolicy controls the backoff."},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hybrid := rag.NewHybridIndex(vector)
+	err = hybrid.Add(ctx, rag.Chunk{Source: "cats.md", Text: "Cats purr."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	question := "How does RetryPolicy treat a vehicle?"
+	hybrid.SetVectorWeight(1)
+	results, err := hybrid.Search(ctx, question, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Source != "engines.md" {
+		t.Errorf("expected meaning alone to find engines.md, got %s", results[0].Source)
+	}
+	hybrid.SetVectorWeight(0.3)
+	hybrid.SetLimit(2)
+	refs, err := hybrid.Retrieve(ctx, question)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refs) != 2 || refs[0].Source != "retry.go" || refs[1].Source != "engines.md" {
+		t.Errorf("expected retry.go then engines.md, got %+v", refs)
+	}
+	if hybrid.Len() != 3 {
+		t.Errorf("expected 3 chunks, got %d", hybrid.Len())
+	}
+}
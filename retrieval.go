@@ -0,0 +1,38 @@
+package goracle
+
+import (
+	"context"
+	"fmt"
+)
+
+// Retriever finds the material most relevant to a question, such as the
+// closest chunks of a [rag.Index], and returns it as references naming where
+// each piece came from.
+//
+// [rag.Index]: https://pkg.go.dev/github.com/mr-joshcrane/goracle/rag#Index
+type Retriever interface {
+	Retrieve(ctx context.Context, question string) ([]Reference, error)
+}
+
+// AskWithRetrieval is similar to [*Oracle.Ask], but first asks store for the
+// material most relevant to the question, and gives it to the model ahead of
+// any other references. Only what is retrieved is sent, rather than whole
+// documents or folders.
+func (o *Oracle) AskWithRetrieval(question string, store Retriever, references ...any) (string, error) {
+	return o.AskWithRetrievalContext(context.Background(), question, store, references...)
+}
+
+// AskWithRetrievalContext is similar to [*Oracle.AskWithRetrieval] but allows
+// for a context to be passed in.
+func (o *Oracle) AskWithRetrievalContext(ctx context.Context, question string, store Retriever, references ...any) (string, error) {
+	retrieved, err := store.Retrieve(ctx, question)
+	if err != nil {
+		return "", fmt.Errorf("retrieving references: %w", err)
+	}
+	refs := make([]any, 0, len(retrieved)+len(references))
+	for _, r := range retrieved {
+		refs = append(refs, r)
+	}
+	refs = append(refs, references...)
+	return o.AskWithContext(ctx, question, refs...)
+}
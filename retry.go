@@ -0,0 +1,112 @@
+package goracle
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/mr-joshcrane/goracle/client"
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+// RetryPolicy controls how the [Retry] middleware retries failed
+// completions. Zero fields take their defaults.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a completion is attempted, including the
+	// first. The default is 4.
+	MaxAttempts int
+	// BaseDelay is the wait before the first retry, which doubles with each
+	// further attempt. The default is 500ms.
+	BaseDelay time.Duration
+	// MaxDelay caps the wait between attempts, unless the provider asks for
+	// longer. The default is 30s.
+	MaxDelay time.Duration
+}
+
+const (
+	defaultMaxAttempts = 4
+	defaultBaseDelay   = 500 * time.Millisecond
+	defaultMaxDelay    = 30 * time.Second
+)
+
+// Retry returns middleware that retries completions which fail with a rate
+// limit, an overloaded or failing server, or a transient network error. It
+// backs off exponentially with jitter, but waits as long as the provider asks
+// when it gives a hint. It gives up early rather than wait past the context's
+// deadline.
+//
+// Only failures to start a completion are retried. Once an answer has begun
+// streaming, errors are passed on to the reader.
+func Retry(policy RetryPolicy) Middleware {
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = defaultMaxAttempts
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = defaultBaseDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = defaultMaxDelay
+	}
+	return func(next LanguageModel) LanguageModel {
+		return LanguageModelFunc(func(ctx context.Context, prompt client.Prompt) (io.Reader, error) {
+			for attempt := 1; ; attempt++ {
+				data, err := next.Completion(ctx, prompt)
+				if err == nil || attempt >= policy.MaxAttempts || !retryable(ctx, err) {
+					return data, err
+				}
+				delay := policy.backoff(attempt)
+				var status *llm.StatusError
+				if errors.As(err, &status) && status.RetryAfter > 0 {
+					delay = status.RetryAfter
+				}
+				if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+					return nil, err
+				}
+				timer := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					timer.Stop()
+					return nil, errors.Join(err, ctx.Err())
+				case <-timer.C:
+				}
+			}
+		})
+	}
+}
+
+// backoff returns the wait before the given retry: an exponentially growing
+// delay, capped at MaxDelay, with up to half of it taken off at random so
+// that many clients don't retry in lockstep.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.MaxDelay
+	if shift := attempt - 1; shift < 32 {
+		delay = min(p.BaseDelay<<shift, p.MaxDelay)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// retryable reports whether err is worth retrying.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var status *llm.StatusError
+	if errors.As(err, &status) {
+		return status.Temporary()
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
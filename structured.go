@@ -0,0 +1,136 @@
+package goracle
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// defaultRepairAttempts is how many times a structured answer that fails
+// validation is sent back to the model for correction.
+const defaultRepairAttempts = 2
+
+// wrappedField holds non-object answers, as most providers insist that the
+// root of a structured response is an object.
+const wrappedField = "response"
+
+// SchemaError is returned by [AskInto] when the model's answer still fails to
+// match the requested schema after all repair attempts are used up.
+type SchemaError struct {
+	Answer   string
+	Problems []string
+}
+
+func (e SchemaError) Error() string {
+	return fmt.Sprintf("answer does not match schema: %s", strings.Join(e.Problems, "; "))
+}
+
+// SetRepairAttempts sets how many times [AskInto] re-asks the model, along
+// with the validation errors, when an answer doesn't match the schema.
+// The default is 2.
+func (o *Oracle) SetRepairAttempts(attempts int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.repairAttempts = attempts
+}
+
+// AskInto asks the Oracle a question and decodes the answer into a T. A JSON
+// schema derived from T, including nested structs, slices, numbers and enums
+// (see the `description` and `enum` struct tags), is handed to the provider's
+// native JSON mode. The answer is validated against the schema, and the model
+// is asked to repair invalid answers before giving up with a [SchemaError].
+func AskInto[T any](o *Oracle, question string, references ...any) (T, error) {
+	return AskIntoWithContext[T](context.Background(), o, question, references...)
+}
+
+// AskIntoWithContext is similar to [AskInto] but allows for a context to be passed in.
+func AskIntoWithContext[T any](ctx context.Context, o *Oracle, question string, references ...any) (T, error) {
+	var result T
+	schema := schemaFor(reflect.TypeOf(&result).Elem())
+	wrapped := schema["type"] != "object"
+	if wrapped {
+		schema = map[string]any{
+			"type":                 "object",
+			"properties":           map[string]any{wrappedField: schema},
+			"required":             []string{wrappedField},
+			"additionalProperties": false,
+		}
+	}
+	p, err := o.prompt(ctx, question, references...)
+	if err != nil {
+		return result, err
+	}
+	p.ResponseSchema = schema
+	o.mu.Lock()
+	repairAttempts := o.repairAttempts
+	o.mu.Unlock()
+	ask := p
+	for attempt := 0; ; attempt++ {
+		answer, err := o.generate(ctx, ask, func(string) bool { return true })
+		if err != nil {
+			return result, err
+		}
+		answer = trimCodeFence(answer)
+		problems := decodeInto(schema, answer, wrapped, &result)
+		if len(problems) == 0 {
+			o.remember(question, answer)
+			return result, nil
+		}
+		if attempt >= repairAttempts {
+			return result, SchemaError{Answer: answer, Problems: problems}
+		}
+		ask.InputHistory = append(slices.Clone(ask.InputHistory), ask.Question)
+		ask.OutputHistory = append(slices.Clone(ask.OutputHistory), answer)
+		ask.Question = fmt.Sprintf(
+			"Your previous answer did not match the required JSON schema:\n- %s\nReply again with only the corrected JSON.",
+			strings.Join(problems, "\n- "),
+		)
+	}
+}
+
+// decodeInto validates answer against schema and, if it is valid, decodes it
+// into result. Any problems found are returned instead.
+func decodeInto(schema map[string]any, answer string, wrapped bool, result any) []string {
+	decoder := json.NewDecoder(strings.NewReader(answer))
+	decoder.UseNumber()
+	var value any
+	err := decoder.Decode(&value)
+	if err != nil {
+		return []string{fmt.Sprintf("answer is not valid JSON: %s", err)}
+	}
+	problems := validateSchema(schema, value, "")
+	if len(problems) > 0 {
+		return problems
+	}
+	data := []byte(answer)
+	if wrapped {
+		var envelope map[string]json.RawMessage
+		err = json.Unmarshal(data, &envelope)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		data = envelope[wrappedField]
+	}
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	return nil
+}
+
+// trimCodeFence strips the markdown code fence some models wrap JSON in, even
+// when asked not to.
+func trimCodeFence(answer string) string {
+	trimmed := strings.TrimSpace(answer)
+	if !strings.HasPrefix(trimmed, "```") {
+		return answer
+	}
+	trimmed = strings.TrimPrefix(trimmed, "```")
+	if i := strings.IndexByte(trimmed, '\n'); i >= 0 && !strings.ContainsAny(trimmed[:i], "{[") {
+		trimmed = trimmed[i+1:]
+	}
+	return strings.TrimSpace(strings.TrimSuffix(trimmed, "```"))
+}
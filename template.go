@@ -0,0 +1,357 @@
+package goracle
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// TemplateExt is the extension of the files [LoadTemplates] reads.
+const TemplateExt = ".tmpl"
+
+// Template renders a prompt from variables, using [text/template]. The body
+// of the template is the question. It may also define a purpose, and
+// examples given as pairs of inputs and outputs, numbered from 1:
+//
+//	{{/* version: 2 */}}
+//	{{define "purpose"}}You review {{.Language}} code.{{end}}
+//	{{define "example 1 input"}}x = x{{end}}
+//	{{define "example 1 output"}}This line does nothing.{{end}}
+//	Review this {{.Language}} code.
+//
+// The version comment is optional. Without it, the version is taken from a
+// hash of the template's text, so that any change gives a new one.
+type Template struct {
+	Name      string
+	Version   string
+	tmpl      *template.Template
+	variables []string
+}
+
+// RenderedTemplate is a [Template] filled in with its variables.
+type RenderedTemplate struct {
+	Name     string
+	Version  string
+	Purpose  string
+	Examples []Exchange
+	Question string
+}
+
+// MissingVariablesError is returned when a template is rendered without all
+// the variables it uses.
+type MissingVariablesError struct {
+	Template string
+	Missing  []string
+}
+
+func (e MissingVariablesError) Error() string {
+	return fmt.Sprintf("template %s is missing variables: %s", e.Template, strings.Join(e.Missing, ", "))
+}
+
+// versionComment declares a template's version.
+var versionComment = regexp.MustCompile(`^\s*{{-?\s*/\*\s*version:\s*(\S+)\s*\*/\s*-?}}`)
+
+// ParseTemplate parses the text of a template, giving it name.
+func ParseTemplate(name, text string) (*Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template %s: %w", name, err)
+	}
+	t := &Template{
+		Name: name,
+		tmpl: tmpl,
+	}
+	if m := versionComment.FindStringSubmatch(text); m != nil {
+		t.Version = m[1]
+	} else {
+		sum := sha256.Sum256([]byte(text))
+		t.Version = "sha256:" + hex.EncodeToString(sum[:6])
+	}
+	seen := map[string]bool{}
+	for _, section := range tmpl.Templates() {
+		if section.Tree == nil {
+			continue
+		}
+		for _, v := range fields(section.Tree.Root) {
+			if !seen[v] {
+				seen[v] = true
+				t.variables = append(t.variables, v)
+			}
+		}
+	}
+	slices.Sort(t.variables)
+	return t, nil
+}
+
+// fields finds the top-level variables a template uses, such as "Language"
+// for {{.Language}} or {{$.Language.Name}}. Fields within range and with
+// blocks refer to something else, so are left out, apart from those in
+// their pipelines.
+func fields(node parse.Node) []string {
+	var names []string
+	var walk func(n parse.Node, top bool)
+	walk = func(n parse.Node, top bool) {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Nodes {
+				walk(c, top)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe, top)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Cmds {
+				walk(c, top)
+			}
+		case *parse.CommandNode:
+			for _, a := range n.Args {
+				walk(a, top)
+			}
+		case *parse.FieldNode:
+			if top {
+				names = append(names, n.Ident[0])
+			}
+		case *parse.VariableNode:
+			if len(n.Ident) > 1 && n.Ident[0] == "$" {
+				names = append(names, n.Ident[1])
+			}
+		case *parse.ChainNode:
+			walk(n.Node, top)
+		case *parse.IfNode:
+			walk(n.Pipe, top)
+			walk(n.List, top)
+			walk(n.ElseList, top)
+		case *parse.RangeNode:
+			walk(n.Pipe, top)
+			walk(n.List, false)
+			walk(n.ElseList, top)
+		case *parse.WithNode:
+			walk(n.Pipe, top)
+			walk(n.List, false)
+			walk(n.ElseList, top)
+		case *parse.TemplateNode:
+			walk(n.Pipe, top)
+		}
+	}
+	walk(node, true)
+	return names
+}
+
+// Variables returns the names of the variables the template uses, in order.
+func (t *Template) Variables() []string {
+	return slices.Clone(t.variables)
+}
+
+// Check reports a [MissingVariablesError] if vars, a struct or a map with
+// string keys, doesn't have every variable the template uses.
+func (t *Template) Check(vars any) error {
+	var missing []string
+	for _, name := range t.variables {
+		if !hasVariable(vars, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return MissingVariablesError{Template: t.Name, Missing: missing}
+	}
+	return nil
+}
+
+// hasVariable reports whether vars has a field, method or key called name.
+// Values of other kinds can't be checked, so pass.
+func hasVariable(vars any, name string) bool {
+	if vars == nil {
+		return false
+	}
+	v := reflect.ValueOf(vars)
+	if v.Type().NumMethod() > 0 {
+		if _, ok := v.Type().MethodByName(name); ok {
+			return true
+		}
+	}
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		f, ok := v.Type().FieldByName(name)
+		return ok && f.IsExported()
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return true
+		}
+		return v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key())).IsValid()
+	default:
+		return true
+	}
+}
+
+// Render fills in the template with vars, a struct or a map with string
+// keys, after checking it has every variable the template uses.
+func (t *Template) Render(vars any) (RenderedTemplate, error) {
+	err := t.Check(vars)
+	if err != nil {
+		return RenderedTemplate{}, err
+	}
+	r := RenderedTemplate{
+		Name:    t.Name,
+		Version: t.Version,
+	}
+	r.Question, err = t.execute(t.Name, vars)
+	if err != nil {
+		return RenderedTemplate{}, err
+	}
+	if t.tmpl.Lookup("purpose") != nil {
+		r.Purpose, err = t.execute("purpose", vars)
+		if err != nil {
+			return RenderedTemplate{}, err
+		}
+	}
+	for n := 1; t.tmpl.Lookup("example "+strconv.Itoa(n)+" input") != nil; n++ {
+		input, err := t.execute("example "+strconv.Itoa(n)+" input", vars)
+		if err != nil {
+			return RenderedTemplate{}, err
+		}
+		output, err := t.execute("example "+strconv.Itoa(n)+" output", vars)
+		if err != nil {
+			return RenderedTemplate{}, err
+		}
+		r.Examples = append(r.Examples, Exchange{Input: input, Output: output, Example: true})
+	}
+	return r, nil
+}
+
+// execute renders one section of the template, trimming the space around
+// it that defining sections tends to leave.
+func (t *Template) execute(section string, vars any) (string, error) {
+	if t.tmpl.Lookup(section) == nil {
+		return "", fmt.Errorf("template %s has no %q section", t.Name, section)
+	}
+	var b strings.Builder
+	err := t.tmpl.ExecuteTemplate(&b, section, vars)
+	if err != nil {
+		return "", fmt.Errorf("rendering template %s: %w", t.Name, err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// TemplateLibrary holds templates by name.
+type TemplateLibrary struct {
+	templates map[string]*Template
+}
+
+// LoadTemplates reads every template file under dir, naming each after its
+// path relative to dir without the extension, such as "review/go" for
+// review/go.tmpl.
+func LoadTemplates(dir string) (*TemplateLibrary, error) {
+	return LoadTemplatesFS(os.DirFS(dir))
+}
+
+// LoadTemplatesFS is like [LoadTemplates], but reads the templates of fsys,
+// such as an [embed.FS].
+func LoadTemplatesFS(fsys fs.FS) (*TemplateLibrary, error) {
+	l := &TemplateLibrary{templates: map[string]*Template{}}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(name) != TemplateExt {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		t, err := ParseTemplate(strings.TrimSuffix(name, TemplateExt), string(data))
+		if err != nil {
+			return err
+		}
+		l.templates[t.Name] = t
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Names returns the names of the templates in the library, in order.
+func (l *TemplateLibrary) Names() []string {
+	names := make([]string, 0, len(l.templates))
+	for name := range l.templates {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Template returns the template called name.
+func (l *TemplateLibrary) Template(name string) (*Template, error) {
+	t, ok := l.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("template %s not found. Templates include: %s", name, strings.Join(l.Names(), ", "))
+	}
+	return t, nil
+}
+
+// AskTemplate renders t with vars and asks the question it gives. The
+// template's purpose, if it has one, is used in place of the Oracle's, and
+// its examples are given ahead of the conversation. The prompt records the
+// template's name and version, so that answers can be traced back to the
+// template that produced them.
+func (o *Oracle) AskTemplate(t *Template, vars any, references ...any) (string, error) {
+	return o.AskTemplateWithContext(context.Background(), t, vars, references...)
+}
+
+// AskTemplateWithContext is similar to [*Oracle.AskTemplate] but allows for
+// a context to be passed in.
+func (o *Oracle) AskTemplateWithContext(ctx context.Context, t *Template, vars any, references ...any) (string, error) {
+	r, err := t.Render(vars)
+	if err != nil {
+		return "", err
+	}
+	p, err := o.prompt(ctx, r.Question, references...)
+	if err != nil {
+		return "", err
+	}
+	if r.Purpose != "" {
+		p.Purpose = r.Purpose
+	}
+	if len(r.Examples) > 0 {
+		inputs := make([]string, 0, len(r.Examples)+len(p.InputHistory))
+		outputs := make([]string, 0, len(r.Examples)+len(p.OutputHistory))
+		for _, e := range r.Examples {
+			inputs = append(inputs, e.Input)
+			outputs = append(outputs, e.Output)
+		}
+		p.InputHistory = append(inputs, p.InputHistory...)
+		p.OutputHistory = append(outputs, p.OutputHistory...)
+	}
+	p.Template = r.Name
+	p.TemplateVersion = r.Version
+	answer, err := o.generate(ctx, p, func(string) bool { return true })
+	if err != nil {
+		return "", err
+	}
+	o.remember(r.Question, answer)
+	return answer, nil
+}
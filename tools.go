@@ -0,0 +1,151 @@
+package goracle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+// ErrMaxSteps is returned when the model is still calling tools after the
+// Oracle's maximum number of agent steps.
+var ErrMaxSteps = errors.New("tool calling exceeded maximum steps")
+
+// defaultMaxSteps bounds how many rounds of tool calls a single question may
+// take before the Oracle gives up.
+const defaultMaxSteps = 10
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// tool pairs the definition sent to the model with the Go function that
+// services its calls.
+type tool struct {
+	definition llm.Tool
+	call       func(ctx context.Context, arguments json.RawMessage) (string, error)
+}
+
+// RegisterTool makes a Go function available for the model to call while
+// answering a question. fn must take a single struct argument, optionally
+// preceded by a [context.Context], and return a result and an error, such as
+//
+//	func(ctx context.Context, args WeatherQuery) (Forecast, error)
+//
+// The JSON schema the model sees is derived from the argument struct's json
+// tags, with `description` tags used to document each field. A string result
+// is passed back to the model as is, anything else is encoded as JSON.
+// Registering a tool with an existing name replaces it.
+func (o *Oracle) RegisterTool(name, description string, fn any) error {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func {
+		return fmt.Errorf("tool %s: expected a function, got %T", name, fn)
+	}
+	withContext := t.NumIn() == 2 && t.In(0) == contextType
+	if t.NumIn() != 1 && !withContext {
+		return fmt.Errorf("tool %s: function must take one argument struct, optionally preceded by a context", name)
+	}
+	argType := t.In(t.NumIn() - 1)
+	if argType.Kind() != reflect.Struct {
+		return fmt.Errorf("tool %s: argument must be a struct, got %s", name, argType)
+	}
+	if t.NumOut() != 2 || t.Out(1) != errorType {
+		return fmt.Errorf("tool %s: function must return a result and an error", name)
+	}
+	call := func(ctx context.Context, arguments json.RawMessage) (string, error) {
+		arg := reflect.New(argType)
+		if len(arguments) > 0 {
+			err := json.Unmarshal(arguments, arg.Interface())
+			if err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+		}
+		in := []reflect.Value{arg.Elem()}
+		if withContext {
+			in = append([]reflect.Value{reflect.ValueOf(ctx)}, in...)
+		}
+		out := v.Call(in)
+		if err, _ := out[1].Interface().(error); err != nil {
+			return "", err
+		}
+		if s, ok := out[0].Interface().(string); ok {
+			return s, nil
+		}
+		data, err := json.Marshal(out[0].Interface())
+		if err != nil {
+			return "", err
+		}
+		return string(data), nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.tools == nil {
+		o.tools = map[string]tool{}
+	}
+	if _, exists := o.tools[name]; !exists {
+		o.toolOrder = append(o.toolOrder, name)
+	}
+	o.tools[name] = tool{
+		definition: llm.Tool{
+			Name:        name,
+			Description: description,
+			Parameters:  schemaFor(argType),
+		},
+		call: call,
+	}
+	return nil
+}
+
+// SetMaxSteps limits how many rounds of tool calls the model may make while
+// answering a single question. The default is 10.
+func (o *Oracle) SetMaxSteps(steps int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.maxSteps = steps
+}
+
+// toolDefinitions lists the registered tools in the order they were
+// registered. The caller must hold the lock.
+func (o *Oracle) toolDefinitions() []llm.Tool {
+	var definitions []llm.Tool
+	for _, name := range o.toolOrder {
+		definitions = append(definitions, o.tools[name].definition)
+	}
+	return definitions
+}
+
+// runTools executes each requested call in order. Failures are reported back
+// to the model as error results rather than aborting the question, so the
+// model has a chance to correct itself.
+func (o *Oracle) runTools(ctx context.Context, calls []llm.ToolCall) []llm.ToolResult {
+	results := make([]llm.ToolResult, 0, len(calls))
+	for _, call := range calls {
+		result := llm.ToolResult{
+			ID:   call.ID,
+			Name: call.Name,
+		}
+		o.mu.Lock()
+		t, ok := o.tools[call.Name]
+		o.mu.Unlock()
+		if !ok {
+			result.Content = fmt.Sprintf("unknown tool: %s", call.Name)
+			result.IsError = true
+			results = append(results, result)
+			continue
+		}
+		content, err := t.call(ctx, call.Arguments)
+		if err != nil {
+			result.Content = err.Error()
+			result.IsError = true
+		} else {
+			result.Content = content
+		}
+		results = append(results, result)
+	}
+	return results
+}
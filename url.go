@@ -0,0 +1,137 @@
+package goracle
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+	"github.com/mr-joshcrane/goracle/client/pdf"
+	"github.com/mr-joshcrane/goracle/client/web"
+)
+
+// URLOptions controls how [ReadURL] fetches a page. Zero fields take their
+// defaults.
+type URLOptions struct {
+	// Client makes the request. The default is [http.DefaultClient].
+	Client *http.Client
+	// Timeout limits how long fetching the page may take. The default is 30
+	// seconds, and a negative timeout means no limit.
+	Timeout time.Duration
+	// MaxSize is the most bytes to read. The default is 8MiB, and a negative
+	// size means no limit.
+	MaxSize int64
+}
+
+const defaultURLTimeout = 30 * time.Second
+
+// A Reference helper that fetches a web page and returns it in a form the
+// model can read, named after its URL. HTML pages are converted to Markdown,
+// leaving out their scripts, styles and navigation, while images become
+// image references in their original format and PDFs become documents.
+// Content will be a snapshot of the page at the time of calling. If the page can't be fetched, its
+// contents are empty; use [ReadURL] to see why.
+func URL(u string) Reference {
+	ref, _ := ReadURL(context.Background(), u, URLOptions{})
+	return ref
+}
+
+// ReadURL is like [URL], but fetches the page within ctx and returns an
+// error if it can't be fetched or read. A page larger than the size limit
+// gives an error wrapping [ErrReferenceTooLarge].
+func ReadURL(ctx context.Context, u string, opts URLOptions) (Reference, error) {
+	ref := Reference{
+		Name:   u,
+		Source: u,
+		Data:   []byte{},
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = defaultURLTimeout
+	}
+	if opts.MaxSize == 0 {
+		opts.MaxSize = defaultMaxTotalSize
+	}
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+	data, mediaType, final, err := fetch(ctx, opts, u)
+	if err != nil {
+		return ref, fmt.Errorf("fetching %s: %w", u, err)
+	}
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml":
+		title, text := web.Markdown(data, final)
+		if title != "" && !strings.HasPrefix(text, "# ") {
+			text = "# " + title + "\n\n" + text
+		}
+		ref.MIMEType = "text/markdown; charset=utf-8"
+		ref.Data = []byte(text)
+	case mediaType == pdf.MIMEType:
+		if !bytes.HasPrefix(data, []byte("%PDF-")) {
+			return ref, fmt.Errorf("fetching %s: %w", u, pdf.ErrNotPDF)
+		}
+		ref.MIMEType = pdf.MIMEType
+		ref.Data = data
+	case strings.HasPrefix(mediaType, "image/"):
+		imageType := llm.ImageType(data)
+		if imageType == "" {
+			return ref, fmt.Errorf("fetching %s: unsupported image type %s", u, mediaType)
+		}
+		ref.MIMEType = imageType
+		ref.Data = data
+	case !isBinary(data):
+		ref.MIMEType = mediaType + "; charset=utf-8"
+		ref.Data = data
+	default:
+		return ref, fmt.Errorf("fetching %s: unsupported content type %s", u, mediaType)
+	}
+	return ref, nil
+}
+
+// fetch gets the page at u, returning its contents and media type, along
+// with the URL it was finally served from after any redirects.
+func fetch(ctx context.Context, opts URLOptions, u string) ([]byte, string, *url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	req.Header.Set("Accept", "text/html, text/*;q=0.9, application/pdf, image/*, */*;q=0.5")
+	resp, err := opts.Client.Do(req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, "", nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if opts.MaxSize > 0 && resp.ContentLength > opts.MaxSize {
+		return nil, "", nil, fmt.Errorf("%w: %d bytes is more than %d", ErrReferenceTooLarge, resp.ContentLength, opts.MaxSize)
+	}
+	body := io.Reader(resp.Body)
+	if opts.MaxSize > 0 {
+		body = io.LimitReader(resp.Body, opts.MaxSize+1)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if opts.MaxSize > 0 && int64(len(data)) > opts.MaxSize {
+		return nil, "", nil, fmt.Errorf("%w: more than %d bytes", ErrReferenceTooLarge, opts.MaxSize)
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType == "application/octet-stream" {
+		mediaType, _, _ = mime.ParseMediaType(llm.DetectMIMEType(data))
+	}
+	return data, mediaType, resp.Request.URL, nil
+}
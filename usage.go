@@ -0,0 +1,93 @@
+package goracle
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/mr-joshcrane/goracle/client/llm"
+)
+
+// BudgetExceededError is returned instead of making a request that would take
+// the Oracle's spending past its budget.
+type BudgetExceededError struct {
+	// Budget is the most the Oracle may spend, in US dollars.
+	Budget float64
+	// Spent is what the Oracle has spent so far.
+	Spent float64
+	// Estimate is the expected cost of the refused request's input.
+	Estimate float64
+}
+
+func (e BudgetExceededError) Error() string {
+	return fmt.Sprintf("budget of $%.2f exceeded: spent $%.4f, next request estimated at $%.4f", e.Budget, e.Spent, e.Estimate)
+}
+
+// SetBudget caps what the Oracle may spend, in US dollars. Once a request
+// would take spending past the budget, it is refused with a
+// [BudgetExceededError]. A budget of 0 removes the cap. Costs are only known
+// for models with a price in their configuration.
+func (o *Oracle) SetBudget(dollars float64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.budget = dollars
+}
+
+// Usage returns the tokens the Oracle has used, and what they cost, across
+// every completion so far.
+func (o *Oracle) Usage() llm.Usage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.usage
+}
+
+// checkBudget refuses p if its estimated cost would take the Oracle past its
+// budget.
+func (o *Oracle) checkBudget(p Prompt) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.budget <= 0 {
+		return nil
+	}
+	var price llm.Pricing
+	if c, ok := o.client.(interface{ Pricing() llm.Pricing }); ok {
+		price = c.Pricing()
+	}
+	estimate := price.Cost(llm.Usage{InputTokens: estimatePromptTokens(p)})
+	if o.usage.Cost+estimate > o.budget {
+		return BudgetExceededError{
+			Budget:   o.budget,
+			Spent:    o.usage.Cost,
+			Estimate: estimate,
+		}
+	}
+	return nil
+}
+
+// recordUsage adds the usage reported by a completion that has been read.
+func (o *Oracle) recordUsage(data io.Reader) {
+	u, ok := data.(llm.UsageReporter)
+	if !ok {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.usage = o.usage.Add(u.Usage())
+}
+
+// estimatePromptTokens estimates the whole of p, including its history and
+// any tool calls made so far.
+func estimatePromptTokens(p Prompt) int {
+	total := promptTokens(p)
+	for _, s := range p.InputHistory {
+		total += EstimateTokens(s)
+	}
+	for _, s := range p.OutputHistory {
+		total += EstimateTokens(s)
+	}
+	if len(p.ToolTurns) > 0 {
+		data, _ := json.Marshal(p.ToolTurns)
+		total += EstimateTokens(string(data))
+	}
+	return total
+}